@@ -0,0 +1,109 @@
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+
+	"github.com/flaviogonzalez/instant-layer/internal/config"
+	defaults "github.com/flaviogonzalez/instant-layer/internal/default"
+	"github.com/flaviogonzalez/instant-layer/internal/types"
+)
+
+// GenerateClient writes a typed HTTP client of service to into service from,
+// generated from to's routes, and declares the connections in layer.json so the
+// graph and validation don't depend on scanning from's sources.
+func GenerateClient(root, from, to string) error {
+	layerRoot, err := config.FindLayerRoot(root)
+	if err != nil {
+		return fmt.Errorf("layer.json not found. Run 'layer new' first to create a project")
+	}
+
+	layer := &config.Layer{Root: layerRoot}
+	if err := layer.Reload(); err != nil {
+		return fmt.Errorf("failed to reload layer.json: %w", err)
+	}
+
+	if from == to {
+		return fmt.Errorf("a service can't be a client of itself")
+	}
+	if layer.FindService(from) == nil {
+		return fmt.Errorf("service %s not found in layer.json", from)
+	}
+	target := layer.FindService(to)
+	if target == nil {
+		return fmt.Errorf("service %s not found in layer.json", to)
+	}
+	if target.RoutesConfig == nil || len(target.RoutesConfig.RoutesGroup) == 0 {
+		return fmt.Errorf("service %s has no routes", to)
+	}
+
+	pkg := defaults.ClientPackage(target)
+	pkgPath := filepath.Join(layerRoot, from, defaults.ClientsDir, pkg.Name)
+	for _, f := range pkg.Files {
+		if err := writeGoFile(filepath.Join(pkgPath, f.Name), f); err != nil {
+			return fmt.Errorf("failed to write client: %w", err)
+		}
+	}
+
+	layer.Connections = append(withoutConnections(layer.Connections, from, to), clientConnections(from, target, pkg.Name)...)
+	if err := layer.Update(); err != nil {
+		return fmt.Errorf("failed to update layer.json: %w", err)
+	}
+
+	if err := regenerateDockerCompose(layerRoot); err != nil {
+		return fmt.Errorf("failed to regenerate docker-compose: %w", err)
+	}
+
+	fmt.Printf("Client of '%s' created in %s/%s/%s (base URL from $%s)\n",
+		to, from, defaults.ClientsDir, pkg.Name, defaults.ClientBaseURLEnv(to))
+	return nil
+}
+
+// clientConnections declares a connection per route the client calls
+func clientConnections(from string, target *types.Service, pkg string) []*types.Connection {
+	source := filepath.ToSlash(filepath.Join(from, defaults.ClientsDir, pkg, "api.go"))
+
+	var connections []*types.Connection
+	for _, group := range target.RoutesConfig.RoutesGroup {
+		for _, route := range group.Routes {
+			connections = append(connections, &types.Connection{
+				FromService: from,
+				ToService:   target.Name,
+				Protocol:    types.ProtocolHTTP,
+				Route:       route.Path,
+				Method:      route.Method,
+				SourceFile:  source,
+				Valid:       true,
+			})
+		}
+	}
+	return connections
+}
+
+// withoutConnections drops the declared connections from one service to another,
+// so regenerating a client replaces them
+func withoutConnections(connections []*types.Connection, from, to string) []*types.Connection {
+	var kept []*types.Connection
+	for _, conn := range connections {
+		if conn.FromService != from || conn.ToService != to {
+			kept = append(kept, conn)
+		}
+	}
+	return kept
+}
+
+// declaredTargets returns the services a service has generated clients of,
+// skipping the clients of removed services
+func declaredTargets(layer *config.Layer, from string) []string {
+	seen := make(map[string]bool)
+	var targets []string
+	for _, conn := range layer.Connections {
+		if conn.FromService == from && !seen[conn.ToService] && layer.FindService(conn.ToService) != nil {
+			seen[conn.ToService] = true
+			targets = append(targets, conn.ToService)
+		}
+	}
+	sort.Strings(targets)
+	return targets
+}
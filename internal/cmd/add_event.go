@@ -0,0 +1,258 @@
+package cmd
+
+import (
+	"fmt"
+	"go/format"
+	"go/token"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/flaviogonzalez/instant-layer/internal/config"
+	defaults "github.com/flaviogonzalez/instant-layer/internal/default"
+	"github.com/flaviogonzalez/instant-layer/internal/templ"
+	"github.com/flaviogonzalez/instant-layer/internal/types"
+)
+
+// eventNamePattern matches exported Go identifiers (UserCreated)
+var eventNamePattern = regexp.MustCompile(`^[A-Z][A-Za-z0-9]*$`)
+
+// eventFieldTypes are the Go types accepted for event fields
+var eventFieldTypes = map[string]bool{
+	"string": true, "bool": true, "int": true, "int32": true, "int64": true,
+	"float32": true, "float64": true, "time.Time": true, "json.RawMessage": true,
+	"[]string": true, "[]int": true, "[]byte": true,
+}
+
+// GenerateEvent adds a typed event contract to the project: it writes the shared
+// events module and regenerates the contracts of every broker and listener service.
+func GenerateEvent(root string, ev *types.Event) error {
+	layerRoot, err := config.FindLayerRoot(root)
+	if err != nil {
+		return fmt.Errorf("layer.json not found. Run 'layer new' first to create a project")
+	}
+
+	layer := &config.Layer{Root: layerRoot}
+	if err := layer.Reload(); err != nil {
+		return fmt.Errorf("failed to reload layer.json: %w", err)
+	}
+
+	if !eventNamePattern.MatchString(ev.Name) {
+		return fmt.Errorf("event name %q must be an exported Go identifier (e.g. UserCreated)", ev.Name)
+	}
+	if layer.FindEvent(ev.Name) != nil {
+		return fmt.Errorf("event %q already exists", ev.Name)
+	}
+	if ev.Topic == "" {
+		ev.Topic = defaults.EventTopic(ev.Name)
+	}
+	if ev.Version == 0 {
+		ev.Version = 1
+	}
+
+	layer.Events = append(layer.Events, ev)
+
+	if err := writeEventsModule(layer); err != nil {
+		return fmt.Errorf("failed to write events module: %w", err)
+	}
+	if err := syncGoWork(layer); err != nil {
+		return fmt.Errorf("failed to update go.work: %w", err)
+	}
+
+	if err := regenerateEventContracts(layer); err != nil {
+		return fmt.Errorf("failed to regenerate event contracts: %w", err)
+	}
+
+	if err := layer.Update(); err != nil {
+		return fmt.Errorf("failed to update layer.json: %w", err)
+	}
+
+	fmt.Printf("Event '%s' (%s v%d) created successfully!\n", ev.Name, ev.Topic, ev.Version)
+	return nil
+}
+
+// parseEventFields parses --field flags in the form name:type (e.g. user_id:string)
+func parseEventFields(specs []string) ([]*types.EventField, error) {
+	var fields []*types.EventField
+	seen := make(map[string]bool)
+
+	for _, spec := range specs {
+		name, typ, ok := strings.Cut(spec, ":")
+		name, typ = strings.TrimSpace(name), strings.TrimSpace(typ)
+		if !ok || name == "" || typ == "" {
+			return nil, fmt.Errorf("invalid field %q, expected name:type", spec)
+		}
+		if !eventFieldTypes[typ] {
+			return nil, fmt.Errorf("unsupported type %q for field %q", typ, name)
+		}
+
+		goName := defaults.GoName(name)
+		if seen[goName] {
+			return nil, fmt.Errorf("duplicate field %q", name)
+		}
+		seen[goName] = true
+
+		fields = append(fields, &types.EventField{
+			Name: goName,
+			Type: typ,
+			JSON: defaults.SnakeCase(name),
+		})
+	}
+
+	return fields, nil
+}
+
+// writeEventsModule (re)writes the shared events module at {root}/events
+func writeEventsModule(layer *config.Layer) error {
+	modulePath := filepath.Join(layer.Root, defaults.EventsModule)
+	if err := os.MkdirAll(modulePath, 0755); err != nil {
+		return err
+	}
+
+	goModPath := filepath.Join(modulePath, "go.mod")
+	if _, err := os.Stat(goModPath); os.IsNotExist(err) {
+		data := templ.GoModData{
+			Name:      defaults.EventsModule,
+			GoVersion: templ.DefaultGoVersion(),
+		}
+		if err := templ.GenerateGoMod(goModPath, data); err != nil {
+			return err
+		}
+	}
+
+	pkg := defaults.EventsPackage(layer.Events)
+	for _, f := range pkg.Files {
+		if err := writeGoFile(filepath.Join(modulePath, f.Name), f); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// regenerateEventContracts rewrites event/contracts.go in every broker and listener
+// service so that emitters and handlers are compiled against the current contracts.
+func regenerateEventContracts(layer *config.Layer) error {
+	for _, svc := range layer.Services {
+		servicePath := filepath.Join(layer.Root, svc.Name)
+		svc.Events = layer.Events
+
+		switch serviceRole(servicePath, svc) {
+		case "broker":
+			if err := writeGoFile(filepath.Join(servicePath, "event", "contracts.go"), defaults.BrokerContractsFile(svc)); err != nil {
+				return err
+			}
+			if err := writeMessagingFile(servicePath, svc, defaults.BrokerMessagingFile(svc)); err != nil {
+				return err
+			}
+		case "listener":
+			if err := writeGoFile(filepath.Join(servicePath, "event", "contracts.go"), defaults.ListenerContractsFile(svc)); err != nil {
+				return err
+			}
+			// Event topics are part of the listener bindings, with the default retry policy
+			if err := writeMessagingFile(servicePath, svc, defaults.ListenerMessagingFile(svc)); err != nil {
+				return err
+			}
+			if err := writeGoFileIfMissing(filepath.Join(servicePath, "event", "retry.go"), defaults.ListenerRetryFile(svc)); err != nil {
+				return err
+			}
+			// Handler stubs hold user code: only create the missing ones
+			for _, f := range defaults.ListenerHandlersPackage(svc).Files {
+				if err := writeGoFileIfMissing(filepath.Join(servicePath, "handlers", f.Name), f); err != nil {
+					return err
+				}
+			}
+		default:
+			continue
+		}
+
+		if err := requireLocalModule(filepath.Join(servicePath, "go.mod"), defaults.EventsModule, "../"+defaults.EventsModule); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeMessagingFile rewrites event/messaging.go from the service's messaging config.
+// Services without a recorded config only get the defaults when the file is missing.
+func writeMessagingFile(servicePath string, svc *types.Service, f *types.File) error {
+	path := filepath.Join(servicePath, "event", f.Name)
+	if svc.Messaging == nil {
+		return writeGoFileIfMissing(path, f)
+	}
+	return writeGoFile(path, f)
+}
+
+// serviceKind returns the template a service was generated from, falling back to
+// the generated event and gateway packages for services created before templates
+// were recorded or rescanned by hydrate
+func serviceKind(servicePath string, svc *types.Service) string {
+	if svc.Template != "" {
+		return svc.Template
+	}
+	if _, err := os.Stat(filepath.Join(servicePath, "event", "emitter.go")); err == nil {
+		return "broker"
+	}
+	if _, err := os.Stat(filepath.Join(servicePath, "event", "consumer.go")); err == nil {
+		return "listener"
+	}
+	if isGateway(servicePath) {
+		return "gateway"
+	}
+	return ""
+}
+
+// serviceRole is serviceKind with outbox services, which embed a broker
+// event package, reported as brokers
+func serviceRole(servicePath string, svc *types.Service) string {
+	if svc.Outbox {
+		return "broker"
+	}
+	return serviceKind(servicePath, svc)
+}
+
+// requireLocalModule adds a require + replace pair for a module living in the project
+func requireLocalModule(goModPath, module, dir string) error {
+	content, err := os.ReadFile(goModPath)
+	if err != nil {
+		return err
+	}
+
+	if strings.Contains(string(content), "replace "+module+" =>") {
+		return nil
+	}
+
+	f, err := os.OpenFile(goModPath, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = fmt.Fprintf(f, "\nrequire %s v0.0.0\n\nreplace %s => %s\n", module, module, dir)
+	return err
+}
+
+// writeGoFile formats and writes a generated Go file, creating its directory
+func writeGoFile(path string, f *types.File) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return format.Node(file, token.NewFileSet(), f.Content)
+}
+
+// writeGoFileIfMissing writes a generated Go file only when it doesn't exist yet
+func writeGoFileIfMissing(path string, f *types.File) error {
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+	return writeGoFile(path, f)
+}
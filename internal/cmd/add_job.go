@@ -0,0 +1,94 @@
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/flaviogonzalez/instant-layer/internal/config"
+	defaults "github.com/flaviogonzalez/instant-layer/internal/default"
+	"github.com/flaviogonzalez/instant-layer/internal/types"
+)
+
+// scheduleDescriptors are the predefined schedules accepted besides @every and cron expressions
+var scheduleDescriptors = map[string]bool{
+	"@yearly":   true,
+	"@annually": true,
+	"@monthly":  true,
+	"@weekly":   true,
+	"@daily":    true,
+	"@midnight": true,
+	"@hourly":   true,
+}
+
+// GenerateJob records a scheduled job of a worker service in layer.json,
+// regenerates the worker's job registry and creates the job's stub
+func GenerateJob(root, service string, job *types.Job) error {
+	layerRoot, err := config.FindLayerRoot(root)
+	if err != nil {
+		return fmt.Errorf("layer.json not found. Run 'layer new' first to create a project")
+	}
+
+	layer := &config.Layer{Root: layerRoot}
+	if err := layer.Reload(); err != nil {
+		return fmt.Errorf("failed to reload layer.json: %w", err)
+	}
+
+	svc := layer.FindService(service)
+	if svc == nil {
+		return fmt.Errorf("service %s not found in layer.json", service)
+	}
+	if svc.Template != "worker" {
+		return fmt.Errorf("service %s is not a worker", service)
+	}
+	if defaults.JobFuncName(job) == "" {
+		return fmt.Errorf("invalid job name %q", job.Name)
+	}
+	if err := validateSchedule(job.Schedule); err != nil {
+		return err
+	}
+	for _, existing := range svc.Jobs {
+		if defaults.JobFuncName(existing) == defaults.JobFuncName(job) {
+			return fmt.Errorf("job %s already exists in %s", job.Name, service)
+		}
+	}
+
+	svc.Jobs = append(svc.Jobs, job)
+
+	jobsPath := filepath.Join(layerRoot, svc.Name, "jobs")
+	if err := writeGoFile(filepath.Join(jobsPath, "registry.go"), defaults.WorkerRegistryFile(svc)); err != nil {
+		return fmt.Errorf("failed to write job registry: %w", err)
+	}
+	// Job stubs hold user code: only create the missing one
+	if err := writeGoFileIfMissing(filepath.Join(jobsPath, defaults.JobFileName(job)), defaults.WorkerJobFile(job)); err != nil {
+		return fmt.Errorf("failed to write job: %w", err)
+	}
+
+	if err := layer.Update(); err != nil {
+		return fmt.Errorf("failed to update layer.json: %w", err)
+	}
+
+	fmt.Printf("Job '%s' (%s) added to %s/jobs/%s\n", job.Name, job.Schedule, svc.Name, defaults.JobFileName(job))
+	return nil
+}
+
+// validateSchedule checks a job schedule: a descriptor such as @hourly,
+// @every <duration>, or a standard five-field cron expression
+func validateSchedule(schedule string) error {
+	schedule = strings.TrimSpace(schedule)
+	switch {
+	case scheduleDescriptors[schedule]:
+		return nil
+	case strings.HasPrefix(schedule, "@every "):
+		d, err := time.ParseDuration(strings.TrimSpace(strings.TrimPrefix(schedule, "@every ")))
+		if err != nil || d <= 0 {
+			return fmt.Errorf("invalid interval in schedule %q", schedule)
+		}
+		return nil
+	case len(strings.Fields(schedule)) == 5:
+		return nil
+	default:
+		return fmt.Errorf("invalid schedule %q: want a cron expression (e.g. \"*/5 * * * *\"), a descriptor (e.g. @hourly) or @every <duration>", schedule)
+	}
+}
@@ -5,8 +5,13 @@ import (
 	"go/format"
 	"go/token"
 	"os"
+	"path"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/flaviogonzalez/instant-layer/internal/config"
 	defaults "github.com/flaviogonzalez/instant-layer/internal/default"
@@ -15,7 +20,7 @@ import (
 	"github.com/manifoldco/promptui"
 )
 
-func SelectAndGenerateTemplate(root string) error {
+func SelectAndGenerateTemplate(root string, port int) error {
 	// First, find the layer root (where layer.json is)
 	layerRoot, err := config.FindLayerRoot(root)
 	if err != nil {
@@ -39,30 +44,137 @@ func SelectAndGenerateTemplate(root string) error {
 		return err
 	}
 
-	servicePort, err := promptServicePort(selected.Service.Port)
-	if err != nil {
-		return err
+	layer := &config.Layer{Root: layerRoot}
+	if err := layer.Reload(); err != nil {
+		return fmt.Errorf("failed to reload layer.json: %w", err)
+	}
+
+	// Listeners and workers serve no port to choose
+	servicePort := selected.Service.Port
+	if servicePort == 0 && port != 0 {
+		return fmt.Errorf("%s services don't listen on a port", selected.ID)
+	}
+	if port != 0 {
+		if err := checkServicePort(port, usedPorts(layer, nil)); err != nil {
+			return err
+		}
+	}
+
+	// gRPC services have no HTTP server to expose /metrics on or to trace
+	metrics := defaults.Option(func(*types.Service) {})
+	if selected.ID != "grpc" && promptMetrics() {
+		metrics = defaults.WithMetrics()
+	}
+	tracing := defaults.Option(func(*types.Service) {})
+	if selected.ID != "grpc" && selected.ID != "worker" && promptTracing() {
+		tracing = defaults.WithTracing()
 	}
 
 	var service *types.Service
 	switch selected.ID {
-	case "broker":
-		service = defaults.BrokerService(
+	case "broker", "listener":
+		messaging, err := promptMessaging(selected.ID, serviceName, sharedMessaging(layer))
+		if err != nil {
+			return err
+		}
+
+		if selected.ID == "broker" {
+			service = defaults.BrokerService(
+				defaults.WithName(serviceName),
+				defaults.WithPort(servicePort),
+				defaults.WithEvents(layer.Events...),
+				defaults.WithMessaging(messaging),
+				metrics,
+				tracing,
+			)
+		} else {
+			service = defaults.ListenerService(
+				defaults.WithName(serviceName),
+				defaults.WithEvents(layer.Events...),
+				defaults.WithMessaging(messaging),
+				metrics,
+				tracing,
+			)
+		}
+	case "grpc":
+		service = defaults.GRPCService(
 			defaults.WithName(serviceName),
 			defaults.WithPort(servicePort),
 		)
-	case "listener":
-		service = defaults.ListenerService(
+	case "websocket":
+		opts := []defaults.Option{
 			defaults.WithName(serviceName),
+			defaults.WithPort(servicePort),
+			metrics,
+			tracing,
+		}
+		if promptBridge() {
+			messaging, err := promptMessaging("listener", serviceName, sharedMessaging(layer))
+			if err != nil {
+				return err
+			}
+			opts = append(opts,
+				defaults.WithEvents(layer.Events...),
+				defaults.WithMessaging(messaging),
+			)
+		}
+		service = defaults.WebSocketService(opts...)
+	case "gateway":
+		service = defaults.GatewayService(
+			defaults.WithName(serviceName),
+			defaults.WithPort(servicePort),
+			metrics,
+			tracing,
 		)
+	case "worker":
+		opts := []defaults.Option{defaults.WithName(serviceName), metrics}
+		if promptLeaderLock() {
+			opts = append(opts, defaults.WithLeaderLock())
+		}
+		service = defaults.WorkerService(opts...)
 	default:
-		service = defaults.DefaultService(
+		opts := []defaults.Option{
 			defaults.WithName(serviceName),
 			defaults.WithPort(servicePort),
-		)
+			metrics,
+			tracing,
+		}
+		if promptOutbox() {
+			messaging, err := promptMessaging("broker", serviceName, sharedMessaging(layer))
+			if err != nil {
+				return err
+			}
+			opts = append(opts,
+				defaults.WithOutbox(),
+				defaults.WithEvents(layer.Events...),
+				defaults.WithMessaging(messaging),
+			)
+		}
+		service = defaults.DefaultService(opts...)
+	}
+	service.Template = selected.ID
+
+	// Chosen once the containers the service brings along are known
+	if service.Port != 0 {
+		servicePort, err = chooseServicePort(port, service.Port, usedPorts(layer, service))
+		if err != nil {
+			return err
+		}
+		service.Port = servicePort
+	}
+
+	if err := createService(layerRoot, service); err != nil {
+		return err
 	}
 
-	servicePath := filepath.Join(layerRoot, serviceName)
+	fmt.Printf("Service '%s' created successfully at port %d!\n", serviceName, servicePort)
+	return nil
+}
+
+// createService writes a generated service with its go.mod, records it in
+// layer.json and regenerates docker-compose.yml
+func createService(layerRoot string, service *types.Service) error {
+	servicePath := filepath.Join(layerRoot, service.Name)
 	if err := os.MkdirAll(servicePath, 0755); err != nil {
 		return fmt.Errorf("failed to create service directory: %w", err)
 	}
@@ -72,27 +184,80 @@ func SelectAndGenerateTemplate(root string) error {
 		return err
 	}
 
+	// .env.example lists the variables config.LoadEnv reads
+	if err := generateEnvExample(servicePath, service); err != nil {
+		return fmt.Errorf("failed to generate .env.example: %w", err)
+	}
+
+	// The .proto and its go:generate step sit next to the checked-in stubs
+	if service.Template == "grpc" {
+		if err := templ.GenerateProto(filepath.Join(servicePath, defaults.GRPCPackageName), protoData(service)); err != nil {
+			return fmt.Errorf("failed to generate .proto: %w", err)
+		}
+	}
+
 	// Generate go.mod for the service
-	if err := generateServiceGoMod(servicePath, serviceName, selected.ID); err != nil {
+	transport := ""
+	if service.Messaging != nil {
+		transport = defaults.TransportOf(service)
+	}
+	if err := generateServiceGoMod(servicePath, service.Name, service.Template, transport, service.Metrics, service.Tracing); err != nil {
 		return fmt.Errorf("failed to generate go.mod: %w", err)
 	}
 
+	// Brokers and listeners compile against the shared event contracts
+	if len(service.Events) > 0 {
+		if err := requireLocalModule(filepath.Join(servicePath, "go.mod"), defaults.EventsModule, "../"+defaults.EventsModule); err != nil {
+			return fmt.Errorf("failed to require events module: %w", err)
+		}
+	}
+
 	// Update layer.json with the new service
 	if err := updateLayerWithService(layerRoot, service); err != nil {
 		return fmt.Errorf("failed to update layer.json: %w", err)
 	}
 
+	// Gateways proxy the routes of every service, including the new one
+	layer := &config.Layer{Root: layerRoot}
+	if err := layer.Reload(); err != nil {
+		return fmt.Errorf("failed to reload layer.json: %w", err)
+	}
+	if err := regenerateGateways(layer); err != nil {
+		return fmt.Errorf("failed to regenerate gateway routes: %w", err)
+	}
+
+	// The workspace uses every service module
+	if err := syncGoWork(layer); err != nil {
+		return fmt.Errorf("failed to update go.work: %w", err)
+	}
+
 	// Regenerate docker-compose.yml
 	if err := regenerateDockerCompose(layerRoot); err != nil {
 		return fmt.Errorf("failed to regenerate docker-compose: %w", err)
 	}
 
-	fmt.Printf("Service '%s' created successfully at port %d!\n", serviceName, servicePort)
 	return nil
 }
 
-// generateServiceGoMod creates a go.mod file for the service
-func generateServiceGoMod(servicePath, serviceName, templateID string) error {
+// generateEnvExample writes the .env.example of a service at its root, one
+// line per field of its config.Env with the default docker-compose sets
+func generateEnvExample(servicePath string, service *types.Service) error {
+	data := templ.EnvExampleData{Header: []string{
+		"Environment of " + service.Name + ", loaded into config.Env at startup.",
+		"Unset variables fall back to the values below; required ones must be set.",
+	}}
+	for _, v := range defaults.EnvVars(service) {
+		data.Vars = append(data.Vars, templ.EnvVarData{Name: v.Name, Value: v.Default, Required: v.Required})
+	}
+	return templ.GenerateEnvExample(filepath.Join(servicePath, ".env.example"), data)
+}
+
+// generateServiceGoMod creates a go.mod file for the service.
+// transport selects the client library of brokers, listeners and outbox
+// services, empty for services that don't message. metrics adds the
+// Prometheus client of services generated WithMetrics, tracing the
+// OpenTelemetry SDK, OTLP exporter and otelhttp of services generated WithTracing.
+func generateServiceGoMod(servicePath, serviceName, templateID, transport string, metrics, tracing bool) error {
 	goModPath := filepath.Join(servicePath, "go.mod")
 
 	// Get default dependencies based on what the service uses
@@ -101,11 +266,7 @@ func generateServiceGoMod(servicePath, serviceName, templateID string) error {
 	// Add dependencies based on template type
 	switch templateID {
 	case "broker", "listener":
-		// RabbitMQ dependency
-		deps = append(deps, templ.Dependency{
-			Path:    "github.com/rabbitmq/amqp091-go",
-			Version: "v1.10.0",
-		})
+		deps = append(deps, transportDependency(transport))
 		if templateID == "broker" {
 			// UUID for correlation IDs
 			deps = append(deps, templ.Dependency{
@@ -113,12 +274,51 @@ func generateServiceGoMod(servicePath, serviceName, templateID string) error {
 				Version: "v1.6.0",
 			})
 		}
+	case "grpc":
+		deps = append(deps,
+			templ.Dependency{Path: "google.golang.org/grpc", Version: "v1.67.1"},
+			templ.Dependency{Path: "google.golang.org/protobuf", Version: "v1.35.1"},
+		)
+	case "worker":
+		deps = append(deps,
+			templ.Dependency{Path: "github.com/robfig/cron/v3", Version: "v3.0.1"},
+			// Backs the optional advisory-lock leader election
+			templ.Dependency{Path: "github.com/jackc/pgx/v5", Version: "v5.6.0"},
+		)
+	case "gateway":
+		// Gateways only need chi and cors
+	case "websocket":
+		deps = append(deps, templ.Dependency{Path: "github.com/gorilla/websocket", Version: "v1.5.3"})
+		// Bridged websocket services embed a listener consumer
+		if transport != "" {
+			deps = append(deps, transportDependency(transport))
+		}
 	default:
 		// Default services use postgres
 		deps = append(deps, templ.Dependency{
 			Path:    "github.com/jackc/pgx/v5",
 			Version: "v5.6.0",
 		})
+		// Outbox services embed a broker emitter
+		if transport != "" {
+			deps = append(deps, transportDependency(transport), templ.Dependency{
+				Path:    "github.com/google/uuid",
+				Version: "v1.6.0",
+			})
+		}
+	}
+
+	if metrics {
+		deps = append(deps, templ.Dependency{Path: "github.com/prometheus/client_golang", Version: "v1.20.5"})
+	}
+	if tracing {
+		deps = append(deps,
+			templ.Dependency{Path: "go.opentelemetry.io/otel", Version: "v1.31.0"},
+			templ.Dependency{Path: "go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp", Version: "v1.31.0"},
+			templ.Dependency{Path: "go.opentelemetry.io/otel/sdk", Version: "v1.31.0"},
+			templ.Dependency{Path: "go.opentelemetry.io/otel/trace", Version: "v1.31.0"},
+			templ.Dependency{Path: "go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp", Version: "v0.56.0"},
+		)
 	}
 
 	data := templ.GoModData{
@@ -130,6 +330,30 @@ func generateServiceGoMod(servicePath, serviceName, templateID string) error {
 	return templ.GenerateGoMod(goModPath, data)
 }
 
+// protoData returns the .proto skeleton data of a gRPC service
+func protoData(service *types.Service) templ.ProtoData {
+	return templ.ProtoData{
+		Name:      service.Name,
+		Package:   defaults.GRPCProtoPackage(service),
+		GoPackage: service.Name + "/" + defaults.GRPCPackageName,
+		GoName:    defaults.GRPCPackageName,
+		Service:   defaults.GRPCServiceName(service),
+		File:      defaults.GRPCProtoFile(service),
+	}
+}
+
+// transportDependency returns the client library of a message transport
+func transportDependency(transport string) templ.Dependency {
+	switch transport {
+	case defaults.TransportNATS:
+		return templ.Dependency{Path: "github.com/nats-io/nats.go", Version: "v1.37.0"}
+	case defaults.TransportKafka:
+		return templ.Dependency{Path: "github.com/segmentio/kafka-go", Version: "v0.4.47"}
+	default:
+		return templ.Dependency{Path: "github.com/rabbitmq/amqp091-go", Version: "v1.10.0"}
+	}
+}
+
 // updateLayerWithService adds a service to layer.json
 func updateLayerWithService(root string, service *types.Service) error {
 	layer := &config.Layer{Root: root}
@@ -139,8 +363,9 @@ func updateLayerWithService(root string, service *types.Service) error {
 		return fmt.Errorf("failed to reload layer.json: %w", err)
 	}
 
-	// Add the new service
+	// Add the new service, scanned again if it reuses a removed one's name
 	layer.Services = append(layer.Services, service)
+	layer.Removed = slices.DeleteFunc(layer.Removed, func(name string) bool { return name == service.Name })
 
 	// Save the updated layer
 	return layer.Update()
@@ -164,31 +389,361 @@ func regenerateDockerCompose(root string) error {
 
 	// Build docker-compose data
 	var serviceData []*templ.ServiceData
+	var infra []*templ.InfraData
+	var databases []string
+	seen := make(map[string]bool)
 	for _, svc := range layer.Services {
 		sd := &templ.ServiceData{
-			Name: svc.Name,
-			Port: svc.Port,
-			DB:   svc.DB,
+			Name:          svc.Name,
+			Port:          svc.Port,
+			ContainerPort: defaults.ContainerPort,
+			EnvFile:       defaults.ProjectEnvFile,
+			HealthCheck:   healthCheckURL(filepath.Join(root, svc.Name)),
+		}
+
+		// The same variables as the service's config.Env and .env.example,
+		// secrets interpolated from the project's .env
+		for _, v := range defaults.EnvVars(svc) {
+			sd.Environment = append(sd.Environment, v.Name+"="+v.ComposeValue())
+		}
+
+		// Generated clients read the base URL of the service they call
+		for _, to := range declaredTargets(layer, svc.Name) {
+			sd.Environment = append(sd.Environment, defaults.ClientBaseURLEnv(to)+"="+defaults.ClientBaseURL(to))
 		}
 
 		// Get dependencies for this service
 		deps := layer.GetServiceDependencies(svc.Name)
+
+		// Services depend on the containers of their transport and database
+		for _, inf := range serviceInfra(svc) {
+			if !seen[inf.Name] {
+				seen[inf.Name] = true
+				infra = append(infra, inf)
+			}
+			deps = append(deps, inf.Name)
+		}
+		if defaults.UsesPostgres(svc) {
+			databases = append(databases, defaults.DatabaseName(svc.Name))
+		}
 		sd.DependsOn = deps
 
+		// Images build from the project root, where go.mod's replaces resolve
+		if err := syncDockerfile(root, svc.Name); err != nil {
+			return fmt.Errorf("failed to generate the Dockerfile of %s: %w", svc.Name, err)
+		}
+
 		serviceData = append(serviceData, sd)
 	}
 
 	data := templ.DockerComposeData{
 		Name:     layer.Name,
 		Services: serviceData,
+		Infra:    infra,
 	}
 
 	dockerComposePath := filepath.Join(root, "docker-compose.yml")
-	return templ.GenerateDockerCompose(dockerComposePath, data)
+	if err := templ.GenerateDockerCompose(dockerComposePath, data); err != nil {
+		return err
+	}
+	if len(databases) > 0 {
+		initPath := filepath.Join(root, templ.PostgresInitDir, "init.sql")
+		if err := templ.GeneratePostgresInit(initPath, templ.PostgresInitData{Databases: databases}); err != nil {
+			return err
+		}
+	}
+
+	// The secrets docker-compose.yml interpolates
+	return syncProjectEnv(root, layer)
 }
 
-// promptServicePort prompts for service port
-func promptServicePort(defaultPort int) (int, error) {
+// syncDockerfile writes the Dockerfile of a service, copying the shared
+// modules its go.mod replaces into the build. Dockerfiles the user wrote,
+// without DockerfileHeader, and directories without go.mod are left alone.
+func syncDockerfile(root, name string) error {
+	content, err := os.ReadFile(filepath.Join(root, name, "go.mod"))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	dockerfilePath := filepath.Join(root, name, "Dockerfile")
+	if existing, err := os.ReadFile(dockerfilePath); err == nil && !strings.HasPrefix(string(existing), templ.DockerfileHeader) {
+		return nil
+	}
+
+	data := templ.DockerfileData{
+		Name:          name,
+		ContainerPort: defaults.ContainerPort,
+		Modules:       localReplaces(name, string(content)),
+	}
+	return templ.GenerateDockerfile(dockerfilePath, data)
+}
+
+// localReplacePattern matches the replace directives of a go.mod pointing
+// at a directory, in a replace block or not
+var localReplacePattern = regexp.MustCompile(`(?m)=>\s*(\.\.?/\S*)\s*$`)
+
+// localReplaces returns the directories, relative to the project root, of
+// the modules outside the service that its go.mod replaces, e.g. events or
+// libs/money. Directories outside the project can't be built and are skipped.
+func localReplaces(name, goMod string) []string {
+	var modules []string
+	for _, m := range localReplacePattern.FindAllStringSubmatch(goMod, -1) {
+		dir := path.Join(name, m[1])
+		if dir == "." || dir == ".." || strings.HasPrefix(dir, "../") || dir == name || strings.HasPrefix(dir, name+"/") {
+			continue
+		}
+		if !slices.Contains(modules, dir) {
+			modules = append(modules, dir)
+		}
+	}
+	slices.Sort(modules)
+	return modules
+}
+
+// serviceInfra returns the containers a service runs with in
+// docker-compose.yml: its message transport and the postgres server
+func serviceInfra(svc *types.Service) []*templ.InfraData {
+	var infra []*templ.InfraData
+	if svc.Messaging != nil {
+		if inf := templ.TransportInfra(defaults.TransportOf(svc)); inf != nil {
+			infra = append(infra, inf)
+		}
+	}
+	if defaults.UsesPostgres(svc) {
+		infra = append(infra, templ.PostgresInfra())
+	}
+	return infra
+}
+
+// healthCheckURL returns the readiness probe polled by a service's compose
+// healthcheck: the admin server of listeners and workers, the HTTP server of
+// every other template. Services generated without a health package get none.
+func healthCheckURL(servicePath string) string {
+	dir := filepath.Join(servicePath, defaults.HealthPackageName)
+	if _, err := os.Stat(filepath.Join(dir, "admin.go")); err == nil {
+		return fmt.Sprintf("http://localhost:%d%s", defaults.AdminPort, defaults.ReadyPath)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "health.go")); err == nil {
+		return "http://localhost" + defaults.ReadyPath
+	}
+	return ""
+}
+
+// sharedMessaging returns the messaging config of the first broker or listener
+// in the project, so new services default to the exchange already in use
+func sharedMessaging(layer *config.Layer) *types.Messaging {
+	for _, svc := range layer.Services {
+		if svc.Messaging != nil && svc.Messaging.Exchange != "" {
+			return svc.Messaging
+		}
+	}
+	return nil
+}
+
+// promptMessaging prompts for the exchange of a broker, plus queue, bindings
+// and durability for a listener
+func promptMessaging(templateID, serviceName string, shared *types.Messaging) (*types.Messaging, error) {
+	m := defaults.DefaultMessaging()
+	if shared != nil {
+		m.Transport = shared.Transport
+		m.Exchange = shared.Exchange
+		m.ExchangeType = shared.ExchangeType
+		m.Durable = shared.Durable
+	}
+
+	transport, err := promptChoice("Message transport", defaults.Transports, defaults.TransportOf(&types.Service{Messaging: m}))
+	if err != nil {
+		return nil, err
+	}
+	m.Transport = transport
+
+	exchange, err := promptString(fmt.Sprintf("Exchange name (default: %s)", m.Exchange), m.Exchange, nil)
+	if err != nil {
+		return nil, err
+	}
+	m.Exchange = strings.TrimSpace(exchange)
+
+	// NATS subjects and Kafka topics have no exchange kinds
+	if transport == defaults.TransportRabbitMQ {
+		exchangeType, err := promptExchangeType(m.ExchangeType)
+		if err != nil {
+			return nil, err
+		}
+		m.ExchangeType = exchangeType
+	}
+
+	if templateID == "broker" {
+		// Brokers only publish: no queue or bindings of their own
+		m.Bindings = nil
+		return m, nil
+	}
+
+	defaultQueue := serviceName + "-queue"
+	queue, err := promptString(fmt.Sprintf("Queue name (default: %s)", defaultQueue), defaultQueue, nil)
+	if err != nil {
+		return nil, err
+	}
+	m.Queue = strings.TrimSpace(queue)
+
+	var defaultTopics []string
+	for _, b := range m.Bindings {
+		defaultTopics = append(defaultTopics, b.Topic)
+	}
+	topics, err := promptString("Bindings (comma separated, topic[:retries[:delay]])", strings.Join(defaultTopics, ","), func(input string) error {
+		bindings, err := parseBindings(input)
+		if err != nil {
+			return err
+		}
+		return checkBindings(transport, bindings)
+	})
+	if err != nil {
+		return nil, err
+	}
+	m.Bindings, _ = parseBindings(topics)
+
+	durable := promptui.Prompt{
+		Label:     "Durable exchange and queue",
+		IsConfirm: true,
+		Default:   "y",
+	}
+	result, err := durable.Run()
+	m.Durable = err == nil && (result == "y" || result == "Y" || result == "")
+
+	return m, nil
+}
+
+// promptOutbox asks whether a DB service should emit events through a transactional outbox
+func promptOutbox() bool {
+	prompt := promptui.Prompt{
+		Label:     "Emit events through a transactional outbox",
+		IsConfirm: true,
+	}
+	result, err := prompt.Run()
+	return err == nil && (result == "y" || result == "Y")
+}
+
+// promptMetrics asks whether a service should be instrumented with Prometheus metrics
+func promptMetrics() bool {
+	prompt := promptui.Prompt{
+		Label:     "Expose Prometheus metrics on /metrics",
+		IsConfirm: true,
+	}
+	result, err := prompt.Run()
+	return err == nil && (result == "y" || result == "Y")
+}
+
+// promptTracing asks whether a service should be traced with OpenTelemetry
+func promptTracing() bool {
+	prompt := promptui.Prompt{
+		Label:     "Trace requests and events with OpenTelemetry",
+		IsConfirm: true,
+	}
+	result, err := prompt.Run()
+	return err == nil && (result == "y" || result == "Y")
+}
+
+// promptBridge asks whether a websocket service should forward broker events to its clients
+func promptBridge() bool {
+	prompt := promptui.Prompt{
+		Label:     "Bridge broker events to connected clients",
+		IsConfirm: true,
+	}
+	result, err := prompt.Run()
+	return err == nil && (result == "y" || result == "Y")
+}
+
+// promptLeaderLock asks whether a worker should lock its jobs through Postgres
+func promptLeaderLock() bool {
+	prompt := promptui.Prompt{
+		Label:     "Lock jobs with Postgres advisory locks so only one replica runs each",
+		IsConfirm: true,
+	}
+	result, err := prompt.Run()
+	return err == nil && (result == "y" || result == "Y")
+}
+
+// promptExchangeType prompts for the RabbitMQ exchange kind
+func promptExchangeType(defaultType string) (string, error) {
+	return promptChoice("Exchange type", defaults.ExchangeTypes, defaultType)
+}
+
+// promptChoice prompts for one of items, starting on defaultItem
+func promptChoice(label string, items []string, defaultItem string) (string, error) {
+	cursor := 0
+	for i, item := range items {
+		if item == defaultItem {
+			cursor = i
+		}
+	}
+
+	selector := promptui.Select{
+		Label:     label,
+		Items:     items,
+		CursorPos: cursor,
+	}
+
+	_, result, err := selector.Run()
+	if err != nil {
+		return "", fmt.Errorf("selection cancelled: %w", err)
+	}
+	return result, nil
+}
+
+// parseBindings splits a comma separated list of bindings in the form
+// topic[:retries[:delay]] (e.g. user.*:5:10s); omitted parts use the default retry policy
+func parseBindings(input string) ([]*types.Binding, error) {
+	var bindings []*types.Binding
+	for _, spec := range strings.Split(input, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+
+		parts := strings.Split(spec, ":")
+		if len(parts) > 3 {
+			return nil, fmt.Errorf("invalid binding %q, expected topic[:retries[:delay]]", spec)
+		}
+
+		binding := defaults.NewBinding(parts[0])
+		if len(parts) > 1 {
+			retries, err := strconv.Atoi(parts[1])
+			if err != nil || retries < 0 {
+				return nil, fmt.Errorf("invalid retry count %q for %s", parts[1], parts[0])
+			}
+			binding.MaxRetries = retries
+		}
+		if len(parts) > 2 {
+			delay, err := time.ParseDuration(parts[2])
+			if err != nil || delay < 0 {
+				return nil, fmt.Errorf("invalid retry delay %q for %s", parts[2], parts[0])
+			}
+			binding.RetryDelay = int(delay.Milliseconds())
+		}
+		bindings = append(bindings, binding)
+	}
+	return bindings, nil
+}
+
+// checkBindings rejects bindings the transport can't subscribe to: Kafka
+// consumer groups read whole topics, so routing key wildcards don't apply
+func checkBindings(transport string, bindings []*types.Binding) error {
+	if transport != defaults.TransportKafka {
+		return nil
+	}
+	for _, b := range bindings {
+		if strings.ContainsAny(b.Topic, "*#") {
+			return fmt.Errorf("kafka bindings can't use wildcards: %s", b.Topic)
+		}
+	}
+	return nil
+}
+
+// promptServicePort prompts for service port, refusing the ports in use
+func promptServicePort(defaultPort int, used map[int]string) (int, error) {
 	prompt := promptui.Prompt{
 		Label:   fmt.Sprintf("Service port (default: %d)", defaultPort),
 		Default: fmt.Sprintf("%d", defaultPort),
@@ -200,10 +755,7 @@ func promptServicePort(defaultPort int) (int, error) {
 			if _, err := fmt.Sscanf(input, "%d", &port); err != nil {
 				return fmt.Errorf("invalid port number")
 			}
-			if port < 1 || port > 65535 {
-				return fmt.Errorf("port must be between 1 and 65535")
-			}
-			return nil
+			return checkServicePort(port, used)
 		},
 	}
 
@@ -3,8 +3,11 @@ package cmd
 import (
 	"io"
 	"os"
+	"time"
 
 	"github.com/flaviogonzalez/instant-layer/internal/config"
+	defaults "github.com/flaviogonzalez/instant-layer/internal/default"
+	"github.com/flaviogonzalez/instant-layer/internal/types"
 	"github.com/spf13/cobra"
 )
 
@@ -21,6 +24,12 @@ func Do(args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) int
 	rootCmd.AddCommand(newCmd)
 	rootCmd.AddCommand(addCmd)
 	rootCmd.AddCommand(hydrateCmd)
+	rootCmd.AddCommand(docsCmd)
+	rootCmd.AddCommand(envCmd)
+	rootCmd.AddCommand(deployCmd)
+	rootCmd.AddCommand(removeCmd)
+	rootCmd.AddCommand(renameCmd)
+	rootCmd.AddCommand(checkCmd)
 	// unit-tests
 	rootCmd.SetIn(stdin)
 	rootCmd.SetOut(stdout)
@@ -66,7 +75,152 @@ var addServiceCmd = &cobra.Command{
 		if err != nil {
 			return nil
 		}
-		return SelectAndGenerateTemplate(dir)
+
+		port, _ := cmd.Flags().GetInt("port")
+		if spec, _ := cmd.Flags().GetString("from-openapi"); spec != "" {
+			return GenerateServiceFromOpenAPI(dir, spec, port)
+		}
+		return SelectAndGenerateTemplate(dir, port)
+	},
+}
+
+var addEventCmd = &cobra.Command{
+	Use:   "event <Name>",
+	Short: "creates a typed event contract shared by brokers and listeners",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dir, err := os.Getwd()
+		if err != nil {
+			return err
+		}
+
+		specs, _ := cmd.Flags().GetStringArray("field")
+		fields, err := parseEventFields(specs)
+		if err != nil {
+			return err
+		}
+
+		topic, _ := cmd.Flags().GetString("topic")
+		version, _ := cmd.Flags().GetInt("version")
+
+		return GenerateEvent(dir, &types.Event{
+			Name:    args[0],
+			Topic:   topic,
+			Version: version,
+			Fields:  fields,
+		})
+	},
+}
+
+var addClientCmd = &cobra.Command{
+	Use:   "client",
+	Short: "creates a typed HTTP client of a service in another service",
+	Args:  cobra.ExactArgs(0),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dir, err := os.Getwd()
+		if err != nil {
+			return err
+		}
+
+		from, _ := cmd.Flags().GetString("from")
+		to, _ := cmd.Flags().GetString("to")
+		return GenerateClient(dir, from, to)
+	},
+}
+
+var addLibCmd = &cobra.Command{
+	Use:   "lib <name>",
+	Short: "creates a shared library module the services import through the go.work workspace",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dir, err := os.Getwd()
+		if err != nil {
+			return err
+		}
+
+		services, _ := cmd.Flags().GetStringSlice("service")
+		return GenerateLib(dir, args[0], services)
+	},
+}
+
+var addJobCmd = &cobra.Command{
+	Use:   "job <name>",
+	Short: "creates a scheduled job in a worker service",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dir, err := os.Getwd()
+		if err != nil {
+			return err
+		}
+
+		service, _ := cmd.Flags().GetString("service")
+		schedule, _ := cmd.Flags().GetString("schedule")
+		timeout, _ := cmd.Flags().GetDuration("timeout")
+
+		return GenerateJob(dir, service, &types.Job{
+			Name:     args[0],
+			Schedule: schedule,
+			Timeout:  int(timeout.Round(time.Second) / time.Second),
+		})
+	},
+}
+
+var removeCmd = &cobra.Command{
+	Use:   "remove",
+	Short: "removes a service",
+}
+
+var removeServiceCmd = &cobra.Command{
+	Use:   "service <name>",
+	Short: "removes a service from the project, layer.json, go.work and docker-compose.yml",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dir, err := os.Getwd()
+		if err != nil {
+			return err
+		}
+
+		keepFiles, _ := cmd.Flags().GetBool("keep-files")
+		yes, _ := cmd.Flags().GetBool("yes")
+		return RemoveService(dir, args[0], keepFiles, yes, cmd.OutOrStdout())
+	},
+}
+
+var renameCmd = &cobra.Command{
+	Use:   "rename",
+	Short: "renames a service",
+}
+
+var renameServiceCmd = &cobra.Command{
+	Use:   "service <old-name> <new-name>",
+	Short: "renames a service, its module, imports and the URLs other services call it at",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dir, err := os.Getwd()
+		if err != nil {
+			return err
+		}
+
+		return RenameService(dir, args[0], args[1])
+	},
+}
+
+var checkCmd = &cobra.Command{
+	Use:   "check",
+	Short: "checks the project for conflicts",
+}
+
+var checkPortsCmd = &cobra.Command{
+	Use:   "ports",
+	Short: "finds host ports used twice in layer.json and docker-compose.yml, infra containers included",
+	Args:  cobra.ExactArgs(0),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dir, err := os.Getwd()
+		if err != nil {
+			return err
+		}
+
+		return CheckPorts(dir, cmd.OutOrStdout())
 	},
 }
 
@@ -95,10 +249,193 @@ var hydrateCmd = &cobra.Command{
 		if err != nil {
 			return err
 		}
-		return nil
+
+		// Rescanned services are the modules of the workspace
+		if err := syncGoWork(l); err != nil {
+			return err
+		}
+
+		// Rescanned routes feed the gateways' route tables
+		return regenerateGateways(l)
+	},
+}
+
+var docsCmd = &cobra.Command{
+	Use:   "docs",
+	Short: "generates project documentation",
+}
+
+var docsAsyncAPICmd = &cobra.Command{
+	Use:   "asyncapi",
+	Short: "generates an AsyncAPI document of the project's channels, publishers and subscribers",
+	Args:  cobra.ExactArgs(0),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dir, err := os.Getwd()
+		if err != nil {
+			return err
+		}
+
+		output, _ := cmd.Flags().GetString("output")
+		return GenerateAsyncAPI(dir, output)
+	},
+}
+
+var docsOpenAPICmd = &cobra.Command{
+	Use:   "openapi [service]",
+	Short: "generates OpenAPI specs of the services' HTTP routes",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dir, err := os.Getwd()
+		if err != nil {
+			return err
+		}
+
+		service := ""
+		if len(args) == 1 {
+			service = args[0]
+		}
+		output, _ := cmd.Flags().GetString("output")
+		serve, _ := cmd.Flags().GetBool("serve")
+		return GenerateOpenAPI(dir, service, output, serve)
+	},
+}
+
+var deployCmd = &cobra.Command{
+	Use:   "deploy",
+	Short: "generates deployment manifests of the project's services",
+}
+
+var deployK8sCmd = &cobra.Command{
+	Use:   "k8s",
+	Short: "generates Kubernetes manifests with kustomize overlays for dev and prod",
+	Args:  cobra.ExactArgs(0),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dir, err := os.Getwd()
+		if err != nil {
+			return err
+		}
+
+		output, _ := cmd.Flags().GetString("output")
+		host, _ := cmd.Flags().GetString("ingress-host")
+		registry, _ := cmd.Flags().GetString("registry")
+		return GenerateK8s(dir, output, host, registry)
+	},
+}
+
+var deployHelmCmd = &cobra.Command{
+	Use:   "helm",
+	Short: "generates a Helm umbrella chart with one subchart per service",
+	Args:  cobra.ExactArgs(0),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dir, err := os.Getwd()
+		if err != nil {
+			return err
+		}
+
+		output, _ := cmd.Flags().GetString("output")
+		registry, _ := cmd.Flags().GetString("registry")
+		return GenerateHelm(dir, output, registry)
+	},
+}
+
+var envCmd = &cobra.Command{
+	Use:   "env",
+	Short: "manages the project's .env, holding the secrets docker-compose.yml interpolates",
+}
+
+var envSetCmd = &cobra.Command{
+	Use:   "set KEY=value...",
+	Short: "sets variables of the project's .env",
+	Args:  cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dir, err := os.Getwd()
+		if err != nil {
+			return err
+		}
+
+		return SetEnv(dir, args, cmd.OutOrStdout())
+	},
+}
+
+var envGetCmd = &cobra.Command{
+	Use:   "get KEY",
+	Short: "prints a variable of the project's .env",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dir, err := os.Getwd()
+		if err != nil {
+			return err
+		}
+
+		return GetEnv(dir, args[0], cmd.OutOrStdout())
+	},
+}
+
+var envListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "lists the variables of the project's .env",
+	Args:  cobra.ExactArgs(0),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dir, err := os.Getwd()
+		if err != nil {
+			return err
+		}
+
+		return ListEnv(dir, cmd.OutOrStdout())
 	},
 }
 
 func init() {
 	addCmd.AddCommand(addServiceCmd)
+	addCmd.AddCommand(addEventCmd)
+	addCmd.AddCommand(addClientCmd)
+	addCmd.AddCommand(addLibCmd)
+	addCmd.AddCommand(addJobCmd)
+
+	addServiceCmd.Flags().Int("port", 0, "host port of the service (default: the first free port from the template's)")
+	addServiceCmd.Flags().String("from-openapi", "", "OpenAPI 3 spec (YAML or JSON) to generate the service's routes, models and handlers from")
+
+	addClientCmd.Flags().String("from", "", "service calling the client")
+	addClientCmd.Flags().String("to", "", "service the client calls, generated from its routes")
+	addClientCmd.MarkFlagRequired("from")
+	addClientCmd.MarkFlagRequired("to")
+
+	addLibCmd.Flags().StringSlice("service", nil, "services requiring the library (repeatable or comma-separated)")
+
+	addJobCmd.Flags().String("service", "", "worker service running the job")
+	addJobCmd.Flags().String("schedule", "", "cron expression, descriptor or interval, e.g. \"*/5 * * * *\", @hourly, \"@every 30s\"")
+	addJobCmd.Flags().Duration("timeout", time.Duration(defaults.DefaultJobTimeout)*time.Second, "time a run may take before its context is cancelled")
+	addJobCmd.MarkFlagRequired("service")
+	addJobCmd.MarkFlagRequired("schedule")
+
+	addEventCmd.Flags().StringArray("field", nil, "event field as name:type (repeatable), e.g. --field user_id:string")
+	addEventCmd.Flags().String("topic", "", "wire name and routing key (default: derived from the name, e.g. user.created)")
+	addEventCmd.Flags().Int("version", 1, "schema version of the event")
+
+	removeCmd.AddCommand(removeServiceCmd)
+	renameCmd.AddCommand(renameServiceCmd)
+	checkCmd.AddCommand(checkPortsCmd)
+
+	removeServiceCmd.Flags().Bool("keep-files", false, "keep the service's directory, only removing it from the project")
+	removeServiceCmd.Flags().BoolP("yes", "y", false, "remove without asking for confirmation")
+
+	envCmd.AddCommand(envSetCmd)
+	envCmd.AddCommand(envGetCmd)
+	envCmd.AddCommand(envListCmd)
+
+	deployCmd.AddCommand(deployK8sCmd)
+	deployCmd.AddCommand(deployHelmCmd)
+
+	deployK8sCmd.Flags().StringP("output", "o", "", "output directory (default: deploy/k8s at the project root)")
+	deployK8sCmd.Flags().String("ingress-host", "", "host of an Ingress to the gateway, or to <service>.<host> of each HTTP service")
+	deployK8sCmd.Flags().String("registry", "", "registry prefixed to the images, e.g. ghcr.io/acme")
+	deployHelmCmd.Flags().StringP("output", "o", "", "output directory (default: deploy/helm at the project root)")
+	deployHelmCmd.Flags().String("registry", "", "registry prefixed to the images, e.g. ghcr.io/acme")
+
+	docsCmd.AddCommand(docsAsyncAPICmd)
+	docsCmd.AddCommand(docsOpenAPICmd)
+
+	docsAsyncAPICmd.Flags().StringP("output", "o", "", "output file (default: asyncapi.yaml at the project root)")
+	docsOpenAPICmd.Flags().StringP("output", "o", "", "output file of a single service (default: openapi.json in the service directory)")
+	docsOpenAPICmd.Flags().Bool("serve", false, "serve the spec and a Swagger UI page from the service at "+defaults.DocsPath)
 }
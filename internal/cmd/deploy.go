@@ -0,0 +1,156 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/flaviogonzalez/instant-layer/internal/config"
+	defaults "github.com/flaviogonzalez/instant-layer/internal/default"
+	"github.com/flaviogonzalez/instant-layer/internal/templ"
+	"github.com/flaviogonzalez/instant-layer/internal/types"
+)
+
+// GenerateK8s writes the Kubernetes manifests of every service in layer.json:
+// a kustomize base with dev and prod overlays. output defaults to deploy/k8s
+// at the project root; an Ingress is generated when ingressHost is set.
+func GenerateK8s(root, output, ingressHost, registry string) error {
+	layer, err := deployedLayer(root)
+	if err != nil {
+		return err
+	}
+
+	if output == "" {
+		output = filepath.Join(layer.Root, "deploy", "k8s")
+	}
+
+	if err := templ.GenerateK8s(output, buildK8s(layer, ingressHost, registry)); err != nil {
+		return fmt.Errorf("failed to write Kubernetes manifests: %w", err)
+	}
+
+	fmt.Printf("Kubernetes manifests written to %s\n", output)
+	fmt.Printf("  kubectl apply -k %s\n", filepath.Join(output, "overlays", "dev"))
+	return nil
+}
+
+// GenerateHelm writes a Helm chart of the project: an umbrella chart with one
+// subchart per service in layer.json, configured by the same values as the
+// Kubernetes manifests. output defaults to deploy/helm at the project root.
+func GenerateHelm(root, output, registry string) error {
+	layer, err := deployedLayer(root)
+	if err != nil {
+		return err
+	}
+
+	if output == "" {
+		output = filepath.Join(layer.Root, "deploy", "helm")
+	}
+
+	if err := templ.GenerateHelm(output, buildK8s(layer, "", registry)); err != nil {
+		return fmt.Errorf("failed to write Helm chart: %w", err)
+	}
+
+	fmt.Printf("Helm chart written to %s\n", output)
+	fmt.Printf("  helm install %s %s\n", layer.Name, output)
+	return nil
+}
+
+// deployedLayer loads the layer.json of the project containing root, which
+// must have services to deploy
+func deployedLayer(root string) (*config.Layer, error) {
+	layerRoot, err := config.FindLayerRoot(root)
+	if err != nil {
+		return nil, fmt.Errorf("layer.json not found. Run 'layer new' first to create a project")
+	}
+
+	layer := &config.Layer{Root: layerRoot}
+	if err := layer.Reload(); err != nil {
+		return nil, fmt.Errorf("failed to reload layer.json: %w", err)
+	}
+	if len(layer.Services) == 0 {
+		return nil, fmt.Errorf("no services in layer.json. Run 'layer add service' first")
+	}
+	return layer, nil
+}
+
+// buildK8s maps the services of layer.json to Deployments configured by the
+// same variables as their config.Env: secrets in a Secret, the rest in a
+// ConfigMap. Services are reached at http://<name>, as in docker-compose.yml.
+func buildK8s(layer *config.Layer, ingressHost, registry string) templ.K8sData {
+	data := templ.K8sData{Name: layer.Name}
+	gateway := ""
+	for _, svc := range layer.Services {
+		if svc.Template == "gateway" {
+			gateway = svc.Name
+		}
+	}
+
+	for _, svc := range layer.Services {
+		sd := &templ.K8sServiceData{
+			Name:     svc.Name,
+			Image:    layer.Name + "/" + svc.Name,
+			Replicas: max(svc.Replicas, 1),
+			Probe:    k8sProbe(filepath.Join(layer.Root, svc.Name), svc),
+		}
+		if registry != "" {
+			sd.Image = registry + "/" + sd.Image
+		}
+		if svc.Port != 0 {
+			sd.Port = defaults.ContainerPort
+			sd.Protocol = "http"
+			if svc.Template == "grpc" {
+				sd.Protocol = "grpc"
+			}
+		}
+		if sd.Probe != nil && sd.Probe.Port == defaults.AdminPort {
+			sd.AdminPort = defaults.AdminPort
+		}
+
+		for _, v := range defaults.EnvVars(svc) {
+			value := templ.EnvVarData{Name: v.Name, Value: v.Default, Required: v.Required}
+			switch {
+			case v.Secret:
+				sd.Secrets = append(sd.Secrets, value)
+			case svc.Messaging != nil && v.Name == defaults.TransportEnv(svc):
+				// Transport addresses differ per cluster: set by each overlay
+				sd.External = append(sd.External, value)
+				sd.Config = append(sd.Config, value)
+			default:
+				sd.Config = append(sd.Config, value)
+			}
+		}
+		for _, to := range declaredTargets(layer, svc.Name) {
+			sd.Config = append(sd.Config, templ.EnvVarData{Name: defaults.ClientBaseURLEnv(to), Value: defaults.ClientBaseURL(to)})
+		}
+		data.Services = append(data.Services, sd)
+
+		// The gateway fronts every route; without one, each HTTP service gets a subdomain
+		if ingressHost == "" || sd.Protocol != "http" {
+			continue
+		}
+		switch gateway {
+		case "":
+			data.Ingress = append(data.Ingress, &templ.K8sIngressRule{Host: svc.Name + "." + ingressHost, Service: svc.Name})
+		case svc.Name:
+			data.Ingress = append(data.Ingress, &templ.K8sIngressRule{Host: ingressHost, Service: svc.Name})
+		}
+	}
+	return data
+}
+
+// k8sProbe returns where a service serves its liveness and readiness probes,
+// like healthCheckURL: the admin server of listeners and workers, the health
+// service of gRPC servers, the HTTP server of every other template
+func k8sProbe(servicePath string, svc *types.Service) *templ.K8sProbe {
+	dir := filepath.Join(servicePath, defaults.HealthPackageName)
+	if _, err := os.Stat(filepath.Join(dir, "admin.go")); err == nil {
+		return &templ.K8sProbe{Port: defaults.AdminPort, LivePath: defaults.LivePath, ReadyPath: defaults.ReadyPath}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "health.go")); err == nil {
+		return &templ.K8sProbe{Port: defaults.ContainerPort, LivePath: defaults.LivePath, ReadyPath: defaults.ReadyPath}
+	}
+	if svc.Template == "grpc" && svc.Port != 0 {
+		return &templ.K8sProbe{Port: defaults.ContainerPort, GRPC: true}
+	}
+	return nil
+}
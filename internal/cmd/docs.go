@@ -0,0 +1,253 @@
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/flaviogonzalez/instant-layer/internal/config"
+	defaults "github.com/flaviogonzalez/instant-layer/internal/default"
+	"github.com/flaviogonzalez/instant-layer/internal/templ"
+	"github.com/flaviogonzalez/instant-layer/internal/types"
+)
+
+// genericMessage documents topics without a typed event contract
+const genericMessage = "EventPayload"
+
+// asyncAPIIDInvalid matches the characters AsyncAPI doesn't allow in component keys
+var asyncAPIIDInvalid = regexp.MustCompile(`[^A-Za-z0-9_-]`)
+
+// transportServers are the AsyncAPI servers of the compose infra containers
+var transportServers = map[string]*templ.AsyncAPIServer{
+	defaults.TransportRabbitMQ: {Name: defaults.TransportRabbitMQ, Host: "rabbitmq:5672", Protocol: "amqp"},
+	defaults.TransportNATS:     {Name: defaults.TransportNATS, Host: "nats:4222", Protocol: "nats"},
+	defaults.TransportKafka:    {Name: defaults.TransportKafka, Host: "kafka:9092", Protocol: "kafka"},
+}
+
+// GenerateAsyncAPI writes an AsyncAPI document describing the channels, publishers
+// and subscribers of every broker and listener in the project.
+// output defaults to asyncapi.yaml at the project root.
+func GenerateAsyncAPI(root, output string) error {
+	layerRoot, err := config.FindLayerRoot(root)
+	if err != nil {
+		return fmt.Errorf("layer.json not found. Run 'layer new' first to create a project")
+	}
+
+	layer := &config.Layer{Root: layerRoot}
+	if err := layer.Reload(); err != nil {
+		return fmt.Errorf("failed to reload layer.json: %w", err)
+	}
+
+	if output == "" {
+		output = filepath.Join(layerRoot, "asyncapi.yaml")
+	}
+
+	if err := templ.GenerateAsyncAPI(output, buildAsyncAPI(layer)); err != nil {
+		return fmt.Errorf("failed to write AsyncAPI document: %w", err)
+	}
+
+	fmt.Printf("AsyncAPI document written to %s\n", output)
+	return nil
+}
+
+// buildAsyncAPI maps the exchanges, bindings and event contracts of layer.json
+// to AsyncAPI channels: brokers send every typed event, listeners receive their bindings
+func buildAsyncAPI(layer *config.Layer) templ.AsyncAPIData {
+	data := templ.AsyncAPIData{
+		Title:       layer.Name + " events",
+		Version:     "1.0.0",
+		Description: fmt.Sprintf("Event catalogue of the %s project, generated from layer.json", layer.Name),
+	}
+
+	for _, ev := range layer.Events {
+		data.Messages = append(data.Messages, &templ.AsyncAPIMessage{
+			ID:      ev.Name,
+			Name:    eventWireName(ev),
+			Title:   ev.Name,
+			Version: ev.Version,
+			Const:   true,
+			Schema:  ev.Name,
+		})
+		data.Schemas = append(data.Schemas, eventSchema(ev))
+	}
+
+	channels := make(map[string]*templ.AsyncAPIChannel)
+	servers := make(map[string]bool)
+	generic := false
+
+	// channel returns the channel of a topic on a service's exchange, creating it once
+	channel := func(svc *types.Service, topic string) *templ.AsyncAPIChannel {
+		m := svc.Messaging
+		if m == nil {
+			m = defaults.DefaultMessaging()
+		}
+		transport := defaults.TransportOf(svc)
+
+		key := transport + "|" + m.Exchange + "|" + topic
+		if ch, ok := channels[key]; ok {
+			return ch
+		}
+
+		id := m.Exchange + "." + topic
+		if transport != defaults.TransportRabbitMQ {
+			id = transport + "." + id
+		}
+		ch := &templ.AsyncAPIChannel{
+			ID:          asyncAPIID(id),
+			Address:     defaults.TopicAddress(svc, topic),
+			Description: fmt.Sprintf("Topic %s of the %s exchange", topic, m.Exchange),
+			Server:      transport,
+		}
+		if transport == defaults.TransportRabbitMQ {
+			ch.Exchange = m.Exchange
+			ch.ExchangeType = m.ExchangeType
+			if ch.ExchangeType == "" {
+				ch.ExchangeType = "topic"
+			}
+			ch.Durable = m.Durable
+		}
+		for _, ev := range layer.Events {
+			if routingKeyMatches(topic, eventWireName(ev)) {
+				ch.Messages = append(ch.Messages, ev.Name)
+			}
+		}
+		if len(ch.Messages) == 0 {
+			ch.Messages = []string{genericMessage}
+			generic = true
+		}
+
+		channels[key] = ch
+		servers[transport] = true
+		data.Channels = append(data.Channels, ch)
+		return ch
+	}
+
+	for _, svc := range layer.Services {
+		switch serviceRole(filepath.Join(layer.Root, svc.Name), svc) {
+		case "broker":
+			for _, ev := range layer.Events {
+				ch := channel(svc, eventWireName(ev))
+				data.Operations = append(data.Operations, &templ.AsyncAPIOperation{
+					ID:       asyncAPIID(svc.Name + "_send_" + ch.ID),
+					Action:   "send",
+					Channel:  ch.ID,
+					Summary:  fmt.Sprintf("%s publishes %s", svc.Name, eventWireName(ev)),
+					Messages: []string{ev.Name},
+				})
+			}
+		case "listener":
+			listener := *svc
+			listener.Events = layer.Events
+			for _, b := range defaults.MessagingBindings(&listener) {
+				ch := channel(svc, b.Topic)
+				data.Operations = append(data.Operations, &templ.AsyncAPIOperation{
+					ID:       asyncAPIID(svc.Name + "_receive_" + ch.ID),
+					Action:   "receive",
+					Channel:  ch.ID,
+					Summary:  fmt.Sprintf("%s consumes %s, retrying %d times before dead-lettering", svc.Name, b.Topic, b.MaxRetries),
+					Messages: ch.Messages,
+				})
+			}
+		}
+	}
+
+	for _, t := range defaults.Transports {
+		if servers[t] {
+			data.Servers = append(data.Servers, transportServers[t])
+		}
+	}
+
+	if generic {
+		data.Messages = append(data.Messages, &templ.AsyncAPIMessage{
+			ID:    genericMessage,
+			Name:  genericMessage,
+			Title: "Untyped event",
+		})
+	}
+
+	return data
+}
+
+// eventWireName returns the name an event travels under
+func eventWireName(ev *types.Event) string {
+	if ev.Topic != "" {
+		return ev.Topic
+	}
+	return defaults.EventTopic(ev.Name)
+}
+
+// eventSchema returns the JSON schema of an event's fields
+func eventSchema(ev *types.Event) *templ.AsyncAPISchema {
+	schema := &templ.AsyncAPISchema{ID: ev.Name}
+	for _, f := range ev.Fields {
+		name := f.JSON
+		if name == "" {
+			name = f.Name
+		}
+		prop := jsonSchemaType(f.Type)
+		prop.Name = name
+		schema.Properties = append(schema.Properties, prop)
+	}
+	return schema
+}
+
+// jsonSchemaType maps an event field type to its JSON schema type and format
+func jsonSchemaType(typ string) *templ.SchemaProperty {
+	switch typ {
+	case "string":
+		return &templ.SchemaProperty{Type: "string"}
+	case "bool":
+		return &templ.SchemaProperty{Type: "boolean"}
+	case "int", "int64":
+		return &templ.SchemaProperty{Type: "integer", Format: "int64"}
+	case "int32":
+		return &templ.SchemaProperty{Type: "integer", Format: "int32"}
+	case "float32":
+		return &templ.SchemaProperty{Type: "number", Format: "float"}
+	case "float64":
+		return &templ.SchemaProperty{Type: "number", Format: "double"}
+	case "time.Time":
+		return &templ.SchemaProperty{Type: "string", Format: "date-time"}
+	case "[]byte":
+		return &templ.SchemaProperty{Type: "string", Format: "byte"}
+	case "[]string":
+		return &templ.SchemaProperty{Type: "array", Items: "string"}
+	case "[]int":
+		return &templ.SchemaProperty{Type: "array", Items: "integer"}
+	default:
+		// json.RawMessage: any JSON value
+		return &templ.SchemaProperty{}
+	}
+}
+
+// asyncAPIID turns a name into a valid AsyncAPI component key:
+// "*" and "#" wildcards are spelled out, other invalid characters become "_"
+func asyncAPIID(name string) string {
+	name = strings.NewReplacer(".", "_", "*", "any", "#", "all").Replace(name)
+	return asyncAPIIDInvalid.ReplaceAllString(name, "_")
+}
+
+// routingKeyMatches reports whether a topic exchange binding pattern matches
+// a routing key: "*" matches exactly one word, "#" zero or more words
+func routingKeyMatches(pattern, key string) bool {
+	return matchRoutingWords(strings.Split(pattern, "."), strings.Split(key, "."))
+}
+
+func matchRoutingWords(pattern, key []string) bool {
+	if len(pattern) == 0 {
+		return len(key) == 0
+	}
+	if pattern[0] == "#" {
+		for i := 0; i <= len(key); i++ {
+			if matchRoutingWords(pattern[1:], key[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(key) == 0 || (pattern[0] != "*" && pattern[0] != key[0]) {
+		return false
+	}
+	return matchRoutingWords(pattern[1:], key[1:])
+}
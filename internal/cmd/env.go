@@ -0,0 +1,204 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/flaviogonzalez/instant-layer/internal/config"
+	defaults "github.com/flaviogonzalez/instant-layer/internal/default"
+	"github.com/flaviogonzalez/instant-layer/internal/templ"
+)
+
+// syncProjectEnv writes the project's .env.example and adds the variables
+// the services need to .env without touching values already set. .env holds
+// the secrets docker-compose.yml interpolates, so it is gitignored.
+func syncProjectEnv(root string, layer *config.Layer) error {
+	vars := defaults.ProjectEnvVars(layer.Services)
+
+	data := templ.EnvExampleData{Header: []string{
+		"Secrets of " + layer.Name + ", interpolated into docker-compose.yml and passed to every container.",
+		"Copy to " + defaults.ProjectEnvFile + " (gitignored) and edit it with: layer env set KEY=value",
+	}}
+	for _, svc := range layer.Services {
+		if defaults.UsesPostgres(svc) {
+			data.Header = append(data.Header,
+				"Database URLs default to the "+defaults.PostgresHost+" container of docker-compose.yml, one database per service, logged in as "+defaults.PostgresUserEnv+".",
+				"Change them with: layer env set "+defaults.PostgresUserEnv+"=... "+defaults.PostgresPasswordEnv+"=... (updates the URLs; postgres applies them when its volume is first created)",
+			)
+			break
+		}
+	}
+	for _, v := range vars {
+		data.Vars = append(data.Vars, templ.EnvVarData{Name: v.Name, Value: v.Default, Required: v.Required})
+	}
+	if err := templ.GenerateEnvExample(filepath.Join(root, defaults.ProjectEnvFile+".example"), data); err != nil {
+		return fmt.Errorf("failed to generate .env.example: %w", err)
+	}
+
+	env, err := config.ReadDotEnv(filepath.Join(root, defaults.ProjectEnvFile))
+	if err != nil {
+		return err
+	}
+	// Database URLs of the postgres container log in with its credentials
+	user, password := postgresCredentials(env)
+	for _, v := range vars {
+		if _, ok := env.Get(v.Name); ok {
+			continue
+		}
+		value := v.Default
+		for _, svc := range layer.Services {
+			if defaults.UsesPostgres(svc) && v.Name == defaults.DatabaseURLEnv(svc.Name) {
+				value = defaults.PostgresURL(user, password, svc.Name)
+			}
+		}
+		env.Set(v.Name, value)
+	}
+	if err := env.Save(); err != nil {
+		return err
+	}
+
+	return ensureGitignored(root, defaults.ProjectEnvFile)
+}
+
+// postgresCredentials returns the user and password of the postgres container
+// set in the project's .env, or their defaults
+func postgresCredentials(env *config.DotEnv) (string, string) {
+	user, ok := env.Get(defaults.PostgresUserEnv)
+	if !ok {
+		user = defaults.DefaultPostgresUser
+	}
+	password, ok := env.Get(defaults.PostgresPasswordEnv)
+	if !ok {
+		password = defaults.DefaultPostgresPassword
+	}
+	return user, password
+}
+
+// ensureGitignored adds an entry to the project's .gitignore, creating it if needed
+func ensureGitignored(root, entry string) error {
+	path := filepath.Join(root, ".gitignore")
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.TrimSpace(line) == entry || strings.TrimSpace(line) == "/"+entry {
+			return nil
+		}
+	}
+
+	content := string(data)
+	if content != "" && !strings.HasSuffix(content, "\n") {
+		content += "\n"
+	}
+	return os.WriteFile(path, []byte(content+entry+"\n"), 0644)
+}
+
+// projectEnv reads the .env at the root of the project containing dir
+func projectEnv(dir string) (*config.DotEnv, error) {
+	root, err := config.FindLayerRoot(dir)
+	if err != nil {
+		return nil, fmt.Errorf("layer.json not found. Run 'layer new' first to create a project")
+	}
+	return config.ReadDotEnv(filepath.Join(root, defaults.ProjectEnvFile))
+}
+
+// SetEnv sets KEY=value pairs in the project's .env
+func SetEnv(dir string, pairs []string, w io.Writer) error {
+	env, err := projectEnv(dir)
+	if err != nil {
+		return err
+	}
+
+	// Validate every pair before writing any
+	values := make([][2]string, 0, len(pairs))
+	for _, pair := range pairs {
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return fmt.Errorf("invalid %q: expected KEY=value", pair)
+		}
+		if !config.ValidEnvKey(key) {
+			return fmt.Errorf("invalid variable name %q", key)
+		}
+		values = append(values, [2]string{key, value})
+	}
+
+	layer := &config.Layer{Root: filepath.Dir(env.Path)}
+	if err := layer.Reload(); err != nil {
+		return fmt.Errorf("failed to reload layer.json: %w", err)
+	}
+	before := derivedDatabaseURLs(layer, env)
+
+	set := make(map[string]bool)
+	for _, kv := range values {
+		env.Set(kv[0], kv[1])
+		set[kv[0]] = true
+	}
+
+	// Database URLs still derived from the postgres credentials follow them
+	var followed []string
+	for key, url := range derivedDatabaseURLs(layer, env) {
+		if value, ok := env.Get(key); ok && !set[key] && value == before[key] && value != url {
+			env.Set(key, url)
+			followed = append(followed, key)
+		}
+	}
+	sort.Strings(followed)
+
+	if err := env.Save(); err != nil {
+		return err
+	}
+	for _, kv := range values {
+		fmt.Fprintf(w, "Set %s in %s\n", kv[0], defaults.ProjectEnvFile)
+	}
+	for _, key := range followed {
+		fmt.Fprintf(w, "Updated %s to the postgres credentials\n", key)
+	}
+	return nil
+}
+
+// derivedDatabaseURLs returns the database URLs of the services using the
+// postgres container, logged in with the credentials set in .env
+func derivedDatabaseURLs(layer *config.Layer, env *config.DotEnv) map[string]string {
+	user, password := postgresCredentials(env)
+	urls := make(map[string]string)
+	for _, svc := range layer.Services {
+		if defaults.UsesPostgres(svc) {
+			urls[defaults.DatabaseURLEnv(svc.Name)] = defaults.PostgresURL(user, password, svc.Name)
+		}
+	}
+	return urls
+}
+
+// GetEnv prints the value of a variable of the project's .env
+func GetEnv(dir, key string, w io.Writer) error {
+	env, err := projectEnv(dir)
+	if err != nil {
+		return err
+	}
+
+	value, ok := env.Get(key)
+	if !ok {
+		return fmt.Errorf("%s is not set in %s", key, defaults.ProjectEnvFile)
+	}
+	fmt.Fprintln(w, value)
+	return nil
+}
+
+// ListEnv prints the variables of the project's .env as KEY=value
+func ListEnv(dir string, w io.Writer) error {
+	env, err := projectEnv(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, key := range env.Keys() {
+		value, _ := env.Get(key)
+		fmt.Fprintf(w, "%s=%s\n", key, value)
+	}
+	return nil
+}
@@ -0,0 +1,430 @@
+package cmd
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/flaviogonzalez/instant-layer/internal/config"
+	defaults "github.com/flaviogonzalez/instant-layer/internal/default"
+	"github.com/flaviogonzalez/instant-layer/internal/types"
+	"gopkg.in/yaml.v3"
+)
+
+// componentSchemaRef prefixes the $ref of a component schema
+const componentSchemaRef = "#/components/schemas/"
+
+// openAPIMethods are the operations mapped to routes, in generation order
+var openAPIMethods = []string{"get", "post", "put", "patch", "delete", "options"}
+
+// modelTypeName matches the type names a Go type expression starts with after [] * or map[K]
+var modelTypeName = regexp.MustCompile(`(^|[\]*])([A-Z]\w*)`)
+
+// GenerateServiceFromOpenAPI creates an HTTP service from an OpenAPI spec (YAML or JSON):
+// operations become routes grouped by tag or path prefix, schemas become the models
+// package and handlers decode and encode the typed bodies.
+func GenerateServiceFromOpenAPI(root, specPath string, port int) error {
+	layerRoot, err := config.FindLayerRoot(root)
+	if err != nil {
+		return fmt.Errorf("layer.json not found. Run 'layer new' first to create a project")
+	}
+
+	doc, err := readOpenAPI(specPath)
+	if err != nil {
+		return err
+	}
+
+	defaultName := strings.ReplaceAll(defaults.SnakeCase(doc.Info.Title), "_", "-")
+	if defaultName == "" {
+		defaultName = "api-service"
+	}
+	serviceName, err := promptString(fmt.Sprintf("Service name (default: %s)", defaultName), defaultName, nil)
+	if err != nil {
+		return err
+	}
+
+	layer := &config.Layer{Root: layerRoot}
+	if err := layer.Reload(); err != nil {
+		return fmt.Errorf("failed to reload layer.json: %w", err)
+	}
+
+	routes, models := routesFromOpenAPI(doc)
+	if routes == nil {
+		return fmt.Errorf("%s has no operations to generate routes from", specPath)
+	}
+
+	service := defaults.DefaultService(
+		defaults.WithName(serviceName),
+		defaults.WithPort(openAPIServerPort(doc, 8081)),
+		defaults.WithRoutesConfig(routes),
+		defaults.WithModels(models...),
+		defaults.WithHandlers(),
+		defaults.WithMiddleware(),
+	)
+	service.Template = "custom"
+
+	servicePort, err := chooseServicePort(port, service.Port, usedPorts(layer, service))
+	if err != nil {
+		return err
+	}
+	service.Port = servicePort
+
+	if err := createService(layerRoot, service); err != nil {
+		return err
+	}
+
+	fmt.Printf("Service '%s' created from %s at port %d!\n", serviceName, specPath, servicePort)
+	return nil
+}
+
+// readOpenAPI parses an OpenAPI document; JSON specs parse as YAML
+func readOpenAPI(path string) (*openAPIDocument, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read OpenAPI spec: %w", err)
+	}
+
+	var doc openAPIDocument
+	if err := yaml.Unmarshal(content, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse OpenAPI spec %s: %w", path, err)
+	}
+	if !strings.HasPrefix(doc.OpenAPI, "3.") {
+		return nil, fmt.Errorf("%s is not an OpenAPI 3 document", path)
+	}
+
+	return &doc, nil
+}
+
+// openAPIServerPort returns the port of the first server URL that sets one
+func openAPIServerPort(doc *openAPIDocument, defaultPort int) int {
+	for _, server := range doc.Servers {
+		u, err := url.Parse(server.URL)
+		if err != nil {
+			continue
+		}
+		if port, err := strconv.Atoi(u.Port()); err == nil {
+			return port
+		}
+	}
+	return defaultPort
+}
+
+// routesFromOpenAPI maps the operations of a spec to routes, grouped by their
+// first tag or else their first path segment, and returns the models their bodies use.
+// Returns nil routes when the spec has no supported operations.
+func routesFromOpenAPI(doc *openAPIDocument) (*types.RoutesConfig, []*types.Model) {
+	b := &modelBuilder{
+		components: make(map[string]*openAPISchema),
+		refs:       make(map[string]string),
+		names:      make(map[string]bool),
+	}
+	if doc.Components != nil {
+		b.components = doc.Components.Schemas
+	}
+
+	// Components claim their names before inline request and response structs
+	for _, name := range sortedKeys(b.components) {
+		b.refType(componentSchemaRef + name)
+	}
+
+	var groups []*types.RoutesGroup
+	groupIndex := make(map[string]*types.RoutesGroup)
+	handlers := make(map[string]bool)
+
+	for _, path := range sortedKeys(doc.Paths) {
+		for _, method := range openAPIMethods {
+			op := doc.Paths[path][method]
+			if op == nil {
+				continue
+			}
+
+			handler := uniqueName(operationHandler(op, method, path), handlers)
+			route := &types.Route{
+				Path:    path,
+				Method:  strings.ToUpper(method),
+				Handler: handler,
+			}
+
+			if op.RequestBody != nil {
+				if media := jsonMedia(op.RequestBody.Content); media != nil {
+					route.Request = b.qualify(b.goType(media.Schema, handler+"Request"))
+				}
+			}
+
+			status, response := successResponse(op)
+			if status != 0 {
+				route.Status = status
+			}
+			if response != nil {
+				if media := jsonMedia(response.Content); media != nil {
+					route.Response = b.qualify(b.goType(media.Schema, handler+"Response"))
+				}
+			}
+
+			name := strings.Trim(strings.SplitN(strings.TrimPrefix(path, "/"), "/", 2)[0], "{}")
+			if len(op.Tags) > 0 {
+				name = op.Tags[0]
+			}
+			group, ok := groupIndex[name]
+			if !ok {
+				group = &types.RoutesGroup{Name: name}
+				groupIndex[name] = group
+				groups = append(groups, group)
+			}
+			group.Routes = append(group.Routes, route)
+		}
+	}
+
+	if len(groups) == 0 {
+		return nil, nil
+	}
+
+	for _, group := range groups {
+		group.Prefix = commonPathPrefix(group.Routes)
+	}
+
+	return &types.RoutesConfig{RoutesGroup: groups}, b.models
+}
+
+// operationHandler names the handler of an operation after its operationId,
+// or else its method and path: GET /users/{id} -> GetUsersByID
+func operationHandler(op *openAPIOperation, method, path string) string {
+	if name := defaults.GoName(op.OperationID); name != "" && !startsWithDigit(name) {
+		return name
+	}
+
+	name := defaults.GoName(method)
+	for _, segment := range strings.Split(path, "/") {
+		if strings.HasPrefix(segment, "{") {
+			name += "By" + defaults.GoName(strings.Trim(segment, "{}"))
+			continue
+		}
+		name += defaults.GoName(segment)
+	}
+	return name
+}
+
+// successResponse returns the first 2xx response of an operation and its status code
+func successResponse(op *openAPIOperation) (int, *openAPIResponse) {
+	for _, code := range sortedKeys(op.Responses) {
+		if !strings.HasPrefix(code, "2") {
+			continue
+		}
+		status, err := strconv.Atoi(code)
+		if err != nil {
+			// 2XX ranges
+			status = 200
+		}
+		return status, op.Responses[code]
+	}
+	return 0, nil
+}
+
+// jsonMedia returns the JSON media type of a body, if any
+func jsonMedia(content map[string]*openAPIMediaType) *openAPIMediaType {
+	if media, ok := content["application/json"]; ok {
+		return media
+	}
+	for _, typ := range sortedKeys(content) {
+		if strings.HasSuffix(typ, "+json") {
+			return content[typ]
+		}
+	}
+	return nil
+}
+
+// commonPathPrefix returns the static path segments shared by all routes of a group
+func commonPathPrefix(routes []*types.Route) string {
+	var prefix []string
+	for i, route := range routes {
+		var segments []string
+		for _, segment := range strings.Split(strings.Trim(route.Path, "/"), "/") {
+			if segment == "" || strings.HasPrefix(segment, "{") {
+				break
+			}
+			segments = append(segments, segment)
+		}
+		if i == 0 {
+			prefix = segments
+			continue
+		}
+		n := 0
+		for n < len(prefix) && n < len(segments) && prefix[n] == segments[n] {
+			n++
+		}
+		prefix = prefix[:n]
+	}
+	if len(prefix) == 0 {
+		return ""
+	}
+	return "/" + strings.Join(prefix, "/")
+}
+
+// modelBuilder maps JSON schemas to Go types, collecting the structs of the models package
+type modelBuilder struct {
+	components map[string]*openAPISchema
+	refs       map[string]string // component $ref -> Go type
+	names      map[string]bool   // model names taken
+	models     []*types.Model
+}
+
+// goType returns the Go type of a schema, generating models named after hint for inline objects
+func (b *modelBuilder) goType(schema *openAPISchema, hint string) string {
+	if schema == nil {
+		return "any"
+	}
+	if schema.Ref != "" {
+		return b.refType(schema.Ref)
+	}
+	if len(schema.AllOf) == 1 && len(schema.Properties) == 0 {
+		return b.goType(schema.AllOf[0], hint)
+	}
+
+	switch schema.Type {
+	case "string":
+		switch schema.Format {
+		case "date-time":
+			return "time.Time"
+		case "byte", "binary":
+			return "[]byte"
+		}
+		return "string"
+	case "integer":
+		if schema.Format == "int32" {
+			return "int32"
+		}
+		return "int64"
+	case "number":
+		if schema.Format == "float" {
+			return "float32"
+		}
+		return "float64"
+	case "boolean":
+		return "bool"
+	case "array":
+		return "[]" + b.goType(schema.Items, hint+"Item")
+	case "object", "":
+		if len(schema.Properties) > 0 || len(schema.AllOf) > 0 {
+			name := uniqueName(hint, b.names)
+			b.buildModel(name, schema)
+			return name
+		}
+		if schema.AdditionalProperties != nil {
+			return "map[string]" + b.goType(schema.AdditionalProperties, hint+"Value")
+		}
+		if schema.Type == "object" {
+			return "map[string]any"
+		}
+	}
+	return "any"
+}
+
+// refType returns the Go type of a component schema: a model for objects,
+// the type the schema describes otherwise
+func (b *modelBuilder) refType(ref string) string {
+	if typ, ok := b.refs[ref]; ok {
+		return typ
+	}
+
+	name, ok := strings.CutPrefix(ref, componentSchemaRef)
+	schema := b.components[name]
+	if !ok || schema == nil {
+		return "any"
+	}
+
+	if len(schema.Properties) > 0 || len(schema.AllOf) > 0 {
+		// Registered before the fields so recursive schemas resolve
+		model := uniqueName(defaults.GoName(name), b.names)
+		b.refs[ref] = model
+		b.buildModel(model, schema)
+		return model
+	}
+
+	b.refs[ref] = "any"
+	typ := b.goType(schema, defaults.GoName(name))
+	b.refs[ref] = typ
+	return typ
+}
+
+// buildModel adds the struct of an object schema, allOf members merged in.
+// Optional model fields are pointers so they can be left out and recurse.
+func (b *modelBuilder) buildModel(name string, schema *openAPISchema) {
+	model := &types.Model{Name: name}
+	b.models = append(b.models, model)
+
+	properties := make(map[string]*openAPISchema)
+	required := make(map[string]bool)
+	b.collectProperties(schema, properties, required)
+
+	for _, key := range sortedKeys(properties) {
+		typ := b.goType(properties[key], name+defaults.GoName(key))
+		optional := !required[key]
+		if optional && b.names[typ] {
+			typ = "*" + typ
+		}
+
+		field := defaults.GoName(key)
+		if field == "" || startsWithDigit(field) {
+			field = "Field" + field
+		}
+		model.Fields = append(model.Fields, &types.ModelField{Name: field, Type: typ, JSON: key, Optional: optional})
+	}
+}
+
+func (b *modelBuilder) collectProperties(schema *openAPISchema, properties map[string]*openAPISchema, required map[string]bool) {
+	if schema.Ref != "" {
+		name, _ := strings.CutPrefix(schema.Ref, componentSchemaRef)
+		if component := b.components[name]; component != nil {
+			b.collectProperties(component, properties, required)
+		}
+		return
+	}
+	for key, prop := range schema.Properties {
+		properties[key] = prop
+	}
+	for _, key := range schema.Required {
+		required[key] = true
+	}
+	for _, member := range schema.AllOf {
+		b.collectProperties(member, properties, required)
+	}
+}
+
+// qualify prefixes the models in a Go type with the models package: []User -> []models.User
+func (b *modelBuilder) qualify(typ string) string {
+	return modelTypeName.ReplaceAllStringFunc(typ, func(m string) string {
+		sub := modelTypeName.FindStringSubmatch(m)
+		if !b.names[sub[2]] {
+			return m
+		}
+		return sub[1] + defaults.ModelsPackageName + "." + sub[2]
+	})
+}
+
+// uniqueName returns name, or name with the first free numeric suffix, and marks it taken
+func uniqueName(name string, taken map[string]bool) string {
+	unique := name
+	for i := 2; taken[unique]; i++ {
+		unique = name + strconv.Itoa(i)
+	}
+	taken[unique] = true
+	return unique
+}
+
+func startsWithDigit(s string) bool {
+	return s != "" && s[0] >= '0' && s[0] <= '9'
+}
+
+// sortedKeys returns the keys of a map in order, for deterministic generation
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
@@ -0,0 +1,31 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/flaviogonzalez/instant-layer/internal/config"
+	defaults "github.com/flaviogonzalez/instant-layer/internal/default"
+)
+
+// regenerateGateways rewrites gateway/table.go in every gateway service from the
+// routes of the layer's services. Proxy and middleware hold user code and are kept.
+func regenerateGateways(layer *config.Layer) error {
+	table := defaults.GatewayTableFile(layer.Services)
+	for _, svc := range layer.Services {
+		servicePath := filepath.Join(layer.Root, svc.Name)
+		if serviceKind(servicePath, svc) != "gateway" {
+			continue
+		}
+		if err := writeGoFile(filepath.Join(servicePath, defaults.GatewayPackageName, table.Name), table); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isGateway reports whether a service directory holds a generated gateway route table
+func isGateway(servicePath string) bool {
+	_, err := os.Stat(filepath.Join(servicePath, defaults.GatewayPackageName, "table.go"))
+	return err == nil
+}
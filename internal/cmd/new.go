@@ -34,6 +34,11 @@ func StartGeneration(wd, dir string) error {
 		return fmt.Errorf("failed to save layer config: %w", err)
 	}
 
+	// The workspace of the service modules, empty until the first one
+	if err := syncGoWork(layer); err != nil {
+		return fmt.Errorf("failed to generate go.work: %w", err)
+	}
+
 	fmt.Printf("Project '%s' initialized at: %s\n", projectName, resolvedRoot)
 
 	// Offer to create the first service
@@ -65,7 +70,7 @@ func shouldAddFirstService() bool {
 
 func addFirstService(layer *config.Layer) error {
 	fmt.Println("Let's create your first service")
-	return SelectAndGenerateTemplate(layer.Root)
+	return SelectAndGenerateTemplate(layer.Root, 0)
 }
 
 func generateEmptyDockerCompose(layer *config.Layer) error {
@@ -75,7 +80,10 @@ func generateEmptyDockerCompose(layer *config.Layer) error {
 	}
 
 	dockerComposePath := filepath.Join(layer.Root, "docker-compose.yml")
-	return templ.GenerateDockerCompose(dockerComposePath, data)
+	if err := templ.GenerateDockerCompose(dockerComposePath, data); err != nil {
+		return err
+	}
+	return syncProjectEnv(layer.Root, layer)
 }
 
 func resolveProjectRoot(wd, dir string) (string, error) {
@@ -0,0 +1,415 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/format"
+	gotypes "go/types"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/flaviogonzalez/instant-layer/internal/config"
+	defaults "github.com/flaviogonzalez/instant-layer/internal/default"
+	"github.com/flaviogonzalez/instant-layer/internal/types"
+	"gopkg.in/yaml.v3"
+)
+
+// openAPIVersion is the OpenAPI release the specs are written against
+const openAPIVersion = "3.1.0"
+
+// chiParam matches chi path parameters, with an optional regexp: {id} or {id:[0-9]+}
+var chiParam = regexp.MustCompile(`\{([^}:]+)(:[^}]*)?\}`)
+
+// openAPIDocument is the subset of an OpenAPI 3.1 document the generator writes and reads
+type openAPIDocument struct {
+	OpenAPI    string                                  `json:"openapi" yaml:"openapi"`
+	Info       openAPIInfo                             `json:"info" yaml:"info"`
+	Servers    []*openAPIServer                        `json:"servers,omitempty" yaml:"servers"`
+	Paths      map[string]map[string]*openAPIOperation `json:"paths" yaml:"paths"`
+	Components *openAPIComponents                      `json:"components,omitempty" yaml:"components"`
+}
+
+type openAPIInfo struct {
+	Title   string `json:"title" yaml:"title"`
+	Version string `json:"version" yaml:"version"`
+}
+
+type openAPIServer struct {
+	URL string `json:"url" yaml:"url"`
+}
+
+type openAPIOperation struct {
+	OperationID string                      `json:"operationId,omitempty" yaml:"operationId"`
+	Tags        []string                    `json:"tags,omitempty" yaml:"tags"`
+	Parameters  []*openAPIParameter         `json:"parameters,omitempty" yaml:"parameters"`
+	RequestBody *openAPIRequestBody         `json:"requestBody,omitempty" yaml:"requestBody"`
+	Responses   map[string]*openAPIResponse `json:"responses" yaml:"responses"`
+}
+
+type openAPIParameter struct {
+	Name     string         `json:"name" yaml:"name"`
+	In       string         `json:"in" yaml:"in"`
+	Required bool           `json:"required" yaml:"required"`
+	Schema   *openAPISchema `json:"schema" yaml:"schema"`
+}
+
+type openAPIRequestBody struct {
+	Required bool                         `json:"required" yaml:"required"`
+	Content  map[string]*openAPIMediaType `json:"content" yaml:"content"`
+}
+
+type openAPIResponse struct {
+	Description string                       `json:"description" yaml:"description"`
+	Content     map[string]*openAPIMediaType `json:"content,omitempty" yaml:"content"`
+}
+
+type openAPIMediaType struct {
+	Schema *openAPISchema `json:"schema" yaml:"schema"`
+}
+
+type openAPIComponents struct {
+	Schemas map[string]*openAPISchema `json:"schemas,omitempty" yaml:"schemas"`
+}
+
+// openAPISchema is a JSON schema; the zero value accepts any JSON value
+type openAPISchema struct {
+	Ref                  string                    `json:"$ref,omitempty" yaml:"$ref"`
+	Type                 schemaType                `json:"type,omitempty" yaml:"type"`
+	Format               string                    `json:"format,omitempty" yaml:"format"`
+	Items                *openAPISchema            `json:"items,omitempty" yaml:"items"`
+	Properties           map[string]*openAPISchema `json:"properties,omitempty" yaml:"properties"`
+	AdditionalProperties *openAPISchema            `json:"additionalProperties,omitempty" yaml:"additionalProperties"`
+	Required             []string                  `json:"required,omitempty" yaml:"required"`
+	AllOf                []*openAPISchema          `json:"allOf,omitempty" yaml:"allOf"`
+}
+
+// schemaType is the type of a schema. OpenAPI 3.1 also allows a list such as
+// [string, "null"], read as its first non-null type.
+type schemaType string
+
+func (t *schemaType) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind != yaml.SequenceNode {
+		*t = schemaType(value.Value)
+		return nil
+	}
+	for _, n := range value.Content {
+		if n.Value != "null" {
+			*t = schemaType(n.Value)
+			return nil
+		}
+	}
+	return nil
+}
+
+// GenerateOpenAPI writes an OpenAPI spec for one service, or for every service with
+// routes when service is empty. Specs default to openapi.json in the service directory.
+// With serve, the services also get a docs package serving the spec and a Swagger UI page.
+func GenerateOpenAPI(root, service, output string, serve bool) error {
+	layerRoot, err := config.FindLayerRoot(root)
+	if err != nil {
+		return fmt.Errorf("layer.json not found. Run 'layer new' first to create a project")
+	}
+
+	layer := &config.Layer{Root: layerRoot}
+	if err := layer.Reload(); err != nil {
+		return fmt.Errorf("failed to reload layer.json: %w", err)
+	}
+
+	var services []*types.Service
+	for _, svc := range layer.Services {
+		if service != "" && svc.Name != service {
+			continue
+		}
+		if svc.RoutesConfig == nil {
+			if service != "" {
+				return fmt.Errorf("service %s has no routes", service)
+			}
+			continue
+		}
+		services = append(services, svc)
+	}
+
+	if service != "" && len(services) == 0 {
+		return fmt.Errorf("service %s not found in layer.json", service)
+	}
+	if output != "" && len(services) > 1 {
+		return fmt.Errorf("--output needs a single service")
+	}
+
+	for _, svc := range services {
+		servicePath := filepath.Join(layerRoot, svc.Name)
+
+		// Handlers that don't type-check yet only lose their body schemas
+		handlers, _ := layer.ScanHandlerTypes(servicePath)
+
+		spec, err := json.MarshalIndent(buildOpenAPI(svc, handlers), "", "  ")
+		if err != nil {
+			return err
+		}
+
+		path := output
+		if path == "" {
+			path = filepath.Join(servicePath, "openapi.json")
+		}
+		if err := os.WriteFile(path, append(spec, '\n'), 0644); err != nil {
+			return fmt.Errorf("failed to write OpenAPI spec: %w", err)
+		}
+		fmt.Printf("OpenAPI spec of %s written to %s\n", svc.Name, path)
+
+		if serve {
+			if err := serveOpenAPI(layer, svc, spec); err != nil {
+				return fmt.Errorf("failed to add docs routes to %s: %w", svc.Name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// buildOpenAPI maps a service's routes to OpenAPI paths, with the request and
+// response bodies of the handlers that could be type-checked
+func buildOpenAPI(svc *types.Service, handlers map[string]*config.HandlerTypes) *openAPIDocument {
+	doc := &openAPIDocument{
+		OpenAPI: openAPIVersion,
+		Info:    openAPIInfo{Title: svc.Name, Version: "1.0.0"},
+		Paths:   make(map[string]map[string]*openAPIOperation),
+	}
+	if svc.Port > 0 {
+		doc.Servers = []*openAPIServer{{URL: fmt.Sprintf("http://localhost:%d", svc.Port)}}
+	}
+
+	schemas := &schemaBuilder{schemas: make(map[string]*openAPISchema), names: make(map[*gotypes.TypeName]string)}
+
+	for _, group := range svc.RoutesConfig.RoutesGroup {
+		// Routes are mounted directly on the mux, the group prefix isn't applied
+		for _, route := range group.Routes {
+			path, params := openAPIPath(route.Path)
+
+			op := &openAPIOperation{
+				OperationID: route.Handler,
+				Responses:   make(map[string]*openAPIResponse),
+			}
+			for _, name := range params {
+				op.Parameters = append(op.Parameters, &openAPIParameter{
+					Name:     name,
+					In:       "path",
+					Required: true,
+					Schema:   &openAPISchema{Type: "string"},
+				})
+			}
+
+			status := http.StatusOK
+			response := &openAPIResponse{}
+			if h := handlers[route.Handler]; h != nil {
+				if h.Status != 0 {
+					status = h.Status
+				}
+				if h.Request != nil {
+					op.RequestBody = &openAPIRequestBody{
+						Required: true,
+						Content:  jsonContent(schemas.schema(h.Request)),
+					}
+				}
+				if h.Response != nil {
+					response.Content = jsonContent(schemas.schema(h.Response))
+				}
+			}
+			response.Description = http.StatusText(status)
+			op.Responses[strconv.Itoa(status)] = response
+
+			if doc.Paths[path] == nil {
+				doc.Paths[path] = make(map[string]*openAPIOperation)
+			}
+			doc.Paths[path][strings.ToLower(route.Method)] = op
+		}
+	}
+
+	if len(schemas.schemas) > 0 {
+		doc.Components = &openAPIComponents{Schemas: schemas.schemas}
+	}
+
+	return doc
+}
+
+// openAPIPath converts a chi pattern to an OpenAPI path, returning its parameters
+func openAPIPath(pattern string) (string, []string) {
+	var params []string
+	path := chiParam.ReplaceAllStringFunc(pattern, func(m string) string {
+		name := chiParam.FindStringSubmatch(m)[1]
+		params = append(params, name)
+		return "{" + name + "}"
+	})
+	return path, params
+}
+
+func jsonContent(schema *openAPISchema) map[string]*openAPIMediaType {
+	return map[string]*openAPIMediaType{"application/json": {Schema: schema}}
+}
+
+// schemaBuilder converts Go types to JSON schemas, collecting named structs
+// as components referenced by $ref
+type schemaBuilder struct {
+	schemas map[string]*openAPISchema
+	names   map[*gotypes.TypeName]string
+}
+
+func (b *schemaBuilder) schema(t gotypes.Type) *openAPISchema {
+	switch t := t.(type) {
+	case *gotypes.Named:
+		obj := t.Obj()
+		if obj.Pkg() != nil {
+			switch obj.Pkg().Path() + "." + obj.Name() {
+			case "time.Time":
+				return &openAPISchema{Type: "string", Format: "date-time"}
+			case "encoding/json.RawMessage":
+				return &openAPISchema{}
+			}
+		}
+		if _, ok := t.Underlying().(*gotypes.Struct); !ok {
+			return b.schema(t.Underlying())
+		}
+		if name, ok := b.names[obj]; ok {
+			return &openAPISchema{Ref: "#/components/schemas/" + name}
+		}
+
+		name := obj.Name()
+		if _, taken := b.schemas[name]; taken && obj.Pkg() != nil {
+			name = obj.Pkg().Name() + "_" + name
+		}
+		// Registered before the fields so recursive types resolve to a $ref
+		b.names[obj] = name
+		b.schemas[name] = nil
+		b.schemas[name] = b.schema(t.Underlying())
+		return &openAPISchema{Ref: "#/components/schemas/" + name}
+	case *gotypes.Alias:
+		return b.schema(gotypes.Unalias(t))
+	case *gotypes.Pointer:
+		return b.schema(t.Elem())
+	case *gotypes.Basic:
+		return basicSchema(t)
+	case *gotypes.Slice:
+		if basic, ok := t.Elem().(*gotypes.Basic); ok && basic.Kind() == gotypes.Byte {
+			return &openAPISchema{Type: "string", Format: "byte"}
+		}
+		return &openAPISchema{Type: "array", Items: b.schema(t.Elem())}
+	case *gotypes.Array:
+		return &openAPISchema{Type: "array", Items: b.schema(t.Elem())}
+	case *gotypes.Map:
+		return &openAPISchema{Type: "object", AdditionalProperties: b.schema(t.Elem())}
+	case *gotypes.Struct:
+		schema := &openAPISchema{Type: "object", Properties: make(map[string]*openAPISchema)}
+		b.structFields(schema, t)
+		return schema
+	default:
+		// interfaces and anything else decode any JSON value
+		return &openAPISchema{}
+	}
+}
+
+// structFields adds the JSON fields of a struct to schema, following encoding/json:
+// exported fields only, json tag names, "-" skipped and embedded structs flattened
+func (b *schemaBuilder) structFields(schema *openAPISchema, st *gotypes.Struct) {
+	for i := 0; i < st.NumFields(); i++ {
+		field := st.Field(i)
+		tag := reflect.StructTag(st.Tag(i)).Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+
+		if field.Embedded() && name == "" {
+			embedded := field.Type()
+			if ptr, ok := embedded.(*gotypes.Pointer); ok {
+				embedded = ptr.Elem()
+			}
+			if inner, ok := embedded.Underlying().(*gotypes.Struct); ok {
+				b.structFields(schema, inner)
+				continue
+			}
+		}
+		if !field.Exported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name()
+		}
+
+		schema.Properties[name] = b.schema(field.Type())
+		if !strings.Contains(opts, "omitempty") && !strings.Contains(opts, "omitzero") {
+			schema.Required = append(schema.Required, name)
+		}
+	}
+}
+
+// basicSchema maps a Go basic type to its JSON schema type and format
+func basicSchema(t *gotypes.Basic) *openAPISchema {
+	switch t.Kind() {
+	case gotypes.Bool:
+		return &openAPISchema{Type: "boolean"}
+	case gotypes.String:
+		return &openAPISchema{Type: "string"}
+	case gotypes.Int32, gotypes.Int16, gotypes.Int8, gotypes.Uint16, gotypes.Uint8:
+		return &openAPISchema{Type: "integer", Format: "int32"}
+	case gotypes.Int, gotypes.Int64, gotypes.Uint, gotypes.Uint32, gotypes.Uint64:
+		return &openAPISchema{Type: "integer", Format: "int64"}
+	case gotypes.Float32:
+		return &openAPISchema{Type: "number", Format: "float"}
+	case gotypes.Float64:
+		return &openAPISchema{Type: "number", Format: "double"}
+	default:
+		return &openAPISchema{}
+	}
+}
+
+// serveOpenAPI writes the docs package of a service and mounts its routes,
+// editing routes.go in place so hand-written routes are kept
+func serveOpenAPI(layer *config.Layer, svc *types.Service, spec []byte) error {
+	servicePath := filepath.Join(layer.Root, svc.Name)
+
+	for _, f := range defaults.DocsPackage(spec).Files {
+		if err := writeGoFile(filepath.Join(servicePath, "docs", f.Name), f); err != nil {
+			return err
+		}
+	}
+
+	if svc.RoutesConfig.Docs {
+		return nil
+	}
+
+	if err := mountDocsRoutes(filepath.Join(servicePath, "routes", "routes.go"), svc.Name); err != nil {
+		return err
+	}
+
+	svc.RoutesConfig.Docs = true
+	return layer.Update()
+}
+
+// mountDocsRoutes adds the docs import and routes before the final return mux of routes.go
+func mountDocsRoutes(path, serviceName string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	src := string(content)
+
+	ret := strings.LastIndex(src, "return mux")
+	if ret < 0 || !strings.Contains(src, "import (") {
+		return fmt.Errorf("%s has no return mux to mount the docs routes on", path)
+	}
+
+	routes := fmt.Sprintf("mux.Get(%q, docs.UI)\nmux.Get(%q, docs.Spec)\n",
+		defaults.DocsPath, defaults.DocsPath+"/openapi.json")
+	src = src[:ret] + routes + src[ret:]
+	src = strings.Replace(src, "import (", fmt.Sprintf("import (\n%q", serviceName+"/docs"), 1)
+
+	formatted, err := format.Source([]byte(src))
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, formatted, 0644)
+}
@@ -0,0 +1,219 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/flaviogonzalez/instant-layer/internal/config"
+	"github.com/flaviogonzalez/instant-layer/internal/types"
+	"gopkg.in/yaml.v3"
+)
+
+// usedPorts returns the host ports taken in a project and what takes them:
+// the services of layer.json and the infra containers they run with, plus
+// the containers a service being added brings along, if any
+func usedPorts(layer *config.Layer, adding *types.Service) map[int]string {
+	used := make(map[int]string)
+	for _, svc := range layer.Services {
+		if svc.Port != 0 {
+			used[svc.Port] = svc.Name
+		}
+		reserveInfraPorts(used, svc)
+	}
+	if adding != nil {
+		reserveInfraPorts(used, adding)
+	}
+	return used
+}
+
+// reserveInfraPorts marks the host ports of a service's infra containers used
+func reserveInfraPorts(used map[int]string, svc *types.Service) {
+	for _, inf := range serviceInfra(svc) {
+		for _, mapping := range inf.Ports {
+			if port, ok := hostPort(mapping); ok {
+				used[port] = inf.Name
+			}
+		}
+	}
+}
+
+// nextFreePort returns port, or the first port above it nothing takes
+func nextFreePort(used map[int]string, port int) (int, error) {
+	for ; port <= 65535; port++ {
+		if used[port] == "" {
+			return port, nil
+		}
+	}
+	return 0, fmt.Errorf("no free port left")
+}
+
+// checkServicePort refuses ports out of range or already taken
+func checkServicePort(port int, used map[int]string) error {
+	if port < 1 || port > 65535 {
+		return fmt.Errorf("port must be between 1 and 65535")
+	}
+	if owner := used[port]; owner != "" {
+		if next, err := nextFreePort(used, port); err == nil {
+			return fmt.Errorf("port %d is already used by %s (next free: %d)", port, owner, next)
+		}
+		return fmt.Errorf("port %d is already used by %s", port, owner)
+	}
+	return nil
+}
+
+// chooseServicePort returns the port of a new service: the --port flag when
+// set, otherwise the answer to a prompt suggesting the first free port from
+// the template's default
+func chooseServicePort(flagPort, templatePort int, used map[int]string) (int, error) {
+	if flagPort != 0 {
+		if err := checkServicePort(flagPort, used); err != nil {
+			return 0, err
+		}
+		return flagPort, nil
+	}
+	suggested, err := nextFreePort(used, templatePort)
+	if err != nil {
+		return 0, err
+	}
+	return promptServicePort(suggested, used)
+}
+
+// hostPort returns the host port of a compose port mapping: 8080:80,
+// 127.0.0.1:8080:80 or 8080:80/tcp. Container-only ports have none.
+func hostPort(mapping string) (int, bool) {
+	mapping, _, _ = strings.Cut(mapping, "/")
+	parts := strings.Split(mapping, ":")
+	if len(parts) < 2 {
+		return 0, false
+	}
+	port, err := strconv.Atoi(parts[len(parts)-2])
+	return port, err == nil
+}
+
+// portBinding is a host port claimed by a service or infra container
+type portBinding struct {
+	port   int
+	owner  string
+	source string // layer.json or docker-compose.yml
+}
+
+// CheckPorts reports the host ports claimed twice in layer.json and
+// docker-compose.yml, infra containers included, and the services whose
+// published port differs from layer.json
+func CheckPorts(root string, w io.Writer) error {
+	layerRoot, err := config.FindLayerRoot(root)
+	if err != nil {
+		return fmt.Errorf("layer.json not found. Run 'layer new' first to create a project")
+	}
+
+	layer := &config.Layer{Root: layerRoot}
+	if err := layer.Reload(); err != nil {
+		return fmt.Errorf("failed to reload layer.json: %w", err)
+	}
+
+	var bindings []portBinding
+	for _, svc := range layer.Services {
+		if svc.Port != 0 {
+			bindings = append(bindings, portBinding{svc.Port, svc.Name, "layer.json"})
+		}
+	}
+	published, err := composePorts(filepath.Join(layerRoot, "docker-compose.yml"))
+	if err != nil {
+		return err
+	}
+	for name, ports := range published {
+		for _, port := range ports {
+			bindings = append(bindings, portBinding{port, name, "docker-compose.yml"})
+		}
+	}
+
+	var problems []string
+
+	// A port claimed by two owners, in either file
+	owners := make(map[int]map[string][]string)
+	for _, b := range bindings {
+		if owners[b.port] == nil {
+			owners[b.port] = make(map[string][]string)
+		}
+		owners[b.port][b.owner] = append(owners[b.port][b.owner], b.source)
+	}
+	for port, byOwner := range owners {
+		if len(byOwner) < 2 {
+			continue
+		}
+		var claims []string
+		for owner, sources := range byOwner {
+			claims = append(claims, fmt.Sprintf("%s (%s)", owner, strings.Join(sources, ", ")))
+		}
+		sort.Strings(claims)
+		problems = append(problems, fmt.Sprintf("port %d is used by %s", port, strings.Join(claims, " and ")))
+	}
+
+	// docker-compose.yml left behind by an edit of layer.json
+	for _, svc := range layer.Services {
+		ports, ok := published[svc.Name]
+		if !ok || svc.Port == 0 {
+			continue
+		}
+		if !slices.Contains(ports, svc.Port) {
+			problems = append(problems, fmt.Sprintf("%s has port %d in layer.json but docker-compose.yml publishes %v", svc.Name, svc.Port, ports))
+		}
+	}
+
+	if len(problems) == 0 {
+		fmt.Fprintln(w, "No port conflicts")
+		return nil
+	}
+	sort.Strings(problems)
+	for _, p := range problems {
+		fmt.Fprintf(w, "  - %s\n", p)
+	}
+	return fmt.Errorf("found %d port conflicts", len(problems))
+}
+
+// composePorts returns the host ports each container of a compose file
+// publishes; a missing file publishes none
+func composePorts(path string) (map[string][]int, error) {
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var compose struct {
+		Services map[string]struct {
+			Ports []yaml.Node `yaml:"ports"`
+		} `yaml:"services"`
+	}
+	if err := yaml.Unmarshal(content, &compose); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", filepath.Base(path), err)
+	}
+
+	published := make(map[string][]int)
+	for name, svc := range compose.Services {
+		for _, node := range svc.Ports {
+			// Short syntax "8080:80" or long syntax {target: 80, published: 8080}
+			var long struct {
+				Published string `yaml:"published"`
+			}
+			if node.Kind == yaml.MappingNode && node.Decode(&long) == nil {
+				if port, err := strconv.Atoi(long.Published); err == nil {
+					published[name] = append(published[name], port)
+				}
+				continue
+			}
+			if port, ok := hostPort(node.Value); ok {
+				published[name] = append(published[name], port)
+			}
+		}
+	}
+	return published, nil
+}
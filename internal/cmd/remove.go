@@ -0,0 +1,282 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/flaviogonzalez/instant-layer/internal/config"
+	defaults "github.com/flaviogonzalez/instant-layer/internal/default"
+	"github.com/flaviogonzalez/instant-layer/internal/templ"
+	"github.com/flaviogonzalez/instant-layer/internal/types"
+	"github.com/manifoldco/promptui"
+)
+
+// RemoveService removes a service from the project: its directory, unless
+// keepFiles, and its entries in layer.json, go.work, docker-compose.yml, the
+// project's .env and the gateways' route tables. It prints what it will do and warns about the
+// services calling it, then asks for confirmation unless yes. The connections
+// of their clients stay in layer.json, dangling until the clients are
+// deleted, unless the user confirms dropping them.
+func RemoveService(root, name string, keepFiles, yes bool, w io.Writer) error {
+	layerRoot, err := config.FindLayerRoot(root)
+	if err != nil {
+		return fmt.Errorf("layer.json not found. Run 'layer new' first to create a project")
+	}
+
+	layer := &config.Layer{Root: layerRoot}
+	if err := layer.Reload(); err != nil {
+		return fmt.Errorf("failed to reload layer.json: %w", err)
+	}
+
+	svc := layer.FindService(name)
+	if svc == nil {
+		return fmt.Errorf("service %q not found in layer.json", name)
+	}
+	if filepath.Base(name) != name || name == "." || name == ".." {
+		return fmt.Errorf("invalid service name %q", name)
+	}
+
+	printRemovalPlan(w, layer, svc, keepFiles)
+	if !yes && !confirmRemoval(name) {
+		return fmt.Errorf("removal of %s cancelled", name)
+	}
+
+	envKeys := removedEnvKeys(layer, svc)
+	dropsPostgres := slices.Contains(orphanedInfra(layer, svc), templ.PostgresInfra().Name)
+
+	// Clients generated against the service no longer have a target: their
+	// connections are reported as invalid until the clients are deleted
+	callers := declaredCallers(layer, name)
+	dropCallers := len(callers) > 0 && !yes && confirmDropConnections(name)
+	layer.Services = slices.DeleteFunc(layer.Services, func(s *types.Service) bool { return s.Name == name })
+	layer.Connections = slices.DeleteFunc(layer.Connections, func(c *types.Connection) bool {
+		return c.FromService == name || (dropCallers && c.ToService == name)
+	})
+
+	// A kept directory would be scanned back into the project
+	if keepFiles && !slices.Contains(layer.Removed, name) {
+		layer.Removed = append(layer.Removed, name)
+	}
+	if err := layer.Update(); err != nil {
+		return fmt.Errorf("failed to update layer.json: %w", err)
+	}
+
+	// Its database URL and the credentials of the containers removed with it
+	env, err := config.ReadDotEnv(filepath.Join(layerRoot, defaults.ProjectEnvFile))
+	if err != nil {
+		return err
+	}
+	changed := false
+	for _, key := range envKeys {
+		if _, ok := env.Get(key); ok {
+			env.Delete(key)
+			changed = true
+		}
+	}
+	if changed {
+		if err := env.Save(); err != nil {
+			return err
+		}
+	}
+
+	if !keepFiles {
+		if err := os.RemoveAll(filepath.Join(layerRoot, name)); err != nil {
+			return fmt.Errorf("failed to delete %s: %w", name, err)
+		}
+	}
+
+	if err := syncGoWork(layer); err != nil {
+		return fmt.Errorf("failed to update go.work: %w", err)
+	}
+	if err := regenerateGateways(layer); err != nil {
+		return fmt.Errorf("failed to regenerate gateway routes: %w", err)
+	}
+
+	// An empty project would be rehydrated from the kept directory
+	if len(layer.Services) == 0 {
+		err = generateEmptyDockerCompose(layer)
+	} else {
+		err = regenerateDockerCompose(layerRoot)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to regenerate docker-compose: %w", err)
+	}
+	if dropsPostgres {
+		if err := removePostgresInit(layerRoot); err != nil {
+			return fmt.Errorf("failed to delete %s: %w", templ.PostgresInitDir, err)
+		}
+	}
+
+	fmt.Fprintf(w, "Service '%s' removed\n", name)
+	if len(callers) > 0 && !dropCallers {
+		fmt.Fprintf(w, "The clients of %s in %s stay in layer.json as invalid connections until you delete them\n", name, strings.Join(callers, ", "))
+	}
+	return nil
+}
+
+// declaredCallers returns the services with a generated client of a service
+func declaredCallers(layer *config.Layer, name string) []string {
+	var callers []string
+	for _, conn := range layer.Connections {
+		if conn.ToService == name && conn.FromService != name && !slices.Contains(callers, conn.FromService) {
+			callers = append(callers, conn.FromService)
+		}
+	}
+	slices.Sort(callers)
+	return callers
+}
+
+// removePostgresInit deletes the init script of the postgres container once
+// no service uses it, and its directory when nothing else is left in it
+func removePostgresInit(root string) error {
+	dir := filepath.Join(root, templ.PostgresInitDir)
+	if err := os.Remove(filepath.Join(dir, "init.sql")); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if entries, err := os.ReadDir(dir); err == nil && len(entries) == 0 {
+		return os.Remove(dir)
+	}
+	return nil
+}
+
+// printRemovalPlan prints what removing a service deletes and updates, and
+// which services still call it
+func printRemovalPlan(w io.Writer, layer *config.Layer, svc *types.Service, keepFiles bool) {
+	fmt.Fprintf(w, "Removing service '%s':\n", svc.Name)
+	if keepFiles {
+		fmt.Fprintf(w, "  - keep %s/ (no longer part of the project, skipped by layer hydrate)\n", svc.Name)
+	} else {
+		fmt.Fprintf(w, "  - delete %s/\n", svc.Name)
+	}
+	fmt.Fprintln(w, "  - remove it from layer.json, go.work and docker-compose.yml")
+
+	for _, inf := range orphanedInfra(layer, svc) {
+		fmt.Fprintf(w, "  - remove the %s container, no other service uses it\n", inf)
+		if inf == templ.PostgresInfra().Name {
+			fmt.Fprintf(w, "  - delete %s/init.sql; the container's volume keeps its data until: docker compose down -v\n", templ.PostgresInitDir)
+		}
+	}
+	if defaults.UsesPostgres(svc) && !slices.Contains(orphanedInfra(layer, svc), templ.PostgresInfra().Name) {
+		fmt.Fprintf(w, "  - keep its %s database in the postgres container until dropped\n", defaults.DatabaseName(svc.Name))
+	}
+	for _, key := range removedEnvKeys(layer, svc) {
+		fmt.Fprintf(w, "  - remove %s from %s\n", key, defaults.ProjectEnvFile)
+	}
+	for _, s := range layer.Services {
+		if svc.RoutesConfig != nil && s.Name != svc.Name && serviceKind(filepath.Join(layer.Root, s.Name), s) == "gateway" {
+			fmt.Fprintf(w, "  - drop its routes from the %s route table\n", s.Name)
+		}
+	}
+
+	dependents := serviceDependents(layer, svc.Name)
+	if len(dependents) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "Warning: these services call %s and will fail once it is removed:\n", svc.Name)
+	// Generated clients declare one connection per route, all in the same file
+	seen := make(map[string]bool)
+	for _, conn := range dependents {
+		location := filepath.ToSlash(conn.SourceFile)
+		if rel, err := filepath.Rel(layer.Root, conn.SourceFile); err == nil {
+			location = filepath.ToSlash(rel)
+		}
+		if conn.SourceLine > 0 {
+			location += ":" + strconv.Itoa(conn.SourceLine)
+		}
+		if location == "" {
+			location = "layer.json"
+		}
+		if !seen[conn.FromService+" "+location] {
+			seen[conn.FromService+" "+location] = true
+			fmt.Fprintf(w, "  - %s (%s)\n", conn.FromService, location)
+		}
+	}
+	fmt.Fprintf(w, "docker-compose.yml will no longer set %s for their clients\n", defaults.ClientBaseURLEnv(svc.Name))
+}
+
+// confirmDropConnections asks whether to drop the connections of the
+// clients of a removed service from layer.json rather than keep them dangling
+func confirmDropConnections(name string) bool {
+	prompt := promptui.Prompt{
+		Label:     fmt.Sprintf("Drop the connections of the clients of %s from layer.json", name),
+		IsConfirm: true,
+	}
+	result, err := prompt.Run()
+	return err == nil && (result == "y" || result == "Y")
+}
+
+// serviceDependents returns the connections of other services to a service,
+// declared by generated clients or scanned from their sources
+func serviceDependents(layer *config.Layer, name string) []*types.Connection {
+	connections, err := layer.ScanConnections()
+	if err != nil {
+		return nil
+	}
+
+	var dependents []*types.Connection
+	for _, conn := range connections {
+		if conn.ToService == name && conn.FromService != name {
+			dependents = append(dependents, conn)
+		}
+	}
+	return dependents
+}
+
+// removedEnvKeys returns the variables of the project's .env only the service
+// needs: its database URL and the credentials of the containers it alone uses
+func removedEnvKeys(layer *config.Layer, svc *types.Service) []string {
+	var others []*types.Service
+	for _, s := range layer.Services {
+		if s.Name != svc.Name {
+			others = append(others, s)
+		}
+	}
+	kept := make(map[string]bool)
+	for _, v := range defaults.ProjectEnvVars(others) {
+		kept[v.Name] = true
+	}
+
+	var keys []string
+	for _, v := range defaults.ProjectEnvVars([]*types.Service{svc}) {
+		if !kept[v.Name] {
+			keys = append(keys, v.Name)
+		}
+	}
+	return keys
+}
+
+// orphanedInfra returns the containers only the service uses, its transport
+// or the postgres server, removed from docker-compose.yml along with it
+func orphanedInfra(layer *config.Layer, svc *types.Service) []string {
+	shared := make(map[string]bool)
+	for _, s := range layer.Services {
+		if s.Name == svc.Name {
+			continue
+		}
+		for _, inf := range serviceInfra(s) {
+			shared[inf.Name] = true
+		}
+	}
+
+	var orphaned []string
+	for _, inf := range serviceInfra(svc) {
+		if !shared[inf.Name] {
+			orphaned = append(orphaned, inf.Name)
+		}
+	}
+	return orphaned
+}
+
+func confirmRemoval(name string) bool {
+	prompt := promptui.Prompt{
+		Label:     fmt.Sprintf("Remove %s", name),
+		IsConfirm: true,
+	}
+	result, err := prompt.Run()
+	return err == nil && (result == "y" || result == "Y")
+}
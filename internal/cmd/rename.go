@@ -0,0 +1,486 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/flaviogonzalez/instant-layer/internal/config"
+	defaults "github.com/flaviogonzalez/instant-layer/internal/default"
+	"github.com/flaviogonzalez/instant-layer/internal/templ"
+	"github.com/flaviogonzalez/instant-layer/internal/types"
+)
+
+// urlSchemes are the schemes services call each other with, rewritten on rename
+var urlSchemes = []string{"http://", "https://", "ws://"}
+
+// RenameService renames a service: its directory and module path, the
+// imports of its packages, layer.json, docker-compose.yml, the project's .env,
+// the URLs and base URL variables other services call it with, the clients
+// generated of it, regenerated under its new package name, and its generated
+// docs. A step failing undoes the ones before it.
+func RenameService(root, oldName, newName string) error {
+	layerRoot, err := config.FindLayerRoot(root)
+	if err != nil {
+		return fmt.Errorf("layer.json not found. Run 'layer new' first to create a project")
+	}
+
+	layer := &config.Layer{Root: layerRoot}
+	if err := layer.Reload(); err != nil {
+		return fmt.Errorf("failed to reload layer.json: %w", err)
+	}
+
+	svc := layer.FindService(oldName)
+	if svc == nil {
+		return fmt.Errorf("service %q not found in layer.json", oldName)
+	}
+	if !moduleNamePattern.MatchString(newName) {
+		return fmt.Errorf("service name %q must be lowercase letters, digits and hyphens (e.g. user-service)", newName)
+	}
+	if layer.FindService(newName) != nil {
+		return fmt.Errorf("service %q already exists", newName)
+	}
+	if _, err := os.Stat(filepath.Join(layerRoot, newName)); err == nil {
+		return fmt.Errorf("directory %s already exists", newName)
+	}
+
+	// A failed step puts back the directory and every file changed before it
+	rollback := newRenameRollback()
+	if err := renameService(layer, svc, newName, rollback); err != nil {
+		rollback.restore()
+		return err
+	}
+	return nil
+}
+
+// renameService runs the steps of RenameService, recording what they change
+// in rollback
+func renameService(layer *config.Layer, svc *types.Service, newName string, rollback *renameRollback) error {
+	layerRoot, oldName := layer.Root, svc.Name
+	newPath := filepath.Join(layerRoot, newName)
+
+	if err := os.Rename(filepath.Join(layerRoot, oldName), newPath); err != nil {
+		return fmt.Errorf("failed to rename directory: %w", err)
+	}
+	rollback.moved(filepath.Join(layerRoot, oldName), newPath)
+	if err := rollback.backup(filepath.Join(newPath, "go.mod")); err != nil {
+		return err
+	}
+	if err := renameModule(filepath.Join(newPath, "go.mod"), oldName, newName); err != nil {
+		return fmt.Errorf("failed to update go.mod: %w", err)
+	}
+
+	// The service's own imports and name, then the URLs every service calls
+	// it at. Error prefixes are only its own and those of its clients.
+	for _, s := range layer.Services {
+		dir := filepath.Join(layerRoot, s.Name)
+		own := s.Name == oldName
+		if own {
+			dir = newPath
+		}
+		clientPath := filepath.Join(dir, defaults.ClientsDir, defaults.ClientPackageName(oldName))
+		if err := rewriteGoFiles(dir, func(path string, f *ast.File) bool {
+			prefixed := own || strings.HasPrefix(path, clientPath+string(filepath.Separator))
+			return renameReferences(f, oldName, newName, own, prefixed) && rollback.backup(path) == nil
+		}); err != nil {
+			return fmt.Errorf("failed to rewrite %s: %w", s.Name, err)
+		}
+	}
+	if err := renameProtoPackages(newPath, oldName, newName, rollback); err != nil {
+		return fmt.Errorf("failed to update .proto files: %w", err)
+	}
+
+	// Callers with a generated client of the service, regenerated below
+	var callers []string
+	for _, from := range layer.Services {
+		if from.Name == oldName {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(layerRoot, from.Name, defaults.ClientsDir, defaults.ClientPackageName(oldName))); err == nil {
+			callers = append(callers, from.Name)
+		}
+	}
+
+	svc.Name = newName
+	layer.Removed = slices.DeleteFunc(layer.Removed, func(name string) bool { return name == newName })
+	for _, conn := range layer.Connections {
+		if conn.FromService == oldName {
+			conn.FromService = newName
+			if rest, ok := strings.CutPrefix(conn.SourceFile, oldName+"/"); ok {
+				conn.SourceFile = newName + "/" + rest
+			}
+		}
+		if conn.ToService == oldName {
+			conn.ToService = newName
+		}
+	}
+	for _, from := range callers {
+		if err := renameClient(layer, from, svc, oldName, rollback); err != nil {
+			return fmt.Errorf("failed to regenerate the client of %s in %s: %w", newName, from, err)
+		}
+	}
+
+	// The files regenerated from layer.json
+	generated := []string{
+		"layer.json", "go.work", "docker-compose.yml", "asyncapi.yaml", ".gitignore",
+		defaults.ProjectEnvFile, defaults.ProjectEnvFile + ".example",
+		filepath.Join(templ.PostgresInitDir, "init.sql"),
+		filepath.Join(newName, ".env.example"), filepath.Join(newName, "openapi.json"),
+	}
+	for _, s := range layer.Services {
+		generated = append(generated, filepath.Join(s.Name, "Dockerfile"), filepath.Join(s.Name, defaults.GatewayPackageName, "table.go"))
+	}
+	for _, f := range defaults.DocsPackage(nil).Files {
+		generated = append(generated, filepath.Join(newName, "docs", f.Name))
+	}
+	for _, path := range generated {
+		if err := rollback.backup(filepath.Join(layerRoot, path)); err != nil {
+			return err
+		}
+	}
+
+	if err := layer.Update(); err != nil {
+		return fmt.Errorf("failed to update layer.json: %w", err)
+	}
+
+	// The database URL moves to the new name with its value: a generated one
+	// keeps naming the old database, which postgres already created
+	var movedDatabase string
+	if svc.DB != nil {
+		env, err := config.ReadDotEnv(filepath.Join(layerRoot, defaults.ProjectEnvFile))
+		if err != nil {
+			return err
+		}
+		if value, ok := env.Get(defaults.DatabaseURLEnv(oldName)); ok {
+			user, password := postgresCredentials(env)
+			if value == defaults.PostgresURL(user, password, oldName) && defaults.DatabaseName(oldName) != defaults.DatabaseName(newName) {
+				movedDatabase = user
+			}
+			env.Set(defaults.DatabaseURLEnv(newName), value)
+			env.Delete(defaults.DatabaseURLEnv(oldName))
+			if err := env.Save(); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := generateEnvExample(newPath, svc); err != nil {
+		return fmt.Errorf("failed to generate .env.example: %w", err)
+	}
+	if err := syncGoWork(layer); err != nil {
+		return fmt.Errorf("failed to update go.work: %w", err)
+	}
+	if err := regenerateGateways(layer); err != nil {
+		return fmt.Errorf("failed to regenerate gateway routes: %w", err)
+	}
+	if err := regenerateDockerCompose(layerRoot); err != nil {
+		return fmt.Errorf("failed to regenerate docker-compose: %w", err)
+	}
+	if err := regenerateDocs(layer, svc); err != nil {
+		return fmt.Errorf("failed to regenerate the docs: %w", err)
+	}
+
+	fmt.Printf("Service '%s' renamed to '%s'\n", oldName, newName)
+	if movedDatabase != "" {
+		oldDB, newDB := defaults.DatabaseName(oldName), defaults.DatabaseName(newName)
+		fmt.Printf("%s keeps using the %s database; postgres only creates %s on a new volume. To rename it, with %s stopped:\n",
+			defaults.DatabaseURLEnv(newName), oldDB, newDB, newName)
+		fmt.Printf("  docker compose exec postgres psql -U %s -d postgres -c 'ALTER DATABASE %s RENAME TO %s'\n", movedDatabase, oldDB, newDB)
+		fmt.Printf("  then replace %s with %s in it: layer env set %s=...\n", oldDB, newDB, defaults.DatabaseURLEnv(newName))
+	}
+	return nil
+}
+
+// regenerateDocs rewrites the documents titled after a renamed service: its
+// OpenAPI spec and the docs package serving it, and the project's AsyncAPI
+// document, when they were generated
+func regenerateDocs(layer *config.Layer, svc *types.Service) error {
+	servicePath := filepath.Join(layer.Root, svc.Name)
+	specPath := filepath.Join(servicePath, "openapi.json")
+	_, err := os.Stat(specPath)
+	if hasSpec := err == nil; svc.RoutesConfig != nil && (hasSpec || svc.RoutesConfig.Docs) {
+		handlers, _ := layer.ScanHandlerTypes(servicePath)
+		spec, err := json.MarshalIndent(buildOpenAPI(svc, handlers), "", "  ")
+		if err != nil {
+			return err
+		}
+		if hasSpec {
+			if err := os.WriteFile(specPath, append(spec, '\n'), 0644); err != nil {
+				return err
+			}
+		}
+		if svc.RoutesConfig.Docs {
+			for _, f := range defaults.DocsPackage(spec).Files {
+				if err := writeGoFile(filepath.Join(servicePath, "docs", f.Name), f); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	asyncAPIPath := filepath.Join(layer.Root, "asyncapi.yaml")
+	if _, err := os.Stat(asyncAPIPath); err == nil {
+		return templ.GenerateAsyncAPI(asyncAPIPath, buildAsyncAPI(layer))
+	}
+	return nil
+}
+
+// renameRollback records the files a rename changes and the directory it
+// moves, to put the project back as it was when a step fails
+type renameRollback struct {
+	original map[string][]byte // content before the first change
+	created  map[string]bool   // files that didn't exist
+	moves    [][2]string       // directories moved, from and to
+}
+
+func newRenameRollback() *renameRollback {
+	return &renameRollback{original: make(map[string][]byte), created: make(map[string]bool)}
+}
+
+// backup records a file before its first change
+func (r *renameRollback) backup(path string) error {
+	if _, ok := r.original[path]; ok || r.created[path] {
+		return nil
+	}
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		r.created[path] = true
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	r.original[path] = content
+	return nil
+}
+
+// backupDir records every file under a directory before it is deleted
+func (r *renameRollback) backupDir(dir string) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		return r.backup(path)
+	})
+}
+
+// moved records a directory moved from one path to another
+func (r *renameRollback) moved(from, to string) {
+	r.moves = append(r.moves, [2]string{from, to})
+}
+
+// restore puts back the recorded files, then moves the directories back
+func (r *renameRollback) restore() {
+	for path := range r.created {
+		if err := os.Remove(path); err == nil {
+			os.Remove(filepath.Dir(path)) // only once empty
+		}
+	}
+	for path, content := range r.original {
+		err := os.MkdirAll(filepath.Dir(path), 0755)
+		if err == nil {
+			err = os.WriteFile(path, content, 0644)
+		}
+		if err != nil {
+			fmt.Printf("Warning: %s not restored: %v\n", path, err)
+		}
+	}
+	for i := len(r.moves) - 1; i >= 0; i-- {
+		if err := os.Rename(r.moves[i][1], r.moves[i][0]); err != nil {
+			fmt.Printf("Warning: %s not moved back to %s: %v\n", r.moves[i][1], r.moves[i][0], err)
+		}
+	}
+}
+
+// renameModule rewrites the module directive of a go.mod
+func renameModule(goModPath, oldName, newName string) error {
+	content, err := os.ReadFile(goModPath)
+	if err != nil {
+		return err
+	}
+
+	lines := strings.Split(string(content), "\n")
+	for i, line := range lines {
+		if strings.TrimSpace(line) == "module "+oldName {
+			lines[i] = "module " + newName
+			break
+		}
+	}
+	return os.WriteFile(goModPath, []byte(strings.Join(lines, "\n")), 0644)
+}
+
+// renameClient regenerates a caller's client of a renamed service from its
+// routes, under the package named after it, and rewrites the caller's imports
+// of the old package
+func renameClient(layer *config.Layer, from string, target *types.Service, oldName string, rollback *renameRollback) error {
+	oldPkg := defaults.ClientPackageName(oldName)
+	clientsPath := filepath.Join(layer.Root, from, defaults.ClientsDir)
+	if err := rollback.backupDir(filepath.Join(clientsPath, oldPkg)); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(filepath.Join(clientsPath, oldPkg)); err != nil {
+		return err
+	}
+
+	pkg := defaults.ClientPackage(target)
+	for _, f := range pkg.Files {
+		path := filepath.Join(clientsPath, pkg.Name, f.Name)
+		if err := rollback.backup(path); err != nil {
+			return err
+		}
+		if err := writeGoFile(path, f); err != nil {
+			return err
+		}
+	}
+	layer.Connections = append(withoutConnections(layer.Connections, from, target.Name), clientConnections(from, target, pkg.Name)...)
+
+	if pkg.Name == oldPkg {
+		return nil
+	}
+	oldPath := from + "/" + defaults.ClientsDir + "/" + oldPkg
+	newPath := from + "/" + defaults.ClientsDir + "/" + pkg.Name
+	return rewriteGoFiles(filepath.Join(layer.Root, from), func(path string, f *ast.File) bool {
+		return renameImport(f, oldPath, newPath, oldPkg, pkg.Name) && rollback.backup(path) == nil
+	})
+}
+
+// renameImport rewrites an import path and, when the import isn't named, the
+// references to its package
+func renameImport(f *ast.File, oldPath, newPath, oldPkg, newPkg string) bool {
+	changed, named := false, false
+	for _, spec := range f.Imports {
+		if path, err := strconv.Unquote(spec.Path.Value); err == nil && path == oldPath {
+			spec.Path.Value = strconv.Quote(newPath)
+			changed, named = true, spec.Name != nil
+		}
+	}
+	if !changed || named {
+		return changed
+	}
+
+	ast.Inspect(f, func(n ast.Node) bool {
+		sel, ok := n.(*ast.SelectorExpr)
+		if !ok {
+			return true
+		}
+		// Unresolved identifiers are package names, not local variables
+		if id, ok := sel.X.(*ast.Ident); ok && id.Name == oldPkg && id.Obj == nil {
+			id.Name = newPkg
+		}
+		return true
+	})
+	return true
+}
+
+// renameReferences rewrites the references to a renamed service in a file:
+// URLs with its host and the variable holding its base URL, in its own files
+// (own) the imports of its packages and its name as a literal and, in
+// prefixed files, the "<service>:" prefix of its errors
+func renameReferences(f *ast.File, oldName, newName string, own, prefixed bool) bool {
+	changed := false
+	ast.Inspect(f, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.ImportSpec:
+			path, err := strconv.Unquote(n.Path.Value)
+			if err == nil && own && (path == oldName || strings.HasPrefix(path, oldName+"/")) {
+				n.Path.Value = strconv.Quote(newName + strings.TrimPrefix(path, oldName))
+				changed = true
+			}
+			return false
+		case *ast.BasicLit:
+			if n.Kind != token.STRING {
+				return true
+			}
+			value, err := strconv.Unquote(n.Value)
+			if err != nil {
+				return true
+			}
+			if renamed, ok := renameLiteral(value, oldName, newName, own, prefixed); ok {
+				n.Value = strconv.Quote(renamed)
+				changed = true
+			}
+		}
+		return true
+	})
+	return changed
+}
+
+// renameLiteral returns the value of a string literal referencing a renamed
+// service under its new name, like the "<service>: " prefix of client errors
+func renameLiteral(value, oldName, newName string, own, prefixed bool) (string, bool) {
+	if own && value == oldName {
+		return newName, true
+	}
+	if rest, ok := strings.CutPrefix(value, oldName+":"); ok && prefixed {
+		return newName + ":" + rest, true
+	}
+	if value == defaults.ClientBaseURLEnv(oldName) {
+		return defaults.ClientBaseURLEnv(newName), true
+	}
+	for _, scheme := range urlSchemes {
+		rest, ok := strings.CutPrefix(value, scheme+oldName)
+		if ok && (rest == "" || strings.HasPrefix(rest, "/") || strings.HasPrefix(rest, ":")) {
+			return scheme + newName + rest, true
+		}
+	}
+	return "", false
+}
+
+// rewriteGoFiles applies rewrite to every Go file under dir, saving the
+// files it changes with their comments. Files that don't parse are skipped.
+func rewriteGoFiles(dir string, rewrite func(path string, f *ast.File) bool) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !strings.HasSuffix(path, ".go") {
+			return err
+		}
+
+		fset := token.NewFileSet()
+		f, err := parser.ParseFile(fset, path, nil, parser.ParseComments)
+		if err != nil {
+			fmt.Printf("Warning: %s not rewritten: %v\n", path, err)
+			return nil
+		}
+		if !rewrite(path, f) {
+			return nil
+		}
+
+		out, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer out.Close()
+		return format.Node(out, fset, f)
+	})
+}
+
+// renameProtoPackages rewrites the go_package of the .proto files of a gRPC
+// service, which names its module
+func renameProtoPackages(servicePath, oldName, newName string, rollback *renameRollback) error {
+	matches, err := filepath.Glob(filepath.Join(servicePath, defaults.GRPCPackageName, "*.proto"))
+	if err != nil {
+		return err
+	}
+	for _, path := range matches {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		renamed := strings.ReplaceAll(string(content), `go_package = "`+oldName+"/", `go_package = "`+newName+"/")
+		if err := rollback.backup(path); err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, []byte(renamed), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+
+	"github.com/flaviogonzalez/instant-layer/internal/config"
+	defaults "github.com/flaviogonzalez/instant-layer/internal/default"
+	"github.com/flaviogonzalez/instant-layer/internal/templ"
+)
+
+// moduleNamePattern matches service and library names usable as directory,
+// module path and, without hyphens, Go package name
+var moduleNamePattern = regexp.MustCompile(`^[a-z][a-z0-9-]*$`)
+
+// syncGoWork rewrites the go.work at the project root: it uses every service,
+// the events module and the shared libraries, whose v0.0.0 it replaces with
+// the workspace copy services require
+func syncGoWork(layer *config.Layer) error {
+	var data templ.GoWorkData
+	for _, svc := range layer.Services {
+		if _, err := os.Stat(filepath.Join(layer.Root, svc.Name, "go.mod")); err == nil {
+			data.Use = append(data.Use, svc.Name)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(layer.Root, defaults.EventsModule, "go.mod")); err == nil {
+		data.Use = append(data.Use, defaults.EventsModule)
+	}
+	for _, lib := range layer.Libs {
+		module := defaults.LibModule(lib)
+		data.Use = append(data.Use, module)
+		data.Replace = append(data.Replace, templ.Replace{Module: module, Dir: module})
+	}
+
+	return templ.GenerateGoWork(filepath.Join(layer.Root, "go.work"), data)
+}
+
+// GenerateLib creates the shared library module libs/<name>, adds it to the
+// workspace and requires it from the given services
+func GenerateLib(root, name string, services []string) error {
+	layerRoot, err := config.FindLayerRoot(root)
+	if err != nil {
+		return fmt.Errorf("layer.json not found. Run 'layer new' first to create a project")
+	}
+
+	layer := &config.Layer{Root: layerRoot}
+	if err := layer.Reload(); err != nil {
+		return fmt.Errorf("failed to reload layer.json: %w", err)
+	}
+
+	if !moduleNamePattern.MatchString(name) {
+		return fmt.Errorf("library name %q must be lowercase letters, digits and hyphens (e.g. money)", name)
+	}
+	if slices.Contains(layer.Libs, name) {
+		return fmt.Errorf("library %q already exists", name)
+	}
+	for _, svc := range services {
+		if layer.FindService(svc) == nil {
+			return fmt.Errorf("service %q not found in layer.json", svc)
+		}
+	}
+
+	module := defaults.LibModule(name)
+	libPath := filepath.Join(layerRoot, filepath.FromSlash(module))
+	if _, err := os.Stat(libPath); err == nil {
+		return fmt.Errorf("directory %s already exists", module)
+	}
+	if err := os.MkdirAll(libPath, 0755); err != nil {
+		return fmt.Errorf("failed to create library directory: %w", err)
+	}
+
+	goMod := templ.GoModData{Name: module, GoVersion: templ.DefaultGoVersion()}
+	if err := templ.GenerateGoMod(filepath.Join(libPath, "go.mod"), goMod); err != nil {
+		return fmt.Errorf("failed to generate go.mod: %w", err)
+	}
+	lib := templ.LibData{Project: layer.Name, Module: module, Package: defaults.LibPackageName(name)}
+	if err := templ.GenerateLib(filepath.Join(libPath, "doc.go"), lib); err != nil {
+		return fmt.Errorf("failed to generate doc.go: %w", err)
+	}
+
+	// Services build outside the workspace too, e.g. in their Docker image
+	for _, svc := range services {
+		if err := requireLocalModule(filepath.Join(layerRoot, svc, "go.mod"), module, "../"+module); err != nil {
+			return fmt.Errorf("failed to require %s from %s: %w", module, svc, err)
+		}
+	}
+
+	layer.Libs = append(layer.Libs, name)
+	if err := layer.Update(); err != nil {
+		return fmt.Errorf("failed to update layer.json: %w", err)
+	}
+	if err := syncGoWork(layer); err != nil {
+		return fmt.Errorf("failed to update go.work: %w", err)
+	}
+
+	fmt.Printf("Library '%s' created at %s, import it as %q\n", name, module, module)
+	return nil
+}
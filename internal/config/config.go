@@ -7,6 +7,7 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"strings"
 	"text/template"
 	"time"
@@ -20,6 +21,10 @@ type Layer struct {
 	Root        string    `json:"root"`
 	GeneratedAt time.Time `json:"generated_at"`
 	Services    []*types.Service
+	Events      []*types.Event      `json:"events,omitempty"`      // shared event contracts (events module)
+	Connections []*types.Connection `json:"connections,omitempty"` // declared by generated clients (layer add client)
+	Libs        []string            `json:"libs,omitempty"`        // shared library modules under libs/ (layer add lib)
+	Removed     []string            `json:"removed,omitempty"`     // services removed with --keep-files, whose directory isn't scanned
 }
 
 func (l *Layer) Save() error {
@@ -84,6 +89,26 @@ func (l *Layer) Reload() error {
 	return json.Unmarshal(data, l)
 }
 
+// FindService returns the service with the given name, or nil
+func (l *Layer) FindService(name string) *types.Service {
+	for _, svc := range l.Services {
+		if svc.Name == name {
+			return svc
+		}
+	}
+	return nil
+}
+
+// FindEvent returns the event contract with the given name, or nil
+func (l *Layer) FindEvent(name string) *types.Event {
+	for _, ev := range l.Events {
+		if ev.Name == name {
+			return ev
+		}
+	}
+	return nil
+}
+
 func FindLayerRoot(start string) (string, error) {
 	dir := start
 	for {
@@ -124,6 +149,13 @@ func (l *Layer) Hydrate() error {
 	return nil
 }
 
+// sharedDirs are root directories holding shared modules rather than services
+var sharedDirs = map[string]bool{
+	"events":   true,
+	"libs":     true,
+	"postgres": true, // init scripts of the postgres container
+}
+
 func (l *Layer) ScanServices() ([]*types.Service, error) {
 	entries, err := os.ReadDir(l.Root)
 	if err != nil {
@@ -133,8 +165,8 @@ func (l *Layer) ScanServices() ([]*types.Service, error) {
 	var services []*types.Service
 
 	for _, entry := range entries {
-		// Skip files and hidden directories
-		if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+		// Skip files, hidden directories, shared modules and removed services
+		if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") || sharedDirs[entry.Name()] || slices.Contains(l.Removed, entry.Name()) {
 			continue
 		}
 
@@ -255,6 +287,8 @@ var (
 	routePattern = regexp.MustCompile(`\.(Post|Get|Put|Delete|Patch|Options|Head)\s*\(\s*"([^"]+)"`)
 	// Matches handlers.HandlerName
 	handlerPattern = regexp.MustCompile(`handlers\.(\w+)`)
+	// Matches the docs.UI and docs.Spec handlers serving the OpenAPI spec
+	docsPattern = regexp.MustCompile(`\bdocs\.(UI|Spec)\b`)
 )
 
 // scanRoutes scans the routes package for route definitions
@@ -267,6 +301,7 @@ func (l *Layer) scanRoutes(servicePath string) *types.RoutesConfig {
 	}
 
 	var routes []*types.Route
+	docs := false
 
 	// Scan all .go files in routes directory
 	err := filepath.Walk(routesPath, func(path string, info os.FileInfo, err error) error {
@@ -274,8 +309,9 @@ func (l *Layer) scanRoutes(servicePath string) *types.RoutesConfig {
 			return nil
 		}
 
-		fileRoutes := l.parseRoutesFile(path)
+		fileRoutes, fileDocs := l.parseRoutesFile(path)
 		routes = append(routes, fileRoutes...)
+		docs = docs || fileDocs
 		return nil
 	})
 
@@ -289,20 +325,28 @@ func (l *Layer) scanRoutes(servicePath string) *types.RoutesConfig {
 				Routes: routes,
 			},
 		},
+		Docs: docs,
 	}
 }
 
-// parseRoutesFile parses a single routes file for route definitions
-func (l *Layer) parseRoutesFile(filePath string) []*types.Route {
+// parseRoutesFile parses a single routes file for route definitions,
+// reporting separately whether it mounts the OpenAPI docs routes
+func (l *Layer) parseRoutesFile(filePath string) ([]*types.Route, bool) {
 	content, err := os.ReadFile(filePath)
 	if err != nil {
-		return nil
+		return nil, false
 	}
 
 	var routes []*types.Route
+	docs := false
 	lines := strings.Split(string(content), "\n")
 
 	for _, line := range lines {
+		if docsPattern.MatchString(line) {
+			docs = true
+			continue
+		}
+
 		// Find route method and path
 		routeMatches := routePattern.FindStringSubmatch(line)
 		if len(routeMatches) < 3 {
@@ -326,7 +370,7 @@ func (l *Layer) parseRoutesFile(filePath string) []*types.Route {
 		})
 	}
 
-	return routes
+	return routes, docs
 }
 
 // analyzeServiceBenchmark analyzes metrics for a service
@@ -438,6 +482,8 @@ func (l *Layer) countDependencies(servicePath string) int {
 var connectionPattern = regexp.MustCompile(`(?i)(https?|grpc|wss?|rpc)://([a-z0-9][-a-z0-9]*)(:[0-9]+)?(/[a-zA-Z0-9/_-]*)?`)
 
 // ScanConnections scans all services for inter-service connections
+// Connections declared in layer.json take precedence: scanned connections between
+// services already linked by a generated client are dropped
 func (l *Layer) ScanConnections() ([]*types.Connection, error) {
 	var connections []*types.Connection
 
@@ -447,6 +493,27 @@ func (l *Layer) ScanConnections() ([]*types.Connection, error) {
 		serviceMap[svc.Name] = svc
 	}
 
+	declared := make(map[string]bool)
+	for _, c := range l.Connections {
+		// A client of a removed service is dangling until it is deleted
+		if serviceMap[c.ToService] == nil && c.SourceFile != "" {
+			source := filepath.FromSlash(c.SourceFile)
+			if !filepath.IsAbs(source) {
+				source = filepath.Join(l.Root, source)
+			}
+			if _, err := os.Stat(source); os.IsNotExist(err) {
+				continue
+			}
+		}
+		conn := *c
+		l.validateConnection(&conn, serviceMap)
+		if c.Method != "" {
+			conn.Method = c.Method
+		}
+		declared[conn.FromService+"->"+conn.ToService] = true
+		connections = append(connections, &conn)
+	}
+
 	// Scan each service's source files
 	for _, svc := range l.Services {
 		servicePath := filepath.Join(l.Root, svc.Name)
@@ -454,7 +521,11 @@ func (l *Layer) ScanConnections() ([]*types.Connection, error) {
 		if err != nil {
 			continue
 		}
-		connections = append(connections, conns...)
+		for _, conn := range conns {
+			if !declared[conn.FromService+"->"+conn.ToService] {
+				connections = append(connections, conn)
+			}
+		}
 	}
 
 	return connections, nil
@@ -1,8 +1,10 @@
 package config
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/flaviogonzalez/instant-layer/internal/types"
@@ -185,6 +187,8 @@ func NewRoutes(mux *chi.Mux) {
 	mux.Get("/user/{id}", handlers.GetUser)
 	mux.Put("/user/{id}", handlers.UpdateUser)
 	mux.Delete("/user/{id}", handlers.DeleteUser)
+	mux.Get("/docs", docs.UI)
+	mux.Get("/docs/openapi.json", docs.Spec)
 }
 `
 	if err := os.WriteFile(filepath.Join(routesDir, "routes.go"), []byte(routesContent), 0644); err != nil {
@@ -216,6 +220,75 @@ func NewRoutes(mux *chi.Mux) {
 	if methodCounts["POST"] != 1 || methodCounts["GET"] != 1 || methodCounts["PUT"] != 1 || methodCounts["DELETE"] != 1 {
 		t.Errorf("scanRoutes() method counts = %v, want POST:1, GET:1, PUT:1, DELETE:1", methodCounts)
 	}
+
+	if !config.Docs {
+		t.Error("scanRoutes() should report the mounted docs routes")
+	}
+}
+
+// TestScanHandlerTypes tests request and response body discovery through go/types
+func TestScanHandlerTypes(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	handlersDir := filepath.Join(tmpDir, "handlers")
+	if err := os.MkdirAll(handlersDir, 0755); err != nil {
+		t.Fatalf("Failed to create handlers dir: %v", err)
+	}
+
+	handlersContent := `package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+type LoginRequest struct {
+	Email string ` + "`json:\"email\"`" + `
+}
+
+type Session struct {
+	Token string
+}
+
+func Login(w http.ResponseWriter, r *http.Request) {
+	var req LoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(Session{})
+}
+
+func Logout(w http.ResponseWriter, r *http.Request) {}
+`
+	if err := os.WriteFile(filepath.Join(handlersDir, "Login.go"), []byte(handlersContent), 0644); err != nil {
+		t.Fatalf("Failed to write handler: %v", err)
+	}
+
+	layer := &Layer{Root: tmpDir}
+	handlers, err := layer.ScanHandlerTypes(tmpDir)
+	if err != nil {
+		t.Fatalf("ScanHandlerTypes() error = %v", err)
+	}
+
+	login := handlers["Login"]
+	if login == nil {
+		t.Fatal("ScanHandlerTypes() should find Login")
+	}
+	if !strings.HasSuffix(fmt.Sprint(login.Request), "handlers.LoginRequest") {
+		t.Errorf("Login request = %v, want handlers.LoginRequest", login.Request)
+	}
+	if !strings.HasSuffix(fmt.Sprint(login.Response), "handlers.Session") {
+		t.Errorf("Login response = %v, want handlers.Session", login.Response)
+	}
+	if login.Status != 201 {
+		t.Errorf("Login status = %d, want 201", login.Status)
+	}
+
+	logout := handlers["Logout"]
+	if logout == nil || logout.Request != nil || logout.Response != nil {
+		t.Errorf("Logout should have no bodies, got %+v", logout)
+	}
 }
 
 // TestHydrate tests full service hydration
@@ -419,6 +492,29 @@ func Login(w http.ResponseWriter, r *http.Request) {
 	if !found {
 		t.Error("ScanConnections() should find auth-service -> broker-service connection")
 	}
+
+	// A declared connection replaces the scanned ones between the same services
+	layer.Connections = []*types.Connection{{
+		FromService: "auth-service",
+		ToService:   "broker-service",
+		Protocol:    types.ProtocolHTTP,
+		Route:       "/send",
+		Method:      "POST",
+		SourceFile:  "auth-service/clients/brokerservice/api.go",
+	}}
+	connections, err = layer.ScanConnections()
+	if err != nil {
+		t.Fatalf("ScanConnections() error = %v", err)
+	}
+	var declared []*types.Connection
+	for _, conn := range connections {
+		if conn.FromService == "auth-service" && conn.ToService == "broker-service" {
+			declared = append(declared, conn)
+		}
+	}
+	if len(declared) != 1 || declared[0].SourceFile != "auth-service/clients/brokerservice/api.go" || !declared[0].Valid {
+		t.Errorf("ScanConnections() auth-service -> broker-service = %+v, want the valid declared connection only", declared)
+	}
 }
 
 // TestValidateConnection tests connection validation
@@ -723,3 +819,65 @@ func TestConnectionPatternMatching(t *testing.T) {
 		})
 	}
 }
+
+// TestDotEnv tests reading and updating a dotenv file
+func TestDotEnv(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".env")
+	content := "# comment\n\nexport PLAIN=value # inline\nSINGLE='a #literal $x'\nDOUBLE=\"line\\nbreak\"\nPLAIN=override\n"
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	env, err := ReadDotEnv(path)
+	if err != nil {
+		t.Fatalf("ReadDotEnv() error = %v", err)
+	}
+
+	for key, want := range map[string]string{
+		"PLAIN":  "override",
+		"SINGLE": "a #literal $x",
+		"DOUBLE": "line\nbreak",
+	} {
+		if got, ok := env.Get(key); !ok || got != want {
+			t.Errorf("Get(%s) = %q, %v, want %q", key, got, ok, want)
+		}
+	}
+	if _, ok := env.Get("MISSING"); ok {
+		t.Error("Get() should report unset keys")
+	}
+	if keys := env.Keys(); strings.Join(keys, ",") != "PLAIN,SINGLE,DOUBLE" {
+		t.Errorf("Keys() = %v", keys)
+	}
+
+	env.Set("SINGLE", "plain")
+	env.Set("PLAIN", "last")
+	env.Set("NEW", "it's here")
+	if err := env.Save(); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	saved, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "# comment\n\nexport PLAIN=value # inline\nSINGLE=plain\nDOUBLE=\"line\\nbreak\"\nPLAIN=last\nNEW=\"it's here\"\n"
+	if string(saved) != want {
+		t.Errorf("saved .env = %q, want %q", saved, want)
+	}
+
+	// Delete drops every assignment, comments stay
+	env.Delete("PLAIN")
+	if _, ok := env.Get("PLAIN"); ok || strings.Join(env.Keys(), ",") != "SINGLE,DOUBLE,NEW" {
+		t.Errorf("after Delete(PLAIN), Keys() = %v", env.Keys())
+	}
+
+	missing, err := ReadDotEnv(filepath.Join(t.TempDir(), ".env"))
+	if err != nil || len(missing.Keys()) != 0 {
+		t.Errorf("ReadDotEnv() of a missing file = %v, %v", missing.Keys(), err)
+	}
+
+	for key, valid := range map[string]bool{"API_KEY": true, "_x1": true, "1ABC": false, "A-B": false, "": false} {
+		if ValidEnvKey(key) != valid {
+			t.Errorf("ValidEnvKey(%q) = %v, want %v", key, !valid, valid)
+		}
+	}
+}
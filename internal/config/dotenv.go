@@ -0,0 +1,143 @@
+package config
+
+import (
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// DotEnv is a dotenv file of KEY=value lines. Comments, blank lines and the
+// order of the keys are kept as written when it is saved back.
+type DotEnv struct {
+	Path  string
+	lines []string
+}
+
+// ReadDotEnv reads a dotenv file; a missing file reads as empty
+func ReadDotEnv(path string) (*DotEnv, error) {
+	env := &DotEnv{Path: path}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return env, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if content := strings.TrimSuffix(string(data), "\n"); content != "" {
+		env.lines = strings.Split(content, "\n")
+	}
+	return env, nil
+}
+
+// Get returns the value of a key; the last assignment wins, as in docker compose
+func (e *DotEnv) Get(key string) (string, bool) {
+	value, found := "", false
+	for _, line := range e.lines {
+		if k, v, ok := parseDotEnvLine(line); ok && k == key {
+			value, found = v, true
+		}
+	}
+	return value, found
+}
+
+// Set assigns a key in place of its last assignment, appended when unset
+func (e *DotEnv) Set(key, value string) {
+	line := key + "=" + quoteDotEnv(value)
+	for i := len(e.lines) - 1; i >= 0; i-- {
+		if k, _, ok := parseDotEnvLine(e.lines[i]); ok && k == key {
+			e.lines[i] = line
+			return
+		}
+	}
+	e.lines = append(e.lines, line)
+}
+
+// Delete removes every assignment of a key
+func (e *DotEnv) Delete(key string) {
+	e.lines = slices.DeleteFunc(e.lines, func(line string) bool {
+		k, _, ok := parseDotEnvLine(line)
+		return ok && k == key
+	})
+}
+
+// Keys returns the keys in the order they are first set
+func (e *DotEnv) Keys() []string {
+	var keys []string
+	seen := make(map[string]bool)
+	for _, line := range e.lines {
+		if k, _, ok := parseDotEnvLine(line); ok && !seen[k] {
+			seen[k] = true
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// Save writes the file readable by its owner only: it holds secrets
+func (e *DotEnv) Save() error {
+	content := strings.Join(e.lines, "\n")
+	if content != "" {
+		content += "\n"
+	}
+	return os.WriteFile(e.Path, []byte(content), 0600)
+}
+
+// ValidEnvKey reports whether key is a valid variable name: letters, digits
+// and underscores, not starting with a digit
+func ValidEnvKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	for i, r := range key {
+		switch {
+		case r == '_', r >= 'A' && r <= 'Z', r >= 'a' && r <= 'z':
+		case r >= '0' && r <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// parseDotEnvLine parses KEY=value, skipping comments and blank lines.
+// Single-quoted values are literal, double-quoted ones unescaped and
+// unquoted ones end at an inline " #" comment.
+func parseDotEnvLine(line string) (key, value string, ok bool) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return "", "", false
+	}
+	key, value, ok = strings.Cut(strings.TrimPrefix(line, "export "), "=")
+	if !ok {
+		return "", "", false
+	}
+	key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+
+	switch {
+	case len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'':
+		value = value[1 : len(value)-1]
+	case len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"':
+		if unquoted, err := strconv.Unquote(value); err == nil {
+			value = unquoted
+		} else {
+			value = value[1 : len(value)-1]
+		}
+	default:
+		if i := strings.Index(value, " #"); i >= 0 {
+			value = strings.TrimSpace(value[:i])
+		}
+	}
+	return key, value, true
+}
+
+// quoteDotEnv quotes values a dotenv parser would otherwise cut or expand
+func quoteDotEnv(value string) string {
+	if !strings.ContainsAny(value, " \t\n#'\"$\\") {
+		return value
+	}
+	if !strings.ContainsAny(value, "'\n") {
+		return "'" + value + "'"
+	}
+	return strconv.Quote(value)
+}
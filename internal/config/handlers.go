@@ -0,0 +1,194 @@
+package config
+
+import (
+	"fmt"
+	"go/ast"
+	"go/constant"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	gotypes "go/types"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// HandlerTypes holds the JSON bodies an HTTP handler decodes and encodes
+type HandlerTypes struct {
+	Request  gotypes.Type // decoded request body, nil when the handler reads none
+	Response gotypes.Type // encoded response body, nil when the handler writes none
+	Status   int          // status passed to w.WriteHeader, 0 when not set
+}
+
+// ScanHandlerTypes type-checks a service's handlers package and returns, per handler
+// function, the types passed to json.NewDecoder(...).Decode and json.NewEncoder(...).Encode.
+// Type errors are tolerated so partially written handlers still report what resolves.
+func (l *Layer) ScanHandlerTypes(servicePath string) (map[string]*HandlerTypes, error) {
+	handlersPath := filepath.Join(servicePath, "handlers")
+	if _, err := os.Stat(handlersPath); err != nil {
+		return nil, err
+	}
+
+	module := filepath.Base(servicePath)
+	if content, err := os.ReadFile(filepath.Join(servicePath, "go.mod")); err == nil {
+		if name := parseModuleName(string(content)); name != "" {
+			module = name
+		}
+	}
+
+	fset := token.NewFileSet()
+	im := &serviceImporter{
+		fset:     fset,
+		module:   module,
+		dir:      servicePath,
+		fallback: importer.ForCompiler(fset, "source", nil),
+		packages: make(map[string]*gotypes.Package),
+	}
+
+	files := parseGoDir(fset, handlersPath)
+	info := &gotypes.Info{
+		Types: make(map[ast.Expr]gotypes.TypeAndValue),
+		Defs:  make(map[*ast.Ident]gotypes.Object),
+		Uses:  make(map[*ast.Ident]gotypes.Object),
+	}
+	conf := gotypes.Config{Importer: im, Error: func(error) {}}
+	conf.Check(module+"/handlers", fset, files, info)
+
+	handlers := make(map[string]*HandlerTypes)
+	for _, file := range files {
+		for _, decl := range file.Decls {
+			fn, ok := decl.(*ast.FuncDecl)
+			if !ok || fn.Recv != nil || fn.Body == nil {
+				continue
+			}
+			handlers[fn.Name.Name] = scanHandlerBody(fn.Body, info)
+		}
+	}
+
+	return handlers, nil
+}
+
+// serviceImporter type-checks the packages of a service module from source.
+// Other imports go to the source importer, which only resolves the standard library
+// outside the service's module: unresolved types are left invalid.
+type serviceImporter struct {
+	fset     *token.FileSet
+	module   string
+	dir      string
+	fallback gotypes.Importer
+	packages map[string]*gotypes.Package
+}
+
+func (im *serviceImporter) Import(path string) (*gotypes.Package, error) {
+	if pkg, ok := im.packages[path]; ok {
+		return pkg, nil
+	}
+
+	rel, ok := strings.CutPrefix(path, im.module+"/")
+	if !ok {
+		return im.fallback.Import(path)
+	}
+
+	files := parseGoDir(im.fset, filepath.Join(im.dir, filepath.FromSlash(rel)))
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no Go files for %s", path)
+	}
+
+	conf := gotypes.Config{Importer: im, Error: func(error) {}}
+	pkg, _ := conf.Check(path, im.fset, files, nil)
+	im.packages[path] = pkg
+	return pkg, nil
+}
+
+// parseGoDir parses the non-test Go files of a directory, skipping those that don't parse
+func parseGoDir(fset *token.FileSet, dir string) []*ast.File {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+
+	var files []*ast.File
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".go") || strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		file, err := parser.ParseFile(fset, filepath.Join(dir, name), nil, 0)
+		if err != nil {
+			continue
+		}
+		files = append(files, file)
+	}
+	return files
+}
+
+// scanHandlerBody finds the decoded and encoded JSON bodies and the status code of a handler
+func scanHandlerBody(body *ast.BlockStmt, info *gotypes.Info) *HandlerTypes {
+	h := &HandlerTypes{}
+
+	ast.Inspect(body, func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpr)
+		if !ok || len(call.Args) == 0 {
+			return true
+		}
+		sel, ok := call.Fun.(*ast.SelectorExpr)
+		if !ok {
+			return true
+		}
+
+		switch sel.Sel.Name {
+		case "Decode":
+			if h.Request == nil && isJSONCall(sel.X, "NewDecoder") {
+				h.Request = bodyType(info.TypeOf(call.Args[0]))
+			}
+		case "Encode":
+			if h.Response == nil && isJSONCall(sel.X, "NewEncoder") {
+				h.Response = bodyType(info.TypeOf(call.Args[0]))
+			}
+		case "Unmarshal":
+			if h.Request == nil && len(call.Args) == 2 && isIdent(sel.X, "json") {
+				h.Request = bodyType(info.TypeOf(call.Args[1]))
+			}
+		case "WriteHeader":
+			if h.Status == 0 {
+				if tv, ok := info.Types[call.Args[0]]; ok && tv.Value != nil {
+					if status, ok := constant.Int64Val(tv.Value); ok {
+						h.Status = int(status)
+					}
+				}
+			}
+		}
+		return true
+	})
+
+	return h
+}
+
+// isJSONCall reports whether expr is a call to json.<name>
+func isJSONCall(expr ast.Expr, name string) bool {
+	call, ok := expr.(*ast.CallExpr)
+	if !ok {
+		return false
+	}
+	sel, ok := call.Fun.(*ast.SelectorExpr)
+	return ok && sel.Sel.Name == name && isIdent(sel.X, "json")
+}
+
+func isIdent(expr ast.Expr, name string) bool {
+	ident, ok := expr.(*ast.Ident)
+	return ok && ident.Name == name
+}
+
+// bodyType strips the pointer a value is decoded through; unresolved types are dropped
+func bodyType(t gotypes.Type) gotypes.Type {
+	if t == nil {
+		return nil
+	}
+	if ptr, ok := t.(*gotypes.Pointer); ok {
+		t = ptr.Elem()
+	}
+	if basic, ok := t.(*gotypes.Basic); ok && basic.Kind() == gotypes.Invalid {
+		return nil
+	}
+	return t
+}
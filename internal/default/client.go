@@ -0,0 +1,491 @@
+package defaults
+
+import (
+	"go/ast"
+	"go/token"
+	"regexp"
+	"strings"
+
+	"github.com/flaviogonzalez/instant-layer/internal/factory"
+	"github.com/flaviogonzalez/instant-layer/internal/types"
+)
+
+// ClientsDir is the directory of a service holding its clients of other services
+const ClientsDir = "clients"
+
+// pathParam matches chi path parameters, with an optional regexp: {id} or {id:[0-9]+}
+var pathParam = regexp.MustCompile(`\{([^}:]+)(:[^}]*)?\}`)
+
+// typeName matches the exported type names of a Go type expression
+var typeName = regexp.MustCompile(`[A-Z]\w*`)
+
+// ClientPackageName returns the package name of the client of a service (auth-service -> authservice)
+func ClientPackageName(service string) string {
+	return strings.Join(splitWords(service), "")
+}
+
+// ClientBaseURLEnv returns the environment variable holding the base URL of a service
+func ClientBaseURLEnv(service string) string {
+	return strings.ToUpper(SnakeCase(service)) + "_URL"
+}
+
+// ClientBaseURL returns the URL a service is reached at inside the compose network
+func ClientBaseURL(service string) string {
+	return "http://" + service
+}
+
+// ClientPackage generates a typed HTTP client of a service from its routes
+// Contains: client.go (base URL, timeouts, retries), api.go (one method per route)
+// and a file per model of the service
+func ClientPackage(target *types.Service) *types.Package {
+	name := ClientPackageName(target.Name)
+
+	files := []*types.File{
+		clientFile(name, target),
+		clientAPIFile(name, target),
+	}
+	for _, m := range target.Models {
+		f := modelFile(m)
+		f.Content.Name = ast.NewIdent(name)
+		files = append(files, f)
+	}
+
+	return &types.Package{Name: name, Files: files}
+}
+
+// clientFile generates client.go: the Client, its constructor and the request loop
+func clientFile(pkg string, target *types.Service) *types.File {
+	imports := factory.NewImportDecl(
+		factory.NewImport("bytes", ""),
+		factory.NewImport("context", ""),
+		factory.NewImport("encoding/json", ""),
+		factory.NewImport("errors", ""),
+		factory.NewImport("fmt", ""),
+		factory.NewImport("io", ""),
+		factory.NewImport("net/http", ""),
+		factory.NewImport("os", ""),
+		factory.NewImport("time", ""),
+	)
+
+	consts := []ast.Decl{
+		factory.NewConstDecl("BaseURLEnv", factory.NewBasicLit(ClientBaseURLEnv(target.Name))),
+		factory.NewConstDecl("DefaultBaseURL", factory.NewBasicLit(ClientBaseURL(target.Name))),
+		factory.NewConstDecl("DefaultTimeout", &ast.BinaryExpr{
+			X: factory.NewBasicLitInt(10), Op: token.MUL, Y: factory.NewSelector("time", "Second"),
+		}),
+	}
+
+	// type Client struct { BaseURL string; HTTPClient *http.Client; Retries int; RetryDelay time.Duration }
+	clientStruct := factory.NewStructDecl("Client",
+		factory.NewField("BaseURL", ast.NewIdent("string")),
+		factory.NewField("HTTPClient", &ast.StarExpr{X: factory.NewSelector("http", "Client")}),
+		factory.NewField("Retries", ast.NewIdent("int")),
+		factory.NewField("RetryDelay", factory.NewSelector("time", "Duration")),
+	)
+
+	// func New() *Client
+	newFunc := factory.NewFuncDecl(
+		"New",
+		factory.NewFieldList(),
+		factory.NewFuncType(
+			factory.NewFieldList(),
+			factory.NewFieldList(factory.NewField("", &ast.StarExpr{X: ast.NewIdent("Client")})),
+		),
+		factory.NewBodyStmt(
+			factory.NewDefine("baseURL", factory.NewSelectorCall("os", "Getenv", ast.NewIdent("BaseURLEnv"))),
+			factory.NewIf(
+				&ast.BinaryExpr{X: ast.NewIdent("baseURL"), Op: token.EQL, Y: factory.NewBasicLit("")},
+				factory.NewAssign(ast.NewIdent("baseURL"), ast.NewIdent("DefaultBaseURL")),
+			),
+			factory.NewReturn(factory.NewAddressOf(factory.NewCompositeLit(ast.NewIdent("Client"),
+				factory.NewKeyValue("BaseURL", ast.NewIdent("baseURL")),
+				factory.NewKeyValue("HTTPClient", factory.NewAddressOf(factory.NewCompositeLit(
+					factory.NewSelector("http", "Client"),
+					factory.NewKeyValue("Timeout", ast.NewIdent("DefaultTimeout")),
+				))),
+				factory.NewKeyValue("Retries", factory.NewBasicLitInt(2)),
+				factory.NewKeyValue("RetryDelay", &ast.BinaryExpr{
+					X: factory.NewBasicLitInt(100), Op: token.MUL, Y: factory.NewSelector("time", "Millisecond"),
+				}),
+			))),
+		),
+	)
+
+	// type Error struct { StatusCode int; Body string }
+	errorStruct := factory.NewStructDecl("Error",
+		factory.NewField("StatusCode", ast.NewIdent("int")),
+		factory.NewField("Body", ast.NewIdent("string")),
+	)
+
+	// func (e *Error) Error() string
+	errorFunc := factory.NewFuncDecl(
+		"Error",
+		factory.NewFieldList(factory.NewField("e", &ast.StarExpr{X: ast.NewIdent("Error")})),
+		factory.NewFuncType(
+			factory.NewFieldList(),
+			factory.NewFieldList(factory.NewField("", ast.NewIdent("string"))),
+		),
+		factory.NewBodyStmt(
+			factory.NewReturn(factory.NewSelectorCall("fmt", "Sprintf",
+				factory.NewBasicLit(target.Name+": %d %s"),
+				factory.NewSelector("e", "StatusCode"),
+				factory.NewSelector("e", "Body"),
+			)),
+		),
+	)
+
+	return &types.File{
+		Name: "client.go",
+		Content: factory.NewFileNode(pkg,
+			append(append([]ast.Decl{imports}, consts...),
+				clientStruct,
+				newFunc,
+				errorStruct,
+				errorFunc,
+				clientDoFunc(),
+				clientSendFunc(),
+				clientRetryableFunc(),
+			)...,
+		),
+	}
+}
+
+// clientDoFunc generates do: marshals the body and sends the request, retrying
+// idempotent methods with a linear backoff while the error is retryable
+func clientDoFunc() *ast.FuncDecl {
+	sendCall := factory.NewSelectorCall("c", "send",
+		ast.NewIdent("ctx"), ast.NewIdent("method"), ast.NewIdent("path"), ast.NewIdent("body"), ast.NewIdent("out"),
+	)
+
+	return factory.NewFuncDecl(
+		"do",
+		factory.NewFieldList(factory.NewField("c", &ast.StarExpr{X: ast.NewIdent("Client")})),
+		factory.NewFuncType(
+			factory.NewFieldList(
+				factory.NewField("ctx", factory.NewSelector("context", "Context")),
+				&ast.Field{Names: []*ast.Ident{ast.NewIdent("method"), ast.NewIdent("path")}, Type: ast.NewIdent("string")},
+				&ast.Field{Names: []*ast.Ident{ast.NewIdent("in"), ast.NewIdent("out")}, Type: ast.NewIdent("any")},
+			),
+			factory.NewFieldList(factory.NewField("", ast.NewIdent("error"))),
+		),
+		factory.NewBodyStmt(
+			// var body []byte
+			factory.NewVarStmt("body", &ast.ArrayType{Elt: ast.NewIdent("byte")}),
+			factory.NewIf(
+				&ast.BinaryExpr{X: ast.NewIdent("in"), Op: token.NEQ, Y: ast.NewIdent("nil")},
+				factory.NewDefineExpectsError("data", factory.NewSelectorCall("json", "Marshal", ast.NewIdent("in"))),
+				factory.NewIfError(factory.NewReturn(ast.NewIdent("err"))),
+				factory.NewAssign(ast.NewIdent("body"), ast.NewIdent("data")),
+			),
+			// POST and PATCH aren't idempotent and are sent once
+			factory.NewDefine("retries", factory.NewSelector("c", "Retries")),
+			factory.NewIf(
+				&ast.BinaryExpr{
+					X:  &ast.BinaryExpr{X: ast.NewIdent("method"), Op: token.EQL, Y: factory.NewSelector("http", "MethodPost")},
+					Op: token.LOR,
+					Y:  &ast.BinaryExpr{X: ast.NewIdent("method"), Op: token.EQL, Y: factory.NewSelector("http", "MethodPatch")},
+				},
+				factory.NewAssign(ast.NewIdent("retries"), factory.NewBasicLitInt(0)),
+			),
+			factory.NewDefine("err", sendCall),
+			// for attempt := 1; attempt <= retries && retryable(err); attempt++
+			&ast.ForStmt{
+				Init: factory.NewDefine("attempt", factory.NewBasicLitInt(1)),
+				Cond: &ast.BinaryExpr{
+					X:  &ast.BinaryExpr{X: ast.NewIdent("attempt"), Op: token.LEQ, Y: ast.NewIdent("retries")},
+					Op: token.LAND,
+					Y:  factory.NewCall(ast.NewIdent("retryable"), ast.NewIdent("err")),
+				},
+				Post: &ast.IncDecStmt{X: ast.NewIdent("attempt"), Tok: token.INC},
+				Body: factory.NewBodyStmt(
+					&ast.SelectStmt{Body: factory.NewBodyStmt(
+						&ast.CommClause{
+							Comm: factory.NewExprStmt(&ast.UnaryExpr{Op: token.ARROW, X: factory.NewSelectorCall("ctx", "Done")}),
+							Body: []ast.Stmt{factory.NewReturn(factory.NewSelectorCall("ctx", "Err"))},
+						},
+						&ast.CommClause{
+							Comm: factory.NewExprStmt(&ast.UnaryExpr{Op: token.ARROW, X: factory.NewSelectorCall("time", "After",
+								&ast.BinaryExpr{
+									X:  factory.NewSelector("c", "RetryDelay"),
+									Op: token.MUL,
+									Y:  factory.NewSelectorCall("time", "Duration", ast.NewIdent("attempt")),
+								},
+							)}),
+						},
+					)},
+					factory.NewAssign(ast.NewIdent("err"), sendCall),
+				),
+			},
+			factory.NewReturn(ast.NewIdent("err")),
+		),
+	)
+}
+
+// clientSendFunc generates send: one request, non-2xx responses returned as *Error
+func clientSendFunc() *ast.FuncDecl {
+	return factory.NewFuncDecl(
+		"send",
+		factory.NewFieldList(factory.NewField("c", &ast.StarExpr{X: ast.NewIdent("Client")})),
+		factory.NewFuncType(
+			factory.NewFieldList(
+				factory.NewField("ctx", factory.NewSelector("context", "Context")),
+				&ast.Field{Names: []*ast.Ident{ast.NewIdent("method"), ast.NewIdent("path")}, Type: ast.NewIdent("string")},
+				factory.NewField("body", &ast.ArrayType{Elt: ast.NewIdent("byte")}),
+				factory.NewField("out", ast.NewIdent("any")),
+			),
+			factory.NewFieldList(factory.NewField("", ast.NewIdent("error"))),
+		),
+		factory.NewBodyStmt(
+			factory.NewVarStmt("reader", factory.NewSelector("io", "Reader")),
+			factory.NewIf(
+				&ast.BinaryExpr{X: ast.NewIdent("body"), Op: token.NEQ, Y: ast.NewIdent("nil")},
+				factory.NewAssign(ast.NewIdent("reader"), factory.NewSelectorCall("bytes", "NewReader", ast.NewIdent("body"))),
+			),
+			factory.NewDefineExpectsError("req", factory.NewSelectorCall("http", "NewRequestWithContext",
+				ast.NewIdent("ctx"),
+				ast.NewIdent("method"),
+				&ast.BinaryExpr{X: factory.NewSelector("c", "BaseURL"), Op: token.ADD, Y: ast.NewIdent("path")},
+				ast.NewIdent("reader"),
+			)),
+			factory.NewIfError(factory.NewReturn(ast.NewIdent("err"))),
+			factory.NewExprStmt(factory.NewSelectorCall("req", "Header.Set",
+				factory.NewBasicLit("Accept"), factory.NewBasicLit("application/json"),
+			)),
+			factory.NewIf(
+				&ast.BinaryExpr{X: ast.NewIdent("body"), Op: token.NEQ, Y: ast.NewIdent("nil")},
+				factory.NewExprStmt(factory.NewSelectorCall("req", "Header.Set",
+					factory.NewBasicLit("Content-Type"), factory.NewBasicLit("application/json"),
+				)),
+			),
+			factory.NewDefineExpectsError("resp", factory.NewSelectorCall("c", "HTTPClient.Do", ast.NewIdent("req"))),
+			factory.NewIfError(factory.NewReturn(ast.NewIdent("err"))),
+			&ast.DeferStmt{Call: factory.NewSelectorCall("resp", "Body.Close")},
+			factory.NewIf(
+				&ast.BinaryExpr{X: factory.NewSelector("resp", "StatusCode"), Op: token.GEQ, Y: factory.NewBasicLitInt(300)},
+				&ast.AssignStmt{
+					Lhs: []ast.Expr{ast.NewIdent("msg"), ast.NewIdent("_")},
+					Tok: token.DEFINE,
+					Rhs: []ast.Expr{factory.NewSelectorCall("io", "ReadAll", factory.NewSelector("resp", "Body"))},
+				},
+				factory.NewReturn(factory.NewAddressOf(factory.NewCompositeLit(ast.NewIdent("Error"),
+					factory.NewKeyValue("StatusCode", factory.NewSelector("resp", "StatusCode")),
+					factory.NewKeyValue("Body", factory.NewCall(ast.NewIdent("string"), ast.NewIdent("msg"))),
+				))),
+			),
+			factory.NewIf(
+				&ast.BinaryExpr{
+					X:  &ast.BinaryExpr{X: ast.NewIdent("out"), Op: token.EQL, Y: ast.NewIdent("nil")},
+					Op: token.LOR,
+					Y:  &ast.BinaryExpr{X: factory.NewSelector("resp", "StatusCode"), Op: token.EQL, Y: factory.NewSelector("http", "StatusNoContent")},
+				},
+				factory.NewReturn(ast.NewIdent("nil")),
+			),
+			factory.NewReturn(factory.NewCall(
+				&ast.SelectorExpr{
+					X:   factory.NewSelectorCall("json", "NewDecoder", factory.NewSelector("resp", "Body")),
+					Sel: ast.NewIdent("Decode"),
+				},
+				ast.NewIdent("out"),
+			)),
+		),
+	)
+}
+
+// clientRetryableFunc generates retryable: network errors, 5xx and 429 are retried,
+// cancelled or expired contexts are not
+func clientRetryableFunc() *ast.FuncDecl {
+	return factory.NewFuncDecl(
+		"retryable",
+		factory.NewFieldList(),
+		factory.NewFuncType(
+			factory.NewFieldList(factory.NewField("err", ast.NewIdent("error"))),
+			factory.NewFieldList(factory.NewField("", ast.NewIdent("bool"))),
+		),
+		factory.NewBodyStmt(
+			factory.NewIf(
+				&ast.BinaryExpr{
+					X: &ast.BinaryExpr{
+						X:  &ast.BinaryExpr{X: ast.NewIdent("err"), Op: token.EQL, Y: ast.NewIdent("nil")},
+						Op: token.LOR,
+						Y:  factory.NewSelectorCall("errors", "Is", ast.NewIdent("err"), factory.NewSelector("context", "Canceled")),
+					},
+					Op: token.LOR,
+					Y:  factory.NewSelectorCall("errors", "Is", ast.NewIdent("err"), factory.NewSelector("context", "DeadlineExceeded")),
+				},
+				factory.NewReturn(ast.NewIdent("false")),
+			),
+			factory.NewVarStmt("apiErr", &ast.StarExpr{X: ast.NewIdent("Error")}),
+			factory.NewIf(
+				factory.NewSelectorCall("errors", "As", ast.NewIdent("err"), factory.NewAddressOf(ast.NewIdent("apiErr"))),
+				factory.NewReturn(&ast.BinaryExpr{
+					X:  &ast.BinaryExpr{X: factory.NewSelector("apiErr", "StatusCode"), Op: token.GEQ, Y: factory.NewBasicLitInt(500)},
+					Op: token.LOR,
+					Y:  &ast.BinaryExpr{X: factory.NewSelector("apiErr", "StatusCode"), Op: token.EQL, Y: factory.NewSelector("http", "StatusTooManyRequests")},
+				}),
+			),
+			factory.NewReturn(ast.NewIdent("true")),
+		),
+	)
+}
+
+// clientAPIFile generates api.go: a method per route of the target. Routes with
+// typed bodies take and return their models, the others take in and out values.
+func clientAPIFile(pkg string, target *types.Service) *types.File {
+	models := make(map[string]bool)
+	for _, m := range target.Models {
+		models[m.Name] = true
+	}
+
+	usesURL := false
+	var methods []ast.Decl
+	seen := make(map[string]bool)
+
+	for _, group := range target.RoutesConfig.RoutesGroup {
+		for _, route := range group.Routes {
+			if route.Handler == "" || seen[route.Handler] {
+				continue
+			}
+			seen[route.Handler] = true
+
+			path, params := clientPathExpr(route.Path)
+			usesURL = usesURL || len(params) > 0
+			methods = append(methods, clientMethod(route, path, params, models))
+		}
+	}
+
+	importSpecs := []*ast.ImportSpec{
+		factory.NewImport("context", ""),
+		factory.NewImport("net/http", ""),
+	}
+	if usesURL {
+		importSpecs = append(importSpecs, factory.NewImport("net/url", ""))
+	}
+
+	return &types.File{
+		Name:    "api.go",
+		Content: factory.NewFileNode(pkg, append([]ast.Decl{factory.NewImportDecl(importSpecs...)}, methods...)...),
+	}
+}
+
+// clientPathExpr builds the request path of a route, escaping its parameters:
+// /pets/{id}/toys -> "/pets/" + url.PathEscape(id) + "/toys"
+func clientPathExpr(pattern string) (ast.Expr, []string) {
+	var expr ast.Expr
+	var params []string
+
+	add := func(e ast.Expr) {
+		if expr == nil {
+			expr = e
+			return
+		}
+		expr = &ast.BinaryExpr{X: expr, Op: token.ADD, Y: e}
+	}
+
+	last := 0
+	for _, loc := range pathParam.FindAllStringSubmatchIndex(pattern, -1) {
+		if loc[0] > last {
+			add(factory.NewBasicLit(pattern[last:loc[0]]))
+		}
+		param := clientParamName(pattern[loc[2]:loc[3]])
+		params = append(params, param)
+		add(factory.NewSelectorCall("url", "PathEscape", ast.NewIdent(param)))
+		last = loc[1]
+	}
+	if last < len(pattern) || expr == nil {
+		add(factory.NewBasicLit(pattern[last:]))
+	}
+
+	return expr, params
+}
+
+// clientParamName turns a path parameter into a Go parameter name (pet_id -> petID)
+func clientParamName(name string) string {
+	goName := GoName(name)
+	words := splitWords(name)
+	if len(words) > 0 && initialisms[words[0]] {
+		goName = strings.ToLower(words[0]) + goName[len(words[0]):]
+	} else if goName != "" {
+		goName = strings.ToLower(goName[:1]) + goName[1:]
+	}
+	switch goName {
+	case "", "c", "ctx", "req", "resp", "err", "in", "out", "url", "http", "context":
+		return goName + "Param"
+	}
+	return goName
+}
+
+// clientType returns the client package type of a body, empty when it references
+// models the target didn't record
+func clientType(typ string, models map[string]bool) string {
+	if typ == "" {
+		return ""
+	}
+	local := strings.ReplaceAll(typ, ModelsPackageName+".", "")
+	for _, m := range typeName.FindAllString(local, -1) {
+		if strings.Contains(typ, ModelsPackageName+"."+m) && !models[m] {
+			return ""
+		}
+	}
+	return local
+}
+
+// clientMethod generates the client method of a route
+func clientMethod(route *types.Route, path ast.Expr, params []string, models map[string]bool) *ast.FuncDecl {
+	method := factory.NewSelector("http", "Method"+GoName(strings.ToLower(route.Method)))
+	request := clientType(route.Request, models)
+	response := clientType(route.Response, models)
+	typed := typedRoute(route) &&
+		(route.Request == "" || request != "") &&
+		(route.Response == "" || response != "")
+
+	fields := []*ast.Field{factory.NewField("ctx", factory.NewSelector("context", "Context"))}
+	if len(params) > 0 {
+		var names []*ast.Ident
+		for _, p := range params {
+			names = append(names, ast.NewIdent(p))
+		}
+		fields = append(fields, &ast.Field{Names: names, Type: ast.NewIdent("string")})
+	}
+
+	var body []ast.Stmt
+	results := []*ast.Field{factory.NewField("", ast.NewIdent("error"))}
+
+	switch {
+	case !typed:
+		// func (c *Client) X(ctx context.Context, params..., in, out any) error
+		fields = append(fields, &ast.Field{Names: []*ast.Ident{ast.NewIdent("in"), ast.NewIdent("out")}, Type: ast.NewIdent("any")})
+		body = append(body, factory.NewReturn(factory.NewSelectorCall("c", "do",
+			ast.NewIdent("ctx"), method, path, ast.NewIdent("in"), ast.NewIdent("out"),
+		)))
+	default:
+		in := ast.Expr(ast.NewIdent("nil"))
+		if request != "" {
+			fields = append(fields, factory.NewField("req", factory.NewTypeExpr(request)))
+			in = ast.NewIdent("req")
+		}
+
+		if response == "" {
+			body = append(body, factory.NewReturn(factory.NewSelectorCall("c", "do",
+				ast.NewIdent("ctx"), method, path, in, ast.NewIdent("nil"),
+			)))
+			break
+		}
+
+		// var resp T; err := c.do(...); return resp, err
+		results = append([]*ast.Field{factory.NewField("", factory.NewTypeExpr(response))}, results...)
+		body = append(body,
+			factory.NewVarStmt("resp", factory.NewTypeExpr(response)),
+			factory.NewDefine("err", factory.NewSelectorCall("c", "do",
+				ast.NewIdent("ctx"), method, path, in, factory.NewAddressOf(ast.NewIdent("resp")),
+			)),
+			factory.NewReturn(ast.NewIdent("resp"), ast.NewIdent("err")),
+		)
+	}
+
+	return factory.NewFuncDecl(
+		route.Handler,
+		factory.NewFieldList(factory.NewField("c", &ast.StarExpr{X: ast.NewIdent("Client")})),
+		factory.NewFuncType(factory.NewFieldList(fields...), factory.NewFieldList(results...)),
+		factory.NewBodyStmt(body...),
+	)
+}
@@ -51,6 +51,38 @@ var AvailableTemplates = []*Template{
 			WithName("listener-service"),
 		),
 	},
+	{
+		ID:          "grpc",
+		Name:        "grpc-service",
+		Description: "gRPC service with a .proto skeleton, health checks, reflection and graceful stop.",
+		Service: GRPCService(
+			WithName("grpc-service"),
+		),
+	},
+	{
+		ID:          "websocket",
+		Name:        "websocket-service",
+		Description: "websocket hub with read/write pumps, ping/pong keepalive and an optional bridge from broker events.",
+		Service: WebSocketService(
+			WithName("websocket-service"),
+		),
+	},
+	{
+		ID:          "gateway",
+		Name:        "gateway-service",
+		Description: "API gateway reverse-proxying the other services' routes, with central auth, CORS and rate limiting.",
+		Service: GatewayService(
+			WithName("gateway-service"),
+		),
+	},
+	{
+		ID:          "worker",
+		Name:        "worker-service",
+		Description: "background worker running scheduled jobs with overlap prevention, timeouts and optional Postgres leader locking.",
+		Service: WorkerService(
+			WithName("worker-service"),
+		),
+	},
 }
 
 func DefaultService(opts ...Option) *types.Service {
@@ -67,6 +99,23 @@ func DefaultService(opts ...Option) *types.Service {
 		WithPostgres(),
 		WithMain(),
 		WithRoutes(),
+		WithHTTPLogger(),
+		WithHealth(),
+	}
+	if s.Metrics {
+		baseOpts = append(baseOpts, WithMetricsPackage(true))
+	}
+	if s.Tracing {
+		baseOpts = append(baseOpts, WithTracingPackage(true))
+	}
+
+	// Outbox services relay their events through a broker emitter
+	if s.Outbox {
+		if s.Messaging == nil {
+			s.Messaging = DefaultMessaging()
+		}
+		s.Messaging.Bindings = nil
+		baseOpts = append(baseOpts, WithBrokerEvent(), WithOutboxPackage())
 	}
 
 	return applyOptions(s, baseOpts...)
@@ -77,7 +126,7 @@ func DefaultConfigFile(s *types.Service) *types.File {
 	importSpecs := []*ast.ImportSpec{
 		factory.NewImport(s.Name+"/routes", ""),
 		factory.NewImport("database/sql", ""),
-		factory.NewImport("log", ""),
+		factory.NewImport("log/slog", ""),
 		factory.NewImport("net/http", ""),
 		factory.NewImport("os", ""),
 		factory.NewImport("time", ""),
@@ -96,36 +145,62 @@ func DefaultConfigFile(s *types.Service) *types.File {
 		)
 	}
 
+	if s.DB != nil || s.Outbox {
+		importSpecs = append(importSpecs, factory.NewImport(s.Name+"/"+HealthPackageName, ""))
+	}
+	if s.DB != nil && s.Metrics {
+		importSpecs = append(importSpecs, factory.NewImport(s.Name+"/"+MetricsPackageName, ""))
+	}
+
+	if s.Outbox {
+		importSpecs = append(importSpecs,
+			factory.NewImport(s.Name+"/event", ""),
+			factory.NewImport(s.Name+"/outbox", ""),
+			factory.NewImport("context", ""),
+		)
+	}
+
 	imports := factory.NewImportDecl(importSpecs...)
 
 	// var counts int
 	countsVar := factory.NewVarDecl("counts", ast.NewIdent("int"))
 
-	// type Config struct { Db *sql.DB }
+	// type Config struct { Db *sql.DB; Env *Env }
 	configStruct := factory.NewTypeStruct("Config", factory.NewFieldList(
 		factory.NewField("Db", &ast.StarExpr{X: factory.NewSelector("sql", "DB")}),
+		envField(),
 	))
 
-	// func InitConfig() *Config
+	initConfigStmts := []ast.Stmt{
+		factory.NewDefine("db", factory.NewCall(ast.NewIdent("connectToDB"), factory.NewSelector("env", "DatabaseURL"))),
+	}
+	// The readiness probe pings the pool
+	if s.DB != nil {
+		initConfigStmts = append(initConfigStmts, registerCheckStmt("postgres", "Postgres", ast.NewIdent("db")))
+		if s.Metrics {
+			initConfigStmts = append(initConfigStmts, registerDBStmt(s))
+		}
+	}
+	initConfigStmts = append(initConfigStmts, factory.NewReturn(
+		&ast.UnaryExpr{
+			Op: token.AND,
+			X: factory.NewCompositeLit(
+				ast.NewIdent("Config"),
+				factory.NewKeyValue("Db", ast.NewIdent("db")),
+				factory.NewKeyValue("Env", ast.NewIdent("env")),
+			),
+		},
+	))
+
+	// func InitConfig(env *Env) *Config
 	initConfigFunc := factory.NewFuncDecl(
 		"InitConfig",
 		factory.NewFieldList(),
 		factory.NewFuncType(
-			factory.NewFieldList(),
+			envParams(),
 			factory.NewFieldList(factory.NewField("", &ast.StarExpr{X: ast.NewIdent("Config")})),
 		),
-		factory.NewBodyStmt(
-			factory.NewDefine("db", factory.NewCall(ast.NewIdent("connectToDB"))),
-			factory.NewReturn(
-				&ast.UnaryExpr{
-					Op: token.AND,
-					X: factory.NewCompositeLit(
-						ast.NewIdent("Config"),
-						factory.NewKeyValue("Db", ast.NewIdent("db")),
-					),
-				},
-			),
-		),
+		factory.NewBodyStmt(initConfigStmts...),
 	)
 
 	// func (app *Config) InitServer()
@@ -141,18 +216,18 @@ func DefaultConfigFile(s *types.Service) *types.File {
 					Op: token.AND,
 					X: factory.NewCompositeLit(
 						factory.NewSelector("http", "Server"),
-						factory.NewKeyValue("Addr", factory.NewBasicLit(":80")),
-						factory.NewKeyValue("Handler",
-							factory.NewSelectorCall("routes", "Routes",
-								factory.NewSelector("app", "Db"),
+						append([]ast.Expr{
+							factory.NewKeyValue("Addr", serverAddr()),
+							factory.NewKeyValue("Handler",
+								factory.NewSelectorCall("routes", "Routes",
+									factory.NewSelector("app", "Db"),
+								),
 							),
-						),
+						}, serverTimeouts()...)...,
 					),
 				},
 			),
-			factory.NewIfError(
-				factory.NewExprStmt(factory.NewSelectorCall("log", "Fatal", ast.NewIdent("err"))),
-			),
+			factory.NewIfError(logFatal("server stopped", errAttr())...),
 		),
 	)
 
@@ -164,11 +239,33 @@ func DefaultConfigFile(s *types.Service) *types.File {
 			Op: token.NEQ,
 			Y:  ast.NewIdent("nil"),
 		},
-		Body: factory.NewBodyStmt(
-			factory.NewExprStmt(factory.NewSelectorCall("log", "Fatal", ast.NewIdent("err"))),
+		Body: factory.NewBodyStmt(logFatal("server stopped", errAttr())...),
+	}
+
+	// Start relaying the outbox before serving requests that fill it
+	if s.Outbox {
+		initServerFunc.Body.List = append(outboxRelayStmts(s), initServerFunc.Body.List...)
+	}
+
+	openDBFunc, connectToDBFunc := connectToDBFuncs(driverName)
+
+	return &types.File{
+		Name: "config.go",
+		Content: factory.NewFileNode("config",
+			imports,
+			countsVar,
+			configStruct,
+			initConfigFunc,
+			initServerFunc,
+			openDBFunc,
+			connectToDBFunc,
 		),
 	}
+}
 
+// connectToDBFuncs generates openDB and connectToDB, which retries opening
+// the DATABASE_URL of config.Env while postgres starts. Uses the package-level counts var.
+func connectToDBFuncs(driverName string) (*ast.FuncDecl, *ast.FuncDecl) {
 	// func openDB(dsn string) (*sql.DB, error)
 	openDBFunc := factory.NewFuncDecl(
 		"openDB",
@@ -198,16 +295,15 @@ func DefaultConfigFile(s *types.Service) *types.File {
 		),
 	)
 
-	// func connectToDB() *sql.DB
+	// func connectToDB(dsn string) *sql.DB
 	connectToDBFunc := factory.NewFuncDecl(
 		"connectToDB",
 		factory.NewFieldList(),
 		factory.NewFuncType(
-			factory.NewFieldList(),
+			factory.NewFieldList(factory.NewField("dsn", ast.NewIdent("string"))),
 			factory.NewFieldList(factory.NewField("", &ast.StarExpr{X: factory.NewSelector("sql", "DB")})),
 		),
 		factory.NewBodyStmt(
-			factory.NewDefine("dsn", factory.NewSelectorCall("os", "Getenv", factory.NewBasicLit("DATABASE_URL"))),
 			&ast.ForStmt{
 				Body: factory.NewBodyStmt(
 					factory.NewDefineExpectsError("conn", factory.NewCall(ast.NewIdent("openDB"), ast.NewIdent("dsn"))),
@@ -218,11 +314,11 @@ func DefaultConfigFile(s *types.Service) *types.File {
 							Y:  ast.NewIdent("nil"),
 						},
 						Body: factory.NewBodyStmt(
-							factory.NewExprStmt(factory.NewSelectorCall("log", "Println", factory.NewBasicLit("postgres no parece estar listo..."))),
+							logWarn("postgres not ready yet", errAttr()),
 							&ast.IncDecStmt{X: ast.NewIdent("counts"), Tok: token.INC},
 						),
 						Else: factory.NewBodyStmt(
-							factory.NewExprStmt(factory.NewSelectorCall("log", "Println", factory.NewBasicLit("conectado a postgres"))),
+							logInfo("connected to postgres"),
 							factory.NewReturn(ast.NewIdent("conn")),
 						),
 					},
@@ -233,11 +329,11 @@ func DefaultConfigFile(s *types.Service) *types.File {
 							Y:  factory.NewBasicLitInt(10),
 						},
 						Body: factory.NewBodyStmt(
-							factory.NewExprStmt(factory.NewSelectorCall("log", "Println", ast.NewIdent("err"))),
+							logError("giving up on postgres", errAttr()),
 							factory.NewReturn(ast.NewIdent("nil")),
 						),
 					},
-					factory.NewExprStmt(factory.NewSelectorCall("log", "Println", factory.NewBasicLit("esperando por dos segundos"))),
+					logInfo("retrying postgres connection", attr("backoff", factory.NewBasicLit("2s"))),
 					factory.NewExprStmt(factory.NewSelectorCall("time", "Sleep",
 						&ast.BinaryExpr{
 							X:  factory.NewBasicLitInt(2),
@@ -251,42 +347,29 @@ func DefaultConfigFile(s *types.Service) *types.File {
 		),
 	)
 
-	return &types.File{
-		Name: "config.go",
-		Content: factory.NewFileNode("config",
-			imports,
-			countsVar,
-			configStruct,
-			initConfigFunc,
-			initServerFunc,
-			openDBFunc,
-			connectToDBFunc,
-		),
-	}
+	return openDBFunc, connectToDBFunc
 }
 
 func DefaultMainFile(s *types.Service) *types.File {
 	// import "{service-name}/config"
-	imports := factory.NewImportDecl(
+	imports := factory.NewImportDecl(append([]*ast.ImportSpec{
 		factory.NewImport(s.Name+"/config", ""),
-	)
+		factory.NewImport(s.Name+"/"+LoggerPackageName, ""),
+	}, tracingMainImports(s)...)...)
 
-	// func main() { config.InitConfig().InitServer() }
+	// func main() { env := config.LoadEnv(); logger.Setup(...); config.InitConfig(env).InitServer() }
 	mainFunc := factory.NewFuncDecl(
 		"main",
 		factory.NewFieldList(),
 		factory.NewFuncType(factory.NewFieldList(), factory.NewFieldList()),
-		factory.NewBodyStmt(
+		factory.NewBodyStmt(append(append([]ast.Stmt{loadEnvStmt(), loggerSetupStmt(s)}, tracingSetupStmts(s)...),
 			factory.NewExprStmt(
 				factory.NewCall(&ast.SelectorExpr{
-					X: factory.NewCall(&ast.SelectorExpr{
-						X:   ast.NewIdent("config"),
-						Sel: ast.NewIdent("InitConfig"),
-					}),
+					X:   factory.NewSelectorCall("config", "InitConfig", ast.NewIdent("env")),
 					Sel: ast.NewIdent("InitServer"),
 				}),
 			),
-		),
+		)...),
 	)
 
 	return &types.File{
@@ -299,6 +382,8 @@ func DefaultRoutesFile(s *types.Service) *types.File {
 	// Build imports dynamically
 	importSpecs := []*ast.ImportSpec{
 		factory.NewImport(s.Name+"/handlers", ""),
+		factory.NewImport(s.Name+"/"+HealthPackageName, ""),
+		factory.NewImport(s.Name+"/"+LoggerPackageName, ""),
 		factory.NewImport("database/sql", ""),
 		factory.NewImport("net/http", ""),
 		factory.NewImport("github.com/go-chi/chi/v5", ""),
@@ -313,19 +398,44 @@ func DefaultRoutesFile(s *types.Service) *types.File {
 		)
 	}
 
+	if s.RoutesConfig != nil && s.RoutesConfig.Docs {
+		importSpecs = append(importSpecs, factory.NewImport(s.Name+"/docs", ""))
+	}
+
+	if s.Metrics {
+		importSpecs = append(importSpecs, factory.NewImport(s.Name+"/"+MetricsPackageName, ""))
+	}
+
+	if s.Tracing {
+		importSpecs = append(importSpecs, factory.NewImport(s.Name+"/"+TracingPackageName, ""))
+	}
+
 	imports := factory.NewImportDecl(importSpecs...)
 
 	// Build function body statements
 	bodyStmts := []ast.Stmt{
 		// mux := chi.NewRouter()
 		factory.NewDefine("mux", factory.NewSelectorCall("chi", "NewRouter")),
-		// mux.Use(middleware.Logger)
+		// mux.Use(health.Probes)
+		probesStmt(),
+	}
+	if s.Metrics {
+		// mux.Use(metrics.Endpoint, metrics.Requests)
+		bodyStmts = append(bodyStmts, metricsStmt())
+	}
+	if s.Tracing {
+		// mux.Use(tracing.Middleware)
+		bodyStmts = append(bodyStmts, tracingMiddlewareStmt())
+	}
+	bodyStmts = append(bodyStmts,
+		// mux.Use(middleware.RequestID, logger.Requests)
 		factory.NewExprStmt(
 			factory.NewSelectorCall("mux", "Use",
-				factory.NewSelector("middleware", "Logger"),
+				factory.NewSelector("middleware", "RequestID"),
+				factory.NewSelector(LoggerPackageName, "Requests"),
 			),
 		),
-	}
+	)
 
 	// Add CORS middleware if configured
 	if s.RoutesConfig != nil && s.RoutesConfig.CORS != nil {
@@ -401,6 +511,16 @@ func DefaultRoutesFile(s *types.Service) *types.File {
 		}
 	}
 
+	// mux.Get("/docs", docs.UI) and mux.Get("/docs/openapi.json", docs.Spec)
+	if s.RoutesConfig != nil && s.RoutesConfig.Docs {
+		bodyStmts = append(bodyStmts,
+			factory.NewExprStmt(factory.NewSelectorCall("mux", "Get",
+				factory.NewBasicLit(DocsPath), factory.NewSelector("docs", "UI"))),
+			factory.NewExprStmt(factory.NewSelectorCall("mux", "Get",
+				factory.NewBasicLit(DocsPath+"/openapi.json"), factory.NewSelector("docs", "Spec"))),
+		)
+	}
+
 	// return mux
 	bodyStmts = append(bodyStmts, factory.NewReturn(ast.NewIdent("mux")))
 
@@ -477,8 +597,11 @@ func DefaultHandlersPackage(s *types.Service) *types.Package {
 			}
 			seen[handlerName] = true
 
-			// Create handler file
+			// Create handler file, typed when the route declares its bodies
 			file := createHandlerFile(handlerName)
+			if typedRoute(route) {
+				file = createTypedHandlerFile(s, route)
+			}
 			if file != nil {
 				files = append(files, file)
 			}
@@ -0,0 +1,98 @@
+package defaults
+
+import (
+	"go/ast"
+	"go/token"
+	"strconv"
+	"strings"
+
+	"github.com/flaviogonzalez/instant-layer/internal/factory"
+	"github.com/flaviogonzalez/instant-layer/internal/types"
+)
+
+// DocsPath is where a service serves its Swagger UI page, the spec lives under it
+const DocsPath = "/docs"
+
+// swaggerUIPage loads Swagger UI from a CDN and points it at the served spec
+const swaggerUIPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+	<meta charset="utf-8">
+	<title>API docs</title>
+	<link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+	<div id="swagger-ui"></div>
+	<script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+	<script>
+		window.onload = () => {
+			window.ui = SwaggerUIBundle({ url: "` + DocsPath + `/openapi.json", dom_id: "#swagger-ui" });
+		};
+	</script>
+</body>
+</html>
+`
+
+// DocsPackage generates the docs package serving a service's OpenAPI spec
+// Contains: docs.go with the spec, the Swagger UI page and their handlers
+func DocsPackage(spec []byte) *types.Package {
+	imports := factory.NewImportDecl(
+		factory.NewImport("net/http", ""),
+	)
+
+	return &types.Package{
+		Name: "docs",
+		Files: []*types.File{
+			{
+				Name: "docs.go",
+				Content: factory.NewFileNode("docs",
+					imports,
+					factory.NewConstDecl("spec", stringLit(string(spec))),
+					factory.NewConstDecl("page", stringLit(swaggerUIPage)),
+					docsHandlerFunc("Spec", "application/json", "spec"),
+					docsHandlerFunc("UI", "text/html; charset=utf-8", "page"),
+				),
+			},
+		},
+	}
+}
+
+// docsHandlerFunc generates a handler writing a constant document:
+// func <name>(w http.ResponseWriter, r *http.Request)
+func docsHandlerFunc(name, contentType, doc string) *ast.FuncDecl {
+	return factory.NewFuncDecl(
+		name,
+		factory.NewFieldList(),
+		factory.NewFuncType(
+			factory.NewFieldList(
+				factory.NewField("w", factory.NewSelector("http", "ResponseWriter")),
+				factory.NewField("r", &ast.StarExpr{X: factory.NewSelector("http", "Request")}),
+			),
+			factory.NewFieldList(),
+		),
+		factory.NewBodyStmt(
+			// w.Header().Set("Content-Type", contentType)
+			factory.NewExprStmt(
+				factory.NewCall(
+					&ast.SelectorExpr{X: factory.NewSelectorCall("w", "Header"), Sel: ast.NewIdent("Set")},
+					factory.NewBasicLit("Content-Type"),
+					factory.NewBasicLit(contentType),
+				),
+			),
+			// w.Write([]byte(doc))
+			factory.NewExprStmt(
+				factory.NewSelectorCall("w", "Write",
+					factory.NewCall(&ast.ArrayType{Elt: ast.NewIdent("byte")}, ast.NewIdent(doc)),
+				),
+			),
+		),
+	)
+}
+
+// stringLit returns a raw string literal, or a quoted one when s holds a backquote
+func stringLit(s string) *ast.BasicLit {
+	if strings.Contains(s, "`") {
+		return &ast.BasicLit{Kind: token.STRING, Value: strconv.Quote(s)}
+	}
+	return &ast.BasicLit{Kind: token.STRING, Value: "`" + s + "`"}
+}
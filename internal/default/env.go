@@ -0,0 +1,405 @@
+package defaults
+
+import (
+	"go/ast"
+	"go/token"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/flaviogonzalez/instant-layer/internal/factory"
+	"github.com/flaviogonzalez/instant-layer/internal/types"
+)
+
+// ContainerPort is where every server listens inside its container; compose
+// publishes it on the port recorded in layer.json
+const ContainerPort = 80
+
+// Kinds of environment variables, each parsed by its own loader method
+const (
+	EnvString   = "string"
+	EnvInt      = "int"
+	EnvDuration = "duration"
+)
+
+// ProjectEnvFile is the gitignored file at the project root holding the
+// secrets docker-compose.yml interpolates, next to its committed .env.example
+const ProjectEnvFile = ".env"
+
+// Broker credentials of the rabbitmq container, kept in the project's .env
+const (
+	RabbitMQUserEnv     = "RABBITMQ_DEFAULT_USER"
+	RabbitMQPasswordEnv = "RABBITMQ_DEFAULT_PASS"
+)
+
+// Superuser of the postgres container, kept in the project's .env and part of
+// the database URLs pointing at it
+const (
+	PostgresUserEnv         = "POSTGRES_USER"
+	PostgresPasswordEnv     = "POSTGRES_PASSWORD"
+	DefaultPostgresUser     = "postgres"
+	DefaultPostgresPassword = "postgres"
+)
+
+// EnvVar is an environment variable a service reads at startup into a field
+// of its config.Env. Default is the value .env.example carries; required
+// variables have no fallback and fail startup when unset.
+type EnvVar struct {
+	Name     string // e.g. DATABASE_URL
+	Field    string // field of config.Env, e.g. DatabaseURL
+	Kind     string // EnvString, EnvInt or EnvDuration
+	Default  string
+	Required bool
+	Compose  string // value docker-compose sets when it differs from Default, e.g. ${AUTH_SERVICE_DATABASE_URL}
+	Secret   bool   // holds credentials: deployed from a Secret rather than a ConfigMap
+}
+
+// ComposeValue returns the value docker-compose sets: secrets are
+// interpolated from the project's .env instead of committed in the file
+func (v EnvVar) ComposeValue() string {
+	if v.Compose != "" {
+		return v.Compose
+	}
+	return v.Default
+}
+
+// EnvVars returns the environment variables of a service, in config.Env field order
+func EnvVars(s *types.Service) []EnvVar {
+	var vars []EnvVar
+	if s.Port != 0 {
+		vars = append(vars, EnvVar{Name: "PORT", Field: "Port", Kind: EnvInt, Default: strconv.Itoa(ContainerPort)})
+	}
+	vars = append(vars,
+		EnvVar{Name: "LOG_LEVEL", Field: "LogLevel", Kind: EnvString, Default: "info"},
+		EnvVar{Name: "LOG_FORMAT", Field: "LogFormat", Kind: EnvString, Default: "json"},
+	)
+	if s.DB != nil {
+		vars = append(vars, EnvVar{
+			Name: "DATABASE_URL", Field: "DatabaseURL", Kind: EnvString, Default: DatabaseURL(s), Required: true,
+			Compose: "${" + DatabaseURLEnv(s.Name) + "}", Secret: true,
+		})
+	}
+	if s.Messaging != nil {
+		t := transportFor(s)
+		// Transports compose interpolates credentials into are secrets
+		vars = append(vars, EnvVar{
+			Name: t.env, Field: t.field, Kind: EnvString, Default: t.address,
+			Compose: t.composeAddress, Secret: t.composeAddress != "",
+		})
+	}
+	if s.Port != 0 {
+		vars = append(vars,
+			EnvVar{Name: "READ_HEADER_TIMEOUT", Field: "ReadHeaderTimeout", Kind: EnvDuration, Default: "5s"},
+			EnvVar{Name: "IDLE_TIMEOUT", Field: "IdleTimeout", Kind: EnvDuration, Default: "60s"},
+		)
+	}
+	return vars
+}
+
+// DatabaseURLEnv returns the variable of the project's .env holding the
+// database URL of a service (auth-service -> AUTH_SERVICE_DATABASE_URL)
+func DatabaseURLEnv(service string) string {
+	return strings.ToUpper(SnakeCase(service)) + "_DATABASE_URL"
+}
+
+// ProjectEnvVars returns the variables of the project's .env: the database
+// URL of each service and the credentials of the postgres and rabbitmq
+// containers
+func ProjectEnvVars(services []*types.Service) []EnvVar {
+	var vars []EnvVar
+	postgres, rabbit := false, false
+	for _, s := range services {
+		if s.DB != nil {
+			vars = append(vars, EnvVar{Name: DatabaseURLEnv(s.Name), Kind: EnvString, Default: DatabaseURL(s), Required: true})
+		}
+		if UsesPostgres(s) {
+			postgres = true
+		}
+		if s.Messaging != nil && TransportOf(s) == TransportRabbitMQ {
+			rabbit = true
+		}
+	}
+	if postgres {
+		vars = append(vars,
+			EnvVar{Name: PostgresUserEnv, Kind: EnvString, Default: DefaultPostgresUser},
+			EnvVar{Name: PostgresPasswordEnv, Kind: EnvString, Default: DefaultPostgresPassword},
+		)
+	}
+	if rabbit {
+		vars = append(vars,
+			EnvVar{Name: RabbitMQUserEnv, Kind: EnvString, Default: "guest"},
+			EnvVar{Name: RabbitMQPasswordEnv, Kind: EnvString, Default: "guest"},
+		)
+	}
+	return vars
+}
+
+// PostgresHost is the container of docker-compose.yml serving the databases
+// of the services whose layer.json records no URL
+const PostgresHost = "postgres"
+
+// DatabaseURL returns the DSN of a service's database: its database in the
+// project's postgres container when layer.json records no URL
+func DatabaseURL(s *types.Service) string {
+	if s.DB.URL != "" {
+		return s.DB.URL
+	}
+	return PostgresURL(DefaultPostgresUser, DefaultPostgresPassword, s.Name)
+}
+
+// PostgresURL returns the DSN of a service's database in the postgres
+// container, logged in with the container's credentials
+func PostgresURL(user, password, service string) string {
+	u := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(user, password),
+		Host:     PostgresHost + ":5432",
+		Path:     "/" + DatabaseName(service),
+		RawQuery: "sslmode=disable",
+	}
+	return u.String()
+}
+
+// UsesPostgres reports whether a service's database is served by the
+// project's postgres container
+func UsesPostgres(s *types.Service) bool {
+	return s.DB != nil && s.DB.URL == ""
+}
+
+// DatabaseName returns the database of a service in the postgres container
+// (auth-service -> auth_service)
+func DatabaseName(service string) string {
+	return SnakeCase(service)
+}
+
+// EnvFile generates env.go of the config package: the Env struct, LoadEnv
+// reading it with defaults and validation, and the loader collecting errors
+func EnvFile(s *types.Service) *types.File {
+	vars := EnvVars(s)
+
+	imports := factory.NewImportDecl(
+		factory.NewImport("errors", ""),
+		factory.NewImport("fmt", ""),
+		factory.NewImport("log/slog", ""),
+		factory.NewImport("os", ""),
+		factory.NewImport("strconv", ""),
+		factory.NewImport("time", ""),
+	)
+
+	// One field per variable, each assigned by its own loader call
+	var fields []*ast.Field
+	loads := []ast.Stmt{
+		factory.NewVarStmt("l", ast.NewIdent("loader")),
+		factory.NewVarStmt("env", ast.NewIdent("Env")),
+	}
+	for _, v := range vars {
+		fields = append(fields, factory.NewField(v.Field, envFieldType(v.Kind)))
+		loads = append(loads, factory.NewAssign(factory.NewSelector("env", v.Field), envLoadExpr(v)))
+	}
+
+	// type Env struct { Port int; LogLevel string; ... }
+	envStruct := factory.NewTypeStruct("Env", factory.NewFieldList(fields...))
+
+	// func LoadEnv() *Env
+	loadEnvFunc := factory.NewFuncDecl(
+		"LoadEnv",
+		factory.NewFieldList(),
+		factory.NewFuncType(
+			factory.NewFieldList(),
+			factory.NewFieldList(factory.NewField("", &ast.StarExpr{X: ast.NewIdent("Env")})),
+		),
+		factory.NewBodyStmt(append(loads,
+			// Every invalid variable is reported at once, before anything connects
+			withInit(
+				factory.NewIfError(logFatal("invalid environment", errAttr())...),
+				factory.NewDefine("err", &ast.CallExpr{
+					Fun:      factory.NewSelector("errors", "Join"),
+					Args:     []ast.Expr{factory.NewSelector("l", "errs")},
+					Ellipsis: 1,
+				}),
+			),
+			factory.NewReturn(factory.NewAddressOf(ast.NewIdent("env"))),
+		)...),
+	)
+
+	decls := []ast.Decl{imports, envStruct, loadEnvFunc}
+	if s.Port != 0 {
+		// func (e *Env) Addr() string
+		decls = append(decls, factory.NewFuncDecl(
+			"Addr",
+			factory.NewFieldList(factory.NewField("e", &ast.StarExpr{X: ast.NewIdent("Env")})),
+			factory.NewFuncType(
+				factory.NewFieldList(),
+				factory.NewFieldList(factory.NewField("", ast.NewIdent("string"))),
+			),
+			factory.NewBodyStmt(
+				factory.NewReturn(&ast.BinaryExpr{
+					X:  factory.NewBasicLit(":"),
+					Op: token.ADD,
+					Y:  factory.NewSelectorCall("strconv", "Itoa", factory.NewSelector("e", "Port")),
+				}),
+			),
+		))
+	}
+	decls = append(decls, envLoaderDecls()...)
+
+	return &types.File{
+		Name:    "env.go",
+		Content: factory.NewFileNode("config", decls...),
+	}
+}
+
+// envFieldType returns the Go type of a kind of variable
+func envFieldType(kind string) ast.Expr {
+	switch kind {
+	case EnvInt:
+		return ast.NewIdent("int")
+	case EnvDuration:
+		return factory.NewSelector("time", "Duration")
+	default:
+		return ast.NewIdent("string")
+	}
+}
+
+// envLoadExpr generates the loader call reading a variable: l.required(name),
+// l.text(name, default), l.integer(name, default) or l.duration(name, default)
+func envLoadExpr(v EnvVar) ast.Expr {
+	name := factory.NewBasicLit(v.Name)
+	switch {
+	case v.Required:
+		return factory.NewSelectorCall("l", "required", name)
+	case v.Kind == EnvInt:
+		n, _ := strconv.Atoi(v.Default)
+		return factory.NewSelectorCall("l", "integer", name, factory.NewBasicLitInt(n))
+	case v.Kind == EnvDuration:
+		d, _ := time.ParseDuration(v.Default)
+		return factory.NewSelectorCall("l", "duration", name, &ast.BinaryExpr{
+			X:  factory.NewBasicLitInt(int(d / time.Second)),
+			Op: token.MUL,
+			Y:  factory.NewSelector("time", "Second"),
+		})
+	default:
+		return factory.NewSelectorCall("l", "text", name, factory.NewBasicLit(v.Default))
+	}
+}
+
+// envLoaderDecls generates the loader type and its methods: each reads a
+// variable, falls back to its default when unset and records parse errors
+func envLoaderDecls() []ast.Decl {
+	recv := factory.NewFieldList(factory.NewField("l", &ast.StarExpr{X: ast.NewIdent("loader")}))
+	nameParam := factory.NewField("name", ast.NewIdent("string"))
+	lookup := factory.NewDefine("value", factory.NewSelectorCall("os", "Getenv", ast.NewIdent("name")))
+	unset := &ast.BinaryExpr{X: ast.NewIdent("value"), Op: token.EQL, Y: factory.NewBasicLit("")}
+
+	// l.errs = append(l.errs, fmt.Errorf(format, args...))
+	recordErr := func(format string, args ...ast.Expr) ast.Stmt {
+		return factory.NewAssign(factory.NewSelector("l", "errs"), factory.NewCall(ast.NewIdent("append"),
+			factory.NewSelector("l", "errs"),
+			factory.NewSelectorCall("fmt", "Errorf", append([]ast.Expr{factory.NewBasicLit(format)}, args...)...),
+		))
+	}
+
+	// parsed generates a method parsing a variable with parse, e.g. strconv.Atoi
+	parsed := func(method string, typ ast.Expr, parse ast.Expr) *ast.FuncDecl {
+		return factory.NewFuncDecl(
+			method,
+			recv,
+			factory.NewFuncType(
+				factory.NewFieldList(nameParam, factory.NewField("fallback", typ)),
+				factory.NewFieldList(factory.NewField("", typ)),
+			),
+			factory.NewBodyStmt(
+				lookup,
+				factory.NewIf(unset, factory.NewReturn(ast.NewIdent("fallback"))),
+				&ast.AssignStmt{
+					Lhs: []ast.Expr{ast.NewIdent("parsed"), ast.NewIdent("err")},
+					Tok: token.DEFINE,
+					Rhs: []ast.Expr{factory.NewCall(parse, ast.NewIdent("value"))},
+				},
+				factory.NewIfError(
+					recordErr("%s: %w", ast.NewIdent("name"), ast.NewIdent("err")),
+					factory.NewReturn(ast.NewIdent("fallback")),
+				),
+				factory.NewReturn(ast.NewIdent("parsed")),
+			),
+		)
+	}
+
+	// type loader struct { errs []error }
+	loaderStruct := factory.NewTypeStruct("loader", factory.NewFieldList(
+		factory.NewField("errs", &ast.ArrayType{Elt: ast.NewIdent("error")}),
+	))
+
+	// func (l *loader) text(name, fallback string) string
+	textFunc := factory.NewFuncDecl(
+		"text",
+		recv,
+		factory.NewFuncType(
+			factory.NewFieldList(nameParam, factory.NewField("fallback", ast.NewIdent("string"))),
+			factory.NewFieldList(factory.NewField("", ast.NewIdent("string"))),
+		),
+		factory.NewBodyStmt(
+			lookup,
+			factory.NewIf(unset, factory.NewReturn(ast.NewIdent("fallback"))),
+			factory.NewReturn(ast.NewIdent("value")),
+		),
+	)
+
+	// func (l *loader) required(name string) string
+	requiredFunc := factory.NewFuncDecl(
+		"required",
+		recv,
+		factory.NewFuncType(
+			factory.NewFieldList(nameParam),
+			factory.NewFieldList(factory.NewField("", ast.NewIdent("string"))),
+		),
+		factory.NewBodyStmt(
+			lookup,
+			factory.NewIf(unset, recordErr("%s is required", ast.NewIdent("name"))),
+			factory.NewReturn(ast.NewIdent("value")),
+		),
+	)
+
+	return []ast.Decl{
+		loaderStruct,
+		textFunc,
+		requiredFunc,
+		parsed("integer", ast.NewIdent("int"), factory.NewSelector("strconv", "Atoi")),
+		parsed("duration", factory.NewSelector("time", "Duration"), factory.NewSelector("time", "ParseDuration")),
+	}
+}
+
+// loadEnvStmt generates env := config.LoadEnv(), the first statement of every main
+func loadEnvStmt() ast.Stmt {
+	return factory.NewDefine("env", factory.NewSelectorCall("config", "LoadEnv"))
+}
+
+// envParams is the parameter list of InitConfig: (env *Env)
+func envParams() *ast.FieldList {
+	return factory.NewFieldList(factory.NewField("env", &ast.StarExpr{X: ast.NewIdent("Env")}))
+}
+
+// envField is the Env field of every Config struct
+func envField() *ast.Field {
+	return factory.NewField("Env", &ast.StarExpr{X: ast.NewIdent("Env")})
+}
+
+// eventAddressStmt generates event.Address = <env>.<transport field>, pointing
+// the event package at the broker of the environment before it connects
+func eventAddressStmt(s *types.Service, env string) ast.Stmt {
+	return factory.NewAssign(factory.NewSelector("event", "Address"), factory.NewSelector(env, transportFor(s).field))
+}
+
+// serverTimeouts are the http.Server fields read from the environment
+func serverTimeouts() []ast.Expr {
+	return []ast.Expr{
+		factory.NewKeyValue("ReadHeaderTimeout", factory.NewSelector("app", "Env.ReadHeaderTimeout")),
+		factory.NewKeyValue("IdleTimeout", factory.NewSelector("app", "Env.IdleTimeout")),
+	}
+}
+
+// serverAddr generates app.Env.Addr(), the address every server listens on
+func serverAddr() ast.Expr {
+	return factory.NewSelectorCall("app", "Env.Addr")
+}
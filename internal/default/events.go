@@ -0,0 +1,397 @@
+package defaults
+
+import (
+	"go/ast"
+	"go/token"
+	"strings"
+
+	"github.com/flaviogonzalez/instant-layer/internal/factory"
+	"github.com/flaviogonzalez/instant-layer/internal/types"
+)
+
+// EventsModule is the module path (and root directory) of the shared event contracts
+const EventsModule = "events"
+
+// EventsPackage generates the shared events module package
+// Contains: payload.go and one file per event contract ({snake_name}.go)
+func EventsPackage(events []*types.Event) *types.Package {
+	files := []*types.File{eventsPayloadFile()}
+	for _, ev := range events {
+		files = append(files, EventContractFile(ev))
+	}
+
+	return &types.Package{
+		Name:  EventsModule,
+		Files: files,
+	}
+}
+
+// eventsPayloadFile generates payload.go: the Event interface and the wire envelope
+func eventsPayloadFile() *types.File {
+	imports := factory.NewImportDecl(
+		factory.NewImport("encoding/json", ""),
+	)
+
+	// type Event interface { EventName() string; SchemaVersion() int }
+	eventInterface := &ast.GenDecl{
+		Tok: token.TYPE,
+		Specs: []ast.Spec{
+			&ast.TypeSpec{
+				Name: ast.NewIdent("Event"),
+				Type: &ast.InterfaceType{
+					Methods: factory.NewFieldList(
+						factory.NewField("EventName", factory.NewFuncType(
+							factory.NewFieldList(),
+							factory.NewFieldList(factory.NewField("", ast.NewIdent("string"))),
+						)),
+						factory.NewField("SchemaVersion", factory.NewFuncType(
+							factory.NewFieldList(),
+							factory.NewFieldList(factory.NewField("", ast.NewIdent("int"))),
+						)),
+					),
+				},
+			},
+		},
+	}
+
+	// type Payload struct
+	payloadStruct := factory.NewStructDecl("Payload",
+		factory.NewJsonField("Name", "string", "name"),
+		factory.NewJsonField("Version", "int", "version"),
+		factory.NewStructField("Data", factory.NewSelector("json", "RawMessage"), `json:"data"`),
+	)
+
+	// func NewPayload(ev Event) (Payload, error)
+	newPayloadFunc := factory.NewFuncDecl(
+		"NewPayload",
+		factory.NewFieldList(),
+		factory.NewFuncType(
+			factory.NewFieldList(factory.NewField("ev", ast.NewIdent("Event"))),
+			factory.NewFieldList(
+				factory.NewField("", ast.NewIdent("Payload")),
+				factory.NewField("", ast.NewIdent("error")),
+			),
+		),
+		factory.NewBodyStmt(
+			factory.NewDefineExpectsError("data", factory.NewSelectorCall("json", "Marshal", ast.NewIdent("ev"))),
+			factory.NewIfError(
+				factory.NewReturn(factory.NewCompositeLit(ast.NewIdent("Payload")), ast.NewIdent("err")),
+			),
+			factory.NewReturn(
+				factory.NewCompositeLit(
+					ast.NewIdent("Payload"),
+					factory.NewKeyValue("Name", factory.NewSelectorCall("ev", "EventName")),
+					factory.NewKeyValue("Version", factory.NewSelectorCall("ev", "SchemaVersion")),
+					factory.NewKeyValue("Data", ast.NewIdent("data")),
+				),
+				ast.NewIdent("nil"),
+			),
+		),
+	)
+
+	return &types.File{
+		Name:    "payload.go",
+		Content: factory.NewFileNode(EventsModule, imports, eventInterface, payloadStruct, newPayloadFunc),
+	}
+}
+
+// EventContractFile generates the typed struct, name constant and schema version of an event
+func EventContractFile(ev *types.Event) *types.File {
+	var decls []ast.Decl
+
+	// Imports needed by field types (time.Time, json.RawMessage...)
+	var importSpecs []*ast.ImportSpec
+	seen := make(map[string]bool)
+	for _, f := range ev.Fields {
+		path := fieldTypeImport(f.Type)
+		if path != "" && !seen[path] {
+			seen[path] = true
+			importSpecs = append(importSpecs, factory.NewImport(path, ""))
+		}
+	}
+	if len(importSpecs) > 0 {
+		decls = append(decls, factory.NewImportDecl(importSpecs...))
+	}
+
+	version := ev.Version
+	if version == 0 {
+		version = 1
+	}
+
+	decls = append(decls,
+		factory.NewConstDecl(ev.Name+"Name", factory.NewBasicLit(eventTopic(ev))),
+		factory.NewConstDecl(ev.Name+"Version", factory.NewBasicLitInt(version)),
+	)
+
+	// type {Name} struct { ... }
+	var fields []*ast.Field
+	for _, f := range ev.Fields {
+		tag := f.JSON
+		if tag == "" {
+			tag = SnakeCase(f.Name)
+		}
+		fields = append(fields, factory.NewStructField(f.Name, factory.NewTypeExpr(f.Type), `json:"`+tag+`"`))
+	}
+	decls = append(decls, factory.NewStructDecl(ev.Name, fields...))
+
+	// func ({Name}) EventName() string { return {Name}Name }
+	decls = append(decls,
+		factory.NewFuncDecl(
+			"EventName",
+			factory.NewFieldList(factory.NewField("", ast.NewIdent(ev.Name))),
+			factory.NewFuncType(
+				factory.NewFieldList(),
+				factory.NewFieldList(factory.NewField("", ast.NewIdent("string"))),
+			),
+			factory.NewBodyStmt(factory.NewReturn(ast.NewIdent(ev.Name+"Name"))),
+		),
+		factory.NewFuncDecl(
+			"SchemaVersion",
+			factory.NewFieldList(factory.NewField("", ast.NewIdent(ev.Name))),
+			factory.NewFuncType(
+				factory.NewFieldList(),
+				factory.NewFieldList(factory.NewField("", ast.NewIdent("int"))),
+			),
+			factory.NewBodyStmt(factory.NewReturn(ast.NewIdent(ev.Name+"Version"))),
+		),
+	)
+
+	return &types.File{
+		Name:    SnakeCase(ev.Name) + ".go",
+		Content: factory.NewFileNode(EventsModule, decls...),
+	}
+}
+
+// eventTopic returns the configured topic of an event or derives it from its name
+func eventTopic(ev *types.Event) string {
+	if ev.Topic != "" {
+		return ev.Topic
+	}
+	return EventTopic(ev.Name)
+}
+
+// fieldTypeImport returns the import path required by a field type, if any
+func fieldTypeImport(typ string) string {
+	typ = strings.TrimLeft(typ, "[]*")
+	switch {
+	case strings.HasPrefix(typ, "time."):
+		return "time"
+	case strings.HasPrefix(typ, "json."):
+		return "encoding/json"
+	default:
+		return ""
+	}
+}
+
+// BrokerContractsFile generates contracts.go for a broker service: one typed emitter per event.
+// Returns nil when the service has no event contracts. Traced emitters take the
+// request context first, like SendToListener.
+func BrokerContractsFile(s *types.Service) *types.File {
+	if len(s.Events) == 0 {
+		return nil
+	}
+
+	importSpecs := []*ast.ImportSpec{
+		factory.NewImport("net/http", ""),
+		factory.NewImport(EventsModule, ""),
+	}
+	if tracesMessages(s) {
+		importSpecs = append([]*ast.ImportSpec{factory.NewImport("context", "")}, importSpecs...)
+	}
+	imports := factory.NewImportDecl(importSpecs...)
+
+	decls := []ast.Decl{imports}
+	for _, ev := range s.Events {
+		params := factory.NewFieldList(
+			factory.NewField("w", factory.NewSelector("http", "ResponseWriter")),
+			factory.NewField("ev", factory.NewSelector(EventsModule, ev.Name)),
+		)
+		var sendArgs []ast.Expr
+		if tracesMessages(s) {
+			params.List = append([]*ast.Field{contextField()}, params.List...)
+			sendArgs = append(sendArgs, ast.NewIdent("ctx"))
+		}
+		sendArgs = append(sendArgs,
+			ast.NewIdent("w"),
+			ast.NewIdent("Exchange"),
+			factory.NewCompositeLit(
+				ast.NewIdent("TopicPayload"),
+				factory.NewKeyValue("Name", factory.NewSelector("p", "Name")),
+				factory.NewKeyValue("Event", factory.NewCompositeLit(
+					ast.NewIdent("EventPayload"),
+					factory.NewKeyValue("Name", factory.NewSelector("p", "Name")),
+					factory.NewKeyValue("Version", factory.NewSelector("p", "Version")),
+					factory.NewKeyValue("Data", factory.NewSelector("p", "Data")),
+				)),
+			),
+		)
+
+		// func Emit{Name}(w http.ResponseWriter, ev events.{Name}) error
+		decls = append(decls, factory.NewFuncDecl(
+			"Emit"+ev.Name,
+			factory.NewFieldList(),
+			factory.NewFuncType(
+				params,
+				factory.NewFieldList(factory.NewField("", ast.NewIdent("error"))),
+			),
+			factory.NewBodyStmt(
+				factory.NewDefineExpectsError("p", factory.NewSelectorCall(EventsModule, "NewPayload", ast.NewIdent("ev"))),
+				factory.NewIfError(
+					factory.NewReturn(ast.NewIdent("err")),
+				),
+				factory.NewReturn(factory.NewCall(ast.NewIdent("SendToListener"), sendArgs...)),
+			),
+		))
+	}
+
+	return &types.File{
+		Name:    "contracts.go",
+		Content: factory.NewFileNode("event", decls...),
+	}
+}
+
+// ListenerContractsFile generates contracts.go for a listener service:
+// a Contracts interface with one typed method per event and NewHandlers,
+// which decodes each payload into its event struct before dispatching.
+// Returns nil when the service has no event contracts.
+func ListenerContractsFile(s *types.Service) *types.File {
+	if len(s.Events) == 0 {
+		return nil
+	}
+
+	imports := factory.NewImportDecl(
+		factory.NewImport("encoding/json", ""),
+		factory.NewImport(EventsModule, ""),
+	)
+
+	var methods []*ast.Field
+	var entries []ast.Expr
+	for _, ev := range s.Events {
+		// Handle{Name}(ev events.{Name}) ([]byte, error)
+		methods = append(methods, factory.NewField("Handle"+ev.Name, factory.NewFuncType(
+			factory.NewFieldList(factory.NewField("ev", factory.NewSelector(EventsModule, ev.Name))),
+			factory.NewFieldList(
+				factory.NewField("", &ast.ArrayType{Elt: ast.NewIdent("byte")}),
+				factory.NewField("", ast.NewIdent("error")),
+			),
+		)))
+
+		// events.{Name}Name: func(raw json.RawMessage) ([]byte, error) { ... }
+		entries = append(entries, &ast.KeyValueExpr{
+			Key: factory.NewSelector(EventsModule, ev.Name+"Name"),
+			Value: factory.NewFuncLit(
+				factory.NewFuncType(
+					factory.NewFieldList(factory.NewField("raw", factory.NewSelector("json", "RawMessage"))),
+					factory.NewFieldList(
+						factory.NewField("", &ast.ArrayType{Elt: ast.NewIdent("byte")}),
+						factory.NewField("", ast.NewIdent("error")),
+					),
+				),
+				factory.NewBodyStmt(
+					&ast.DeclStmt{Decl: factory.NewVarDecl("ev", factory.NewSelector(EventsModule, ev.Name))},
+					&ast.IfStmt{
+						Init: &ast.AssignStmt{
+							Lhs: []ast.Expr{ast.NewIdent("err")},
+							Tok: token.DEFINE,
+							Rhs: []ast.Expr{
+								factory.NewSelectorCall("json", "Unmarshal",
+									ast.NewIdent("raw"),
+									&ast.UnaryExpr{Op: token.AND, X: ast.NewIdent("ev")},
+								),
+							},
+						},
+						Cond: &ast.BinaryExpr{X: ast.NewIdent("err"), Op: token.NEQ, Y: ast.NewIdent("nil")},
+						Body: factory.NewBodyStmt(
+							factory.NewReturn(ast.NewIdent("nil"), ast.NewIdent("err")),
+						),
+					},
+					factory.NewReturn(factory.NewSelectorCall("c", "Handle"+ev.Name, ast.NewIdent("ev"))),
+				),
+			),
+		})
+	}
+
+	// type Contracts interface { ... }
+	contractsInterface := &ast.GenDecl{
+		Tok: token.TYPE,
+		Specs: []ast.Spec{
+			&ast.TypeSpec{
+				Name: ast.NewIdent("Contracts"),
+				Type: &ast.InterfaceType{Methods: factory.NewFieldList(methods...)},
+			},
+		},
+	}
+
+	// func NewHandlers(c Contracts) Handlers
+	newHandlersFunc := factory.NewFuncDecl(
+		"NewHandlers",
+		factory.NewFieldList(),
+		factory.NewFuncType(
+			factory.NewFieldList(factory.NewField("c", ast.NewIdent("Contracts"))),
+			factory.NewFieldList(factory.NewField("", ast.NewIdent("Handlers"))),
+		),
+		factory.NewBodyStmt(
+			factory.NewReturn(factory.NewCompositeLit(ast.NewIdent("Handlers"), entries...)),
+		),
+	)
+
+	return &types.File{
+		Name:    "contracts.go",
+		Content: factory.NewFileNode("event", imports, contractsInterface, newHandlersFunc),
+	}
+}
+
+// ListenerHandlersPackage generates the handlers package of a listener service:
+// handlers.go with the Handlers type and one stub file per event contract.
+func ListenerHandlersPackage(s *types.Service) *types.Package {
+	if len(s.Events) == 0 {
+		return nil
+	}
+
+	files := []*types.File{
+		{
+			Name: "handlers.go",
+			Content: factory.NewFileNode("handlers",
+				factory.NewTypeStruct("Handlers", factory.NewFieldList()),
+			),
+		},
+	}
+
+	for _, ev := range s.Events {
+		files = append(files, ListenerHandlerFile(ev))
+	}
+
+	return &types.Package{
+		Name:  "handlers",
+		Files: files,
+	}
+}
+
+// ListenerHandlerFile generates the stub implementation of one event contract.
+// File name: {snake_name}.go (e.g., user_created.go)
+func ListenerHandlerFile(ev *types.Event) *types.File {
+	imports := factory.NewImportDecl(
+		factory.NewImport(EventsModule, ""),
+	)
+
+	// func (h Handlers) Handle{Name}(ev events.{Name}) ([]byte, error) { return nil, nil }
+	handlerFunc := factory.NewFuncDecl(
+		"Handle"+ev.Name,
+		factory.NewFieldList(factory.NewField("h", ast.NewIdent("Handlers"))),
+		factory.NewFuncType(
+			factory.NewFieldList(factory.NewField("ev", factory.NewSelector(EventsModule, ev.Name))),
+			factory.NewFieldList(
+				factory.NewField("", &ast.ArrayType{Elt: ast.NewIdent("byte")}),
+				factory.NewField("", ast.NewIdent("error")),
+			),
+		),
+		factory.NewBodyStmt(
+			factory.NewReturn(ast.NewIdent("nil"), ast.NewIdent("nil")),
+		),
+	)
+
+	return &types.File{
+		Name:    SnakeCase(ev.Name) + ".go",
+		Content: factory.NewFileNode("handlers", imports, handlerFunc),
+	}
+}
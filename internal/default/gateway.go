@@ -0,0 +1,530 @@
+package defaults
+
+import (
+	"go/ast"
+	"go/token"
+	"strings"
+
+	"github.com/flaviogonzalez/instant-layer/internal/factory"
+	"github.com/flaviogonzalez/instant-layer/internal/types"
+)
+
+// GatewayPackageName is the package holding the gateway route table, proxy and middleware
+const GatewayPackageName = "gateway"
+
+// GatewayService creates an API gateway proxying to the other services of the layer.
+// Its route table starts empty and is regenerated from layer.json with GatewayTableFile.
+func GatewayService(opts ...Option) *types.Service {
+	s := &types.Service{Port: 8000}
+
+	// Apply user options first
+	for _, o := range opts {
+		o(s)
+	}
+
+	// Gateway services need: gateway package, routes, config, main
+	baseOpts := []Option{
+		WithGatewayPackage(),
+		WithGatewayRoutes(),
+		WithGatewayConfig(),
+		WithGatewayMain(),
+		WithHTTPLogger(),
+		WithHealth(),
+	}
+	if s.Metrics {
+		baseOpts = append(baseOpts, WithMetricsPackage(true))
+	}
+	if s.Tracing {
+		baseOpts = append(baseOpts, WithTracingPackage(true))
+	}
+
+	return applyOptions(s, baseOpts...)
+}
+
+// GatewayPrefix returns the path prefix a service is exposed under, e.g. /auth for auth-service
+func GatewayPrefix(name string) string {
+	return "/" + strings.TrimSuffix(name, "-service")
+}
+
+// GatewayPackage generates the gateway package
+// Contains: table.go (upstreams, regenerated), proxy.go (reverse proxy) and
+// middleware.go (auth, CORS and rate limiting applied to every proxied route)
+func GatewayPackage() *types.Package {
+	return &types.Package{
+		Name: GatewayPackageName,
+		Files: []*types.File{
+			GatewayTableFile(nil),
+			gatewayProxyFile(),
+			gatewayMiddlewareFile(),
+		},
+	}
+}
+
+// GatewayTableFile generates table.go: one upstream per service with routes,
+// listing the routes the gateway exposes under the service's prefix.
+// Gateways and services without routes are left out.
+func GatewayTableFile(services []*types.Service) *types.File {
+	// type Route struct { Method string; Path string }
+	routeStruct := factory.NewTypeStruct("Route", factory.NewFieldList(
+		factory.NewField("Method", ast.NewIdent("string")),
+		factory.NewField("Path", ast.NewIdent("string")),
+	))
+
+	// type Upstream struct { Service, Prefix, URL, Env string; Routes []Route }
+	upstreamStruct := factory.NewTypeStruct("Upstream", factory.NewFieldList(
+		factory.NewField("Service", ast.NewIdent("string")),
+		factory.NewField("Prefix", ast.NewIdent("string")),
+		factory.NewField("URL", ast.NewIdent("string")),
+		factory.NewField("Env", ast.NewIdent("string")),
+		factory.NewField("Routes", &ast.ArrayType{Elt: ast.NewIdent("Route")}),
+	))
+
+	var upstreams []ast.Expr
+	for _, svc := range services {
+		if svc.Template == "gateway" || svc.RoutesConfig == nil {
+			continue
+		}
+
+		var routes []ast.Expr
+		for _, group := range svc.RoutesConfig.RoutesGroup {
+			for _, route := range group.Routes {
+				routes = append(routes, factory.NewCompositeLit(nil,
+					factory.NewKeyValue("Method", factory.NewBasicLit(strings.ToUpper(route.Method))),
+					factory.NewKeyValue("Path", factory.NewBasicLit(route.Path)),
+				))
+			}
+		}
+		if len(routes) == 0 {
+			continue
+		}
+
+		upstreams = append(upstreams, factory.NewCompositeLit(nil,
+			factory.NewKeyValue("Service", factory.NewBasicLit(svc.Name)),
+			factory.NewKeyValue("Prefix", factory.NewBasicLit(GatewayPrefix(svc.Name))),
+			factory.NewKeyValue("URL", factory.NewBasicLit(ClientBaseURL(svc.Name))),
+			factory.NewKeyValue("Env", factory.NewBasicLit(ClientBaseURLEnv(svc.Name))),
+			factory.NewKeyValue("Routes", factory.NewCompositeLit(&ast.ArrayType{Elt: ast.NewIdent("Route")}, routes...)),
+		))
+	}
+
+	// var Upstreams = []Upstream{...}
+	upstreamsVar := factory.NewVarDecl("Upstreams", nil)
+	upstreamsVar.Specs[0].(*ast.ValueSpec).Values = []ast.Expr{
+		factory.NewCompositeLit(&ast.ArrayType{Elt: ast.NewIdent("Upstream")}, upstreams...),
+	}
+
+	return &types.File{
+		Name:    "table.go",
+		Content: factory.NewFileNode(GatewayPackageName, routeStruct, upstreamStruct, upstreamsVar),
+	}
+}
+
+// gatewayProxyFile generates proxy.go: a reverse proxy per upstream, stripping the
+// gateway prefix. The upstream URL can be overridden through its environment variable.
+func gatewayProxyFile() *types.File {
+	imports := factory.NewImportDecl(
+		factory.NewImport("log/slog", ""),
+		factory.NewImport("net/http", ""),
+		factory.NewImport("net/http/httputil", ""),
+		factory.NewImport("net/url", ""),
+		factory.NewImport("os", ""),
+		factory.NewImport("strings", ""),
+		factory.NewImport("github.com/go-chi/chi/v5/middleware", ""),
+	)
+
+	// func NewProxy(u Upstream) (*httputil.ReverseProxy, error)
+	newProxyFunc := factory.NewFuncDecl(
+		"NewProxy",
+		factory.NewFieldList(),
+		factory.NewFuncType(
+			factory.NewFieldList(factory.NewField("u", ast.NewIdent("Upstream"))),
+			factory.NewFieldList(
+				factory.NewField("", &ast.StarExpr{X: factory.NewSelector("httputil", "ReverseProxy")}),
+				factory.NewField("", ast.NewIdent("error")),
+			),
+		),
+		factory.NewBodyStmt(
+			factory.NewDefine("base", factory.NewSelector("u", "URL")),
+			withInit(
+				factory.NewIf(
+					&ast.BinaryExpr{X: ast.NewIdent("env"), Op: token.NEQ, Y: factory.NewBasicLit("")},
+					factory.NewAssign(ast.NewIdent("base"), ast.NewIdent("env")),
+				),
+				factory.NewDefine("env", factory.NewSelectorCall("os", "Getenv", factory.NewSelector("u", "Env"))),
+			),
+			factory.NewDefineExpectsError("target", factory.NewSelectorCall("url", "Parse", ast.NewIdent("base"))),
+			factory.NewIfError(factory.NewReturn(ast.NewIdent("nil"), ast.NewIdent("err"))),
+			factory.NewReturn(
+				factory.NewAddressOf(factory.NewCompositeLit(factory.NewSelector("httputil", "ReverseProxy"),
+					factory.NewKeyValue("Rewrite", factory.NewFuncLit(
+						factory.NewFuncType(
+							factory.NewFieldList(factory.NewField("r", &ast.StarExpr{X: factory.NewSelector("httputil", "ProxyRequest")})),
+							factory.NewFieldList(),
+						),
+						factory.NewBodyStmt(
+							// /auth/login reaches auth-service as /login
+							&ast.AssignStmt{
+								Lhs: []ast.Expr{factory.NewSelector("r", "Out.URL.Path")},
+								Tok: token.ASSIGN,
+								Rhs: []ast.Expr{factory.NewSelectorCall("strings", "TrimPrefix",
+									factory.NewSelector("r", "In.URL.Path"),
+									factory.NewSelector("u", "Prefix"),
+								)},
+							},
+							&ast.AssignStmt{
+								Lhs: []ast.Expr{factory.NewSelector("r", "Out.URL.RawPath")},
+								Tok: token.ASSIGN,
+								Rhs: []ast.Expr{factory.NewBasicLit("")},
+							},
+							factory.NewExprStmt(factory.NewSelectorCall("r", "SetURL", ast.NewIdent("target"))),
+							factory.NewExprStmt(factory.NewSelectorCall("r", "SetXForwarded")),
+							// Upstreams log the gateway's request ID instead of minting their own
+							factory.NewExprStmt(factory.NewSelectorCall("r", "Out.Header.Set",
+								factory.NewSelector("middleware", "RequestIDHeader"),
+								factory.NewSelectorCall("middleware", "GetReqID", factory.NewSelectorCall("r", "In.Context")),
+							)),
+						),
+					)),
+					factory.NewKeyValue("ErrorHandler", factory.NewFuncLit(
+						factory.NewFuncType(
+							factory.NewFieldList(
+								factory.NewField("w", factory.NewSelector("http", "ResponseWriter")),
+								factory.NewField("r", &ast.StarExpr{X: factory.NewSelector("http", "Request")}),
+								factory.NewField("err", ast.NewIdent("error")),
+							),
+							factory.NewFieldList(),
+						),
+						factory.NewBodyStmt(
+							logError("upstream unavailable",
+								attr("upstream", factory.NewSelector("u", "Service")),
+								attr("request_id", factory.NewSelectorCall("middleware", "GetReqID", factory.NewSelectorCall("r", "Context"))),
+								errAttr(),
+							),
+							factory.NewExprStmt(factory.NewSelectorCall("http", "Error",
+								ast.NewIdent("w"),
+								factory.NewSelectorCall("http", "StatusText", factory.NewSelector("http", "StatusBadGateway")),
+								factory.NewSelector("http", "StatusBadGateway"),
+							)),
+						),
+					)),
+				)),
+				ast.NewIdent("nil"),
+			),
+		),
+	)
+
+	return &types.File{
+		Name:    "proxy.go",
+		Content: factory.NewFileNode(GatewayPackageName, imports, newProxyFunc),
+	}
+}
+
+// gatewayMiddlewareFile generates middleware.go: the central place for the
+// CORS policy, authentication and per-client rate limiting of the gateway
+func gatewayMiddlewareFile() *types.File {
+	imports := factory.NewImportDecl(
+		factory.NewImport("net", ""),
+		factory.NewImport("net/http", ""),
+		factory.NewImport("strconv", ""),
+		factory.NewImport("sync", ""),
+		factory.NewImport("time", ""),
+		factory.NewImport("github.com/go-chi/cors", ""),
+	)
+
+	middlewareType := func() *ast.FuncType {
+		return factory.NewFuncType(
+			factory.NewFieldList(factory.NewField("next", factory.NewSelector("http", "Handler"))),
+			factory.NewFieldList(factory.NewField("", factory.NewSelector("http", "Handler"))),
+		)
+	}
+	handlerFunc := func(stmts ...ast.Stmt) ast.Expr {
+		return factory.NewCall(factory.NewSelector("http", "HandlerFunc"), factory.NewFuncLit(
+			factory.NewFuncType(
+				factory.NewFieldList(
+					factory.NewField("w", factory.NewSelector("http", "ResponseWriter")),
+					factory.NewField("r", &ast.StarExpr{X: factory.NewSelector("http", "Request")}),
+				),
+				factory.NewFieldList(),
+			),
+			factory.NewBodyStmt(stmts...),
+		))
+	}
+	serveNext := func() ast.Stmt {
+		return factory.NewExprStmt(factory.NewSelectorCall("next", "ServeHTTP", ast.NewIdent("w"), ast.NewIdent("r")))
+	}
+
+	// var CORS = cors.Options{...}
+	corsVar := factory.NewVarDecl("CORS", nil)
+	corsVar.Specs[0].(*ast.ValueSpec).Values = []ast.Expr{
+		factory.NewCompositeLit(factory.NewSelector("cors", "Options"),
+			factory.NewKeyValue("AllowedOrigins", factory.NewStringSliceLit("https://*", "http://*")),
+			factory.NewKeyValue("AllowedMethods", factory.NewStringSliceLit("GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS")),
+			factory.NewKeyValue("AllowedHeaders", factory.NewStringSliceLit("Accept", "Authorization", "Content-Type")),
+			factory.NewKeyValue("MaxAge", factory.NewBasicLitInt(300)),
+		),
+	}
+
+	// func Authenticate(next http.Handler) http.Handler
+	authenticateFunc := factory.NewFuncDecl(
+		"Authenticate",
+		factory.NewFieldList(),
+		middlewareType(),
+		factory.NewBodyStmt(
+			factory.NewReturn(handlerFunc(
+				// Every proxied request goes through here: verify credentials before serving next
+				serveNext(),
+			)),
+		),
+	)
+
+	// type window struct { count int; reset time.Time }
+	windowStruct := factory.NewTypeStruct("window", factory.NewFieldList(
+		factory.NewField("count", ast.NewIdent("int")),
+		factory.NewField("reset", factory.NewSelector("time", "Time")),
+	))
+
+	windowsMap := func() ast.Expr {
+		return &ast.MapType{Key: ast.NewIdent("string"), Value: &ast.StarExpr{X: ast.NewIdent("window")}}
+	}
+
+	// func RateLimit(requests int, per time.Duration) func(next http.Handler) http.Handler
+	rateLimitFunc := factory.NewFuncDecl(
+		"RateLimit",
+		factory.NewFieldList(),
+		factory.NewFuncType(
+			factory.NewFieldList(
+				factory.NewField("requests", ast.NewIdent("int")),
+				factory.NewField("per", factory.NewSelector("time", "Duration")),
+			),
+			factory.NewFieldList(factory.NewField("", middlewareType())),
+		),
+		factory.NewBodyStmt(
+			&ast.DeclStmt{Decl: factory.NewVarDecl("mu", factory.NewSelector("sync", "Mutex"))},
+			factory.NewDefine("windows", factory.NewCall(ast.NewIdent("make"), windowsMap())),
+			factory.NewReturn(factory.NewFuncLit(
+				middlewareType(),
+				factory.NewBodyStmt(
+					factory.NewReturn(handlerFunc(
+						&ast.AssignStmt{
+							Lhs: []ast.Expr{ast.NewIdent("client"), ast.NewIdent("_"), ast.NewIdent("err")},
+							Tok: token.DEFINE,
+							Rhs: []ast.Expr{factory.NewSelectorCall("net", "SplitHostPort", factory.NewSelector("r", "RemoteAddr"))},
+						},
+						factory.NewIfError(factory.NewAssign(ast.NewIdent("client"), factory.NewSelector("r", "RemoteAddr"))),
+						factory.NewDefine("now", factory.NewSelectorCall("time", "Now")),
+						factory.NewExprStmt(factory.NewSelectorCall("mu", "Lock")),
+						&ast.AssignStmt{
+							Lhs: []ast.Expr{ast.NewIdent("win"), ast.NewIdent("ok")},
+							Tok: token.DEFINE,
+							Rhs: []ast.Expr{&ast.IndexExpr{X: ast.NewIdent("windows"), Index: ast.NewIdent("client")}},
+						},
+						// Start a new window for unseen clients and expired windows
+						factory.NewIf(
+							&ast.BinaryExpr{
+								X:  &ast.UnaryExpr{Op: token.NOT, X: ast.NewIdent("ok")},
+								Op: token.LOR,
+								Y:  factory.NewSelectorCall("now", "After", factory.NewSelector("win", "reset")),
+							},
+							factory.NewAssign(ast.NewIdent("win"), factory.NewAddressOf(factory.NewCompositeLit(ast.NewIdent("window"),
+								factory.NewKeyValue("reset", factory.NewSelectorCall("now", "Add", ast.NewIdent("per"))),
+							))),
+							&ast.AssignStmt{
+								Lhs: []ast.Expr{&ast.IndexExpr{X: ast.NewIdent("windows"), Index: ast.NewIdent("client")}},
+								Tok: token.ASSIGN,
+								Rhs: []ast.Expr{ast.NewIdent("win")},
+							},
+						),
+						&ast.IncDecStmt{X: factory.NewSelector("win", "count"), Tok: token.INC},
+						factory.NewDefine("allowed", &ast.BinaryExpr{
+							X: factory.NewSelector("win", "count"), Op: token.LEQ, Y: ast.NewIdent("requests"),
+						}),
+						factory.NewDefine("retry", factory.NewSelectorCall("win", "reset.Sub", ast.NewIdent("now"))),
+						factory.NewExprStmt(factory.NewSelectorCall("mu", "Unlock")),
+						factory.NewIf(
+							&ast.UnaryExpr{Op: token.NOT, X: ast.NewIdent("allowed")},
+							factory.NewExprStmt(factory.NewSelectorCall("w", "Header().Set",
+								factory.NewBasicLit("Retry-After"),
+								factory.NewSelectorCall("strconv", "Itoa", &ast.BinaryExpr{
+									X:  factory.NewCall(ast.NewIdent("int"), factory.NewSelectorCall("retry", "Seconds")),
+									Op: token.ADD,
+									Y:  factory.NewBasicLitInt(1),
+								}),
+							)),
+							factory.NewExprStmt(factory.NewSelectorCall("http", "Error",
+								ast.NewIdent("w"),
+								factory.NewSelectorCall("http", "StatusText", factory.NewSelector("http", "StatusTooManyRequests")),
+								factory.NewSelector("http", "StatusTooManyRequests"),
+							)),
+							factory.NewReturn(),
+						),
+						serveNext(),
+					)),
+				),
+			)),
+		),
+	)
+
+	return &types.File{
+		Name: "middleware.go",
+		Content: factory.NewFileNode(GatewayPackageName,
+			imports,
+			corsVar,
+			authenticateFunc,
+			windowStruct,
+			rateLimitFunc,
+		),
+	}
+}
+
+// GatewayRoutesFile generates routes.go: the middleware chain followed by one
+// proxied route per upstream route, under the upstream's prefix
+func GatewayRoutesFile(s *types.Service) *types.File {
+	importSpecs := []*ast.ImportSpec{
+		factory.NewImport(s.Name+"/"+GatewayPackageName, ""),
+		factory.NewImport(s.Name+"/"+HealthPackageName, ""),
+		factory.NewImport(s.Name+"/"+LoggerPackageName, ""),
+		factory.NewImport("net/http", ""),
+		factory.NewImport("time", ""),
+		factory.NewImport("github.com/go-chi/chi/v5", ""),
+		factory.NewImport("github.com/go-chi/chi/v5/middleware", ""),
+		factory.NewImport("github.com/go-chi/cors", ""),
+	}
+
+	use := func(arg ast.Expr) ast.Stmt {
+		return factory.NewExprStmt(factory.NewSelectorCall("mux", "Use", arg))
+	}
+
+	// Probes and /metrics are answered before authentication and rate limiting
+	stmts := []ast.Stmt{
+		factory.NewDefine("mux", factory.NewSelectorCall("chi", "NewRouter")),
+		use(factory.NewSelector(HealthPackageName, "Probes")),
+	}
+	if s.Metrics {
+		importSpecs = append(importSpecs, factory.NewImport(s.Name+"/"+MetricsPackageName, ""))
+		stmts = append(stmts, metricsStmt())
+	}
+	// Traced gateways forward the trace context to the upstreams
+	proxyStmts := []ast.Stmt{
+		factory.NewDefineExpectsError("proxy", factory.NewSelectorCall(GatewayPackageName, "NewProxy", ast.NewIdent("u"))),
+		factory.NewIfError(factory.NewReturn(ast.NewIdent("nil"), ast.NewIdent("err"))),
+	}
+	if s.Tracing {
+		importSpecs = append(importSpecs, factory.NewImport(s.Name+"/"+TracingPackageName, ""))
+		stmts = append(stmts, tracingMiddlewareStmt())
+		proxyStmts = append(proxyStmts, factory.NewAssign(
+			factory.NewSelector("proxy", "Transport"),
+			factory.NewSelectorCall(TracingPackageName, "Transport", factory.NewSelector("http", "DefaultTransport")),
+		))
+	}
+	imports := factory.NewImportDecl(importSpecs...)
+
+	// func Routes() (http.Handler, error)
+	routesFunc := factory.NewFuncDecl(
+		"Routes",
+		factory.NewFieldList(),
+		factory.NewFuncType(
+			factory.NewFieldList(),
+			factory.NewFieldList(
+				factory.NewField("", factory.NewSelector("http", "Handler")),
+				factory.NewField("", ast.NewIdent("error")),
+			),
+		),
+		factory.NewBodyStmt(append(stmts,
+			use(factory.NewSelector("middleware", "RequestID")),
+			use(factory.NewSelector("middleware", "RealIP")),
+			use(factory.NewSelector(LoggerPackageName, "Requests")),
+			use(factory.NewSelectorCall("cors", "Handler", factory.NewSelector(GatewayPackageName, "CORS"))),
+			use(factory.NewSelectorCall(GatewayPackageName, "RateLimit",
+				factory.NewBasicLitInt(100),
+				factory.NewSelector("time", "Minute"),
+			)),
+			use(factory.NewSelector(GatewayPackageName, "Authenticate")),
+			&ast.RangeStmt{
+				Key:   ast.NewIdent("_"),
+				Value: ast.NewIdent("u"),
+				Tok:   token.DEFINE,
+				X:     factory.NewSelector(GatewayPackageName, "Upstreams"),
+				Body: factory.NewBodyStmt(append(proxyStmts,
+					&ast.RangeStmt{
+						Key:   ast.NewIdent("_"),
+						Value: ast.NewIdent("route"),
+						Tok:   token.DEFINE,
+						X:     factory.NewSelector("u", "Routes"),
+						Body: factory.NewBodyStmt(
+							factory.NewExprStmt(factory.NewSelectorCall("mux", "Method",
+								factory.NewSelector("route", "Method"),
+								&ast.BinaryExpr{X: factory.NewSelector("u", "Prefix"), Op: token.ADD, Y: factory.NewSelector("route", "Path")},
+								ast.NewIdent("proxy"),
+							)),
+						),
+					},
+				)...),
+			},
+			factory.NewReturn(ast.NewIdent("mux"), ast.NewIdent("nil")),
+		)...),
+	)
+
+	return &types.File{
+		Name:    "routes.go",
+		Content: factory.NewFileNode("routes", imports, routesFunc),
+	}
+}
+
+// GatewayConfigFile generates config.go for a gateway service (no DB, no broker)
+func GatewayConfigFile(s *types.Service) *types.File {
+	imports := factory.NewImportDecl(
+		factory.NewImport(s.Name+"/routes", ""),
+		factory.NewImport("log/slog", ""),
+		factory.NewImport("net/http", ""),
+		factory.NewImport("os", ""),
+	)
+
+	// type Config struct { Handler http.Handler; Env *Env }
+	configStruct := factory.NewTypeStruct("Config", factory.NewFieldList(
+		factory.NewField("Handler", factory.NewSelector("http", "Handler")),
+		envField(),
+	))
+
+	// func InitConfig(env *Env) *Config
+	initConfigFunc := factory.NewFuncDecl(
+		"InitConfig",
+		factory.NewFieldList(),
+		factory.NewFuncType(
+			envParams(),
+			factory.NewFieldList(factory.NewField("", &ast.StarExpr{X: ast.NewIdent("Config")})),
+		),
+		factory.NewBodyStmt(
+			factory.NewDefineExpectsError("handler", factory.NewSelectorCall("routes", "Routes")),
+			factory.NewIfError(logFatal("invalid route table", errAttr())...),
+			factory.NewReturn(factory.NewAddressOf(factory.NewCompositeLit(ast.NewIdent("Config"),
+				factory.NewKeyValue("Handler", ast.NewIdent("handler")),
+				factory.NewKeyValue("Env", ast.NewIdent("env")),
+			))),
+		),
+	)
+
+	// func (app *Config) InitServer()
+	initServerFunc := factory.NewFuncDecl(
+		"InitServer",
+		factory.NewFieldList(factory.NewField("app", &ast.StarExpr{X: ast.NewIdent("Config")})),
+		factory.NewFuncType(factory.NewFieldList(), factory.NewFieldList()),
+		factory.NewBodyStmt(
+			factory.NewDefine("server", factory.NewAddressOf(factory.NewCompositeLit(
+				factory.NewSelector("http", "Server"),
+				append([]ast.Expr{
+					factory.NewKeyValue("Addr", serverAddr()),
+					factory.NewKeyValue("Handler", factory.NewSelector("app", "Handler")),
+				}, serverTimeouts()...)...,
+			))),
+			withInit(
+				factory.NewIfError(logFatal("server stopped", errAttr())...),
+				factory.NewDefine("err", factory.NewSelectorCall("server", "ListenAndServe")),
+			),
+		),
+	)
+
+	return &types.File{
+		Name:    "config.go",
+		Content: factory.NewFileNode("config", imports, configStruct, initConfigFunc, initServerFunc),
+	}
+}
@@ -0,0 +1,496 @@
+package defaults
+
+import (
+	"go/ast"
+	"go/token"
+	"strings"
+
+	"github.com/flaviogonzalez/instant-layer/internal/factory"
+	"github.com/flaviogonzalez/instant-layer/internal/types"
+)
+
+// GRPCPackageName is the package holding the .proto and the gRPC stubs of a service
+const GRPCPackageName = "pb"
+
+// GRPCService creates a gRPC service with appropriate options
+func GRPCService(opts ...Option) *types.Service {
+	s := &types.Service{Port: 50051}
+
+	// Apply user options first
+	for _, o := range opts {
+		o(s)
+	}
+
+	// No HTTP server to expose /metrics on or to trace
+	s.Metrics = false
+	s.Tracing = false
+
+	// gRPC services need: stubs, the server implementation, config and main
+	// No routes or database - the API is declared in the .proto
+	baseOpts := []Option{
+		WithGRPCStubs(),
+		WithGRPCHandlers(),
+		WithGRPCConfig(),
+		WithGRPCMain(),
+		WithLogger(),
+	}
+
+	return applyOptions(s, baseOpts...)
+}
+
+// GRPCServiceName returns the name of the gRPC service declared in the .proto (user-service -> UserService)
+func GRPCServiceName(s *types.Service) string {
+	name := GoName(s.Name)
+	if !strings.HasSuffix(name, "Service") {
+		name += "Service"
+	}
+	return name
+}
+
+// GRPCProtoPackage returns the protobuf package of a service (user-service -> user_service.v1)
+func GRPCProtoPackage(s *types.Service) string {
+	return SnakeCase(s.Name) + ".v1"
+}
+
+// GRPCProtoFile returns the base name of the .proto and its generated files (user-service -> user_service)
+func GRPCProtoFile(s *types.Service) string {
+	return SnakeCase(s.Name)
+}
+
+// GRPCStubsPackage generates the pb package with the stubs protoc-gen-go-grpc produces for the
+// skeleton .proto, checked in so the service builds without protoc. The skeleton only uses
+// well-known types, so no message code (.pb.go) is needed until the .proto declares messages.
+// Contains: <service>_grpc.pb.go
+func GRPCStubsPackage(s *types.Service) *types.Package {
+	svc := GRPCServiceName(s)
+	fullMethod := svc + "_Ping_FullMethodName"
+	serverIface := svc + "Server"
+	clientIface := svc + "Client"
+	clientImpl := strings.ToLower(svc[:1]) + svc[1:] + "Client"
+	unimplemented := "Unimplemented" + serverIface
+	mustEmbed := "mustEmbed" + unimplemented
+	handlerName := "_" + svc + "_Ping_Handler"
+	descName := svc + "_ServiceDesc"
+	empty := func() ast.Expr { return &ast.StarExpr{X: factory.NewSelector("emptypb", "Empty")} }
+
+	imports := factory.NewImportDecl(
+		factory.NewImport("context", ""),
+		factory.NewImport("google.golang.org/grpc", ""),
+		factory.NewImport("google.golang.org/grpc/codes", ""),
+		factory.NewImport("google.golang.org/grpc/status", ""),
+		factory.NewImport("google.golang.org/protobuf/types/known/emptypb", ""),
+	)
+
+	// const UserService_Ping_FullMethodName = "/user_service.v1.UserService/Ping"
+	fullMethodConst := factory.NewConstDecl(fullMethod,
+		factory.NewBasicLit("/"+GRPCProtoPackage(s)+"."+svc+"/Ping"),
+	)
+
+	pingParams := func() *ast.FieldList {
+		return factory.NewFieldList(
+			factory.NewField("", factory.NewSelector("context", "Context")),
+			factory.NewField("", empty()),
+		)
+	}
+	pingResults := func() *ast.FieldList {
+		return factory.NewFieldList(factory.NewField("", empty()), factory.NewField("", ast.NewIdent("error")))
+	}
+
+	// type UserServiceClient interface { Ping(ctx, in, opts...) (*emptypb.Empty, error) }
+	clientInterface := factory.NewTypeInterface(clientIface, factory.NewFieldList(
+		factory.NewField("Ping", factory.NewFuncType(
+			factory.NewFieldList(
+				factory.NewField("ctx", factory.NewSelector("context", "Context")),
+				factory.NewField("in", empty()),
+				factory.NewField("opts", &ast.Ellipsis{Elt: factory.NewSelector("grpc", "CallOption")}),
+			),
+			pingResults(),
+		)),
+	))
+
+	// type userServiceClient struct { cc grpc.ClientConnInterface }
+	clientStruct := factory.NewTypeStruct(clientImpl, factory.NewFieldList(
+		factory.NewField("cc", factory.NewSelector("grpc", "ClientConnInterface")),
+	))
+
+	// func NewUserServiceClient(cc grpc.ClientConnInterface) UserServiceClient
+	newClientFunc := factory.NewFuncDecl(
+		"New"+clientIface,
+		factory.NewFieldList(),
+		factory.NewFuncType(
+			factory.NewFieldList(factory.NewField("cc", factory.NewSelector("grpc", "ClientConnInterface"))),
+			factory.NewFieldList(factory.NewField("", ast.NewIdent(clientIface))),
+		),
+		factory.NewBodyStmt(
+			factory.NewReturn(factory.NewAddressOf(factory.NewCompositeLit(ast.NewIdent(clientImpl), ast.NewIdent("cc")))),
+		),
+	)
+
+	// func (c *userServiceClient) Ping(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	clientPingFunc := factory.NewFuncDecl(
+		"Ping",
+		factory.NewFieldList(factory.NewField("c", &ast.StarExpr{X: ast.NewIdent(clientImpl)})),
+		factory.NewFuncType(
+			factory.NewFieldList(
+				factory.NewField("ctx", factory.NewSelector("context", "Context")),
+				factory.NewField("in", empty()),
+				factory.NewField("opts", &ast.Ellipsis{Elt: factory.NewSelector("grpc", "CallOption")}),
+			),
+			pingResults(),
+		),
+		factory.NewBodyStmt(
+			factory.NewDefine("out", factory.NewCall(ast.NewIdent("new"), factory.NewSelector("emptypb", "Empty"))),
+			factory.NewDefine("err", &ast.CallExpr{
+				Fun: factory.NewSelector("c", "cc.Invoke"),
+				Args: []ast.Expr{
+					ast.NewIdent("ctx"), ast.NewIdent(fullMethod), ast.NewIdent("in"), ast.NewIdent("out"), ast.NewIdent("opts"),
+				},
+				Ellipsis: 1,
+			}),
+			factory.NewIfError(factory.NewReturn(ast.NewIdent("nil"), ast.NewIdent("err"))),
+			factory.NewReturn(ast.NewIdent("out"), ast.NewIdent("nil")),
+		),
+	)
+
+	// type UserServiceServer interface { Ping(...); mustEmbedUnimplementedUserServiceServer() }
+	serverInterface := factory.NewTypeInterface(serverIface, factory.NewFieldList(
+		factory.NewField("Ping", factory.NewFuncType(pingParams(), pingResults())),
+		factory.NewField(mustEmbed, factory.NewFuncType(factory.NewFieldList(), factory.NewFieldList())),
+	))
+
+	// type UnimplementedUserServiceServer struct{}
+	unimplementedStruct := factory.NewTypeStruct(unimplemented, factory.NewFieldList())
+
+	// func (UnimplementedUserServiceServer) Ping(context.Context, *emptypb.Empty) (*emptypb.Empty, error)
+	unimplementedPingFunc := factory.NewFuncDecl(
+		"Ping",
+		factory.NewFieldList(factory.NewField("", ast.NewIdent(unimplemented))),
+		factory.NewFuncType(pingParams(), pingResults()),
+		factory.NewBodyStmt(
+			factory.NewReturn(ast.NewIdent("nil"), factory.NewSelectorCall("status", "Errorf",
+				factory.NewSelector("codes", "Unimplemented"),
+				factory.NewBasicLit("method Ping not implemented"),
+			)),
+		),
+	)
+
+	// func (UnimplementedUserServiceServer) mustEmbedUnimplementedUserServiceServer() {}
+	mustEmbedFunc := factory.NewFuncDecl(
+		mustEmbed,
+		factory.NewFieldList(factory.NewField("", ast.NewIdent(unimplemented))),
+		factory.NewFuncType(factory.NewFieldList(), factory.NewFieldList()),
+		factory.NewBodyStmt(),
+	)
+
+	// func RegisterUserServiceServer(s grpc.ServiceRegistrar, srv UserServiceServer)
+	registerFunc := factory.NewFuncDecl(
+		"Register"+serverIface,
+		factory.NewFieldList(),
+		factory.NewFuncType(
+			factory.NewFieldList(
+				factory.NewField("s", factory.NewSelector("grpc", "ServiceRegistrar")),
+				factory.NewField("srv", ast.NewIdent(serverIface)),
+			),
+			factory.NewFieldList(),
+		),
+		factory.NewBodyStmt(
+			factory.NewExprStmt(factory.NewSelectorCall("s", "RegisterService",
+				factory.NewAddressOf(ast.NewIdent(descName)),
+				ast.NewIdent("srv"),
+			)),
+		),
+	)
+
+	// srv.(UserServiceServer).Ping(ctx, in)
+	serverPing := func(in ast.Expr) ast.Expr {
+		return factory.NewCall(
+			&ast.SelectorExpr{
+				X:   &ast.TypeAssertExpr{X: ast.NewIdent("srv"), Type: ast.NewIdent(serverIface)},
+				Sel: ast.NewIdent("Ping"),
+			},
+			ast.NewIdent("ctx"),
+			in,
+		)
+	}
+
+	// func _UserService_Ping_Handler(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error)
+	handlerFunc := factory.NewFuncDecl(
+		handlerName,
+		factory.NewFieldList(),
+		factory.NewFuncType(
+			factory.NewFieldList(
+				factory.NewField("srv", ast.NewIdent("any")),
+				factory.NewField("ctx", factory.NewSelector("context", "Context")),
+				factory.NewField("dec", factory.NewFuncType(
+					factory.NewFieldList(factory.NewField("", ast.NewIdent("any"))),
+					factory.NewFieldList(factory.NewField("", ast.NewIdent("error"))),
+				)),
+				factory.NewField("interceptor", factory.NewSelector("grpc", "UnaryServerInterceptor")),
+			),
+			factory.NewFieldList(factory.NewField("", ast.NewIdent("any")), factory.NewField("", ast.NewIdent("error"))),
+		),
+		factory.NewBodyStmt(
+			factory.NewDefine("in", factory.NewCall(ast.NewIdent("new"), factory.NewSelector("emptypb", "Empty"))),
+			withInit(
+				factory.NewIfError(factory.NewReturn(ast.NewIdent("nil"), ast.NewIdent("err"))),
+				factory.NewDefine("err", factory.NewCall(ast.NewIdent("dec"), ast.NewIdent("in"))),
+			),
+			factory.NewIf(
+				&ast.BinaryExpr{X: ast.NewIdent("interceptor"), Op: token.EQL, Y: ast.NewIdent("nil")},
+				factory.NewReturn(serverPing(ast.NewIdent("in"))),
+			),
+			factory.NewDefine("info", factory.NewAddressOf(factory.NewCompositeLit(
+				factory.NewSelector("grpc", "UnaryServerInfo"),
+				factory.NewKeyValue("Server", ast.NewIdent("srv")),
+				factory.NewKeyValue("FullMethod", ast.NewIdent(fullMethod)),
+			))),
+			factory.NewDefine("handler", factory.NewFuncLit(
+				factory.NewFuncType(
+					factory.NewFieldList(
+						factory.NewField("ctx", factory.NewSelector("context", "Context")),
+						factory.NewField("req", ast.NewIdent("any")),
+					),
+					factory.NewFieldList(factory.NewField("", ast.NewIdent("any")), factory.NewField("", ast.NewIdent("error"))),
+				),
+				factory.NewBodyStmt(
+					factory.NewReturn(serverPing(&ast.TypeAssertExpr{X: ast.NewIdent("req"), Type: empty()})),
+				),
+			)),
+			factory.NewReturn(factory.NewCall(ast.NewIdent("interceptor"),
+				ast.NewIdent("ctx"), ast.NewIdent("in"), ast.NewIdent("info"), ast.NewIdent("handler"),
+			)),
+		),
+	)
+
+	// var UserService_ServiceDesc = grpc.ServiceDesc{...}
+	serviceDesc := &ast.GenDecl{
+		Tok: token.VAR,
+		Specs: []ast.Spec{
+			&ast.ValueSpec{
+				Names: []*ast.Ident{ast.NewIdent(descName)},
+				Values: []ast.Expr{factory.NewCompositeLit(
+					factory.NewSelector("grpc", "ServiceDesc"),
+					factory.NewKeyValue("ServiceName", factory.NewBasicLit(GRPCProtoPackage(s)+"."+svc)),
+					factory.NewKeyValue("HandlerType", factory.NewCall(
+						&ast.ParenExpr{X: &ast.StarExpr{X: ast.NewIdent(serverIface)}},
+						ast.NewIdent("nil"),
+					)),
+					factory.NewKeyValue("Methods", factory.NewCompositeLit(
+						&ast.ArrayType{Elt: factory.NewSelector("grpc", "MethodDesc")},
+						factory.NewCompositeLit(nil,
+							factory.NewKeyValue("MethodName", factory.NewBasicLit("Ping")),
+							factory.NewKeyValue("Handler", ast.NewIdent(handlerName)),
+						),
+					)),
+					factory.NewKeyValue("Streams", factory.NewCompositeLit(&ast.ArrayType{Elt: factory.NewSelector("grpc", "StreamDesc")})),
+					factory.NewKeyValue("Metadata", factory.NewBasicLit(GRPCProtoFile(s)+".proto")),
+				)},
+			},
+		},
+	}
+
+	return &types.Package{
+		Name: GRPCPackageName,
+		Files: []*types.File{
+			{
+				Name: GRPCProtoFile(s) + "_grpc.pb.go",
+				Content: factory.NewFileNode(GRPCPackageName,
+					imports,
+					fullMethodConst,
+					clientInterface,
+					clientStruct,
+					newClientFunc,
+					clientPingFunc,
+					serverInterface,
+					unimplementedStruct,
+					unimplementedPingFunc,
+					mustEmbedFunc,
+					registerFunc,
+					handlerFunc,
+					serviceDesc,
+				),
+			},
+		},
+	}
+}
+
+// GRPCServerFile generates server.go, the implementation of the .proto service
+func GRPCServerFile(s *types.Service) *types.File {
+	svc := GRPCServiceName(s)
+
+	imports := factory.NewImportDecl(
+		factory.NewImport(s.Name+"/"+GRPCPackageName, ""),
+		factory.NewImport("context", ""),
+		factory.NewImport("google.golang.org/protobuf/types/known/emptypb", ""),
+	)
+
+	// type Server struct { pb.UnimplementedUserServiceServer }
+	serverStruct := factory.NewTypeStruct("Server", factory.NewFieldList(
+		factory.NewField("", factory.NewSelector(GRPCPackageName, "Unimplemented"+svc+"Server")),
+	))
+
+	// func (s *Server) Ping(ctx context.Context, in *emptypb.Empty) (*emptypb.Empty, error)
+	pingFunc := factory.NewFuncDecl(
+		"Ping",
+		factory.NewFieldList(factory.NewField("s", &ast.StarExpr{X: ast.NewIdent("Server")})),
+		factory.NewFuncType(
+			factory.NewFieldList(
+				factory.NewField("ctx", factory.NewSelector("context", "Context")),
+				factory.NewField("in", &ast.StarExpr{X: factory.NewSelector("emptypb", "Empty")}),
+			),
+			factory.NewFieldList(
+				factory.NewField("", &ast.StarExpr{X: factory.NewSelector("emptypb", "Empty")}),
+				factory.NewField("", ast.NewIdent("error")),
+			),
+		),
+		factory.NewBodyStmt(
+			factory.NewReturn(
+				factory.NewAddressOf(factory.NewCompositeLit(factory.NewSelector("emptypb", "Empty"))),
+				ast.NewIdent("nil"),
+			),
+		),
+	)
+
+	return &types.File{
+		Name:    "server.go",
+		Content: factory.NewFileNode("handlers", imports, serverStruct, pingFunc),
+	}
+}
+
+// GRPCConfigFile generates config.go for a gRPC service: registers the server, the
+// health service and reflection, and stops gracefully on SIGINT/SIGTERM
+func GRPCConfigFile(s *types.Service) *types.File {
+	svc := GRPCServiceName(s)
+
+	imports := factory.NewImportDecl(
+		factory.NewImport(s.Name+"/handlers", ""),
+		factory.NewImport(s.Name+"/"+GRPCPackageName, ""),
+		factory.NewImport("log/slog", ""),
+		factory.NewImport("net", ""),
+		factory.NewImport("os", ""),
+		factory.NewImport("os/signal", ""),
+		factory.NewImport("syscall", ""),
+		factory.NewImport("google.golang.org/grpc", ""),
+		factory.NewImport("google.golang.org/grpc/health", ""),
+		factory.NewImport("google.golang.org/grpc/health/grpc_health_v1", "healthpb"),
+		factory.NewImport("google.golang.org/grpc/keepalive", ""),
+		factory.NewImport("google.golang.org/grpc/reflection", ""),
+	)
+
+	// type Config struct { Server *grpc.Server; Health *health.Server; Env *Env }
+	configStruct := factory.NewTypeStruct("Config", factory.NewFieldList(
+		factory.NewField("Server", &ast.StarExpr{X: factory.NewSelector("grpc", "Server")}),
+		factory.NewField("Health", &ast.StarExpr{X: factory.NewSelector("health", "Server")}),
+		envField(),
+	))
+
+	// func InitConfig(env *Env) *Config
+	initConfigFunc := factory.NewFuncDecl(
+		"InitConfig",
+		factory.NewFieldList(),
+		factory.NewFuncType(
+			envParams(),
+			factory.NewFieldList(factory.NewField("", &ast.StarExpr{X: ast.NewIdent("Config")})),
+		),
+		factory.NewBodyStmt(
+			// The handshake and idle timeouts of the environment bound each connection
+			factory.NewDefine("server", factory.NewSelectorCall("grpc", "NewServer",
+				factory.NewSelectorCall("grpc", "ConnectionTimeout", factory.NewSelector("env", "ReadHeaderTimeout")),
+				factory.NewSelectorCall("grpc", "KeepaliveParams", factory.NewCompositeLit(
+					factory.NewSelector("keepalive", "ServerParameters"),
+					factory.NewKeyValue("MaxConnectionIdle", factory.NewSelector("env", "IdleTimeout")),
+				)),
+			)),
+			factory.NewExprStmt(factory.NewSelectorCall(GRPCPackageName, "Register"+svc+"Server",
+				ast.NewIdent("server"),
+				factory.NewAddressOf(factory.NewCompositeLit(factory.NewSelector("handlers", "Server"))),
+			)),
+			// grpc.health.v1.Health reports SERVING for the service until shutdown
+			factory.NewDefine("healthServer", factory.NewSelectorCall("health", "NewServer")),
+			factory.NewExprStmt(factory.NewSelectorCall("healthpb", "RegisterHealthServer",
+				ast.NewIdent("server"), ast.NewIdent("healthServer"),
+			)),
+			factory.NewExprStmt(factory.NewSelectorCall("healthServer", "SetServingStatus",
+				factory.NewSelector(GRPCPackageName, svc+"_ServiceDesc.ServiceName"),
+				factory.NewSelector("healthpb", "HealthCheckResponse_SERVING"),
+			)),
+			// Reflection lets grpcurl and grpcui list the services without the .proto
+			factory.NewExprStmt(factory.NewSelectorCall("reflection", "Register", ast.NewIdent("server"))),
+			factory.NewReturn(factory.NewAddressOf(factory.NewCompositeLit(ast.NewIdent("Config"),
+				factory.NewKeyValue("Server", ast.NewIdent("server")),
+				factory.NewKeyValue("Health", ast.NewIdent("healthServer")),
+				factory.NewKeyValue("Env", ast.NewIdent("env")),
+			))),
+		),
+	)
+
+	// func (app *Config) InitServer()
+	initServerFunc := factory.NewFuncDecl(
+		"InitServer",
+		factory.NewFieldList(factory.NewField("app", &ast.StarExpr{X: ast.NewIdent("Config")})),
+		factory.NewFuncType(factory.NewFieldList(), factory.NewFieldList()),
+		factory.NewBodyStmt(
+			factory.NewDefineExpectsError("lis", factory.NewSelectorCall("net", "Listen",
+				factory.NewBasicLit("tcp"), serverAddr(),
+			)),
+			factory.NewIfError(logFatal("failed to listen", errAttr())...),
+			// stop := make(chan os.Signal, 1)
+			factory.NewDefine("stop", factory.NewCall(ast.NewIdent("make"),
+				&ast.ChanType{Dir: ast.SEND | ast.RECV, Value: factory.NewSelector("os", "Signal")},
+				factory.NewBasicLitInt(1),
+			)),
+			factory.NewExprStmt(factory.NewSelectorCall("signal", "Notify",
+				ast.NewIdent("stop"), factory.NewSelector("os", "Interrupt"), factory.NewSelector("syscall", "SIGTERM"),
+			)),
+			// Report NOT_SERVING, then let in-flight RPCs finish
+			&ast.GoStmt{Call: factory.NewCall(factory.NewFuncLit(
+				factory.NewFuncType(factory.NewFieldList(), factory.NewFieldList()),
+				factory.NewBodyStmt(
+					factory.NewExprStmt(&ast.UnaryExpr{Op: token.ARROW, X: ast.NewIdent("stop")}),
+					factory.NewExprStmt(factory.NewSelectorCall("app", "Health.Shutdown")),
+					factory.NewExprStmt(factory.NewSelectorCall("app", "Server.GracefulStop")),
+				),
+			))},
+			logInfo("gRPC server listening", attr("addr", factory.NewSelectorCall("lis", "Addr().String"))),
+			withInit(
+				factory.NewIfError(logFatal("gRPC server stopped", errAttr())...),
+				factory.NewAssignExpectsError(factory.NewSelectorCall("app", "Server.Serve", ast.NewIdent("lis"))),
+			),
+		),
+	)
+
+	return &types.File{
+		Name:    "config.go",
+		Content: factory.NewFileNode("config", imports, configStruct, initConfigFunc, initServerFunc),
+	}
+}
+
+// GRPCMainFile generates main.go for a gRPC service
+func GRPCMainFile(s *types.Service) *types.File {
+	imports := factory.NewImportDecl(append([]*ast.ImportSpec{
+		factory.NewImport(s.Name+"/config", ""),
+		factory.NewImport(s.Name+"/"+LoggerPackageName, ""),
+	}, tracingMainImports(s)...)...)
+
+	// func main() { env := config.LoadEnv(); logger.Setup(...); app := config.InitConfig(env); app.InitServer() }
+	mainFunc := factory.NewFuncDecl(
+		"main",
+		factory.NewFieldList(),
+		factory.NewFuncType(factory.NewFieldList(), factory.NewFieldList()),
+		factory.NewBodyStmt(append(append([]ast.Stmt{loadEnvStmt(), loggerSetupStmt(s)}, tracingSetupStmts(s)...),
+			factory.NewDefine("app", factory.NewSelectorCall("config", "InitConfig", ast.NewIdent("env"))),
+			factory.NewExprStmt(factory.NewSelectorCall("app", "InitServer")),
+		)...),
+	)
+
+	return &types.File{
+		Name:    "main.go",
+		Content: factory.NewFileNode("main", imports, mainFunc),
+	}
+}
+
+// withInit sets the init statement of an if: if <init>; err != nil { ... }
+func withInit(stmt *ast.IfStmt, init ast.Stmt) *ast.IfStmt {
+	stmt.Init = init
+	return stmt
+}
@@ -0,0 +1,445 @@
+package defaults
+
+import (
+	"go/ast"
+	"go/token"
+	"strconv"
+
+	"github.com/flaviogonzalez/instant-layer/internal/factory"
+	"github.com/flaviogonzalez/instant-layer/internal/types"
+)
+
+// HealthPackageName is the package serving the liveness and readiness probes
+const HealthPackageName = "health"
+
+// Probe paths served by every HTTP template and by the admin server
+const (
+	LivePath  = "/healthz"
+	ReadyPath = "/readyz"
+)
+
+// AdminPort is where services without HTTP routes (listeners, workers) serve their probes
+const AdminPort = 8081
+
+// HealthPackage generates the health package: health.go with the check registry
+// and the probe middleware, checks.go with the checks of the service's database
+// and transport, and admin.go serving the probes when the service has no HTTP server
+func HealthPackage(s *types.Service, admin bool) *types.Package {
+	files := []*types.File{healthFile()}
+	if checks := healthChecksFile(s); checks != nil {
+		files = append(files, checks)
+	}
+	if admin {
+		files = append(files, healthAdminFile())
+	}
+	return &types.Package{Name: HealthPackageName, Files: files}
+}
+
+// healthFile generates health.go
+func healthFile() *types.File {
+	imports := factory.NewImportDecl(
+		factory.NewImport("context", ""),
+		factory.NewImport("encoding/json", ""),
+		factory.NewImport("net/http", ""),
+		factory.NewImport("sync", ""),
+		factory.NewImport("time", ""),
+	)
+
+	consts := &ast.GenDecl{
+		Tok:    token.CONST,
+		Lparen: 1,
+		Specs: []ast.Spec{
+			&ast.ValueSpec{Names: []*ast.Ident{ast.NewIdent("LivePath")}, Values: []ast.Expr{factory.NewBasicLit(LivePath)}},
+			&ast.ValueSpec{Names: []*ast.Ident{ast.NewIdent("ReadyPath")}, Values: []ast.Expr{factory.NewBasicLit(ReadyPath)}},
+			// Timeout bounds all the checks of one readiness probe
+			&ast.ValueSpec{Names: []*ast.Ident{ast.NewIdent("Timeout")}, Values: []ast.Expr{&ast.BinaryExpr{
+				X:  factory.NewBasicLitInt(2),
+				Op: token.MUL,
+				Y:  factory.NewSelector("time", "Second"),
+			}}},
+		},
+	}
+
+	// type Check func(ctx context.Context) error
+	checkType := &ast.GenDecl{
+		Tok: token.TYPE,
+		Specs: []ast.Spec{&ast.TypeSpec{
+			Name: ast.NewIdent("Check"),
+			Type: factory.NewFuncType(
+				factory.NewFieldList(factory.NewField("ctx", factory.NewSelector("context", "Context"))),
+				factory.NewFieldList(factory.NewField("", ast.NewIdent("error"))),
+			),
+		}},
+	}
+
+	checksMap := &ast.MapType{Key: ast.NewIdent("string"), Value: ast.NewIdent("Check")}
+	registry := &ast.GenDecl{
+		Tok:    token.VAR,
+		Lparen: 1,
+		Specs: []ast.Spec{
+			&ast.ValueSpec{Names: []*ast.Ident{ast.NewIdent("mu")}, Type: factory.NewSelector("sync", "RWMutex")},
+			&ast.ValueSpec{Names: []*ast.Ident{ast.NewIdent("checks")}, Values: []ast.Expr{factory.NewCompositeLit(checksMap)}},
+		},
+	}
+
+	handlerParams := factory.NewFieldList(
+		factory.NewField("w", factory.NewSelector("http", "ResponseWriter")),
+		factory.NewField("r", &ast.StarExpr{X: factory.NewSelector("http", "Request")}),
+	)
+	bodyType := &ast.MapType{Key: ast.NewIdent("string"), Value: ast.NewIdent("any")}
+	status := func(value string, extra ...ast.Expr) ast.Expr {
+		elts := append([]ast.Expr{&ast.KeyValueExpr{Key: factory.NewBasicLit("status"), Value: factory.NewBasicLit(value)}}, extra...)
+		return factory.NewCompositeLit(bodyType, elts...)
+	}
+
+	// func Register(name string, check Check)
+	registerFunc := factory.NewFuncDecl(
+		"Register",
+		factory.NewFieldList(),
+		factory.NewFuncType(
+			factory.NewFieldList(
+				factory.NewField("name", ast.NewIdent("string")),
+				factory.NewField("check", ast.NewIdent("Check")),
+			),
+			factory.NewFieldList(),
+		),
+		factory.NewBodyStmt(
+			factory.NewExprStmt(factory.NewSelectorCall("mu", "Lock")),
+			&ast.DeferStmt{Call: factory.NewSelectorCall("mu", "Unlock")},
+			factory.NewAssign(&ast.IndexExpr{X: ast.NewIdent("checks"), Index: ast.NewIdent("name")}, ast.NewIdent("check")),
+		),
+	)
+
+	// func Live(w http.ResponseWriter, r *http.Request)
+	liveFunc := factory.NewFuncDecl(
+		"Live",
+		factory.NewFieldList(),
+		factory.NewFuncType(handlerParams, factory.NewFieldList()),
+		factory.NewBodyStmt(
+			factory.NewExprStmt(factory.NewCall(ast.NewIdent("write"),
+				ast.NewIdent("w"), factory.NewSelector("http", "StatusOK"), status("ok"),
+			)),
+		),
+	)
+
+	// func Ready(w http.ResponseWriter, r *http.Request)
+	readyFunc := factory.NewFuncDecl(
+		"Ready",
+		factory.NewFieldList(),
+		factory.NewFuncType(handlerParams, factory.NewFieldList()),
+		factory.NewBodyStmt(
+			&ast.AssignStmt{
+				Lhs: []ast.Expr{ast.NewIdent("ctx"), ast.NewIdent("cancel")},
+				Tok: token.DEFINE,
+				Rhs: []ast.Expr{factory.NewSelectorCall("context", "WithTimeout",
+					factory.NewSelectorCall("r", "Context"),
+					ast.NewIdent("Timeout"),
+				)},
+			},
+			&ast.DeferStmt{Call: factory.NewCall(ast.NewIdent("cancel"))},
+			factory.NewDefine("failed", factory.NewCompositeLit(&ast.MapType{Key: ast.NewIdent("string"), Value: ast.NewIdent("string")})),
+			factory.NewExprStmt(factory.NewSelectorCall("mu", "RLock")),
+			&ast.RangeStmt{
+				Key:   ast.NewIdent("name"),
+				Value: ast.NewIdent("check"),
+				Tok:   token.DEFINE,
+				X:     ast.NewIdent("checks"),
+				Body: factory.NewBodyStmt(
+					&ast.IfStmt{
+						Init: factory.NewDefine("err", factory.NewCall(ast.NewIdent("check"), ast.NewIdent("ctx"))),
+						Cond: &ast.BinaryExpr{X: ast.NewIdent("err"), Op: token.NEQ, Y: ast.NewIdent("nil")},
+						Body: factory.NewBodyStmt(
+							factory.NewAssign(
+								&ast.IndexExpr{X: ast.NewIdent("failed"), Index: ast.NewIdent("name")},
+								factory.NewSelectorCall("err", "Error"),
+							),
+						),
+					},
+				),
+			},
+			factory.NewExprStmt(factory.NewSelectorCall("mu", "RUnlock")),
+			factory.NewIf(
+				&ast.BinaryExpr{X: factory.NewCall(ast.NewIdent("len"), ast.NewIdent("failed")), Op: token.GTR, Y: factory.NewBasicLitInt(0)},
+				factory.NewExprStmt(factory.NewCall(ast.NewIdent("write"),
+					ast.NewIdent("w"),
+					factory.NewSelector("http", "StatusServiceUnavailable"),
+					status("unavailable", &ast.KeyValueExpr{Key: factory.NewBasicLit("checks"), Value: ast.NewIdent("failed")}),
+				)),
+				&ast.ReturnStmt{},
+			),
+			factory.NewExprStmt(factory.NewCall(ast.NewIdent("write"),
+				ast.NewIdent("w"), factory.NewSelector("http", "StatusOK"), status("ok"),
+			)),
+		),
+	)
+
+	isProbe := func(path string) ast.Expr {
+		return &ast.BinaryExpr{
+			X:  &ast.BinaryExpr{X: factory.NewSelector("r", "Method"), Op: token.EQL, Y: factory.NewSelector("http", "MethodGet")},
+			Op: token.LAND,
+			Y:  &ast.BinaryExpr{X: factory.NewSelector("r", "URL.Path"), Op: token.EQL, Y: ast.NewIdent(path)},
+		}
+	}
+
+	// func Probes(next http.Handler) http.Handler
+	probesFunc := factory.NewFuncDecl(
+		"Probes",
+		factory.NewFieldList(),
+		factory.NewFuncType(
+			factory.NewFieldList(factory.NewField("next", factory.NewSelector("http", "Handler"))),
+			factory.NewFieldList(factory.NewField("", factory.NewSelector("http", "Handler"))),
+		),
+		factory.NewBodyStmt(
+			factory.NewReturn(factory.NewCall(factory.NewSelector("http", "HandlerFunc"), factory.NewFuncLit(
+				factory.NewFuncType(handlerParams, factory.NewFieldList()),
+				factory.NewBodyStmt(
+					factory.NewIf(isProbe("LivePath"),
+						factory.NewExprStmt(factory.NewCall(ast.NewIdent("Live"), ast.NewIdent("w"), ast.NewIdent("r"))),
+						&ast.ReturnStmt{},
+					),
+					factory.NewIf(isProbe("ReadyPath"),
+						factory.NewExprStmt(factory.NewCall(ast.NewIdent("Ready"), ast.NewIdent("w"), ast.NewIdent("r"))),
+						&ast.ReturnStmt{},
+					),
+					factory.NewExprStmt(factory.NewSelectorCall("next", "ServeHTTP", ast.NewIdent("w"), ast.NewIdent("r"))),
+				),
+			))),
+		),
+	)
+
+	// func write(w http.ResponseWriter, status int, body map[string]any)
+	writeFunc := factory.NewFuncDecl(
+		"write",
+		factory.NewFieldList(),
+		factory.NewFuncType(
+			factory.NewFieldList(
+				factory.NewField("w", factory.NewSelector("http", "ResponseWriter")),
+				factory.NewField("status", ast.NewIdent("int")),
+				factory.NewField("body", bodyType),
+			),
+			factory.NewFieldList(),
+		),
+		factory.NewBodyStmt(
+			factory.NewExprStmt(factory.NewSelectorCall("w", "Header().Set",
+				factory.NewBasicLit("Content-Type"), factory.NewBasicLit("application/json"),
+			)),
+			factory.NewExprStmt(factory.NewSelectorCall("w", "WriteHeader", ast.NewIdent("status"))),
+			factory.NewExprStmt(factory.NewCall(
+				&ast.SelectorExpr{X: factory.NewSelectorCall("json", "NewEncoder", ast.NewIdent("w")), Sel: ast.NewIdent("Encode")},
+				ast.NewIdent("body"),
+			)),
+		),
+	)
+
+	return &types.File{
+		Name: "health.go",
+		Content: factory.NewFileNode(HealthPackageName,
+			imports,
+			consts,
+			checkType,
+			registry,
+			registerFunc,
+			liveFunc,
+			readyFunc,
+			probesFunc,
+			writeFunc,
+		),
+	}
+}
+
+// healthChecksFile generates checks.go: a Check per dependency of the service,
+// nil when it has neither a database nor a transport
+func healthChecksFile(s *types.Service) *types.File {
+	if s.DB == nil && s.Messaging == nil {
+		return nil
+	}
+
+	specs := []*ast.ImportSpec{
+		factory.NewImport("context", ""),
+		factory.NewImport("errors", ""),
+	}
+	if s.DB != nil {
+		specs = append(specs, factory.NewImport("database/sql", ""))
+	}
+	t := transportFor(s)
+	if s.Messaging != nil {
+		specs = append(specs, t.imports...)
+	}
+
+	checkFunc := func(name, param string, paramType ast.Expr, body ...ast.Stmt) *ast.FuncDecl {
+		return factory.NewFuncDecl(
+			name,
+			factory.NewFieldList(),
+			factory.NewFuncType(
+				factory.NewFieldList(factory.NewField(param, paramType)),
+				factory.NewFieldList(factory.NewField("", ast.NewIdent("Check"))),
+			),
+			factory.NewBodyStmt(factory.NewReturn(factory.NewFuncLit(
+				factory.NewFuncType(
+					factory.NewFieldList(factory.NewField("ctx", factory.NewSelector("context", "Context"))),
+					factory.NewFieldList(factory.NewField("", ast.NewIdent("error"))),
+				),
+				factory.NewBodyStmt(body...),
+			))),
+		)
+	}
+	isNil := func(name string) ast.Expr {
+		return &ast.BinaryExpr{X: ast.NewIdent(name), Op: token.EQL, Y: ast.NewIdent("nil")}
+	}
+	fail := func(message string) ast.Stmt {
+		return factory.NewReturn(factory.NewSelectorCall("errors", "New", factory.NewBasicLit(message)))
+	}
+
+	decls := []ast.Decl{factory.NewImportDecl(specs...)}
+
+	// func Postgres(db *sql.DB) Check
+	if s.DB != nil {
+		decls = append(decls, checkFunc("Postgres", "db", &ast.StarExpr{X: factory.NewSelector("sql", "DB")},
+			factory.NewIf(isNil("db"), fail("not connected")),
+			factory.NewReturn(factory.NewSelectorCall("db", "PingContext", ast.NewIdent("ctx"))),
+		))
+	}
+
+	// func RabbitMQ(conn *amqp.Connection) Check, NATS or Kafka
+	if s.Messaging != nil {
+		var body []ast.Stmt
+		switch TransportOf(s) {
+		case TransportNATS:
+			body = []ast.Stmt{
+				factory.NewIf(
+					&ast.BinaryExpr{X: isNil("conn"), Op: token.LOR, Y: &ast.UnaryExpr{Op: token.NOT, X: factory.NewSelectorCall("conn", "IsConnected")}},
+					fail("not connected"),
+				),
+				factory.NewReturn(ast.NewIdent("nil")),
+			}
+		case TransportKafka:
+			// Kafka connections carry no state: ask the cluster for its controller
+			body = []ast.Stmt{
+				factory.NewIf(isNil("conn"), fail("not connected")),
+				&ast.AssignStmt{
+					Lhs: []ast.Expr{ast.NewIdent("_"), ast.NewIdent("err")},
+					Tok: token.DEFINE,
+					Rhs: []ast.Expr{factory.NewSelectorCall("conn", "Controller")},
+				},
+				factory.NewReturn(ast.NewIdent("err")),
+			}
+		default:
+			body = []ast.Stmt{
+				factory.NewIf(
+					&ast.BinaryExpr{X: isNil("conn"), Op: token.LOR, Y: factory.NewSelectorCall("conn", "IsClosed")},
+					fail("connection closed"),
+				),
+				factory.NewReturn(ast.NewIdent("nil")),
+			}
+		}
+		decls = append(decls, checkFunc(transportCheckName(s), "conn", t.connType, body...))
+	}
+
+	return &types.File{
+		Name:    "checks.go",
+		Content: factory.NewFileNode(HealthPackageName, decls...),
+	}
+}
+
+// healthAdminFile generates admin.go: the probes on AdminAddr, for services without HTTP routes
+func healthAdminFile() *types.File {
+	imports := factory.NewImportDecl(factory.NewImport("net/http", ""))
+
+	middlewareType := factory.NewFuncType(
+		factory.NewFieldList(factory.NewField("", factory.NewSelector("http", "Handler"))),
+		factory.NewFieldList(factory.NewField("", factory.NewSelector("http", "Handler"))),
+	)
+
+	// func Serve(addr string, middlewares ...func(http.Handler) http.Handler) error
+	serveFunc := factory.NewFuncDecl(
+		"Serve",
+		factory.NewFieldList(),
+		factory.NewFuncType(
+			factory.NewFieldList(
+				factory.NewField("addr", ast.NewIdent("string")),
+				factory.NewField("middlewares", &ast.Ellipsis{Elt: middlewareType}),
+			),
+			factory.NewFieldList(factory.NewField("", ast.NewIdent("error"))),
+		),
+		factory.NewBodyStmt(
+			// Middlewares answer their own paths (e.g. /metrics), anything else is a 404
+			&ast.DeclStmt{Decl: &ast.GenDecl{
+				Tok: token.VAR,
+				Specs: []ast.Spec{&ast.ValueSpec{
+					Names:  []*ast.Ident{ast.NewIdent("handler")},
+					Type:   factory.NewSelector("http", "Handler"),
+					Values: []ast.Expr{factory.NewSelectorCall("http", "NotFoundHandler")},
+				}},
+			}},
+			&ast.RangeStmt{
+				Key:   ast.NewIdent("_"),
+				Value: ast.NewIdent("middleware"),
+				Tok:   token.DEFINE,
+				X:     ast.NewIdent("middlewares"),
+				Body: factory.NewBodyStmt(
+					factory.NewAssign(ast.NewIdent("handler"), factory.NewCall(ast.NewIdent("middleware"), ast.NewIdent("handler"))),
+				),
+			},
+			factory.NewReturn(factory.NewSelectorCall("http", "ListenAndServe",
+				ast.NewIdent("addr"),
+				factory.NewCall(ast.NewIdent("Probes"), ast.NewIdent("handler")),
+			)),
+		),
+	)
+
+	return &types.File{
+		Name: "admin.go",
+		Content: factory.NewFileNode(HealthPackageName,
+			imports,
+			// AdminAddr is where services without HTTP routes serve their probes
+			factory.NewConstDecl("AdminAddr", factory.NewBasicLit(":"+strconv.Itoa(AdminPort))),
+			serveFunc,
+		),
+	}
+}
+
+// transportCheckName returns the health check constructor of a service's transport
+func transportCheckName(s *types.Service) string {
+	switch TransportOf(s) {
+	case TransportNATS:
+		return "NATS"
+	case TransportKafka:
+		return "Kafka"
+	default:
+		return "RabbitMQ"
+	}
+}
+
+// registerCheckStmt generates health.Register("<name>", health.<Check>(<dep>))
+func registerCheckStmt(name, check string, dep ast.Expr) ast.Stmt {
+	return factory.NewExprStmt(factory.NewSelectorCall(HealthPackageName, "Register",
+		factory.NewBasicLit(name),
+		factory.NewSelectorCall(HealthPackageName, check, dep),
+	))
+}
+
+// registerTransportCheckStmt registers the readiness check of a service's transport connection
+func registerTransportCheckStmt(s *types.Service, conn ast.Expr) ast.Stmt {
+	return registerCheckStmt(TransportOf(s), transportCheckName(s), conn)
+}
+
+// serveAdminStmt generates the goroutine serving the probes of a service without
+// HTTP routes, along with the endpoints of middlewares (e.g. metrics.Endpoint)
+func serveAdminStmt(middlewares ...ast.Expr) ast.Stmt {
+	return &ast.GoStmt{Call: factory.NewCall(factory.NewFuncLit(
+		factory.NewFuncType(factory.NewFieldList(), factory.NewFieldList()),
+		factory.NewBodyStmt(
+			withInit(
+				factory.NewIfError(logError("admin server stopped", errAttr())),
+				factory.NewDefine("err", factory.NewSelectorCall(HealthPackageName, "Serve",
+					append([]ast.Expr{factory.NewSelector(HealthPackageName, "AdminAddr")}, middlewares...)...,
+				)),
+			),
+		),
+	))}
+}
+
+// probesStmt generates mux.Use(health.Probes), answering the probes before any other middleware
+func probesStmt() ast.Stmt {
+	return factory.NewExprStmt(factory.NewSelectorCall("mux", "Use", factory.NewSelector(HealthPackageName, "Probes")))
+}
@@ -0,0 +1,664 @@
+package defaults
+
+import (
+	"go/ast"
+	"go/token"
+
+	"github.com/flaviogonzalez/instant-layer/internal/factory"
+	"github.com/flaviogonzalez/instant-layer/internal/types"
+)
+
+// kafkaTopic generates exchange + "." + name: bindings map to exchange-prefixed Kafka topics
+func kafkaTopic(exchange ast.Expr, name ast.Expr) ast.Expr {
+	return &ast.BinaryExpr{
+		X:  &ast.BinaryExpr{X: exchange, Op: token.ADD, Y: factory.NewBasicLit(".")},
+		Op: token.ADD,
+		Y:  name,
+	}
+}
+
+// kafkaWriter generates the writer publishing to any topic of the cluster behind conn
+func kafkaWriter(conn ast.Expr) ast.Expr {
+	return factory.NewAddressOf(factory.NewCompositeLit(
+		factory.NewSelector("kafka", "Writer"),
+		factory.NewKeyValue("Addr", factory.NewCall(&ast.SelectorExpr{X: conn, Sel: ast.NewIdent("RemoteAddr")})),
+		factory.NewKeyValue("Balancer", factory.NewAddressOf(factory.NewCompositeLit(factory.NewSelector("kafka", "LeastBytes")))),
+		factory.NewKeyValue("AllowAutoTopicCreation", ast.NewIdent("true")),
+		// Writes return once every in-sync replica has the message
+		factory.NewKeyValue("RequiredAcks", factory.NewSelector("kafka", "RequireAll")),
+	))
+}
+
+// kafkaCorrelationID generates correlationID(msg), the correlation ID header of a Kafka message
+func kafkaCorrelationID() ast.Expr {
+	return factory.NewCall(ast.NewIdent("correlationID"), ast.NewIdent("msg"))
+}
+
+// kafkaBrokerEventFile generates event.go for a Kafka broker service
+func kafkaBrokerEventFile(t transport) *types.File {
+	imports := factory.NewImportDecl(append([]*ast.ImportSpec{
+		factory.NewImport("encoding/json", ""),
+		factory.NewImport("log/slog", ""),
+		factory.NewImport("math", ""),
+		factory.NewImport("net/http", ""),
+		factory.NewImport("time", ""),
+	}, t.imports...)...)
+
+	// func SendToListener(w http.ResponseWriter, exchange string, topicPayload TopicPayload) error
+	sendToListenerFunc := factory.NewFuncDecl(
+		"SendToListener",
+		factory.NewFieldList(),
+		factory.NewFuncType(
+			factory.NewFieldList(
+				factory.NewField("w", factory.NewSelector("http", "ResponseWriter")),
+				factory.NewField("exchange", ast.NewIdent("string")),
+				factory.NewField("topicPayload", ast.NewIdent("TopicPayload")),
+			),
+			factory.NewFieldList(factory.NewField("", ast.NewIdent("error"))),
+		),
+		factory.NewBodyStmt(
+			factory.NewDefine("conn", factory.NewCall(ast.NewIdent(t.connect))),
+			&ast.DeferStmt{Call: factory.NewSelectorCall("conn", "Close")},
+			factory.NewDefine("e", factory.NewCall(ast.NewIdent("NewEmitter"), ast.NewIdent("conn"), ast.NewIdent("exchange"))),
+			&ast.DeferStmt{Call: factory.NewSelectorCall("e", "Close")},
+			factory.NewReturn(factory.NewSelectorCall("e", "Push", ast.NewIdent("w"), ast.NewIdent("topicPayload"))),
+		),
+	)
+
+	return &types.File{
+		Name: "event.go",
+		Content: factory.NewFileNode("event",
+			imports,
+			// CorrelationIDHeader identifies the request an event was emitted for
+			factory.NewConstDecl("CorrelationIDHeader", factory.NewBasicLit("Correlation-Id")),
+			eventPayloadDecl(),
+			topicPayloadDecl(),
+			addressDecl(t),
+			connectFunc(t),
+			sendToListenerFunc,
+		),
+	}
+}
+
+// kafkaBrokerEmitterFile generates emitter.go for a Kafka broker service.
+// Kafka has no request/reply: requests are acknowledged with 202 Accepted.
+func kafkaBrokerEmitterFile(t transport) *types.File {
+	imports := factory.NewImportDecl(append([]*ast.ImportSpec{
+		factory.NewImport("context", ""),
+		factory.NewImport("encoding/json", ""),
+		factory.NewImport("log/slog", ""),
+		factory.NewImport("net/http", ""),
+		factory.NewImport("github.com/google/uuid", ""),
+	}, t.imports...)...)
+
+	// type Emitter struct
+	emitterStruct := factory.NewTypeStruct("Emitter", factory.NewFieldList(
+		factory.NewField("writer", &ast.StarExpr{X: factory.NewSelector("kafka", "Writer")}),
+		factory.NewField("exchange", ast.NewIdent("string")),
+		factory.NewField("id", factory.NewSelector("uuid", "UUID")),
+	))
+
+	// func NewEmitter(conn *kafka.Conn, exchange string) *Emitter
+	newEmitterFunc := factory.NewFuncDecl(
+		"NewEmitter",
+		factory.NewFieldList(),
+		factory.NewFuncType(
+			factory.NewFieldList(
+				factory.NewField("conn", t.connType),
+				factory.NewField("exchange", ast.NewIdent("string")),
+			),
+			factory.NewFieldList(factory.NewField("", &ast.StarExpr{X: ast.NewIdent("Emitter")})),
+		),
+		factory.NewBodyStmt(
+			factory.NewReturn(factory.NewAddressOf(factory.NewCompositeLit(
+				ast.NewIdent("Emitter"),
+				factory.NewKeyValue("writer", kafkaWriter(ast.NewIdent("conn"))),
+				factory.NewKeyValue("exchange", ast.NewIdent("exchange")),
+				factory.NewKeyValue("id", factory.NewSelectorCall("uuid", "New")),
+			))),
+		),
+	)
+
+	recv := factory.NewFieldList(factory.NewField("e", &ast.StarExpr{X: ast.NewIdent("Emitter")}))
+
+	// func (e *Emitter) Close() error
+	closeFunc := factory.NewFuncDecl(
+		"Close",
+		recv,
+		factory.NewFuncType(factory.NewFieldList(), factory.NewFieldList(factory.NewField("", ast.NewIdent("error")))),
+		factory.NewBodyStmt(
+			factory.NewReturn(factory.NewSelectorCall("e", "writer.Close")),
+		),
+	)
+
+	// func (e *Emitter) Push(w http.ResponseWriter, topicPayload TopicPayload) error
+	pushFunc := factory.NewFuncDecl(
+		"Push",
+		recv,
+		factory.NewFuncType(
+			factory.NewFieldList(
+				factory.NewField("w", factory.NewSelector("http", "ResponseWriter")),
+				factory.NewField("topicPayload", ast.NewIdent("TopicPayload")),
+			),
+			factory.NewFieldList(factory.NewField("", ast.NewIdent("error"))),
+		),
+		factory.NewBodyStmt(
+			logCall("slog", "Debug", "publishing event",
+				attr("event", factory.NewSelector("topicPayload", "Name")),
+				attr("correlation_id", factory.NewSelectorCall("e", "id.String")),
+			),
+			factory.NewDefineExpectsError("jsonBytes",
+				factory.NewSelectorCall("json", "Marshal", factory.NewSelector("topicPayload", "Event")),
+			),
+			factory.NewIfError(factory.NewReturn(ast.NewIdent("err"))),
+			factory.NewAssignExpectsError(
+				factory.NewSelectorCall("e", "writer.WriteMessages",
+					factory.NewSelectorCall("context", "Background"),
+					factory.NewCompositeLit(factory.NewSelector("kafka", "Message"),
+						factory.NewKeyValue("Topic", kafkaTopic(factory.NewSelector("e", "exchange"), factory.NewSelector("topicPayload", "Name"))),
+						factory.NewKeyValue("Value", ast.NewIdent("jsonBytes")),
+						factory.NewKeyValue("Headers", factory.NewCompositeLit(
+							&ast.ArrayType{Elt: factory.NewSelector("kafka", "Header")},
+							factory.NewCompositeLit(nil,
+								factory.NewKeyValue("Key", ast.NewIdent("CorrelationIDHeader")),
+								factory.NewKeyValue("Value", factory.NewCall(
+									&ast.ArrayType{Elt: ast.NewIdent("byte")},
+									factory.NewSelectorCall("e", "id.String"),
+								)),
+							),
+						)),
+					),
+				),
+			),
+			factory.NewIfError(factory.NewReturn(ast.NewIdent("err"))),
+			factory.NewReturn(factory.NewSelectorCall("e", "SendResponse", ast.NewIdent("w"))),
+		),
+	)
+
+	// func (e *Emitter) SendResponse(w http.ResponseWriter) error
+	sendResponseFunc := factory.NewFuncDecl(
+		"SendResponse",
+		recv,
+		factory.NewFuncType(
+			factory.NewFieldList(factory.NewField("w", factory.NewSelector("http", "ResponseWriter"))),
+			factory.NewFieldList(factory.NewField("", ast.NewIdent("error"))),
+		),
+		factory.NewBodyStmt(
+			factory.NewExprStmt(factory.NewCall(
+				factory.NewSelector("w", "Header().Set"),
+				factory.NewBasicLit("Content-Type"),
+				factory.NewBasicLit("application/json"),
+			)),
+			factory.NewExprStmt(factory.NewSelectorCall("w", "WriteHeader", factory.NewSelector("http", "StatusAccepted"))),
+			factory.NewReturn(factory.NewCall(
+				factory.NewSelector("json", "NewEncoder(w).Encode"),
+				factory.NewCompositeLit(
+					&ast.MapType{Key: ast.NewIdent("string"), Value: ast.NewIdent("string")},
+					&ast.KeyValueExpr{
+						Key:   factory.NewBasicLit("correlation_id"),
+						Value: factory.NewSelectorCall("e", "id.String"),
+					},
+				),
+			)),
+		),
+	)
+
+	// func (e *Emitter) Publish(ctx context.Context, topic string, payload []byte) error
+	publishFunc := publishFuncDecl(
+		factory.NewReturn(factory.NewSelectorCall("e", "writer.WriteMessages",
+			ast.NewIdent("ctx"),
+			factory.NewCompositeLit(factory.NewSelector("kafka", "Message"),
+				factory.NewKeyValue("Topic", kafkaTopic(factory.NewSelector("e", "exchange"), ast.NewIdent("topic"))),
+				factory.NewKeyValue("Value", ast.NewIdent("payload")),
+			),
+		)),
+	)
+
+	return &types.File{
+		Name: "emitter.go",
+		Content: factory.NewFileNode("event",
+			imports,
+			emitterStruct,
+			newEmitterFunc,
+			closeFunc,
+			pushFunc,
+			sendResponseFunc,
+			publishFunc,
+		),
+	}
+}
+
+// kafkaListenerEventFile generates event.go for a Kafka listener service
+func kafkaListenerEventFile(t transport) *types.File {
+	imports := factory.NewImportDecl(append([]*ast.ImportSpec{
+		factory.NewImport("encoding/json", ""),
+		factory.NewImport("log/slog", ""),
+		factory.NewImport("math", ""),
+		factory.NewImport("time", ""),
+	}, t.imports...)...)
+
+	// func (c *Consumer) handlePayload(payload EventPayload, msg kafka.Message)
+	handlePayloadFunc := factory.NewFuncDecl(
+		"handlePayload",
+		factory.NewFieldList(factory.NewField("c", &ast.StarExpr{X: ast.NewIdent("Consumer")})),
+		factory.NewFuncType(
+			factory.NewFieldList(
+				factory.NewField("payload", ast.NewIdent("EventPayload")),
+				factory.NewField("msg", factory.NewSelector("kafka", "Message")),
+			),
+			factory.NewFieldList(),
+		),
+		factory.NewBodyStmt(
+			deliveryLogger(factory.NewSelector("payload", "Name"), kafkaCorrelationID()),
+			&ast.AssignStmt{
+				Lhs: []ast.Expr{ast.NewIdent("function"), ast.NewIdent("ok")},
+				Tok: token.DEFINE,
+				Rhs: []ast.Expr{&ast.IndexExpr{
+					X:     factory.NewSelector("c", "handlers"),
+					Index: factory.NewSelector("payload", "Name"),
+				}},
+			},
+			factory.NewIf(&ast.UnaryExpr{Op: token.NOT, X: ast.NewIdent("ok")},
+				logCall("logger", "Warn", "no handler for event, moving it to the dead-letter queue"),
+				factory.NewExprStmt(factory.NewSelectorCall("c", "deadLetter", ast.NewIdent("msg"))),
+				&ast.ReturnStmt{},
+			),
+			logCall("logger", "Info", "handling event"),
+			// Kafka has no replies: the handler's response is dropped
+			factory.NewDefineExpectsError("_",
+				factory.NewCall(ast.NewIdent("function"), factory.NewSelector("payload", "Data")),
+			),
+			factory.NewIfError(
+				logCall("logger", "Error", "event handler failed", errAttr()),
+				factory.NewExprStmt(factory.NewSelectorCall("c", "retry", ast.NewIdent("msg"))),
+				&ast.ReturnStmt{},
+			),
+			logCall("logger", "Info", "event handled"),
+		),
+	)
+
+	// func correlationID(msg kafka.Message) string
+	correlationIDFunc := factory.NewFuncDecl(
+		"correlationID",
+		factory.NewFieldList(),
+		factory.NewFuncType(
+			factory.NewFieldList(factory.NewField("msg", factory.NewSelector("kafka", "Message"))),
+			factory.NewFieldList(factory.NewField("", ast.NewIdent("string"))),
+		),
+		factory.NewBodyStmt(
+			&ast.RangeStmt{
+				Key:   ast.NewIdent("_"),
+				Value: ast.NewIdent("h"),
+				Tok:   token.DEFINE,
+				X:     factory.NewSelector("msg", "Headers"),
+				Body: factory.NewBodyStmt(
+					factory.NewIf(
+						&ast.BinaryExpr{X: factory.NewSelector("h", "Key"), Op: token.EQL, Y: ast.NewIdent("CorrelationIDHeader")},
+						factory.NewReturn(factory.NewCall(ast.NewIdent("string"), factory.NewSelector("h", "Value"))),
+					),
+				),
+			},
+			factory.NewReturn(factory.NewBasicLit("")),
+		),
+	)
+
+	return &types.File{
+		Name: "event.go",
+		Content: factory.NewFileNode("event",
+			imports,
+			// CorrelationIDHeader identifies the request an event was emitted for
+			factory.NewConstDecl("CorrelationIDHeader", factory.NewBasicLit("Correlation-Id")),
+			eventPayloadDecl(),
+			addressDecl(t),
+			connectFunc(t),
+			handlePayloadFunc,
+			correlationIDFunc,
+		),
+	}
+}
+
+// kafkaConsumerFile generates consumer.go for a Kafka listener service
+func kafkaConsumerFile(t transport) *types.File {
+	imports := factory.NewImportDecl(append([]*ast.ImportSpec{
+		factory.NewImport("context", ""),
+		factory.NewImport("encoding/json", ""),
+		factory.NewImport("log/slog", ""),
+	}, t.imports...)...)
+
+	// type Consumer struct
+	consumerStruct := factory.NewTypeStruct("Consumer", factory.NewFieldList(
+		factory.NewField("conn", t.connType),
+		factory.NewField("exchange", ast.NewIdent("string")),
+		factory.NewField("handlers", ast.NewIdent("Handlers")),
+		factory.NewField("writer", &ast.StarExpr{X: factory.NewSelector("kafka", "Writer")}),
+	))
+
+	// func NewConsumer(conn *kafka.Conn, exchange string, handlers Handlers) *Consumer
+	newConsumerFunc := factory.NewFuncDecl(
+		"NewConsumer",
+		factory.NewFieldList(),
+		factory.NewFuncType(
+			factory.NewFieldList(
+				factory.NewField("conn", t.connType),
+				factory.NewField("exchange", ast.NewIdent("string")),
+				factory.NewField("handlers", ast.NewIdent("Handlers")),
+			),
+			factory.NewFieldList(factory.NewField("", &ast.StarExpr{X: ast.NewIdent("Consumer")})),
+		),
+		factory.NewBodyStmt(
+			factory.NewReturn(factory.NewAddressOf(factory.NewCompositeLit(
+				ast.NewIdent("Consumer"),
+				factory.NewKeyValue("conn", ast.NewIdent("conn")),
+				factory.NewKeyValue("exchange", ast.NewIdent("exchange")),
+				factory.NewKeyValue("handlers", ast.NewIdent("handlers")),
+				factory.NewKeyValue("writer", kafkaWriter(ast.NewIdent("conn"))),
+			))),
+		),
+	)
+
+	topicConfig := func(topic ast.Expr) ast.Expr {
+		return factory.NewCompositeLit(factory.NewSelector("kafka", "TopicConfig"),
+			factory.NewKeyValue("Topic", topic),
+			factory.NewKeyValue("NumPartitions", factory.NewBasicLitInt(1)),
+			factory.NewKeyValue("ReplicationFactor", factory.NewBasicLitInt(1)),
+		)
+	}
+
+	// func (c *Consumer) Setup() error
+	setupFunc := factory.NewFuncDecl(
+		"Setup",
+		factory.NewFieldList(factory.NewField("c", &ast.StarExpr{X: ast.NewIdent("Consumer")})),
+		factory.NewFuncType(factory.NewFieldList(), factory.NewFieldList(factory.NewField("", ast.NewIdent("error")))),
+		factory.NewBodyStmt(
+			factory.NewReturn(factory.NewSelectorCall("c", "conn.CreateTopics", topicConfig(ast.NewIdent("DeadLetterQueue")))),
+		),
+	)
+
+	errReturn := factory.NewIfError(factory.NewReturn(ast.NewIdent("err")))
+
+	// func (c *Consumer) Listen(topics []string) error
+	listenFunc := factory.NewFuncDecl(
+		"Listen",
+		factory.NewFieldList(factory.NewField("c", &ast.StarExpr{X: ast.NewIdent("Consumer")})),
+		factory.NewFuncType(
+			factory.NewFieldList(factory.NewField("topics", &ast.ArrayType{Elt: ast.NewIdent("string")})),
+			factory.NewFieldList(factory.NewField("", ast.NewIdent("error"))),
+		),
+		factory.NewBodyStmt(
+			factory.NewVarStmt("names", &ast.ArrayType{Elt: ast.NewIdent("string")}),
+			factory.NewVarStmt("configs", &ast.ArrayType{Elt: factory.NewSelector("kafka", "TopicConfig")}),
+			&ast.RangeStmt{
+				Key:   ast.NewIdent("_"),
+				Value: ast.NewIdent("topic"),
+				Tok:   token.DEFINE,
+				X:     ast.NewIdent("topics"),
+				Body: factory.NewBodyStmt(
+					factory.NewDefine("name", kafkaTopic(factory.NewSelector("c", "exchange"), ast.NewIdent("topic"))),
+					factory.NewAssign(ast.NewIdent("names"), factory.NewCall(ast.NewIdent("append"), ast.NewIdent("names"), ast.NewIdent("name"))),
+					factory.NewAssign(ast.NewIdent("configs"), factory.NewCall(ast.NewIdent("append"), ast.NewIdent("configs"), topicConfig(ast.NewIdent("name")))),
+				),
+			},
+			// Topics must exist before the consumer group subscribes to them
+			factory.NewDefine("err", &ast.CallExpr{
+				Fun:      factory.NewSelector("c", "conn.CreateTopics"),
+				Args:     []ast.Expr{ast.NewIdent("configs")},
+				Ellipsis: 1,
+			}),
+			errReturn,
+			// Queue names the consumer group, so the listener resumes where it stopped
+			factory.NewDefine("reader", factory.NewSelectorCall("kafka", "NewReader",
+				factory.NewCompositeLit(factory.NewSelector("kafka", "ReaderConfig"),
+					factory.NewKeyValue("Brokers", factory.NewCompositeLit(
+						&ast.ArrayType{Elt: ast.NewIdent("string")},
+						factory.NewSelectorCall("c", "conn.RemoteAddr().String"),
+					)),
+					factory.NewKeyValue("GroupID", ast.NewIdent("Queue")),
+					factory.NewKeyValue("GroupTopics", ast.NewIdent("names")),
+				),
+			)),
+			&ast.DeferStmt{Call: factory.NewSelectorCall("reader", "Close")},
+			logInfo("listening for messages", attr("topics", ast.NewIdent("names"))),
+			factory.NewDefine("ctx", factory.NewSelectorCall("context", "Background")),
+			&ast.ForStmt{
+				Body: factory.NewBodyStmt(
+					factory.NewDefineExpectsError("msg", factory.NewSelectorCall("reader", "FetchMessage", ast.NewIdent("ctx"))),
+					errReturn,
+					factory.NewVarStmt("eventPayload", ast.NewIdent("EventPayload")),
+					// Malformed messages can't succeed on retry: dead-letter them
+					&ast.IfStmt{
+						Init: factory.NewDefine("err", factory.NewSelectorCall("json", "Unmarshal",
+							factory.NewSelector("msg", "Value"),
+							factory.NewAddressOf(ast.NewIdent("eventPayload")),
+						)),
+						Cond: &ast.BinaryExpr{X: ast.NewIdent("err"), Op: token.NEQ, Y: ast.NewIdent("nil")},
+						Body: factory.NewBodyStmt(
+							logWarn("malformed message, moving it to the dead-letter queue",
+								attr("correlation_id", kafkaCorrelationID()),
+								errAttr(),
+							),
+							factory.NewExprStmt(factory.NewSelectorCall("c", "deadLetter", ast.NewIdent("msg"))),
+						),
+						Else: factory.NewBodyStmt(
+							// handlePayload handles, retries or dead-letters the message
+							factory.NewExprStmt(factory.NewSelectorCall("c", "handlePayload",
+								ast.NewIdent("eventPayload"),
+								ast.NewIdent("msg"),
+							)),
+						),
+					},
+					// The message is settled either way: commit its offset
+					factory.NewAssign(ast.NewIdent("err"), factory.NewSelectorCall("reader", "CommitMessages", ast.NewIdent("ctx"), ast.NewIdent("msg"))),
+					errReturn,
+				),
+			},
+		),
+	)
+
+	return &types.File{
+		Name: "consumer.go",
+		Content: factory.NewFileNode("event",
+			imports,
+			handlerTypeDecl(),
+			consumerStruct,
+			newConsumerFunc,
+			setupFunc,
+			listenFunc,
+		),
+	}
+}
+
+// kafkaRetryFile generates retry.go for a Kafka listener: failed messages are
+// republished to their topic with an incremented retry count, then dead-lettered
+func kafkaRetryFile(t transport) *types.File {
+	imports := factory.NewImportDecl(append([]*ast.ImportSpec{
+		factory.NewImport("context", ""),
+		factory.NewImport("log/slog", ""),
+		factory.NewImport("strconv", ""),
+		factory.NewImport("strings", ""),
+		factory.NewImport("time", ""),
+	}, t.imports...)...)
+
+	headers := &ast.GenDecl{
+		Tok:    token.CONST,
+		Lparen: 1,
+		Specs: []ast.Spec{
+			&ast.ValueSpec{
+				Names:  []*ast.Ident{ast.NewIdent("RetryCountHeader")},
+				Values: []ast.Expr{factory.NewBasicLit("x-retry-count")},
+			},
+			&ast.ValueSpec{
+				Names:  []*ast.Ident{ast.NewIdent("OriginalTopicHeader")},
+				Values: []ast.Expr{factory.NewBasicLit("x-original-topic")},
+			},
+		},
+	}
+
+	msgParams := factory.NewFieldList(factory.NewField("msg", factory.NewSelector("kafka", "Message")))
+	headersType := &ast.ArrayType{Elt: factory.NewSelector("kafka", "Header")}
+
+	// func retryCount(msg kafka.Message) int
+	retryCountFunc := factory.NewFuncDecl(
+		"retryCount",
+		factory.NewFieldList(),
+		factory.NewFuncType(msgParams, factory.NewFieldList(factory.NewField("", ast.NewIdent("int")))),
+		factory.NewBodyStmt(
+			&ast.RangeStmt{
+				Key:   ast.NewIdent("_"),
+				Value: ast.NewIdent("h"),
+				Tok:   token.DEFINE,
+				X:     factory.NewSelector("msg", "Headers"),
+				Body: factory.NewBodyStmt(
+					factory.NewIf(
+						&ast.BinaryExpr{X: factory.NewSelector("h", "Key"), Op: token.EQL, Y: ast.NewIdent("RetryCountHeader")},
+						&ast.AssignStmt{
+							Lhs: []ast.Expr{ast.NewIdent("count"), ast.NewIdent("_")},
+							Tok: token.DEFINE,
+							Rhs: []ast.Expr{factory.NewSelectorCall("strconv", "Atoi",
+								factory.NewCall(ast.NewIdent("string"), factory.NewSelector("h", "Value")),
+							)},
+						},
+						factory.NewReturn(ast.NewIdent("count")),
+					),
+				),
+			},
+			factory.NewReturn(factory.NewBasicLitInt(0)),
+		),
+	)
+
+	// func withHeader(headers []kafka.Header, key, value string) []kafka.Header
+	withHeaderFunc := factory.NewFuncDecl(
+		"withHeader",
+		factory.NewFieldList(),
+		factory.NewFuncType(
+			factory.NewFieldList(
+				factory.NewField("headers", headersType),
+				&ast.Field{
+					Names: []*ast.Ident{ast.NewIdent("key"), ast.NewIdent("value")},
+					Type:  ast.NewIdent("string"),
+				},
+			),
+			factory.NewFieldList(factory.NewField("", headersType)),
+		),
+		factory.NewBodyStmt(
+			factory.NewVarStmt("result", headersType),
+			&ast.RangeStmt{
+				Key:   ast.NewIdent("_"),
+				Value: ast.NewIdent("h"),
+				Tok:   token.DEFINE,
+				X:     ast.NewIdent("headers"),
+				Body: factory.NewBodyStmt(
+					factory.NewIf(
+						&ast.BinaryExpr{X: factory.NewSelector("h", "Key"), Op: token.NEQ, Y: ast.NewIdent("key")},
+						factory.NewAssign(ast.NewIdent("result"), factory.NewCall(ast.NewIdent("append"), ast.NewIdent("result"), ast.NewIdent("h"))),
+					),
+				),
+			},
+			factory.NewReturn(factory.NewCall(ast.NewIdent("append"),
+				ast.NewIdent("result"),
+				factory.NewCompositeLit(factory.NewSelector("kafka", "Header"),
+					factory.NewKeyValue("Key", ast.NewIdent("key")),
+					factory.NewKeyValue("Value", factory.NewCall(&ast.ArrayType{Elt: ast.NewIdent("byte")}, ast.NewIdent("value"))),
+				),
+			)),
+		),
+	)
+
+	recv := factory.NewFieldList(factory.NewField("c", &ast.StarExpr{X: ast.NewIdent("Consumer")}))
+
+	// func (c *Consumer) retry(msg kafka.Message)
+	retryFunc := factory.NewFuncDecl(
+		"retry",
+		recv,
+		factory.NewFuncType(msgParams, factory.NewFieldList()),
+		factory.NewBodyStmt(
+			factory.NewDefine("key", factory.NewSelectorCall("strings", "TrimPrefix",
+				factory.NewSelector("msg", "Topic"),
+				&ast.BinaryExpr{X: factory.NewSelector("c", "exchange"), Op: token.ADD, Y: factory.NewBasicLit(".")},
+			)),
+			factory.NewDefine("policy", &ast.IndexExpr{X: ast.NewIdent("RetryPolicies"), Index: ast.NewIdent("key")}),
+			factory.NewDefine("count", factory.NewCall(ast.NewIdent("retryCount"), ast.NewIdent("msg"))),
+			factory.NewIf(
+				&ast.BinaryExpr{X: ast.NewIdent("count"), Op: token.GEQ, Y: factory.NewSelector("policy", "MaxRetries")},
+				logWarn("retries exhausted, moving message to the dead-letter queue",
+					attr("event", ast.NewIdent("key")),
+					attr("correlation_id", kafkaCorrelationID()),
+					attr("retries", ast.NewIdent("count")),
+				),
+				factory.NewExprStmt(factory.NewSelectorCall("c", "deadLetter", ast.NewIdent("msg"))),
+				&ast.ReturnStmt{},
+			),
+			logInfo("retrying event",
+				attr("event", ast.NewIdent("key")),
+				attr("correlation_id", kafkaCorrelationID()),
+				attr("attempt", &ast.BinaryExpr{X: ast.NewIdent("count"), Op: token.ADD, Y: factory.NewBasicLitInt(1)}),
+				attr("max_retries", factory.NewSelector("policy", "MaxRetries")),
+				attr("delay", factory.NewSelector("policy", "Delay")),
+			),
+			// Retries hold the partition for the retry delay: keep delays short
+			factory.NewExprStmt(factory.NewSelectorCall("time", "Sleep", factory.NewSelector("policy", "Delay"))),
+			factory.NewDefine("err", factory.NewSelectorCall("c", "writer.WriteMessages",
+				factory.NewSelectorCall("context", "Background"),
+				factory.NewCompositeLit(factory.NewSelector("kafka", "Message"),
+					factory.NewKeyValue("Topic", factory.NewSelector("msg", "Topic")),
+					factory.NewKeyValue("Key", factory.NewSelector("msg", "Key")),
+					factory.NewKeyValue("Value", factory.NewSelector("msg", "Value")),
+					factory.NewKeyValue("Headers", factory.NewCall(ast.NewIdent("withHeader"),
+						factory.NewSelector("msg", "Headers"),
+						ast.NewIdent("RetryCountHeader"),
+						factory.NewSelectorCall("strconv", "Itoa", &ast.BinaryExpr{X: ast.NewIdent("count"), Op: token.ADD, Y: factory.NewBasicLitInt(1)}),
+					)),
+				),
+			)),
+			factory.NewIfError(
+				logError("failed to schedule retry",
+					attr("event", ast.NewIdent("key")),
+					attr("correlation_id", kafkaCorrelationID()),
+					errAttr(),
+				),
+				factory.NewExprStmt(factory.NewSelectorCall("c", "deadLetter", ast.NewIdent("msg"))),
+			),
+		),
+	)
+
+	// func (c *Consumer) deadLetter(msg kafka.Message)
+	deadLetterFunc := factory.NewFuncDecl(
+		"deadLetter",
+		recv,
+		factory.NewFuncType(msgParams, factory.NewFieldList()),
+		factory.NewBodyStmt(
+			factory.NewDefine("err", factory.NewSelectorCall("c", "writer.WriteMessages",
+				factory.NewSelectorCall("context", "Background"),
+				factory.NewCompositeLit(factory.NewSelector("kafka", "Message"),
+					factory.NewKeyValue("Topic", ast.NewIdent("DeadLetterQueue")),
+					factory.NewKeyValue("Key", factory.NewSelector("msg", "Key")),
+					factory.NewKeyValue("Value", factory.NewSelector("msg", "Value")),
+					factory.NewKeyValue("Headers", factory.NewCall(ast.NewIdent("withHeader"),
+						factory.NewSelector("msg", "Headers"),
+						ast.NewIdent("OriginalTopicHeader"),
+						factory.NewSelector("msg", "Topic"),
+					)),
+				),
+			)),
+			factory.NewIfError(
+				logError("failed to dead-letter message",
+					attr("topic", factory.NewSelector("msg", "Topic")),
+					attr("correlation_id", kafkaCorrelationID()),
+					errAttr(),
+				),
+			),
+		),
+	)
+
+	return &types.File{
+		Name: "retry.go",
+		Content: factory.NewFileNode("event",
+			imports,
+			headers,
+			retryPolicyDecl(),
+			retryCountFunc,
+			withHeaderFunc,
+			retryFunc,
+			deadLetterFunc,
+		),
+	}
+}
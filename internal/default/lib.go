@@ -0,0 +1,18 @@
+package defaults
+
+import "strings"
+
+// LibsDir is the root directory of the project's shared library modules
+const LibsDir = "libs"
+
+// LibModule returns the module path (and directory) of a shared library,
+// e.g. money -> libs/money
+func LibModule(name string) string {
+	return LibsDir + "/" + name
+}
+
+// LibPackageName returns the Go package name of a shared library
+// (http-utils -> httputils)
+func LibPackageName(name string) string {
+	return strings.ReplaceAll(name, "-", "")
+}
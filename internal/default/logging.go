@@ -0,0 +1,231 @@
+package defaults
+
+import (
+	"go/ast"
+	"go/token"
+
+	"github.com/flaviogonzalez/instant-layer/internal/factory"
+	"github.com/flaviogonzalez/instant-layer/internal/types"
+)
+
+// LoggerPackageName is the package every template imports to set up log/slog
+const LoggerPackageName = "logger"
+
+// logAttr is a key/value pair of a generated slog call
+type logAttr struct {
+	key   string
+	value ast.Expr
+}
+
+// attr builds a logAttr
+func attr(key string, value ast.Expr) logAttr {
+	return logAttr{key: key, value: value}
+}
+
+// errAttr is the "err" attribute carrying the err variable in scope
+func errAttr() logAttr {
+	return attr("err", ast.NewIdent("err"))
+}
+
+// logCall generates <logger>.<level>(msg, key, value, ...): logger is slog for
+// the default logger or the name of a *slog.Logger in scope
+func logCall(logger, level, msg string, attrs ...logAttr) ast.Stmt {
+	args := []ast.Expr{factory.NewBasicLit(msg)}
+	for _, a := range attrs {
+		args = append(args, factory.NewBasicLit(a.key), a.value)
+	}
+	return factory.NewExprStmt(factory.NewSelectorCall(logger, level, args...))
+}
+
+// logInfo, logWarn and logError log through the default slog logger
+func logInfo(msg string, attrs ...logAttr) ast.Stmt {
+	return logCall("slog", "Info", msg, attrs...)
+}
+
+func logWarn(msg string, attrs ...logAttr) ast.Stmt {
+	return logCall("slog", "Warn", msg, attrs...)
+}
+
+func logError(msg string, attrs ...logAttr) ast.Stmt {
+	return logCall("slog", "Error", msg, attrs...)
+}
+
+// logFatal replaces log.Fatal: an error record followed by os.Exit(1).
+// Files using it import "os" besides "log/slog".
+func logFatal(msg string, attrs ...logAttr) []ast.Stmt {
+	return []ast.Stmt{
+		logError(msg, attrs...),
+		factory.NewExprStmt(factory.NewSelectorCall("os", "Exit", factory.NewBasicLitInt(1))),
+	}
+}
+
+// loggerSetupStmt generates logger.Setup("<service>", env.LogLevel, env.LogFormat),
+// run by every main right after loading the environment
+func loggerSetupStmt(s *types.Service) ast.Stmt {
+	return factory.NewExprStmt(factory.NewSelectorCall(LoggerPackageName, "Setup",
+		factory.NewBasicLit(s.Name),
+		factory.NewSelector("env", "LogLevel"),
+		factory.NewSelector("env", "LogFormat"),
+	))
+}
+
+// deliveryLogger generates logger := slog.With("event", <event>, "correlation_id", <id>),
+// scoping every record of a delivery to its event and correlation ID
+func deliveryLogger(event, correlationID ast.Expr) ast.Stmt {
+	return factory.NewDefine("logger", factory.NewSelectorCall("slog", "With",
+		factory.NewBasicLit("event"), event,
+		factory.NewBasicLit("correlation_id"), correlationID,
+	))
+}
+
+// LoggerPackage generates the logger package: Setup installs a JSON or text
+// slog handler from the LOG_FORMAT and LOG_LEVEL of config.Env. HTTP services also get the
+// Requests middleware, which logs one record per request.
+func LoggerPackage(requests bool) *types.Package {
+	files := []*types.File{loggerSetupFile()}
+	if requests {
+		files = append(files, loggerMiddlewareFile())
+	}
+	return &types.Package{Name: LoggerPackageName, Files: files}
+}
+
+// loggerSetupFile generates logger.go with Setup
+func loggerSetupFile() *types.File {
+	imports := factory.NewImportDecl(
+		factory.NewImport("log/slog", ""),
+		factory.NewImport("os", ""),
+		factory.NewImport("strings", ""),
+	)
+
+	// func Setup(service, level, format string) *slog.Logger
+	setupFunc := factory.NewFuncDecl(
+		"Setup",
+		factory.NewFieldList(),
+		factory.NewFuncType(
+			factory.NewFieldList(&ast.Field{
+				Names: []*ast.Ident{ast.NewIdent("service"), ast.NewIdent("level"), ast.NewIdent("format")},
+				Type:  ast.NewIdent("string"),
+			}),
+			factory.NewFieldList(factory.NewField("", &ast.StarExpr{X: factory.NewSelector("slog", "Logger")})),
+		),
+		factory.NewBodyStmt(
+			factory.NewVarStmt("minLevel", factory.NewSelector("slog", "Level")),
+			// Unset or unknown levels fall back to info
+			&ast.IfStmt{
+				Init: factory.NewDefine("err", factory.NewSelectorCall("minLevel", "UnmarshalText",
+					factory.NewCall(&ast.ArrayType{Elt: ast.NewIdent("byte")}, ast.NewIdent("level")),
+				)),
+				Cond: &ast.BinaryExpr{X: ast.NewIdent("err"), Op: token.NEQ, Y: ast.NewIdent("nil")},
+				Body: factory.NewBodyStmt(
+					factory.NewAssign(ast.NewIdent("minLevel"), factory.NewSelector("slog", "LevelInfo")),
+				),
+			},
+			factory.NewDefine("options", factory.NewAddressOf(factory.NewCompositeLit(
+				factory.NewSelector("slog", "HandlerOptions"),
+				factory.NewKeyValue("Level", ast.NewIdent("minLevel")),
+			))),
+			&ast.DeclStmt{Decl: &ast.GenDecl{
+				Tok: token.VAR,
+				Specs: []ast.Spec{&ast.ValueSpec{
+					Names: []*ast.Ident{ast.NewIdent("handler")},
+					Type:  factory.NewSelector("slog", "Handler"),
+					Values: []ast.Expr{factory.NewSelectorCall("slog", "NewJSONHandler",
+						factory.NewSelector("os", "Stdout"),
+						ast.NewIdent("options"),
+					)},
+				}},
+			}},
+			factory.NewIf(
+				factory.NewSelectorCall("strings", "EqualFold", ast.NewIdent("format"), factory.NewBasicLit("text")),
+				factory.NewAssign(ast.NewIdent("handler"), factory.NewSelectorCall("slog", "NewTextHandler",
+					factory.NewSelector("os", "Stdout"),
+					ast.NewIdent("options"),
+				)),
+			),
+			factory.NewDefine("logger", factory.NewCall(
+				&ast.SelectorExpr{
+					X:   factory.NewSelectorCall("slog", "New", ast.NewIdent("handler")),
+					Sel: ast.NewIdent("With"),
+				},
+				factory.NewBasicLit("service"),
+				ast.NewIdent("service"),
+			)),
+			factory.NewExprStmt(factory.NewSelectorCall("slog", "SetDefault", ast.NewIdent("logger"))),
+			factory.NewReturn(ast.NewIdent("logger")),
+		),
+	)
+
+	return &types.File{
+		Name:    "logger.go",
+		Content: factory.NewFileNode(LoggerPackageName, imports, setupFunc),
+	}
+}
+
+// loggerMiddlewareFile generates middleware.go with Requests, which replaces
+// chi's middleware.Logger: one record per request with its ID, status and latency
+func loggerMiddlewareFile() *types.File {
+	imports := factory.NewImportDecl(
+		factory.NewImport("log/slog", ""),
+		factory.NewImport("net/http", ""),
+		factory.NewImport("time", ""),
+		factory.NewImport("github.com/go-chi/chi/v5/middleware", ""),
+	)
+
+	handlerFunc := factory.NewFuncLit(
+		factory.NewFuncType(
+			factory.NewFieldList(
+				factory.NewField("w", factory.NewSelector("http", "ResponseWriter")),
+				factory.NewField("r", &ast.StarExpr{X: factory.NewSelector("http", "Request")}),
+			),
+			factory.NewFieldList(),
+		),
+		factory.NewBodyStmt(
+			factory.NewDefine("start", factory.NewSelectorCall("time", "Now")),
+			factory.NewDefine("ww", factory.NewSelectorCall("middleware", "NewWrapResponseWriter",
+				ast.NewIdent("w"),
+				factory.NewSelector("r", "ProtoMajor"),
+			)),
+			factory.NewExprStmt(factory.NewSelectorCall("next", "ServeHTTP", ast.NewIdent("ww"), ast.NewIdent("r"))),
+			// Handlers that never call WriteHeader answer 200
+			factory.NewDefine("status", factory.NewSelectorCall("ww", "Status")),
+			factory.NewIf(
+				&ast.BinaryExpr{X: ast.NewIdent("status"), Op: token.EQL, Y: factory.NewBasicLitInt(0)},
+				factory.NewAssign(ast.NewIdent("status"), factory.NewSelector("http", "StatusOK")),
+			),
+			factory.NewDefine("level", factory.NewSelector("slog", "LevelInfo")),
+			factory.NewIf(
+				&ast.BinaryExpr{X: ast.NewIdent("status"), Op: token.GEQ, Y: factory.NewSelector("http", "StatusInternalServerError")},
+				factory.NewAssign(ast.NewIdent("level"), factory.NewSelector("slog", "LevelError")),
+			),
+			factory.NewExprStmt(factory.NewSelectorCall("slog", "Log",
+				factory.NewSelectorCall("r", "Context"),
+				ast.NewIdent("level"),
+				factory.NewBasicLit("request"),
+				factory.NewBasicLit("request_id"), factory.NewSelectorCall("middleware", "GetReqID", factory.NewSelectorCall("r", "Context")),
+				factory.NewBasicLit("method"), factory.NewSelector("r", "Method"),
+				factory.NewBasicLit("path"), factory.NewSelector("r", "URL.Path"),
+				factory.NewBasicLit("status"), ast.NewIdent("status"),
+				factory.NewBasicLit("bytes"), factory.NewSelectorCall("ww", "BytesWritten"),
+				factory.NewBasicLit("latency"), factory.NewSelectorCall("time", "Since", ast.NewIdent("start")),
+			)),
+		),
+	)
+
+	// func Requests(next http.Handler) http.Handler
+	requestsFunc := factory.NewFuncDecl(
+		"Requests",
+		factory.NewFieldList(),
+		factory.NewFuncType(
+			factory.NewFieldList(factory.NewField("next", factory.NewSelector("http", "Handler"))),
+			factory.NewFieldList(factory.NewField("", factory.NewSelector("http", "Handler"))),
+		),
+		factory.NewBodyStmt(
+			factory.NewReturn(factory.NewCall(factory.NewSelector("http", "HandlerFunc"), handlerFunc)),
+		),
+	)
+
+	return &types.File{
+		Name:    "middleware.go",
+		Content: factory.NewFileNode(LoggerPackageName, imports, requestsFunc),
+	}
+}
@@ -0,0 +1,227 @@
+package defaults
+
+import (
+	"go/ast"
+	"go/token"
+
+	"github.com/flaviogonzalez/instant-layer/internal/factory"
+	"github.com/flaviogonzalez/instant-layer/internal/types"
+)
+
+// DefaultExchange is the exchange brokers and listeners share unless configured otherwise
+const DefaultExchange = "logs_topic"
+
+// DefaultMaxRetries and DefaultRetryDelay (milliseconds) are the retry policy of
+// bindings that don't set their own, including the topics of typed events
+const (
+	DefaultMaxRetries = 3
+	DefaultRetryDelay = 5000
+)
+
+// ExchangeTypes are the RabbitMQ exchange kinds accepted in the messaging config
+var ExchangeTypes = []string{"topic", "direct", "fanout", "headers"}
+
+// DefaultMessaging returns the messaging config used when none is provided
+func DefaultMessaging() *types.Messaging {
+	return &types.Messaging{
+		Exchange:     DefaultExchange,
+		ExchangeType: "topic",
+		Durable:      true,
+		Bindings: []*types.Binding{
+			NewBinding("log.INFO"),
+			NewBinding("log.WARNING"),
+			NewBinding("log.ERROR"),
+		},
+	}
+}
+
+// WithMessaging sets the exchange, queue and bindings of a broker or listener service
+func WithMessaging(m *types.Messaging) Option {
+	return func(s *types.Service) {
+		s.Messaging = m
+	}
+}
+
+// NewBinding returns a binding for topic with the default retry policy
+func NewBinding(topic string) *types.Binding {
+	return &types.Binding{
+		Topic:      topic,
+		MaxRetries: DefaultMaxRetries,
+		RetryDelay: DefaultRetryDelay,
+	}
+}
+
+// MessagingBindings returns the configured bindings followed by the topics
+// of the service's event contracts, without duplicates
+func MessagingBindings(s *types.Service) []*types.Binding {
+	var bindings []*types.Binding
+	seen := make(map[string]bool)
+
+	add := func(b *types.Binding) {
+		if b.Topic != "" && !seen[b.Topic] {
+			seen[b.Topic] = true
+			bindings = append(bindings, b)
+		}
+	}
+
+	if s.Messaging != nil {
+		for _, b := range s.Messaging.Bindings {
+			add(b)
+		}
+	}
+	for _, ev := range s.Events {
+		add(NewBinding(eventTopic(ev)))
+	}
+
+	return bindings
+}
+
+// deadLetterName returns the base name of the dead-letter exchange and queue of a listener
+func deadLetterName(s *types.Service, m *types.Messaging) string {
+	if m.Queue != "" {
+		return m.Queue
+	}
+	if s.Name != "" {
+		return s.Name
+	}
+	return "listener"
+}
+
+// BrokerMessagingFile generates messaging.go for a broker service: the exchange it publishes to
+func BrokerMessagingFile(s *types.Service) *types.File {
+	m := s.Messaging
+	if m == nil {
+		m = DefaultMessaging()
+	}
+
+	var decls []ast.Decl
+	switch TransportOf(s) {
+	case TransportNATS:
+		decls = []ast.Decl{
+			factory.NewImportDecl(factory.NewImport("github.com/nats-io/nats.go/jetstream", "")),
+			factory.NewConstDecl("Exchange", factory.NewBasicLit(m.Exchange)),
+			factory.NewConstDecl("Stream", factory.NewBasicLit(natsStreamName(m.Exchange))),
+			factory.NewConstDecl("Storage", natsStorage(m)),
+		}
+	case TransportKafka:
+		decls = []ast.Decl{
+			factory.NewConstDecl("Exchange", factory.NewBasicLit(m.Exchange)),
+		}
+	default:
+		decls = []ast.Decl{
+			factory.NewConstDecl("Exchange", factory.NewBasicLit(m.Exchange)),
+			factory.NewConstDecl("ExchangeType", factory.NewBasicLit(m.ExchangeType)),
+			factory.NewConstDecl("Durable", ast.NewIdent(boolIdent(m.Durable))),
+		}
+	}
+
+	return &types.File{
+		Name:    "messaging.go",
+		Content: factory.NewFileNode("event", decls...),
+	}
+}
+
+// ListenerMessagingFile generates messaging.go for a listener service: the exchange,
+// queue and routing keys it consumes from, and the retry policy of each routing key
+func ListenerMessagingFile(s *types.Service) *types.File {
+	m := s.Messaging
+	if m == nil {
+		m = DefaultMessaging()
+	}
+
+	transport := TransportOf(s)
+	bindings := MessagingBindings(&types.Service{Messaging: m, Events: s.Events})
+	deadLetter := deadLetterName(s, m)
+
+	var topics []string
+	var policies []ast.Expr
+	for _, b := range bindings {
+		topic := b.Topic
+		if transport == TransportNATS {
+			topic = natsSubject(topic)
+		}
+		topics = append(topics, topic)
+		// "topic": {MaxRetries: 3, Delay: 5000 * time.Millisecond}
+		policies = append(policies, &ast.KeyValueExpr{
+			Key: factory.NewBasicLit(topic),
+			Value: factory.NewCompositeLit(nil,
+				factory.NewKeyValue("MaxRetries", factory.NewBasicLitInt(b.MaxRetries)),
+				factory.NewKeyValue("Delay", &ast.BinaryExpr{
+					X:  factory.NewBasicLitInt(b.RetryDelay),
+					Op: token.MUL,
+					Y:  factory.NewSelector("time", "Millisecond"),
+				}),
+			),
+		})
+	}
+
+	// var Bindings = []string{...}
+	bindingsVar := factory.NewVarDecl("Bindings", nil)
+	bindingsVar.Specs[0].(*ast.ValueSpec).Values = []ast.Expr{
+		factory.NewStringSliceLit(topics...),
+	}
+
+	// var RetryPolicies = map[string]RetryPolicy{...}
+	policiesVar := factory.NewVarDecl("RetryPolicies", nil)
+	policiesVar.Specs[0].(*ast.ValueSpec).Values = []ast.Expr{
+		factory.NewCompositeLit(
+			&ast.MapType{Key: ast.NewIdent("string"), Value: ast.NewIdent("RetryPolicy")},
+			policies...,
+		),
+	}
+
+	var importSpecs []*ast.ImportSpec
+	if len(policies) > 0 {
+		importSpecs = append(importSpecs, factory.NewImport("time", ""))
+	}
+
+	// NATS consumers and Kafka consumer groups need a name: fall back to the dead-letter base
+	var consts []ast.Decl
+	switch transport {
+	case TransportNATS:
+		importSpecs = append(importSpecs, factory.NewImport("github.com/nats-io/nats.go/jetstream", ""))
+		consts = []ast.Decl{
+			factory.NewConstDecl("Exchange", factory.NewBasicLit(m.Exchange)),
+			factory.NewConstDecl("Stream", factory.NewBasicLit(natsStreamName(m.Exchange))),
+			factory.NewConstDecl("Storage", natsStorage(m)),
+			factory.NewConstDecl("Queue", factory.NewBasicLit(deadLetter)),
+			factory.NewConstDecl("DeadLetterQueue", factory.NewBasicLit(deadLetter+".dlq")),
+			factory.NewConstDecl("DeadLetterStream", factory.NewBasicLit(natsStreamName(deadLetter+"_dlq"))),
+		}
+	case TransportKafka:
+		consts = []ast.Decl{
+			factory.NewConstDecl("Exchange", factory.NewBasicLit(m.Exchange)),
+			factory.NewConstDecl("Queue", factory.NewBasicLit(deadLetter)),
+			factory.NewConstDecl("DeadLetterQueue", factory.NewBasicLit(deadLetter+".dlq")),
+		}
+	default:
+		consts = []ast.Decl{
+			factory.NewConstDecl("Exchange", factory.NewBasicLit(m.Exchange)),
+			factory.NewConstDecl("ExchangeType", factory.NewBasicLit(m.ExchangeType)),
+			factory.NewConstDecl("Queue", factory.NewBasicLit(m.Queue)),
+			factory.NewConstDecl("Durable", ast.NewIdent(boolIdent(m.Durable))),
+			factory.NewConstDecl("DeadLetterExchange", factory.NewBasicLit(deadLetter+".dlx")),
+			factory.NewConstDecl("DeadLetterQueue", factory.NewBasicLit(deadLetter+".dlq")),
+		}
+	}
+
+	var decls []ast.Decl
+	if len(importSpecs) > 0 {
+		decls = append(decls, factory.NewImportDecl(importSpecs...))
+	}
+	decls = append(decls, consts...)
+	decls = append(decls, bindingsVar, policiesVar)
+
+	return &types.File{
+		Name:    "messaging.go",
+		Content: factory.NewFileNode("event", decls...),
+	}
+}
+
+// boolIdent returns the Go identifier for a boolean value
+func boolIdent(b bool) string {
+	if b {
+		return "true"
+	}
+	return "false"
+}
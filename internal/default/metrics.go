@@ -0,0 +1,493 @@
+package defaults
+
+import (
+	"go/ast"
+	"go/token"
+	"strings"
+
+	"github.com/flaviogonzalez/instant-layer/internal/factory"
+	"github.com/flaviogonzalez/instant-layer/internal/types"
+)
+
+// MetricsPackageName is the package exposing Prometheus metrics
+const MetricsPackageName = "metrics"
+
+// MetricsPath is where every instrumented service serves its metrics
+const MetricsPath = "/metrics"
+
+// WithMetrics instruments a service with Prometheus: /metrics, request count and
+// latency per route for HTTP templates, pool stats for the database and
+// published/consumed/failed/ack-latency metrics for the event package.
+// gRPC services have no HTTP server to serve /metrics on and ignore it.
+func WithMetrics() Option {
+	return func(s *types.Service) {
+		s.Metrics = true
+	}
+}
+
+// WithMetricsPackage adds the metrics package, with the request middleware for HTTP templates
+func WithMetricsPackage(requests bool) Option {
+	return func(s *types.Service) {
+		s.Packages = append(s.Packages, MetricsPackage(s, requests))
+	}
+}
+
+// MetricsPackage generates the metrics package: metrics.go with the /metrics
+// endpoint, http.go with the request middleware and db.go with the pool collector
+func MetricsPackage(s *types.Service, requests bool) *types.Package {
+	files := []*types.File{metricsEndpointFile()}
+	if requests {
+		files = append(files, metricsRequestsFile())
+	}
+	if s.DB != nil {
+		files = append(files, metricsDBFile())
+	}
+	return &types.Package{Name: MetricsPackageName, Files: files}
+}
+
+// metricsEndpointFile generates metrics.go with Endpoint, which answers
+// GET /metrics from the default registry (Go runtime and process collectors included)
+func metricsEndpointFile() *types.File {
+	imports := factory.NewImportDecl(
+		factory.NewImport("net/http", ""),
+		factory.NewImport("github.com/prometheus/client_golang/prometheus/promhttp", ""),
+	)
+
+	handlerParams := factory.NewFieldList(
+		factory.NewField("w", factory.NewSelector("http", "ResponseWriter")),
+		factory.NewField("r", &ast.StarExpr{X: factory.NewSelector("http", "Request")}),
+	)
+
+	// func Endpoint(next http.Handler) http.Handler
+	endpointFunc := factory.NewFuncDecl(
+		"Endpoint",
+		factory.NewFieldList(),
+		factory.NewFuncType(
+			factory.NewFieldList(factory.NewField("next", factory.NewSelector("http", "Handler"))),
+			factory.NewFieldList(factory.NewField("", factory.NewSelector("http", "Handler"))),
+		),
+		factory.NewBodyStmt(
+			factory.NewDefine("handler", factory.NewSelectorCall("promhttp", "Handler")),
+			factory.NewReturn(factory.NewCall(factory.NewSelector("http", "HandlerFunc"), factory.NewFuncLit(
+				factory.NewFuncType(handlerParams, factory.NewFieldList()),
+				factory.NewBodyStmt(
+					factory.NewIf(
+						&ast.BinaryExpr{
+							X:  &ast.BinaryExpr{X: factory.NewSelector("r", "Method"), Op: token.EQL, Y: factory.NewSelector("http", "MethodGet")},
+							Op: token.LAND,
+							Y:  &ast.BinaryExpr{X: factory.NewSelector("r", "URL.Path"), Op: token.EQL, Y: ast.NewIdent("Path")},
+						},
+						factory.NewExprStmt(factory.NewSelectorCall("handler", "ServeHTTP", ast.NewIdent("w"), ast.NewIdent("r"))),
+						&ast.ReturnStmt{},
+					),
+					factory.NewExprStmt(factory.NewSelectorCall("next", "ServeHTTP", ast.NewIdent("w"), ast.NewIdent("r"))),
+				),
+			))),
+		),
+	)
+
+	return &types.File{
+		Name: "metrics.go",
+		Content: factory.NewFileNode(MetricsPackageName,
+			imports,
+			factory.NewConstDecl("Path", factory.NewBasicLit(MetricsPath)),
+			endpointFunc,
+		),
+	}
+}
+
+// metricsRequestsFile generates http.go with Requests, which counts requests and
+// observes their latency per chi route pattern, keeping label cardinality bounded
+func metricsRequestsFile() *types.File {
+	imports := factory.NewImportDecl(
+		factory.NewImport("net/http", ""),
+		factory.NewImport("strconv", ""),
+		factory.NewImport("time", ""),
+		factory.NewImport("github.com/go-chi/chi/v5", ""),
+		factory.NewImport("github.com/go-chi/chi/v5/middleware", ""),
+		factory.NewImport("github.com/prometheus/client_golang/prometheus", ""),
+		factory.NewImport("github.com/prometheus/client_golang/prometheus/promauto", ""),
+	)
+
+	collectors := &ast.GenDecl{
+		Tok:    token.VAR,
+		Lparen: 1,
+		Specs: []ast.Spec{
+			collectorSpec("requests", "NewCounterVec", "CounterOpts", "http_requests_total",
+				"HTTP requests by route pattern, method and status.", "route", "method", "status"),
+			collectorSpec("latency", "NewHistogramVec", "HistogramOpts", "http_request_duration_seconds",
+				"HTTP request latency by route pattern and method.", "route", "method"),
+		},
+	}
+
+	handlerFunc := factory.NewFuncLit(
+		factory.NewFuncType(
+			factory.NewFieldList(
+				factory.NewField("w", factory.NewSelector("http", "ResponseWriter")),
+				factory.NewField("r", &ast.StarExpr{X: factory.NewSelector("http", "Request")}),
+			),
+			factory.NewFieldList(),
+		),
+		factory.NewBodyStmt(
+			factory.NewDefine("start", factory.NewSelectorCall("time", "Now")),
+			factory.NewDefine("ww", factory.NewSelectorCall("middleware", "NewWrapResponseWriter",
+				ast.NewIdent("w"),
+				factory.NewSelector("r", "ProtoMajor"),
+			)),
+			factory.NewExprStmt(factory.NewSelectorCall("next", "ServeHTTP", ast.NewIdent("ww"), ast.NewIdent("r"))),
+			// The pattern is only known once chi has routed the request
+			factory.NewDefine("route", factory.NewBasicLit("unmatched")),
+			&ast.IfStmt{
+				Init: factory.NewDefine("rctx", factory.NewSelectorCall("chi", "RouteContext", factory.NewSelectorCall("r", "Context"))),
+				Cond: &ast.BinaryExpr{
+					X:  &ast.BinaryExpr{X: ast.NewIdent("rctx"), Op: token.NEQ, Y: ast.NewIdent("nil")},
+					Op: token.LAND,
+					Y:  &ast.BinaryExpr{X: factory.NewSelectorCall("rctx", "RoutePattern"), Op: token.NEQ, Y: factory.NewBasicLit("")},
+				},
+				Body: factory.NewBodyStmt(
+					factory.NewAssign(ast.NewIdent("route"), factory.NewSelectorCall("rctx", "RoutePattern")),
+				),
+			},
+			factory.NewDefine("status", factory.NewSelectorCall("ww", "Status")),
+			factory.NewIf(
+				&ast.BinaryExpr{X: ast.NewIdent("status"), Op: token.EQL, Y: factory.NewBasicLitInt(0)},
+				factory.NewAssign(ast.NewIdent("status"), factory.NewSelector("http", "StatusOK")),
+			),
+			factory.NewExprStmt(factory.NewCall(
+				&ast.SelectorExpr{
+					X: factory.NewSelectorCall("requests", "WithLabelValues",
+						ast.NewIdent("route"),
+						factory.NewSelector("r", "Method"),
+						factory.NewSelectorCall("strconv", "Itoa", ast.NewIdent("status")),
+					),
+					Sel: ast.NewIdent("Inc"),
+				},
+			)),
+			factory.NewExprStmt(factory.NewCall(
+				&ast.SelectorExpr{
+					X:   factory.NewSelectorCall("latency", "WithLabelValues", ast.NewIdent("route"), factory.NewSelector("r", "Method")),
+					Sel: ast.NewIdent("Observe"),
+				},
+				sinceStart(),
+			)),
+		),
+	)
+
+	// func Requests(next http.Handler) http.Handler
+	requestsFunc := factory.NewFuncDecl(
+		"Requests",
+		factory.NewFieldList(),
+		factory.NewFuncType(
+			factory.NewFieldList(factory.NewField("next", factory.NewSelector("http", "Handler"))),
+			factory.NewFieldList(factory.NewField("", factory.NewSelector("http", "Handler"))),
+		),
+		factory.NewBodyStmt(
+			factory.NewReturn(factory.NewCall(factory.NewSelector("http", "HandlerFunc"), handlerFunc)),
+		),
+	)
+
+	return &types.File{
+		Name:    "http.go",
+		Content: factory.NewFileNode(MetricsPackageName, imports, collectors, requestsFunc),
+	}
+}
+
+// metricsDBFile generates db.go with RegisterDB, exporting the sql.DB pool stats
+func metricsDBFile() *types.File {
+	imports := factory.NewImportDecl(
+		factory.NewImport("database/sql", ""),
+		factory.NewImport("github.com/prometheus/client_golang/prometheus", ""),
+		factory.NewImport("github.com/prometheus/client_golang/prometheus/collectors", ""),
+	)
+
+	// func RegisterDB(db *sql.DB, name string)
+	registerFunc := factory.NewFuncDecl(
+		"RegisterDB",
+		factory.NewFieldList(),
+		factory.NewFuncType(
+			factory.NewFieldList(
+				factory.NewField("db", &ast.StarExpr{X: factory.NewSelector("sql", "DB")}),
+				factory.NewField("name", ast.NewIdent("string")),
+			),
+			factory.NewFieldList(),
+		),
+		factory.NewBodyStmt(
+			factory.NewIf(&ast.BinaryExpr{X: ast.NewIdent("db"), Op: token.EQL, Y: ast.NewIdent("nil")}, &ast.ReturnStmt{}),
+			factory.NewExprStmt(factory.NewSelectorCall("prometheus", "MustRegister",
+				factory.NewSelectorCall("collectors", "NewDBStatsCollector", ast.NewIdent("db"), ast.NewIdent("name")),
+			)),
+		),
+	)
+
+	return &types.File{
+		Name:    "db.go",
+		Content: factory.NewFileNode(MetricsPackageName, imports, registerFunc),
+	}
+}
+
+// collectorSpec generates <name> = promauto.<constructor>(prometheus.<opts>{Name, Help}, []string{labels...}),
+// histograms using the default buckets
+func collectorSpec(name, constructor, opts, metric, help string, labels ...string) *ast.ValueSpec {
+	elts := []ast.Expr{
+		factory.NewKeyValue("Name", factory.NewBasicLit(metric)),
+		factory.NewKeyValue("Help", factory.NewBasicLit(help)),
+	}
+	if opts == "HistogramOpts" {
+		elts = append(elts, factory.NewKeyValue("Buckets", factory.NewSelector("prometheus", "DefBuckets")))
+	}
+	return &ast.ValueSpec{
+		Names: []*ast.Ident{ast.NewIdent(name)},
+		Values: []ast.Expr{factory.NewSelectorCall("promauto", constructor,
+			factory.NewCompositeLit(factory.NewSelector("prometheus", opts), elts...),
+			factory.NewStringSliceLit(labels...),
+		)},
+	}
+}
+
+// brokerMetricsFile generates event/metrics.go for brokers and outbox services:
+// SendToListener and Emitter.Publish become wrappers counting published and
+// failed events and observing the time until each publish was acknowledged
+// (the listener's reply for SendToListener, the broker confirm for Publish)
+func brokerMetricsFile(files []*types.File) *types.File {
+	decls := []ast.Decl{
+		factory.NewImportDecl(
+			factory.NewImport("context", ""),
+			factory.NewImport("net/http", ""),
+			factory.NewImport("time", ""),
+			factory.NewImport("github.com/prometheus/client_golang/prometheus", ""),
+			factory.NewImport("github.com/prometheus/client_golang/prometheus/promauto", ""),
+		),
+		&ast.GenDecl{
+			Tok:    token.VAR,
+			Lparen: 1,
+			Specs: []ast.Spec{
+				collectorSpec("published", "NewCounterVec", "CounterOpts", "events_published_total",
+					"Events published, by event name.", "event"),
+				collectorSpec("failed", "NewCounterVec", "CounterOpts", "events_failed_total",
+					"Events that could not be published, by event name.", "event"),
+				collectorSpec("ackLatency", "NewHistogramVec", "HistogramOpts", "events_ack_latency_seconds",
+					"Time from publishing an event to its acknowledgement, by event name.", "event"),
+			},
+		},
+		// func observePublish(event string, start time.Time, err error) error
+		factory.NewFuncDecl(
+			"observePublish",
+			factory.NewFieldList(),
+			factory.NewFuncType(
+				factory.NewFieldList(
+					factory.NewField("event", ast.NewIdent("string")),
+					factory.NewField("start", factory.NewSelector("time", "Time")),
+					factory.NewField("err", ast.NewIdent("error")),
+				),
+				factory.NewFieldList(factory.NewField("", ast.NewIdent("error"))),
+			),
+			factory.NewBodyStmt(
+				factory.NewIfError(
+					incStmt("failed", ast.NewIdent("event")),
+					factory.NewReturn(ast.NewIdent("err")),
+				),
+				incStmt("published", ast.NewIdent("event")),
+				observeSinceStmt("ackLatency", ast.NewIdent("event")),
+				factory.NewReturn(ast.NewIdent("nil")),
+			),
+		),
+	}
+
+	observe := func(event ast.Expr) func(call *ast.CallExpr) []ast.Stmt {
+		return func(call *ast.CallExpr) []ast.Stmt {
+			return []ast.Stmt{
+				factory.NewDefine("start", factory.NewSelectorCall("time", "Now")),
+				factory.NewReturn(factory.NewCall(ast.NewIdent("observePublish"), event, ast.NewIdent("start"), call)),
+			}
+		}
+	}
+	if decl := findFuncDecl(files, "SendToListener"); decl != nil {
+		decls = append(decls, wrapDecl(decl, observe(factory.NewSelector("topicPayload", "Name"))))
+	}
+	if decl := findFuncDecl(files, "Publish"); decl != nil {
+		decls = append(decls, wrapDecl(decl, observe(ast.NewIdent("topic"))))
+	}
+
+	return &types.File{
+		Name:    "metrics.go",
+		Content: factory.NewFileNode("event", decls...),
+	}
+}
+
+// listenerMetricsFile generates event/metrics.go for listeners: NewConsumer
+// becomes a wrapper instrumenting every handler, counting consumed and failed
+// events and observing the time from delivery to the handler's verdict
+func listenerMetricsFile(t transport, files []*types.File) *types.File {
+	handlerType := factory.NewFuncType(
+		factory.NewFieldList(factory.NewField("event", factory.NewSelector("json", "RawMessage"))),
+		factory.NewFieldList(
+			factory.NewField("", &ast.ArrayType{Elt: ast.NewIdent("byte")}),
+			factory.NewField("", ast.NewIdent("error")),
+		),
+	)
+
+	decls := []ast.Decl{
+		// NewConsumer keeps its signature, which takes the transport connection
+		factory.NewImportDecl(append([]*ast.ImportSpec{
+			factory.NewImport("encoding/json", ""),
+			factory.NewImport("time", ""),
+			factory.NewImport("github.com/prometheus/client_golang/prometheus", ""),
+			factory.NewImport("github.com/prometheus/client_golang/prometheus/promauto", ""),
+		}, t.imports...)...),
+		&ast.GenDecl{
+			Tok:    token.VAR,
+			Lparen: 1,
+			Specs: []ast.Spec{
+				collectorSpec("consumed", "NewCounterVec", "CounterOpts", "events_consumed_total",
+					"Events handled, by event name.", "event"),
+				collectorSpec("failed", "NewCounterVec", "CounterOpts", "events_failed_total",
+					"Events whose handler returned an error, by event name.", "event"),
+				collectorSpec("ackLatency", "NewHistogramVec", "HistogramOpts", "events_ack_latency_seconds",
+					"Time from delivering an event to its acknowledgement, by event name.", "event"),
+			},
+		},
+		// func instrument(handlers Handlers) Handlers
+		factory.NewFuncDecl(
+			"instrument",
+			factory.NewFieldList(),
+			factory.NewFuncType(
+				factory.NewFieldList(factory.NewField("handlers", ast.NewIdent("Handlers"))),
+				factory.NewFieldList(factory.NewField("", ast.NewIdent("Handlers"))),
+			),
+			factory.NewBodyStmt(
+				factory.NewDefine("instrumented", factory.NewCall(ast.NewIdent("make"),
+					ast.NewIdent("Handlers"),
+					factory.NewCall(ast.NewIdent("len"), ast.NewIdent("handlers")),
+				)),
+				&ast.RangeStmt{
+					Key:   ast.NewIdent("name"),
+					Value: ast.NewIdent("handle"),
+					Tok:   token.DEFINE,
+					X:     ast.NewIdent("handlers"),
+					Body: factory.NewBodyStmt(
+						factory.NewAssign(
+							&ast.IndexExpr{X: ast.NewIdent("instrumented"), Index: ast.NewIdent("name")},
+							factory.NewFuncLit(handlerType, factory.NewBodyStmt(
+								factory.NewDefine("start", factory.NewSelectorCall("time", "Now")),
+								&ast.AssignStmt{
+									Lhs: []ast.Expr{ast.NewIdent("response"), ast.NewIdent("err")},
+									Tok: token.DEFINE,
+									Rhs: []ast.Expr{factory.NewCall(ast.NewIdent("handle"), ast.NewIdent("event"))},
+								},
+								incStmt("consumed", ast.NewIdent("name")),
+								factory.NewIfError(incStmt("failed", ast.NewIdent("name"))),
+								observeSinceStmt("ackLatency", ast.NewIdent("name")),
+								factory.NewReturn(ast.NewIdent("response"), ast.NewIdent("err")),
+							)),
+						),
+					),
+				},
+				factory.NewReturn(ast.NewIdent("instrumented")),
+			),
+		),
+	}
+
+	if decl := findFuncDecl(files, "NewConsumer"); decl != nil {
+		decls = append(decls, wrapDecl(decl, func(call *ast.CallExpr) []ast.Stmt {
+			for i, arg := range call.Args {
+				if ident, ok := arg.(*ast.Ident); ok && ident.Name == "handlers" {
+					call.Args[i] = factory.NewCall(ast.NewIdent("instrument"), ident)
+				}
+			}
+			return []ast.Stmt{factory.NewReturn(call)}
+		}))
+	}
+
+	return &types.File{
+		Name:    "metrics.go",
+		Content: factory.NewFileNode("event", decls...),
+	}
+}
+
+// findFuncDecl returns the function or method called name among files, nil if none declares it
+func findFuncDecl(files []*types.File, name string) *ast.FuncDecl {
+	for _, f := range files {
+		for _, decl := range f.Content.Decls {
+			if fn, ok := decl.(*ast.FuncDecl); ok && fn.Name.Name == name {
+				return fn
+			}
+		}
+	}
+	return nil
+}
+
+// wrapDecl renames a generated function to its unexported form and returns a
+// function with the original name and signature; body builds its statements
+// around the call forwarding every parameter to the renamed function
+func wrapDecl(decl *ast.FuncDecl, body func(call *ast.CallExpr) []ast.Stmt) *ast.FuncDecl {
+	inner := strings.ToLower(decl.Name.Name[:1]) + decl.Name.Name[1:]
+
+	var args []ast.Expr
+	for _, field := range decl.Type.Params.List {
+		for _, name := range field.Names {
+			args = append(args, ast.NewIdent(name.Name))
+		}
+	}
+	fun := ast.Expr(ast.NewIdent(inner))
+	recv := factory.NewFieldList()
+	if decl.Recv != nil {
+		recv = decl.Recv
+		fun = factory.NewSelector(decl.Recv.List[0].Names[0].Name, inner)
+	}
+
+	wrapper := factory.NewFuncDecl(decl.Name.Name, recv, decl.Type, factory.NewBodyStmt(body(factory.NewCall(fun, args...))...))
+	decl.Name = ast.NewIdent(inner)
+	return wrapper
+}
+
+// incStmt generates <counter>.WithLabelValues(<label>).Inc()
+func incStmt(counter string, label ast.Expr) ast.Stmt {
+	return factory.NewExprStmt(factory.NewCall(&ast.SelectorExpr{
+		X:   factory.NewSelectorCall(counter, "WithLabelValues", label),
+		Sel: ast.NewIdent("Inc"),
+	}))
+}
+
+// observeSinceStmt generates <histogram>.WithLabelValues(<label>).Observe(time.Since(start).Seconds())
+func observeSinceStmt(histogram string, label ast.Expr) ast.Stmt {
+	return factory.NewExprStmt(factory.NewCall(
+		&ast.SelectorExpr{
+			X:   factory.NewSelectorCall(histogram, "WithLabelValues", label),
+			Sel: ast.NewIdent("Observe"),
+		},
+		sinceStart(),
+	))
+}
+
+// sinceStart generates time.Since(start).Seconds()
+func sinceStart() ast.Expr {
+	return factory.NewCall(&ast.SelectorExpr{
+		X:   factory.NewSelectorCall("time", "Since", ast.NewIdent("start")),
+		Sel: ast.NewIdent("Seconds"),
+	})
+}
+
+// metricsStmt generates mux.Use(metrics.Endpoint, metrics.Requests), placed right
+// after the probes so /metrics skips authentication and request logging
+func metricsStmt() ast.Stmt {
+	return factory.NewExprStmt(factory.NewSelectorCall("mux", "Use",
+		factory.NewSelector(MetricsPackageName, "Endpoint"),
+		factory.NewSelector(MetricsPackageName, "Requests"),
+	))
+}
+
+// registerDBStmt generates metrics.RegisterDB(db, "<service>")
+func registerDBStmt(s *types.Service) ast.Stmt {
+	return factory.NewExprStmt(factory.NewSelectorCall(MetricsPackageName, "RegisterDB",
+		ast.NewIdent("db"),
+		factory.NewBasicLit(s.Name),
+	))
+}
+
+// adminMiddlewares returns the middlewares served next to the probes by the admin server
+func adminMiddlewares(s *types.Service) []ast.Expr {
+	if !s.Metrics {
+		return nil
+	}
+	return []ast.Expr{factory.NewSelector(MetricsPackageName, "Endpoint")}
+}
@@ -0,0 +1,243 @@
+package defaults
+
+import (
+	"go/ast"
+	"net/http"
+	"strings"
+
+	"github.com/flaviogonzalez/instant-layer/internal/factory"
+	"github.com/flaviogonzalez/instant-layer/internal/types"
+)
+
+// ModelsPackageName is the package holding the request and response structs of a service
+const ModelsPackageName = "models"
+
+// statusNames are the net/http constants of the status codes handlers write
+var statusNames = map[int]string{
+	http.StatusOK:        "StatusOK",
+	http.StatusCreated:   "StatusCreated",
+	http.StatusAccepted:  "StatusAccepted",
+	http.StatusNoContent: "StatusNoContent",
+}
+
+// WithRoutesConfig sets the routes the routes and handlers packages are generated from
+func WithRoutesConfig(rc *types.RoutesConfig) Option {
+	return func(s *types.Service) {
+		s.RoutesConfig = rc
+	}
+}
+
+// WithModels adds the models package with the structs typed handlers decode and encode
+func WithModels(models ...*types.Model) Option {
+	return func(s *types.Service) {
+		s.Models = models
+		if len(models) > 0 {
+			s.Packages = append(s.Packages, ModelsPackage(models))
+		}
+	}
+}
+
+// WithMiddleware adds the middleware package route groups are wrapped with
+func WithMiddleware() Option {
+	return func(s *types.Service) {
+		s.Packages = append(s.Packages, DefaultMiddlewarePackage())
+	}
+}
+
+// ModelsPackage generates the models package with one file per model
+func ModelsPackage(models []*types.Model) *types.Package {
+	var files []*types.File
+	for _, m := range models {
+		files = append(files, modelFile(m))
+	}
+
+	return &types.Package{
+		Name:  ModelsPackageName,
+		Files: files,
+	}
+}
+
+// modelFile generates the struct of a model, omitempty on its optional fields
+func modelFile(m *types.Model) *types.File {
+	var decls []ast.Decl
+
+	var importSpecs []*ast.ImportSpec
+	seen := make(map[string]bool)
+	for _, f := range m.Fields {
+		path := fieldTypeImport(f.Type)
+		if path != "" && !seen[path] {
+			seen[path] = true
+			importSpecs = append(importSpecs, factory.NewImport(path, ""))
+		}
+	}
+	if len(importSpecs) > 0 {
+		decls = append(decls, factory.NewImportDecl(importSpecs...))
+	}
+
+	var fields []*ast.Field
+	for _, f := range m.Fields {
+		tag := f.JSON
+		if f.Optional {
+			tag += ",omitempty"
+		}
+		fields = append(fields, factory.NewStructField(f.Name, factory.NewTypeExpr(f.Type), `json:"`+tag+`"`))
+	}
+	decls = append(decls, factory.NewStructDecl(m.Name, fields...))
+
+	return &types.File{
+		Name:    SnakeCase(m.Name) + ".go",
+		Content: factory.NewFileNode(ModelsPackageName, decls...),
+	}
+}
+
+// DefaultMiddlewarePackage generates the middleware package imported by routes.go
+// when the service has route groups: a pass-through HandlerWrapper to fill in
+func DefaultMiddlewarePackage() *types.Package {
+	imports := factory.NewImportDecl(
+		factory.NewImport("database/sql", ""),
+		factory.NewImport("net/http", ""),
+	)
+
+	middlewareType := &ast.FuncType{
+		Params:  factory.NewFieldList(factory.NewField("", factory.NewSelector("http", "Handler"))),
+		Results: factory.NewFieldList(factory.NewField("", factory.NewSelector("http", "Handler"))),
+	}
+
+	// func HandlerWrapper(db *sql.DB) func(http.Handler) http.Handler
+	wrapperFunc := factory.NewFuncDecl(
+		"HandlerWrapper",
+		factory.NewFieldList(),
+		factory.NewFuncType(
+			factory.NewFieldList(
+				factory.NewField("db", &ast.StarExpr{X: factory.NewSelector("sql", "DB")}),
+			),
+			factory.NewFieldList(factory.NewField("", middlewareType)),
+		),
+		factory.NewBodyStmt(
+			// return func(next http.Handler) http.Handler { return next }
+			factory.NewReturn(
+				factory.NewFuncLit(
+					factory.NewFuncType(
+						factory.NewFieldList(factory.NewField("next", factory.NewSelector("http", "Handler"))),
+						factory.NewFieldList(factory.NewField("", factory.NewSelector("http", "Handler"))),
+					),
+					factory.NewBodyStmt(factory.NewReturn(ast.NewIdent("next"))),
+				),
+			),
+		),
+	)
+
+	return &types.Package{
+		Name: "middleware",
+		Files: []*types.File{
+			{Name: "middleware.go", Content: factory.NewFileNode("middleware", imports, wrapperFunc)},
+		},
+	}
+}
+
+// typedRoute reports whether a route's handler decodes, encodes or sets a status
+func typedRoute(route *types.Route) bool {
+	return route.Request != "" || route.Response != "" || route.Status != 0
+}
+
+// createTypedHandlerFile creates a handler decoding the route's request body into
+// its Go type and encoding a zero response with the route's status
+func createTypedHandlerFile(s *types.Service, route *types.Route) *types.File {
+	importSpecs := []*ast.ImportSpec{
+		factory.NewImport("net/http", ""),
+	}
+	if route.Request != "" || route.Response != "" {
+		importSpecs = append(importSpecs, factory.NewImport("encoding/json", ""))
+	}
+	seen := make(map[string]bool)
+	for _, typ := range []string{route.Request, route.Response} {
+		path := fieldTypeImport(typ)
+		if strings.Contains(typ, ModelsPackageName+".") {
+			path = s.Name + "/" + ModelsPackageName
+		}
+		if path != "" && !seen[path] {
+			seen[path] = true
+			importSpecs = append(importSpecs, factory.NewImport(path, ""))
+		}
+	}
+
+	var body []ast.Stmt
+
+	if route.Request != "" {
+		// var req {Request}
+		// if err := json.NewDecoder(r.Body).Decode(&req); err != nil { http.Error(...); return }
+		decode := factory.NewIfError(
+			factory.NewExprStmt(factory.NewSelectorCall("http", "Error",
+				ast.NewIdent("w"),
+				factory.NewSelectorCall("err", "Error"),
+				factory.NewSelector("http", "StatusBadRequest"),
+			)),
+			factory.NewReturn(),
+		)
+		decode.Init = factory.NewDefine("err",
+			factory.NewCall(
+				&ast.SelectorExpr{
+					X:   factory.NewSelectorCall("json", "NewDecoder", factory.NewSelector("r", "Body")),
+					Sel: ast.NewIdent("Decode"),
+				},
+				factory.NewAddressOf(ast.NewIdent("req")),
+			),
+		)
+		body = append(body, factory.NewVarStmt("req", factory.NewTypeExpr(route.Request)), decode)
+	}
+
+	if route.Response != "" {
+		// var resp {Response}
+		// w.Header().Set("Content-Type", "application/json")
+		body = append(body,
+			factory.NewVarStmt("resp", factory.NewTypeExpr(route.Response)),
+			factory.NewExprStmt(
+				factory.NewCall(
+					&ast.SelectorExpr{X: factory.NewSelectorCall("w", "Header"), Sel: ast.NewIdent("Set")},
+					factory.NewBasicLit("Content-Type"),
+					factory.NewBasicLit("application/json"),
+				),
+			),
+		)
+	}
+
+	if route.Status != 0 && route.Status != http.StatusOK {
+		// w.WriteHeader(http.Status...)
+		var status ast.Expr = factory.NewBasicLitInt(route.Status)
+		if name, ok := statusNames[route.Status]; ok {
+			status = factory.NewSelector("http", name)
+		}
+		body = append(body, factory.NewExprStmt(factory.NewSelectorCall("w", "WriteHeader", status)))
+	}
+
+	if route.Response != "" {
+		// json.NewEncoder(w).Encode(resp)
+		body = append(body, factory.NewExprStmt(
+			factory.NewCall(
+				&ast.SelectorExpr{
+					X:   factory.NewSelectorCall("json", "NewEncoder", ast.NewIdent("w")),
+					Sel: ast.NewIdent("Encode"),
+				},
+				ast.NewIdent("resp"),
+			),
+		))
+	}
+
+	handlerFunc := factory.NewFuncDecl(
+		route.Handler,
+		factory.NewFieldList(),
+		factory.NewFuncType(
+			factory.NewFieldList(
+				factory.NewField("w", factory.NewSelector("http", "ResponseWriter")),
+				factory.NewField("r", &ast.StarExpr{X: factory.NewSelector("http", "Request")}),
+			),
+			factory.NewFieldList(),
+		),
+		factory.NewBodyStmt(body...),
+	)
+
+	return &types.File{
+		Name:    route.Handler + ".go",
+		Content: factory.NewFileNode("handlers", factory.NewImportDecl(importSpecs...), handlerFunc),
+	}
+}
@@ -0,0 +1,68 @@
+package defaults
+
+import (
+	"strings"
+	"unicode"
+)
+
+// initialisms are kept upper-case when building Go identifiers
+var initialisms = map[string]bool{
+	"id": true, "url": true, "uri": true, "api": true, "http": true,
+	"json": true, "ip": true, "uuid": true, "sql": true, "db": true,
+}
+
+// splitWords splits snake_case, kebab-case, dotted and CamelCase names into lower-case words
+func splitWords(name string) []string {
+	var words []string
+	var current []rune
+
+	flush := func() {
+		if len(current) > 0 {
+			words = append(words, strings.ToLower(string(current)))
+			current = nil
+		}
+	}
+
+	runes := []rune(name)
+	for i, r := range runes {
+		switch {
+		case r == '_' || r == '-' || r == '.' || r == ' ' || r == '/':
+			flush()
+		case unicode.IsUpper(r):
+			// Start a new word on lower->Upper and on the last upper of an acronym (IDToken -> ID, Token)
+			if i > 0 && (unicode.IsLower(runes[i-1]) || unicode.IsDigit(runes[i-1]) ||
+				(i+1 < len(runes) && unicode.IsLower(runes[i+1]) && unicode.IsUpper(runes[i-1]))) {
+				flush()
+			}
+			current = append(current, r)
+		default:
+			current = append(current, r)
+		}
+	}
+	flush()
+
+	return words
+}
+
+// GoName converts a name (user_id, user-id, userId) to an exported Go identifier (UserID)
+func GoName(name string) string {
+	var b strings.Builder
+	for _, w := range splitWords(name) {
+		if initialisms[w] {
+			b.WriteString(strings.ToUpper(w))
+			continue
+		}
+		b.WriteString(strings.ToUpper(w[:1]) + w[1:])
+	}
+	return b.String()
+}
+
+// SnakeCase converts a name (UserID, user-id) to snake_case (user_id)
+func SnakeCase(name string) string {
+	return strings.Join(splitWords(name), "_")
+}
+
+// EventTopic derives the wire name of an event from its Go name (UserCreated -> user.created)
+func EventTopic(name string) string {
+	return strings.Join(splitWords(name), ".")
+}
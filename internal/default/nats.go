@@ -0,0 +1,741 @@
+package defaults
+
+import (
+	"go/ast"
+	"go/token"
+	"regexp"
+	"strings"
+
+	"github.com/flaviogonzalez/instant-layer/internal/factory"
+	"github.com/flaviogonzalez/instant-layer/internal/types"
+)
+
+// streamNameInvalid matches the characters JetStream doesn't allow in stream names
+var streamNameInvalid = regexp.MustCompile(`[^A-Za-z0-9_-]`)
+
+// natsStreamName turns an exchange or queue name into a JetStream stream name
+func natsStreamName(name string) string {
+	return strings.ToUpper(streamNameInvalid.ReplaceAllString(name, "_"))
+}
+
+// natsSubject translates a RabbitMQ style routing key into NATS subject syntax:
+// a trailing "#" becomes ">", any other "#" falls back to "*"
+func natsSubject(topic string) string {
+	words := strings.Split(topic, ".")
+	for i, w := range words {
+		if w == "#" {
+			if i == len(words)-1 {
+				words[i] = ">"
+			} else {
+				words[i] = "*"
+			}
+		}
+	}
+	return strings.Join(words, ".")
+}
+
+// natsStorage returns the JetStream storage matching the durability of the messaging config
+func natsStorage(m *types.Messaging) ast.Expr {
+	if m.Durable {
+		return factory.NewSelector("jetstream", "FileStorage")
+	}
+	return factory.NewSelector("jetstream", "MemoryStorage")
+}
+
+// natsReplyHeaders declares the headers brokers and listeners exchange replies with
+func natsReplyHeaders() *ast.GenDecl {
+	return &ast.GenDecl{
+		Tok:    token.CONST,
+		Lparen: 1,
+		Specs: []ast.Spec{
+			&ast.ValueSpec{
+				Names:  []*ast.Ident{ast.NewIdent("ReplyToHeader")},
+				Values: []ast.Expr{factory.NewBasicLit("Reply-To")},
+			},
+			&ast.ValueSpec{
+				Names:  []*ast.Ident{ast.NewIdent("CorrelationIDHeader")},
+				Values: []ast.Expr{factory.NewBasicLit("Correlation-Id")},
+			},
+		},
+	}
+}
+
+// natsSetupStreamFunc generates setupStream: the stream capturing every subject of the exchange
+func natsSetupStreamFunc() *ast.FuncDecl {
+	return factory.NewFuncDecl(
+		"setupStream",
+		factory.NewFieldList(),
+		factory.NewFuncType(
+			factory.NewFieldList(
+				factory.NewField("ctx", factory.NewSelector("context", "Context")),
+				factory.NewField("js", factory.NewSelector("jetstream", "JetStream")),
+				factory.NewField("exchange", ast.NewIdent("string")),
+			),
+			factory.NewFieldList(factory.NewField("", ast.NewIdent("error"))),
+		),
+		factory.NewBodyStmt(
+			factory.NewDefineExpectsError("_",
+				factory.NewSelectorCall("js", "CreateOrUpdateStream",
+					ast.NewIdent("ctx"),
+					factory.NewCompositeLit(factory.NewSelector("jetstream", "StreamConfig"),
+						factory.NewKeyValue("Name", ast.NewIdent("Stream")),
+						factory.NewKeyValue("Subjects", factory.NewCompositeLit(
+							&ast.ArrayType{Elt: ast.NewIdent("string")},
+							&ast.BinaryExpr{X: ast.NewIdent("exchange"), Op: token.ADD, Y: factory.NewBasicLit(".>")},
+						)),
+						factory.NewKeyValue("Storage", ast.NewIdent("Storage")),
+					),
+				),
+			),
+			factory.NewReturn(ast.NewIdent("err")),
+		),
+	)
+}
+
+// natsJetStream generates js, err := jetstream.New(conn) followed by the error check
+func natsJetStream(conn ast.Expr) []ast.Stmt {
+	return []ast.Stmt{
+		factory.NewDefineExpectsError("js", factory.NewCall(factory.NewSelector("jetstream", "New"), conn)),
+		factory.NewIfError(factory.NewReturn(ast.NewIdent("err"))),
+	}
+}
+
+// natsBrokerEventFile generates event.go for a NATS JetStream broker service
+func natsBrokerEventFile(t transport) *types.File {
+	imports := factory.NewImportDecl(append([]*ast.ImportSpec{
+		factory.NewImport("context", ""),
+		factory.NewImport("encoding/json", ""),
+		factory.NewImport("log/slog", ""),
+		factory.NewImport("math", ""),
+		factory.NewImport("net/http", ""),
+		factory.NewImport("time", ""),
+		factory.NewImport("github.com/nats-io/nats.go/jetstream", ""),
+	}, t.imports...)...)
+
+	// func SendToListener(w http.ResponseWriter, exchange string, topicPayload TopicPayload) error
+	sendToListenerFunc := factory.NewFuncDecl(
+		"SendToListener",
+		factory.NewFieldList(),
+		factory.NewFuncType(
+			factory.NewFieldList(
+				factory.NewField("w", factory.NewSelector("http", "ResponseWriter")),
+				factory.NewField("exchange", ast.NewIdent("string")),
+				factory.NewField("topicPayload", ast.NewIdent("TopicPayload")),
+			),
+			factory.NewFieldList(factory.NewField("", ast.NewIdent("error"))),
+		),
+		factory.NewBodyStmt(
+			factory.NewDefine("conn", factory.NewCall(ast.NewIdent(t.connect))),
+			&ast.DeferStmt{Call: factory.NewSelectorCall("conn", "Close")},
+			factory.NewDefine("e", factory.NewCall(ast.NewIdent("NewEmitter"), ast.NewIdent("conn"), ast.NewIdent("exchange"))),
+			factory.NewReturn(factory.NewSelectorCall("e", "Push", ast.NewIdent("w"), ast.NewIdent("topicPayload"))),
+		),
+	)
+
+	return &types.File{
+		Name: "event.go",
+		Content: factory.NewFileNode("event",
+			imports,
+			natsReplyHeaders(),
+			// ReplyTimeout bounds how long a request waits for the listener's reply
+			factory.NewConstDecl("ReplyTimeout", &ast.BinaryExpr{
+				X:  factory.NewBasicLitInt(30),
+				Op: token.MUL,
+				Y:  factory.NewSelector("time", "Second"),
+			}),
+			eventPayloadDecl(),
+			topicPayloadDecl(),
+			addressDecl(t),
+			connectFunc(t),
+			natsSetupStreamFunc(),
+			sendToListenerFunc,
+		),
+	}
+}
+
+// natsBrokerEmitterFile generates emitter.go for a NATS JetStream broker service
+func natsBrokerEmitterFile(t transport) *types.File {
+	imports := factory.NewImportDecl(append([]*ast.ImportSpec{
+		factory.NewImport("context", ""),
+		factory.NewImport("encoding/json", ""),
+		factory.NewImport("fmt", ""),
+		factory.NewImport("log/slog", ""),
+		factory.NewImport("net/http", ""),
+		factory.NewImport("github.com/google/uuid", ""),
+		factory.NewImport("github.com/nats-io/nats.go/jetstream", ""),
+	}, t.imports...)...)
+
+	// type Emitter struct
+	emitterStruct := factory.NewTypeStruct("Emitter", factory.NewFieldList(
+		factory.NewField("conn", t.connType),
+		factory.NewField("exchange", ast.NewIdent("string")),
+		factory.NewField("id", factory.NewSelector("uuid", "UUID")),
+	))
+
+	// func NewEmitter(conn *nats.Conn, exchange string) *Emitter
+	newEmitterFunc := factory.NewFuncDecl(
+		"NewEmitter",
+		factory.NewFieldList(),
+		factory.NewFuncType(
+			factory.NewFieldList(
+				factory.NewField("conn", t.connType),
+				factory.NewField("exchange", ast.NewIdent("string")),
+			),
+			factory.NewFieldList(factory.NewField("", &ast.StarExpr{X: ast.NewIdent("Emitter")})),
+		),
+		factory.NewBodyStmt(
+			factory.NewReturn(factory.NewAddressOf(factory.NewCompositeLit(
+				ast.NewIdent("Emitter"),
+				factory.NewKeyValue("conn", ast.NewIdent("conn")),
+				factory.NewKeyValue("exchange", ast.NewIdent("exchange")),
+				factory.NewKeyValue("id", factory.NewSelectorCall("uuid", "New")),
+			))),
+		),
+	)
+
+	errReturn := factory.NewIfError(factory.NewReturn(ast.NewIdent("err")))
+
+	// func (e *Emitter) Push(w http.ResponseWriter, topicPayload TopicPayload) error
+	pushFunc := factory.NewFuncDecl(
+		"Push",
+		factory.NewFieldList(factory.NewField("e", &ast.StarExpr{X: ast.NewIdent("Emitter")})),
+		factory.NewFuncType(
+			factory.NewFieldList(
+				factory.NewField("w", factory.NewSelector("http", "ResponseWriter")),
+				factory.NewField("topicPayload", ast.NewIdent("TopicPayload")),
+			),
+			factory.NewFieldList(factory.NewField("", ast.NewIdent("error"))),
+		),
+		factory.NewBodyStmt(append(
+			[]ast.Stmt{
+				// ctx, cancel := context.WithTimeout(context.Background(), ReplyTimeout)
+				&ast.AssignStmt{
+					Lhs: []ast.Expr{ast.NewIdent("ctx"), ast.NewIdent("cancel")},
+					Tok: token.DEFINE,
+					Rhs: []ast.Expr{factory.NewSelectorCall("context", "WithTimeout",
+						factory.NewSelectorCall("context", "Background"),
+						ast.NewIdent("ReplyTimeout"),
+					)},
+				},
+				&ast.DeferStmt{Call: factory.NewCall(ast.NewIdent("cancel"))},
+			},
+			append(natsJetStream(factory.NewSelector("e", "conn")),
+				factory.NewAssignExpectsError(factory.NewCall(ast.NewIdent("setupStream"),
+					ast.NewIdent("ctx"),
+					ast.NewIdent("js"),
+					factory.NewSelector("e", "exchange"),
+				)),
+				errReturn,
+				logCall("slog", "Debug", "publishing event",
+					attr("event", factory.NewSelector("topicPayload", "Name")),
+					attr("correlation_id", factory.NewSelectorCall("e", "id.String")),
+				),
+				factory.NewDefineExpectsError("jsonBytes",
+					factory.NewSelectorCall("json", "Marshal", factory.NewSelector("topicPayload", "Event")),
+				),
+				errReturn,
+				// The listener replies on a private inbox
+				factory.NewDefine("inbox", factory.NewSelectorCall("nats", "NewInbox")),
+				factory.NewDefineExpectsError("sub",
+					factory.NewSelectorCall("e", "conn.SubscribeSync", ast.NewIdent("inbox")),
+				),
+				errReturn,
+				&ast.DeferStmt{Call: factory.NewSelectorCall("sub", "Unsubscribe")},
+				factory.NewDefine("msg", factory.NewSelectorCall("nats", "NewMsg",
+					&ast.BinaryExpr{
+						X: &ast.BinaryExpr{
+							X:  factory.NewSelector("e", "exchange"),
+							Op: token.ADD,
+							Y:  factory.NewBasicLit("."),
+						},
+						Op: token.ADD,
+						Y:  factory.NewSelector("topicPayload", "Name"),
+					},
+				)),
+				factory.NewExprStmt(factory.NewSelectorCall("msg", "Header.Set", ast.NewIdent("ReplyToHeader"), ast.NewIdent("inbox"))),
+				factory.NewExprStmt(factory.NewSelectorCall("msg", "Header.Set",
+					ast.NewIdent("CorrelationIDHeader"),
+					factory.NewSelectorCall("e", "id.String"),
+				)),
+				factory.NewAssign(factory.NewSelector("msg", "Data"), ast.NewIdent("jsonBytes")),
+				&ast.AssignStmt{
+					Lhs: []ast.Expr{ast.NewIdent("_"), ast.NewIdent("err")},
+					Tok: token.ASSIGN,
+					Rhs: []ast.Expr{factory.NewSelectorCall("js", "PublishMsg", ast.NewIdent("ctx"), ast.NewIdent("msg"))},
+				},
+				errReturn,
+				factory.NewReturn(factory.NewSelectorCall("e", "SendResponse", ast.NewIdent("w"), ast.NewIdent("sub"))),
+			)...,
+		)...),
+	)
+
+	// func (e *Emitter) SendResponse(w http.ResponseWriter, sub *nats.Subscription) error
+	sendResponseFunc := factory.NewFuncDecl(
+		"SendResponse",
+		factory.NewFieldList(factory.NewField("e", &ast.StarExpr{X: ast.NewIdent("Emitter")})),
+		factory.NewFuncType(
+			factory.NewFieldList(
+				factory.NewField("w", factory.NewSelector("http", "ResponseWriter")),
+				factory.NewField("sub", &ast.StarExpr{X: factory.NewSelector("nats", "Subscription")}),
+			),
+			factory.NewFieldList(factory.NewField("", ast.NewIdent("error"))),
+		),
+		factory.NewBodyStmt(
+			factory.NewDefineExpectsError("msg", factory.NewSelectorCall("sub", "NextMsg", ast.NewIdent("ReplyTimeout"))),
+			factory.NewIfError(
+				factory.NewExprStmt(factory.NewSelectorCall("http", "Error",
+					ast.NewIdent("w"),
+					factory.NewBasicLit("No response from listener"),
+					factory.NewSelector("http", "StatusGatewayTimeout"),
+				)),
+				factory.NewReturn(factory.NewSelectorCall("fmt", "Errorf",
+					factory.NewBasicLit("failed to receive response: %w"),
+					ast.NewIdent("err"),
+				)),
+			),
+			factory.NewExprStmt(factory.NewCall(
+				factory.NewSelector("w", "Header().Set"),
+				factory.NewBasicLit("Content-Type"),
+				factory.NewBasicLit("application/json"),
+			)),
+			factory.NewExprStmt(factory.NewSelectorCall("w", "WriteHeader", factory.NewSelector("http", "StatusOK"))),
+			&ast.IfStmt{
+				Init: &ast.AssignStmt{
+					Lhs: []ast.Expr{ast.NewIdent("_"), ast.NewIdent("err")},
+					Tok: token.DEFINE,
+					Rhs: []ast.Expr{factory.NewSelectorCall("w", "Write", factory.NewSelector("msg", "Data"))},
+				},
+				Cond: &ast.BinaryExpr{X: ast.NewIdent("err"), Op: token.NEQ, Y: ast.NewIdent("nil")},
+				Body: factory.NewBodyStmt(
+					factory.NewReturn(factory.NewSelectorCall("fmt", "Errorf",
+						factory.NewBasicLit("failed to write HTTP response: %w"),
+						ast.NewIdent("err"),
+					)),
+				),
+			},
+			factory.NewReturn(ast.NewIdent("nil")),
+		),
+	)
+
+	// func (e *Emitter) Publish(ctx context.Context, topic string, payload []byte) error
+	// JetStream acknowledges the publish once the stream has stored it
+	publishFunc := publishFuncDecl(append(
+		natsJetStream(factory.NewSelector("e", "conn")),
+		factory.NewAssignExpectsError(factory.NewCall(ast.NewIdent("setupStream"),
+			ast.NewIdent("ctx"),
+			ast.NewIdent("js"),
+			factory.NewSelector("e", "exchange"),
+		)),
+		errReturn,
+		&ast.AssignStmt{
+			Lhs: []ast.Expr{ast.NewIdent("_"), ast.NewIdent("err")},
+			Tok: token.ASSIGN,
+			Rhs: []ast.Expr{factory.NewSelectorCall("js", "Publish",
+				ast.NewIdent("ctx"),
+				&ast.BinaryExpr{
+					X:  &ast.BinaryExpr{X: factory.NewSelector("e", "exchange"), Op: token.ADD, Y: factory.NewBasicLit(".")},
+					Op: token.ADD,
+					Y:  ast.NewIdent("topic"),
+				},
+				ast.NewIdent("payload"),
+			)},
+		},
+		factory.NewReturn(ast.NewIdent("err")),
+	)...)
+
+	return &types.File{
+		Name: "emitter.go",
+		Content: factory.NewFileNode("event",
+			imports,
+			emitterStruct,
+			newEmitterFunc,
+			pushFunc,
+			sendResponseFunc,
+			publishFunc,
+		),
+	}
+}
+
+// natsCorrelationID generates msg.Headers().Get(CorrelationIDHeader)
+func natsCorrelationID() ast.Expr {
+	return factory.NewCall(factory.NewSelector("msg", "Headers().Get"), ast.NewIdent("CorrelationIDHeader"))
+}
+
+// natsListenerEventFile generates event.go for a NATS JetStream listener service
+func natsListenerEventFile(t transport) *types.File {
+	imports := factory.NewImportDecl(append([]*ast.ImportSpec{
+		factory.NewImport("context", ""),
+		factory.NewImport("encoding/json", ""),
+		factory.NewImport("log/slog", ""),
+		factory.NewImport("math", ""),
+		factory.NewImport("time", ""),
+		factory.NewImport("github.com/nats-io/nats.go/jetstream", ""),
+	}, t.imports...)...)
+
+	// func (c *Consumer) handlePayload(payload EventPayload, msg jetstream.Msg)
+	handlePayloadFunc := factory.NewFuncDecl(
+		"handlePayload",
+		factory.NewFieldList(factory.NewField("c", &ast.StarExpr{X: ast.NewIdent("Consumer")})),
+		factory.NewFuncType(
+			factory.NewFieldList(
+				factory.NewField("payload", ast.NewIdent("EventPayload")),
+				factory.NewField("msg", factory.NewSelector("jetstream", "Msg")),
+			),
+			factory.NewFieldList(),
+		),
+		factory.NewBodyStmt(
+			deliveryLogger(factory.NewSelector("payload", "Name"), natsCorrelationID()),
+			// function, ok := c.handlers[payload.Name]
+			&ast.AssignStmt{
+				Lhs: []ast.Expr{ast.NewIdent("function"), ast.NewIdent("ok")},
+				Tok: token.DEFINE,
+				Rhs: []ast.Expr{&ast.IndexExpr{
+					X:     factory.NewSelector("c", "handlers"),
+					Index: factory.NewSelector("payload", "Name"),
+				}},
+			},
+			factory.NewIf(&ast.UnaryExpr{Op: token.NOT, X: ast.NewIdent("ok")},
+				logCall("logger", "Warn", "no handler for event, moving it to the dead-letter queue"),
+				factory.NewExprStmt(factory.NewSelectorCall("c", "deadLetter", ast.NewIdent("msg"))),
+				&ast.ReturnStmt{},
+			),
+			logCall("logger", "Info", "handling event"),
+			factory.NewDefineExpectsError("response",
+				factory.NewCall(ast.NewIdent("function"), factory.NewSelector("payload", "Data")),
+			),
+			factory.NewIfError(
+				logCall("logger", "Error", "event handler failed", errAttr()),
+				factory.NewExprStmt(factory.NewSelectorCall("c", "retry", ast.NewIdent("msg"))),
+				&ast.ReturnStmt{},
+			),
+			// The handler already ran: a failed reply is logged, not retried
+			&ast.IfStmt{
+				Init: factory.NewDefine("replyTo",
+					factory.NewCall(factory.NewSelector("msg", "Headers().Get"), ast.NewIdent("ReplyToHeader")),
+				),
+				Cond: &ast.BinaryExpr{X: ast.NewIdent("replyTo"), Op: token.NEQ, Y: factory.NewBasicLit("")},
+				Body: factory.NewBodyStmt(
+					&ast.IfStmt{
+						Init: factory.NewDefine("err",
+							factory.NewSelectorCall("c", "conn.Publish", ast.NewIdent("replyTo"), ast.NewIdent("response")),
+						),
+						Cond: &ast.BinaryExpr{X: ast.NewIdent("err"), Op: token.NEQ, Y: ast.NewIdent("nil")},
+						Body: factory.NewBodyStmt(
+							logCall("logger", "Error", "failed to publish response",
+								attr("reply_to", ast.NewIdent("replyTo")),
+								errAttr(),
+							),
+						),
+					},
+				),
+			},
+			factory.NewExprStmt(factory.NewSelectorCall("msg", "Ack")),
+		),
+	)
+
+	return &types.File{
+		Name: "event.go",
+		Content: factory.NewFileNode("event",
+			imports,
+			natsReplyHeaders(),
+			eventPayloadDecl(),
+			addressDecl(t),
+			connectFunc(t),
+			natsSetupStreamFunc(),
+			handlePayloadFunc,
+		),
+	}
+}
+
+// natsConsumerFile generates consumer.go for a NATS JetStream listener service
+func natsConsumerFile(t transport) *types.File {
+	imports := factory.NewImportDecl(append([]*ast.ImportSpec{
+		factory.NewImport("context", ""),
+		factory.NewImport("encoding/json", ""),
+		factory.NewImport("log/slog", ""),
+		factory.NewImport("github.com/nats-io/nats.go/jetstream", ""),
+	}, t.imports...)...)
+
+	// type Consumer struct
+	consumerStruct := factory.NewTypeStruct("Consumer", factory.NewFieldList(
+		factory.NewField("conn", t.connType),
+		factory.NewField("exchange", ast.NewIdent("string")),
+		factory.NewField("handlers", ast.NewIdent("Handlers")),
+	))
+
+	// func NewConsumer(conn *nats.Conn, exchange string, handlers Handlers) *Consumer
+	newConsumerFunc := factory.NewFuncDecl(
+		"NewConsumer",
+		factory.NewFieldList(),
+		factory.NewFuncType(
+			factory.NewFieldList(
+				factory.NewField("conn", t.connType),
+				factory.NewField("exchange", ast.NewIdent("string")),
+				factory.NewField("handlers", ast.NewIdent("Handlers")),
+			),
+			factory.NewFieldList(factory.NewField("", &ast.StarExpr{X: ast.NewIdent("Consumer")})),
+		),
+		factory.NewBodyStmt(
+			factory.NewReturn(factory.NewAddressOf(factory.NewCompositeLit(
+				ast.NewIdent("Consumer"),
+				factory.NewKeyValue("conn", ast.NewIdent("conn")),
+				factory.NewKeyValue("exchange", ast.NewIdent("exchange")),
+				factory.NewKeyValue("handlers", ast.NewIdent("handlers")),
+			))),
+		),
+	)
+
+	errReturn := factory.NewIfError(factory.NewReturn(ast.NewIdent("err")))
+
+	// func (c *Consumer) Setup() error
+	setupFunc := factory.NewFuncDecl(
+		"Setup",
+		factory.NewFieldList(factory.NewField("c", &ast.StarExpr{X: ast.NewIdent("Consumer")})),
+		factory.NewFuncType(factory.NewFieldList(), factory.NewFieldList(factory.NewField("", ast.NewIdent("error")))),
+		factory.NewBodyStmt(append(natsJetStream(factory.NewSelector("c", "conn")),
+			factory.NewDefine("ctx", factory.NewSelectorCall("context", "Background")),
+			factory.NewAssignExpectsError(factory.NewCall(ast.NewIdent("setupStream"),
+				ast.NewIdent("ctx"),
+				ast.NewIdent("js"),
+				factory.NewSelector("c", "exchange"),
+			)),
+			errReturn,
+			// Rejected messages are kept in their own stream
+			&ast.AssignStmt{
+				Lhs: []ast.Expr{ast.NewIdent("_"), ast.NewIdent("err")},
+				Tok: token.ASSIGN,
+				Rhs: []ast.Expr{factory.NewSelectorCall("js", "CreateOrUpdateStream",
+					ast.NewIdent("ctx"),
+					factory.NewCompositeLit(factory.NewSelector("jetstream", "StreamConfig"),
+						factory.NewKeyValue("Name", ast.NewIdent("DeadLetterStream")),
+						factory.NewKeyValue("Subjects", factory.NewCompositeLit(
+							&ast.ArrayType{Elt: ast.NewIdent("string")},
+							ast.NewIdent("DeadLetterQueue"),
+						)),
+						factory.NewKeyValue("Storage", ast.NewIdent("Storage")),
+					),
+				)},
+			},
+			factory.NewReturn(ast.NewIdent("err")),
+		)...),
+	)
+
+	// func (c *Consumer) Listen(topics []string) error
+	listenFunc := factory.NewFuncDecl(
+		"Listen",
+		factory.NewFieldList(factory.NewField("c", &ast.StarExpr{X: ast.NewIdent("Consumer")})),
+		factory.NewFuncType(
+			factory.NewFieldList(factory.NewField("topics", &ast.ArrayType{Elt: ast.NewIdent("string")})),
+			factory.NewFieldList(factory.NewField("", ast.NewIdent("error"))),
+		),
+		factory.NewBodyStmt(append(natsJetStream(factory.NewSelector("c", "conn")),
+			factory.NewVarStmt("subjects", &ast.ArrayType{Elt: ast.NewIdent("string")}),
+			&ast.RangeStmt{
+				Key:   ast.NewIdent("_"),
+				Value: ast.NewIdent("topic"),
+				Tok:   token.DEFINE,
+				X:     ast.NewIdent("topics"),
+				Body: factory.NewBodyStmt(
+					factory.NewAssign(ast.NewIdent("subjects"), factory.NewCall(ast.NewIdent("append"),
+						ast.NewIdent("subjects"),
+						&ast.BinaryExpr{
+							X: &ast.BinaryExpr{
+								X:  factory.NewSelector("c", "exchange"),
+								Op: token.ADD,
+								Y:  factory.NewBasicLit("."),
+							},
+							Op: token.ADD,
+							Y:  ast.NewIdent("topic"),
+						},
+					)),
+				),
+			},
+			// Queue names the durable consumer, so the listener resumes where it stopped
+			factory.NewDefineExpectsError("consumer",
+				factory.NewSelectorCall("js", "CreateOrUpdateConsumer",
+					factory.NewSelectorCall("context", "Background"),
+					ast.NewIdent("Stream"),
+					factory.NewCompositeLit(factory.NewSelector("jetstream", "ConsumerConfig"),
+						factory.NewKeyValue("Durable", ast.NewIdent("Queue")),
+						factory.NewKeyValue("FilterSubjects", ast.NewIdent("subjects")),
+						factory.NewKeyValue("AckPolicy", factory.NewSelector("jetstream", "AckExplicitPolicy")),
+					),
+				),
+			),
+			errReturn,
+			factory.NewDefineExpectsError("consumeContext",
+				factory.NewSelectorCall("consumer", "Consume",
+					factory.NewFuncLit(
+						factory.NewFuncType(
+							factory.NewFieldList(factory.NewField("msg", factory.NewSelector("jetstream", "Msg"))),
+							factory.NewFieldList(),
+						),
+						factory.NewBodyStmt(
+							factory.NewVarStmt("eventPayload", ast.NewIdent("EventPayload")),
+							// Malformed messages can't succeed on retry: dead-letter them
+							&ast.IfStmt{
+								Init: factory.NewDefine("err", factory.NewSelectorCall("json", "Unmarshal",
+									factory.NewSelectorCall("msg", "Data"),
+									factory.NewAddressOf(ast.NewIdent("eventPayload")),
+								)),
+								Cond: &ast.BinaryExpr{X: ast.NewIdent("err"), Op: token.NEQ, Y: ast.NewIdent("nil")},
+								Body: factory.NewBodyStmt(
+									logWarn("malformed message, moving it to the dead-letter queue",
+										attr("correlation_id", natsCorrelationID()),
+										errAttr(),
+									),
+									factory.NewExprStmt(factory.NewSelectorCall("c", "deadLetter", ast.NewIdent("msg"))),
+									&ast.ReturnStmt{},
+								),
+							},
+							// handlePayload acks, retries or dead-letters the message
+							factory.NewExprStmt(factory.NewSelectorCall("c", "handlePayload",
+								ast.NewIdent("eventPayload"),
+								ast.NewIdent("msg"),
+							)),
+						),
+					),
+				),
+			),
+			errReturn,
+			&ast.DeferStmt{Call: factory.NewSelectorCall("consumeContext", "Stop")},
+			logInfo("listening for messages", attr("subjects", ast.NewIdent("subjects"))),
+			factory.NewDefine("forever", factory.NewCall(ast.NewIdent("make"),
+				&ast.ChanType{Dir: ast.SEND | ast.RECV, Value: ast.NewIdent("bool")},
+			)),
+			&ast.ExprStmt{X: &ast.UnaryExpr{Op: token.ARROW, X: ast.NewIdent("forever")}},
+			factory.NewReturn(ast.NewIdent("nil")),
+		)...),
+	)
+
+	return &types.File{
+		Name: "consumer.go",
+		Content: factory.NewFileNode("event",
+			imports,
+			handlerTypeDecl(),
+			consumerStruct,
+			newConsumerFunc,
+			setupFunc,
+			listenFunc,
+		),
+	}
+}
+
+// natsRetryFile generates retry.go for a NATS JetStream listener: redeliveries are
+// delayed with NakWithDelay and exhausted messages are copied to the dead-letter stream
+func natsRetryFile(t transport) *types.File {
+	imports := factory.NewImportDecl(append([]*ast.ImportSpec{
+		factory.NewImport("log/slog", ""),
+		factory.NewImport("strings", ""),
+		factory.NewImport("time", ""),
+		factory.NewImport("github.com/nats-io/nats.go/jetstream", ""),
+	}, t.imports...)...)
+
+	msgParams := factory.NewFieldList(factory.NewField("msg", factory.NewSelector("jetstream", "Msg")))
+
+	// func retryCount(msg jetstream.Msg) int
+	retryCountFunc := factory.NewFuncDecl(
+		"retryCount",
+		factory.NewFieldList(),
+		factory.NewFuncType(msgParams, factory.NewFieldList(factory.NewField("", ast.NewIdent("int")))),
+		factory.NewBodyStmt(
+			factory.NewDefineExpectsError("meta", factory.NewSelectorCall("msg", "Metadata")),
+			factory.NewIfError(factory.NewReturn(factory.NewBasicLitInt(0))),
+			factory.NewReturn(&ast.BinaryExpr{
+				X:  factory.NewCall(ast.NewIdent("int"), factory.NewSelector("meta", "NumDelivered")),
+				Op: token.SUB,
+				Y:  factory.NewBasicLitInt(1),
+			}),
+		),
+	)
+
+	recv := factory.NewFieldList(factory.NewField("c", &ast.StarExpr{X: ast.NewIdent("Consumer")}))
+
+	// func (c *Consumer) retry(msg jetstream.Msg)
+	retryFunc := factory.NewFuncDecl(
+		"retry",
+		recv,
+		factory.NewFuncType(msgParams, factory.NewFieldList()),
+		factory.NewBodyStmt(
+			factory.NewDefine("key", factory.NewSelectorCall("strings", "TrimPrefix",
+				factory.NewSelectorCall("msg", "Subject"),
+				&ast.BinaryExpr{X: factory.NewSelector("c", "exchange"), Op: token.ADD, Y: factory.NewBasicLit(".")},
+			)),
+			factory.NewDefine("policy", factory.NewCall(ast.NewIdent("policyFor"), ast.NewIdent("key"))),
+			factory.NewDefine("count", factory.NewCall(ast.NewIdent("retryCount"), ast.NewIdent("msg"))),
+			factory.NewIf(
+				&ast.BinaryExpr{X: ast.NewIdent("count"), Op: token.GEQ, Y: factory.NewSelector("policy", "MaxRetries")},
+				logWarn("retries exhausted, moving message to the dead-letter queue",
+					attr("event", ast.NewIdent("key")),
+					attr("correlation_id", natsCorrelationID()),
+					attr("retries", ast.NewIdent("count")),
+				),
+				factory.NewExprStmt(factory.NewSelectorCall("c", "deadLetter", ast.NewIdent("msg"))),
+				&ast.ReturnStmt{},
+			),
+			logInfo("retrying event",
+				attr("event", ast.NewIdent("key")),
+				attr("correlation_id", natsCorrelationID()),
+				attr("attempt", &ast.BinaryExpr{X: ast.NewIdent("count"), Op: token.ADD, Y: factory.NewBasicLitInt(1)}),
+				attr("max_retries", factory.NewSelector("policy", "MaxRetries")),
+				attr("delay", factory.NewSelector("policy", "Delay")),
+			),
+			factory.NewExprStmt(factory.NewSelectorCall("msg", "NakWithDelay", factory.NewSelector("policy", "Delay"))),
+		),
+	)
+
+	// func (c *Consumer) deadLetter(msg jetstream.Msg)
+	deadLetterFunc := factory.NewFuncDecl(
+		"deadLetter",
+		recv,
+		factory.NewFuncType(msgParams, factory.NewFieldList()),
+		factory.NewBodyStmt(
+			factory.NewDefine("dead", factory.NewSelectorCall("nats", "NewMsg", ast.NewIdent("DeadLetterQueue"))),
+			&ast.RangeStmt{
+				Key:   ast.NewIdent("k"),
+				Value: ast.NewIdent("v"),
+				Tok:   token.DEFINE,
+				X:     factory.NewSelectorCall("msg", "Headers"),
+				Body: factory.NewBodyStmt(
+					factory.NewAssign(&ast.IndexExpr{X: factory.NewSelector("dead", "Header"), Index: ast.NewIdent("k")}, ast.NewIdent("v")),
+				),
+			},
+			factory.NewExprStmt(factory.NewSelectorCall("dead", "Header.Set",
+				ast.NewIdent("OriginalSubjectHeader"),
+				factory.NewSelectorCall("msg", "Subject"),
+			)),
+			factory.NewAssign(factory.NewSelector("dead", "Data"), factory.NewSelectorCall("msg", "Data")),
+			// Keep the message for redelivery if it can't be stored
+			&ast.IfStmt{
+				Init: factory.NewDefine("err", factory.NewSelectorCall("c", "conn.PublishMsg", ast.NewIdent("dead"))),
+				Cond: &ast.BinaryExpr{X: ast.NewIdent("err"), Op: token.NEQ, Y: ast.NewIdent("nil")},
+				Body: factory.NewBodyStmt(
+					logError("failed to dead-letter message",
+						attr("subject", factory.NewSelectorCall("msg", "Subject")),
+						attr("correlation_id", natsCorrelationID()),
+						errAttr(),
+					),
+					factory.NewExprStmt(factory.NewSelectorCall("msg", "Nak")),
+					&ast.ReturnStmt{},
+				),
+			},
+			factory.NewExprStmt(factory.NewSelectorCall("msg", "Term")),
+		),
+	)
+
+	return &types.File{
+		Name: "retry.go",
+		Content: factory.NewFileNode("event",
+			imports,
+			// OriginalSubjectHeader keeps the subject of dead-lettered messages
+			factory.NewConstDecl("OriginalSubjectHeader", factory.NewBasicLit("Original-Subject")),
+			retryPolicyDecl(),
+			policyForFunc(),
+			topicMatchesFunc(),
+			matchWordsFunc(">", 1),
+			retryCountFunc,
+			retryFunc,
+			deadLetterFunc,
+		),
+	}
+}
@@ -32,7 +32,7 @@ func WithPostgres() Option {
 			TimeoutConn: 10,
 		}
 		s.Packages = append(s.Packages,
-			&types.Package{Name: "config", Files: []*types.File{DefaultConfigFile(s)}},
+			&types.Package{Name: "config", Files: []*types.File{DefaultConfigFile(s), EnvFile(s)}},
 		)
 	}
 }
@@ -65,6 +65,22 @@ func WithHandlers() Option {
 	}
 }
 
+// WithEvents sets the typed event contracts the service is generated against
+func WithEvents(events ...*types.Event) Option {
+	return func(s *types.Service) {
+		s.Events = events
+	}
+}
+
+// WithListenerHandlers adds the handlers package implementing the listener's event contracts
+func WithListenerHandlers() Option {
+	return func(s *types.Service) {
+		if pkg := ListenerHandlersPackage(s); pkg != nil {
+			s.Packages = append(s.Packages, pkg)
+		}
+	}
+}
+
 // WithBrokerEvent adds the broker event package (emitter + event)
 func WithBrokerEvent() Option {
 	return func(s *types.Service) {
@@ -83,7 +99,7 @@ func WithListenerEvent() Option {
 func WithBrokerConfig() Option {
 	return func(s *types.Service) {
 		s.Packages = append(s.Packages,
-			&types.Package{Name: "config", Files: []*types.File{BrokerConfigFile(s)}},
+			&types.Package{Name: "config", Files: []*types.File{BrokerConfigFile(s), EnvFile(s)}},
 		)
 	}
 }
@@ -92,7 +108,7 @@ func WithBrokerConfig() Option {
 func WithListenerConfig() Option {
 	return func(s *types.Service) {
 		s.Packages = append(s.Packages,
-			&types.Package{Name: "config", Files: []*types.File{ListenerConfigFile(s)}},
+			&types.Package{Name: "config", Files: []*types.File{ListenerConfigFile(s), EnvFile(s)}},
 		)
 	}
 }
@@ -120,3 +136,198 @@ func WithListenerMain() Option {
 		)
 	}
 }
+
+// WithGRPCStubs adds the pb package with the checked-in stubs of the service's .proto
+func WithGRPCStubs() Option {
+	return func(s *types.Service) {
+		s.Packages = append(s.Packages, GRPCStubsPackage(s))
+	}
+}
+
+// WithGRPCHandlers adds the handlers package implementing the gRPC server
+func WithGRPCHandlers() Option {
+	return func(s *types.Service) {
+		s.Packages = append(s.Packages,
+			&types.Package{Name: "handlers", Files: []*types.File{GRPCServerFile(s)}},
+		)
+	}
+}
+
+// WithGRPCConfig adds the config for gRPC service (health, reflection, graceful stop)
+func WithGRPCConfig() Option {
+	return func(s *types.Service) {
+		s.Packages = append(s.Packages,
+			&types.Package{Name: "config", Files: []*types.File{GRPCConfigFile(s), EnvFile(s)}},
+		)
+	}
+}
+
+// WithGRPCMain adds main.go for gRPC service
+func WithGRPCMain() Option {
+	return func(s *types.Service) {
+		s.Packages = append(s.Packages,
+			&types.Package{
+				Name:  "cmd",
+				Files: []*types.File{GRPCMainFile(s)},
+			},
+		)
+	}
+}
+
+// WithWebSocketHub adds the hub package (hub, client pumps, bridge)
+func WithWebSocketHub() Option {
+	return func(s *types.Service) {
+		s.Packages = append(s.Packages, WebSocketHubPackage(s))
+	}
+}
+
+// WithWebSocketRoutes adds routes.go mounting the websocket upgrade
+func WithWebSocketRoutes() Option {
+	return func(s *types.Service) {
+		s.Packages = append(s.Packages,
+			&types.Package{Name: "routes", Files: []*types.File{WebSocketRoutesFile(s)}},
+		)
+	}
+}
+
+// WithWebSocketConfig adds the config for websocket service (hub, optional bridge, no DB)
+func WithWebSocketConfig() Option {
+	return func(s *types.Service) {
+		s.Packages = append(s.Packages,
+			&types.Package{Name: "config", Files: []*types.File{WebSocketConfigFile(s), EnvFile(s)}},
+		)
+	}
+}
+
+// WithWebSocketMain adds main.go for websocket service (same InitConfig/InitServer pair as gRPC)
+func WithWebSocketMain() Option {
+	return func(s *types.Service) {
+		s.Packages = append(s.Packages,
+			&types.Package{
+				Name:  "cmd",
+				Files: []*types.File{GRPCMainFile(s)},
+			},
+		)
+	}
+}
+
+// WithGatewayPackage adds the gateway package (route table, proxy, middleware)
+func WithGatewayPackage() Option {
+	return func(s *types.Service) {
+		s.Packages = append(s.Packages, GatewayPackage())
+	}
+}
+
+// WithGatewayRoutes adds routes.go proxying the route table
+func WithGatewayRoutes() Option {
+	return func(s *types.Service) {
+		s.Packages = append(s.Packages,
+			&types.Package{Name: "routes", Files: []*types.File{GatewayRoutesFile(s)}},
+		)
+	}
+}
+
+// WithGatewayConfig adds the config for gateway service (no DB)
+func WithGatewayConfig() Option {
+	return func(s *types.Service) {
+		s.Packages = append(s.Packages,
+			&types.Package{Name: "config", Files: []*types.File{GatewayConfigFile(s), EnvFile(s)}},
+		)
+	}
+}
+
+// WithGatewayMain adds main.go for gateway service
+func WithGatewayMain() Option {
+	return func(s *types.Service) {
+		s.Packages = append(s.Packages,
+			&types.Package{
+				Name:  "cmd",
+				Files: []*types.File{GRPCMainFile(s)},
+			},
+		)
+	}
+}
+
+// WithJobs sets the scheduled jobs of a worker service
+func WithJobs(jobs ...*types.Job) Option {
+	return func(s *types.Service) {
+		s.Jobs = jobs
+	}
+}
+
+// WithLeaderLock gives a worker a Postgres database whose advisory locks
+// make sure only one replica runs each job
+func WithLeaderLock() Option {
+	return func(s *types.Service) {
+		s.DB = &types.Database{
+			Driver:      "pgx",
+			Port:        5432,
+			TimeoutConn: 10,
+		}
+	}
+}
+
+// WithWorkerJobs adds the jobs package (registry + one stub per job)
+func WithWorkerJobs() Option {
+	return func(s *types.Service) {
+		s.Packages = append(s.Packages, WorkerJobsPackage(s))
+	}
+}
+
+// WithWorkerScheduler adds the scheduler package (cron, optional advisory locks)
+func WithWorkerScheduler() Option {
+	return func(s *types.Service) {
+		s.Packages = append(s.Packages, WorkerSchedulerPackage(s))
+	}
+}
+
+// WithWorkerConfig adds the config for worker service (scheduler, optional DB)
+func WithWorkerConfig() Option {
+	return func(s *types.Service) {
+		s.Packages = append(s.Packages,
+			&types.Package{Name: "config", Files: []*types.File{WorkerConfigFile(s), EnvFile(s)}},
+		)
+	}
+}
+
+// WithWorkerMain adds main.go for worker service
+func WithWorkerMain() Option {
+	return func(s *types.Service) {
+		s.Packages = append(s.Packages,
+			&types.Package{
+				Name:  "cmd",
+				Files: []*types.File{WorkerMainFile(s)},
+			},
+		)
+	}
+}
+
+// WithLogger adds the logger package setting up log/slog from LOG_FORMAT and LOG_LEVEL
+func WithLogger() Option {
+	return func(s *types.Service) {
+		s.Packages = append(s.Packages, LoggerPackage(false))
+	}
+}
+
+// WithHTTPLogger adds the logger package with the request-logging middleware
+func WithHTTPLogger() Option {
+	return func(s *types.Service) {
+		s.Packages = append(s.Packages, LoggerPackage(true))
+	}
+}
+
+// WithHealth adds the health package with the probe middleware and the
+// readiness checks of the service's database and transport
+func WithHealth() Option {
+	return func(s *types.Service) {
+		s.Packages = append(s.Packages, HealthPackage(s, false))
+	}
+}
+
+// WithAdminHealth adds the health package with the admin server, for
+// services that serve no HTTP routes of their own
+func WithAdminHealth() Option {
+	return func(s *types.Service) {
+		s.Packages = append(s.Packages, HealthPackage(s, true))
+	}
+}
@@ -0,0 +1,411 @@
+package defaults
+
+import (
+	"go/ast"
+	"go/token"
+
+	"github.com/flaviogonzalez/instant-layer/internal/factory"
+	"github.com/flaviogonzalez/instant-layer/internal/types"
+)
+
+// outboxSchema is the migration creating the outbox table, with a partial
+// index so the relay only scans unsent events
+const outboxSchema = `
+CREATE TABLE IF NOT EXISTS outbox (
+	id BIGSERIAL PRIMARY KEY,
+	topic TEXT NOT NULL,
+	payload JSONB NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	sent_at TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS outbox_unsent_idx ON outbox (id) WHERE sent_at IS NULL;
+`
+
+// WithOutbox makes a DB service emit events through a transactional outbox:
+// events are inserted in the caller's transaction and relayed by a broker emitter
+func WithOutbox() Option {
+	return func(s *types.Service) {
+		s.Outbox = true
+	}
+}
+
+// WithOutboxPackage adds the outbox package (migration, insert helper and relay)
+func WithOutboxPackage() Option {
+	return func(s *types.Service) {
+		s.Packages = append(s.Packages, OutboxPackage())
+	}
+}
+
+// OutboxPackage generates the outbox package of a DB service
+// Contains: migration.go, outbox.go, relay.go
+func OutboxPackage() *types.Package {
+	return &types.Package{
+		Name: "outbox",
+		Files: []*types.File{
+			outboxMigrationFile(),
+			outboxFile(),
+			outboxRelayFile(),
+		},
+	}
+}
+
+// outboxMigrationFile generates migration.go: the outbox table schema and Migrate
+func outboxMigrationFile() *types.File {
+	imports := factory.NewImportDecl(factory.NewImport("database/sql", ""))
+
+	// func Migrate(db *sql.DB) error
+	migrateFunc := factory.NewFuncDecl(
+		"Migrate",
+		factory.NewFieldList(),
+		factory.NewFuncType(
+			factory.NewFieldList(factory.NewField("db", &ast.StarExpr{X: factory.NewSelector("sql", "DB")})),
+			factory.NewFieldList(factory.NewField("", ast.NewIdent("error"))),
+		),
+		factory.NewBodyStmt(
+			factory.NewDefineExpectsError("_", factory.NewSelectorCall("db", "Exec", ast.NewIdent("Schema"))),
+			factory.NewReturn(ast.NewIdent("err")),
+		),
+	)
+
+	return &types.File{
+		Name: "migration.go",
+		Content: factory.NewFileNode("outbox",
+			imports,
+			factory.NewConstDecl("Schema", &ast.BasicLit{Kind: token.STRING, Value: "`" + outboxSchema + "`"}),
+			migrateFunc,
+		),
+	}
+}
+
+// outboxFile generates outbox.go: the Event and Publisher contracts and Insert
+func outboxFile() *types.File {
+	imports := factory.NewImportDecl(
+		factory.NewImport("context", ""),
+		factory.NewImport("database/sql", ""),
+		factory.NewImport("encoding/json", ""),
+	)
+
+	// type Event interface { EventName() string; SchemaVersion() int }
+	// Typed contracts of the events module satisfy it
+	eventInterface := &ast.GenDecl{
+		Tok: token.TYPE,
+		Specs: []ast.Spec{
+			&ast.TypeSpec{
+				Name: ast.NewIdent("Event"),
+				Type: &ast.InterfaceType{Methods: factory.NewFieldList(
+					factory.NewField("EventName", factory.NewFuncType(
+						factory.NewFieldList(),
+						factory.NewFieldList(factory.NewField("", ast.NewIdent("string"))),
+					)),
+					factory.NewField("SchemaVersion", factory.NewFuncType(
+						factory.NewFieldList(),
+						factory.NewFieldList(factory.NewField("", ast.NewIdent("int"))),
+					)),
+				)},
+			},
+		},
+	}
+
+	// type Publisher interface { Publish(ctx context.Context, topic string, payload []byte) error }
+	// The broker event.Emitter implements it
+	publisherInterface := &ast.GenDecl{
+		Tok: token.TYPE,
+		Specs: []ast.Spec{
+			&ast.TypeSpec{
+				Name: ast.NewIdent("Publisher"),
+				Type: &ast.InterfaceType{Methods: factory.NewFieldList(
+					factory.NewField("Publish", factory.NewFuncType(
+						factory.NewFieldList(
+							factory.NewField("ctx", factory.NewSelector("context", "Context")),
+							factory.NewField("topic", ast.NewIdent("string")),
+							factory.NewField("payload", &ast.ArrayType{Elt: ast.NewIdent("byte")}),
+						),
+						factory.NewFieldList(factory.NewField("", ast.NewIdent("error"))),
+					)),
+				)},
+			},
+		},
+	}
+
+	// func Insert(ctx context.Context, tx *sql.Tx, ev Event) error
+	insertFunc := factory.NewFuncDecl(
+		"Insert",
+		factory.NewFieldList(),
+		factory.NewFuncType(
+			factory.NewFieldList(
+				factory.NewField("ctx", factory.NewSelector("context", "Context")),
+				factory.NewField("tx", &ast.StarExpr{X: factory.NewSelector("sql", "Tx")}),
+				factory.NewField("ev", ast.NewIdent("Event")),
+			),
+			factory.NewFieldList(factory.NewField("", ast.NewIdent("error"))),
+		),
+		factory.NewBodyStmt(
+			factory.NewDefineExpectsError("data", factory.NewSelectorCall("json", "Marshal", ast.NewIdent("ev"))),
+			factory.NewIfError(factory.NewReturn(ast.NewIdent("err"))),
+			// The payload is the envelope listeners decode into event.EventPayload
+			factory.NewDefineExpectsError("payload", factory.NewSelectorCall("json", "Marshal",
+				factory.NewCompositeLit(
+					&ast.MapType{Key: ast.NewIdent("string"), Value: ast.NewIdent("any")},
+					&ast.KeyValueExpr{Key: factory.NewBasicLit("name"), Value: factory.NewSelectorCall("ev", "EventName")},
+					&ast.KeyValueExpr{Key: factory.NewBasicLit("version"), Value: factory.NewSelectorCall("ev", "SchemaVersion")},
+					&ast.KeyValueExpr{Key: factory.NewBasicLit("data"), Value: factory.NewCall(
+						factory.NewSelector("json", "RawMessage"),
+						ast.NewIdent("data"),
+					)},
+				),
+			)),
+			factory.NewIfError(factory.NewReturn(ast.NewIdent("err"))),
+			&ast.AssignStmt{
+				Lhs: []ast.Expr{ast.NewIdent("_"), ast.NewIdent("err")},
+				Tok: token.ASSIGN,
+				Rhs: []ast.Expr{factory.NewSelectorCall("tx", "ExecContext",
+					ast.NewIdent("ctx"),
+					factory.NewBasicLit("INSERT INTO outbox (topic, payload) VALUES ($1, $2)"),
+					factory.NewSelectorCall("ev", "EventName"),
+					ast.NewIdent("payload"),
+				)},
+			},
+			factory.NewReturn(ast.NewIdent("err")),
+		),
+	)
+
+	return &types.File{
+		Name: "outbox.go",
+		Content: factory.NewFileNode("outbox",
+			imports,
+			eventInterface,
+			publisherInterface,
+			insertFunc,
+		),
+	}
+}
+
+// outboxRelayFile generates relay.go: a poller publishing unsent events in id
+// order and marking them sent in the same transaction. An event published
+// before a crash is published again, so delivery is at-least-once.
+func outboxRelayFile() *types.File {
+	imports := factory.NewImportDecl(
+		factory.NewImport("context", ""),
+		factory.NewImport("database/sql", ""),
+		factory.NewImport("errors", ""),
+		factory.NewImport("log/slog", ""),
+		factory.NewImport("time", ""),
+	)
+
+	settings := &ast.GenDecl{
+		Tok:    token.CONST,
+		Lparen: 1,
+		Specs: []ast.Spec{
+			&ast.ValueSpec{
+				Names:  []*ast.Ident{ast.NewIdent("BatchSize")},
+				Values: []ast.Expr{factory.NewBasicLitInt(100)},
+			},
+			&ast.ValueSpec{
+				Names:  []*ast.Ident{ast.NewIdent("PollInterval")},
+				Values: []ast.Expr{factory.NewSelector("time", "Second")},
+			},
+		},
+	}
+
+	// type message struct { id int64; topic string; payload []byte }
+	messageStruct := factory.NewTypeStruct("message", factory.NewFieldList(
+		factory.NewField("id", ast.NewIdent("int64")),
+		factory.NewField("topic", ast.NewIdent("string")),
+		factory.NewField("payload", &ast.ArrayType{Elt: ast.NewIdent("byte")}),
+	))
+
+	// type Relay struct { db *sql.DB; publisher Publisher }
+	relayStruct := factory.NewTypeStruct("Relay", factory.NewFieldList(
+		factory.NewField("db", &ast.StarExpr{X: factory.NewSelector("sql", "DB")}),
+		factory.NewField("publisher", ast.NewIdent("Publisher")),
+	))
+
+	// func NewRelay(db *sql.DB, publisher Publisher) *Relay
+	newRelayFunc := factory.NewFuncDecl(
+		"NewRelay",
+		factory.NewFieldList(),
+		factory.NewFuncType(
+			factory.NewFieldList(
+				factory.NewField("db", &ast.StarExpr{X: factory.NewSelector("sql", "DB")}),
+				factory.NewField("publisher", ast.NewIdent("Publisher")),
+			),
+			factory.NewFieldList(factory.NewField("", &ast.StarExpr{X: ast.NewIdent("Relay")})),
+		),
+		factory.NewBodyStmt(
+			factory.NewReturn(factory.NewAddressOf(factory.NewCompositeLit(
+				ast.NewIdent("Relay"),
+				factory.NewKeyValue("db", ast.NewIdent("db")),
+				factory.NewKeyValue("publisher", ast.NewIdent("publisher")),
+			))),
+		),
+	)
+
+	recv := factory.NewFieldList(factory.NewField("r", &ast.StarExpr{X: ast.NewIdent("Relay")}))
+	ctxParams := factory.NewFieldList(factory.NewField("ctx", factory.NewSelector("context", "Context")))
+
+	// func (r *Relay) Start(ctx context.Context)
+	startFunc := factory.NewFuncDecl(
+		"Start",
+		recv,
+		factory.NewFuncType(ctxParams, factory.NewFieldList()),
+		factory.NewBodyStmt(
+			&ast.GoStmt{Call: factory.NewSelectorCall("r", "run", ast.NewIdent("ctx"))},
+		),
+	)
+
+	// func (r *Relay) run(ctx context.Context)
+	runFunc := factory.NewFuncDecl(
+		"run",
+		recv,
+		factory.NewFuncType(ctxParams, factory.NewFieldList()),
+		factory.NewBodyStmt(
+			factory.NewDefine("ticker", factory.NewSelectorCall("time", "NewTicker", ast.NewIdent("PollInterval"))),
+			&ast.DeferStmt{Call: factory.NewSelectorCall("ticker", "Stop")},
+			&ast.ForStmt{
+				Body: factory.NewBodyStmt(
+					&ast.SelectStmt{Body: factory.NewBodyStmt(
+						&ast.CommClause{
+							Comm: factory.NewExprStmt(&ast.UnaryExpr{Op: token.ARROW, X: factory.NewSelectorCall("ctx", "Done")}),
+							Body: []ast.Stmt{&ast.ReturnStmt{}},
+						},
+						&ast.CommClause{
+							Comm: factory.NewExprStmt(&ast.UnaryExpr{Op: token.ARROW, X: factory.NewSelector("ticker", "C")}),
+							Body: []ast.Stmt{
+								&ast.IfStmt{
+									Init: factory.NewDefine("err", factory.NewSelectorCall("r", "Flush", ast.NewIdent("ctx"))),
+									Cond: &ast.BinaryExpr{X: ast.NewIdent("err"), Op: token.NEQ, Y: ast.NewIdent("nil")},
+									Body: factory.NewBodyStmt(
+										logError("outbox relay failed", errAttr()),
+									),
+								},
+							},
+						},
+					)},
+				),
+			},
+		),
+	)
+
+	errReturn := factory.NewIfError(factory.NewReturn(ast.NewIdent("err")))
+
+	// func (r *Relay) Flush(ctx context.Context) error
+	flushFunc := factory.NewFuncDecl(
+		"Flush",
+		recv,
+		factory.NewFuncType(ctxParams, factory.NewFieldList(factory.NewField("", ast.NewIdent("error")))),
+		factory.NewBodyStmt(
+			factory.NewDefineExpectsError("tx", factory.NewSelectorCall("r", "db.BeginTx", ast.NewIdent("ctx"), ast.NewIdent("nil"))),
+			errReturn,
+			&ast.DeferStmt{Call: factory.NewSelectorCall("tx", "Rollback")},
+			// SKIP LOCKED lets several replicas relay concurrently without publishing twice
+			factory.NewDefineExpectsError("rows", factory.NewSelectorCall("tx", "QueryContext",
+				ast.NewIdent("ctx"),
+				factory.NewBasicLit("SELECT id, topic, payload FROM outbox WHERE sent_at IS NULL ORDER BY id LIMIT $1 FOR UPDATE SKIP LOCKED"),
+				ast.NewIdent("BatchSize"),
+			)),
+			errReturn,
+			factory.NewVarStmt("messages", &ast.ArrayType{Elt: ast.NewIdent("message")}),
+			&ast.ForStmt{
+				Cond: factory.NewSelectorCall("rows", "Next"),
+				Body: factory.NewBodyStmt(
+					factory.NewVarStmt("m", ast.NewIdent("message")),
+					&ast.IfStmt{
+						Init: factory.NewDefine("err", factory.NewSelectorCall("rows", "Scan",
+							factory.NewAddressOf(factory.NewSelector("m", "id")),
+							factory.NewAddressOf(factory.NewSelector("m", "topic")),
+							factory.NewAddressOf(factory.NewSelector("m", "payload")),
+						)),
+						Cond: &ast.BinaryExpr{X: ast.NewIdent("err"), Op: token.NEQ, Y: ast.NewIdent("nil")},
+						Body: factory.NewBodyStmt(
+							factory.NewExprStmt(factory.NewSelectorCall("rows", "Close")),
+							factory.NewReturn(ast.NewIdent("err")),
+						),
+					},
+					factory.NewAssign(ast.NewIdent("messages"), factory.NewCall(ast.NewIdent("append"), ast.NewIdent("messages"), ast.NewIdent("m"))),
+				),
+			},
+			// The rows must be closed before the transaction runs other statements
+			factory.NewExprStmt(factory.NewSelectorCall("rows", "Close")),
+			factory.NewAssign(ast.NewIdent("err"), factory.NewSelectorCall("rows", "Err")),
+			errReturn,
+			&ast.RangeStmt{
+				Key:   ast.NewIdent("_"),
+				Value: ast.NewIdent("m"),
+				Tok:   token.DEFINE,
+				X:     ast.NewIdent("messages"),
+				Body: factory.NewBodyStmt(
+					&ast.IfStmt{
+						Init: factory.NewDefine("err", factory.NewSelectorCall("r", "publisher.Publish",
+							ast.NewIdent("ctx"),
+							factory.NewSelector("m", "topic"),
+							factory.NewSelector("m", "payload"),
+						)),
+						Cond: &ast.BinaryExpr{X: ast.NewIdent("err"), Op: token.NEQ, Y: ast.NewIdent("nil")},
+						Body: factory.NewBodyStmt(
+							// Keep the events published so far marked as sent
+							factory.NewReturn(factory.NewSelectorCall("errors", "Join",
+								ast.NewIdent("err"),
+								factory.NewSelectorCall("tx", "Commit"),
+							)),
+						),
+					},
+					&ast.IfStmt{
+						Init: &ast.AssignStmt{
+							Lhs: []ast.Expr{ast.NewIdent("_"), ast.NewIdent("err")},
+							Tok: token.DEFINE,
+							Rhs: []ast.Expr{factory.NewSelectorCall("tx", "ExecContext",
+								ast.NewIdent("ctx"),
+								factory.NewBasicLit("UPDATE outbox SET sent_at = now() WHERE id = $1"),
+								factory.NewSelector("m", "id"),
+							)},
+						},
+						Cond: &ast.BinaryExpr{X: ast.NewIdent("err"), Op: token.NEQ, Y: ast.NewIdent("nil")},
+						Body: factory.NewBodyStmt(factory.NewReturn(ast.NewIdent("err"))),
+					},
+				),
+			},
+			factory.NewReturn(factory.NewSelectorCall("tx", "Commit")),
+		),
+	)
+
+	return &types.File{
+		Name: "relay.go",
+		Content: factory.NewFileNode("outbox",
+			imports,
+			settings,
+			messageStruct,
+			relayStruct,
+			newRelayFunc,
+			startFunc,
+			runFunc,
+			flushFunc,
+		),
+	}
+}
+
+// outboxRelayStmts generates the InitServer statements migrating the outbox
+// table and starting its relay on the broker emitter
+func outboxRelayStmts(s *types.Service) []ast.Stmt {
+	t := transportFor(s)
+	return []ast.Stmt{
+		factory.NewDefine("err", factory.NewSelectorCall("outbox", "Migrate", factory.NewSelector("app", "Db"))),
+		factory.NewIfError(logFatal("outbox migration failed", errAttr())...),
+		eventAddressStmt(s, "app.Env"),
+		factory.NewDefine("conn", factory.NewSelectorCall("event", t.connect)),
+		registerTransportCheckStmt(s, ast.NewIdent("conn")),
+		factory.NewDefine("emitter", factory.NewSelectorCall("event", "NewEmitter",
+			ast.NewIdent("conn"),
+			factory.NewSelector("event", "Exchange"),
+		)),
+		factory.NewExprStmt(factory.NewCall(
+			&ast.SelectorExpr{
+				X: factory.NewSelectorCall("outbox", "NewRelay",
+					factory.NewSelector("app", "Db"),
+					ast.NewIdent("emitter"),
+				),
+				Sel: ast.NewIdent("Start"),
+			},
+			factory.NewSelectorCall("context", "Background"),
+		)),
+	}
+}
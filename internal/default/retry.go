@@ -0,0 +1,462 @@
+package defaults
+
+import (
+	"go/ast"
+	"go/token"
+
+	"github.com/flaviogonzalez/instant-layer/internal/factory"
+	"github.com/flaviogonzalez/instant-layer/internal/types"
+)
+
+// ListenerRetryFile generates retry.go for a listener service: how failed messages
+// are retried and dead-lettered following the per-topic retry policies
+func ListenerRetryFile(s *types.Service) *types.File {
+	t := transportFor(s)
+	switch TransportOf(s) {
+	case TransportNATS:
+		return natsRetryFile(t)
+	case TransportKafka:
+		return kafkaRetryFile(t)
+	default:
+		return rabbitRetryFile()
+	}
+}
+
+// rabbitRetryFile generates retry.go for a RabbitMQ listener: the dead-letter
+// topology and the delay queues used for retries
+func rabbitRetryFile() *types.File {
+	imports := factory.NewImportDecl(
+		factory.NewImport("fmt", ""),
+		factory.NewImport("log/slog", ""),
+		factory.NewImport("strings", ""),
+		factory.NewImport("time", ""),
+		factory.NewImport("github.com/rabbitmq/amqp091-go", "amqp"),
+	)
+
+	headers := &ast.GenDecl{
+		Tok:    token.CONST,
+		Lparen: 1,
+		Specs: []ast.Spec{
+			// RetryCountHeader counts the retries of a message
+			&ast.ValueSpec{
+				Names:  []*ast.Ident{ast.NewIdent("RetryCountHeader")},
+				Values: []ast.Expr{factory.NewBasicLit("x-retry-count")},
+			},
+			// RoutingKeyHeader keeps the original routing key across retries
+			&ast.ValueSpec{
+				Names:  []*ast.Ident{ast.NewIdent("RoutingKeyHeader")},
+				Values: []ast.Expr{factory.NewBasicLit("x-original-routing-key")},
+			},
+		},
+	}
+
+	channelField := factory.NewField("ch", &ast.StarExpr{X: factory.NewSelector("amqp", "Channel")})
+	errorResult := factory.NewFieldList(factory.NewField("", ast.NewIdent("error")))
+
+	// func setupDeadLetter(ch *amqp.Channel) error
+	setupDeadLetterFunc := factory.NewFuncDecl(
+		"setupDeadLetter",
+		factory.NewFieldList(),
+		factory.NewFuncType(factory.NewFieldList(channelField), errorResult),
+		factory.NewBodyStmt(
+			factory.NewDefine("err",
+				factory.NewSelectorCall("ch", "ExchangeDeclare",
+					ast.NewIdent("DeadLetterExchange"),
+					factory.NewBasicLit("fanout"),
+					ast.NewIdent("Durable"),
+					ast.NewIdent("false"),
+					ast.NewIdent("false"),
+					ast.NewIdent("false"),
+					ast.NewIdent("nil"),
+				),
+			),
+			factory.NewIfError(factory.NewReturn(ast.NewIdent("err"))),
+			&ast.AssignStmt{
+				Lhs: []ast.Expr{ast.NewIdent("_"), ast.NewIdent("err")},
+				Tok: token.ASSIGN,
+				Rhs: []ast.Expr{
+					factory.NewSelectorCall("ch", "QueueDeclare",
+						ast.NewIdent("DeadLetterQueue"),
+						ast.NewIdent("Durable"),
+						ast.NewIdent("false"),
+						ast.NewIdent("false"),
+						ast.NewIdent("false"),
+						ast.NewIdent("nil"),
+					),
+				},
+			},
+			factory.NewIfError(factory.NewReturn(ast.NewIdent("err"))),
+			factory.NewReturn(
+				factory.NewSelectorCall("ch", "QueueBind",
+					ast.NewIdent("DeadLetterQueue"),
+					factory.NewBasicLit(""),
+					ast.NewIdent("DeadLetterExchange"),
+					ast.NewIdent("false"),
+					ast.NewIdent("nil"),
+				),
+			),
+		),
+	)
+
+	// func retryQueue(ch *amqp.Channel, queue string, delay time.Duration) (string, error)
+	retryQueueFunc := factory.NewFuncDecl(
+		"retryQueue",
+		factory.NewFieldList(),
+		factory.NewFuncType(
+			factory.NewFieldList(
+				channelField,
+				factory.NewField("queue", ast.NewIdent("string")),
+				factory.NewField("delay", factory.NewSelector("time", "Duration")),
+			),
+			factory.NewFieldList(
+				factory.NewField("", ast.NewIdent("string")),
+				factory.NewField("", ast.NewIdent("error")),
+			),
+		),
+		factory.NewBodyStmt(
+			factory.NewDefine("name",
+				factory.NewSelectorCall("fmt", "Sprintf",
+					factory.NewBasicLit("%s.retry.%d"),
+					ast.NewIdent("queue"),
+					factory.NewSelectorCall("delay", "Milliseconds"),
+				),
+			),
+			// Expired messages go back to the consumer queue through the default exchange
+			factory.NewDefineExpectsError("_",
+				factory.NewSelectorCall("ch", "QueueDeclare",
+					ast.NewIdent("name"),
+					ast.NewIdent("Durable"),
+					ast.NewIdent("false"),
+					ast.NewIdent("false"),
+					ast.NewIdent("false"),
+					factory.NewCompositeLit(factory.NewSelector("amqp", "Table"),
+						&ast.KeyValueExpr{
+							Key:   factory.NewBasicLit("x-message-ttl"),
+							Value: factory.NewSelectorCall("delay", "Milliseconds"),
+						},
+						&ast.KeyValueExpr{
+							Key:   factory.NewBasicLit("x-dead-letter-exchange"),
+							Value: factory.NewBasicLit(""),
+						},
+						&ast.KeyValueExpr{
+							Key:   factory.NewBasicLit("x-dead-letter-routing-key"),
+							Value: ast.NewIdent("queue"),
+						},
+					),
+				),
+			),
+			factory.NewReturn(ast.NewIdent("name"), ast.NewIdent("err")),
+		),
+	)
+
+	deliveryParams := factory.NewFieldList(factory.NewField("msg", factory.NewSelector("amqp", "Delivery")))
+	header := func(name string) ast.Expr {
+		return &ast.IndexExpr{X: factory.NewSelector("msg", "Headers"), Index: ast.NewIdent(name)}
+	}
+
+	// func retryCount(msg amqp.Delivery) int
+	retryCountFunc := factory.NewFuncDecl(
+		"retryCount",
+		factory.NewFieldList(),
+		factory.NewFuncType(deliveryParams, factory.NewFieldList(factory.NewField("", ast.NewIdent("int")))),
+		factory.NewBodyStmt(
+			&ast.AssignStmt{
+				Lhs: []ast.Expr{ast.NewIdent("count"), ast.NewIdent("_")},
+				Tok: token.DEFINE,
+				Rhs: []ast.Expr{&ast.TypeAssertExpr{X: header("RetryCountHeader"), Type: ast.NewIdent("int32")}},
+			},
+			factory.NewReturn(factory.NewCall(ast.NewIdent("int"), ast.NewIdent("count"))),
+		),
+	)
+
+	// func routingKey(msg amqp.Delivery) string
+	routingKeyFunc := factory.NewFuncDecl(
+		"routingKey",
+		factory.NewFieldList(),
+		factory.NewFuncType(deliveryParams, factory.NewFieldList(factory.NewField("", ast.NewIdent("string")))),
+		factory.NewBodyStmt(
+			&ast.IfStmt{
+				Init: &ast.AssignStmt{
+					Lhs: []ast.Expr{ast.NewIdent("key"), ast.NewIdent("ok")},
+					Tok: token.DEFINE,
+					Rhs: []ast.Expr{&ast.TypeAssertExpr{X: header("RoutingKeyHeader"), Type: ast.NewIdent("string")}},
+				},
+				Cond: ast.NewIdent("ok"),
+				Body: factory.NewBodyStmt(factory.NewReturn(ast.NewIdent("key"))),
+			},
+			factory.NewReturn(factory.NewSelector("msg", "RoutingKey")),
+		),
+	)
+
+	requeue := func(message string) []ast.Stmt {
+		return []ast.Stmt{
+			logError(message,
+				attr("event", ast.NewIdent("key")),
+				attr("correlation_id", factory.NewSelector("msg", "CorrelationId")),
+				errAttr(),
+			),
+			factory.NewExprStmt(factory.NewSelectorCall("msg", "Nack", ast.NewIdent("false"), ast.NewIdent("true"))),
+			&ast.ReturnStmt{},
+		}
+	}
+
+	// func (c *Consumer) retry(ch *amqp.Channel, msg amqp.Delivery)
+	retryFunc := factory.NewFuncDecl(
+		"retry",
+		factory.NewFieldList(
+			factory.NewField("c", &ast.StarExpr{X: ast.NewIdent("Consumer")}),
+		),
+		factory.NewFuncType(
+			factory.NewFieldList(
+				channelField,
+				factory.NewField("msg", factory.NewSelector("amqp", "Delivery")),
+			),
+			factory.NewFieldList(),
+		),
+		factory.NewBodyStmt(
+			factory.NewDefine("key", factory.NewCall(ast.NewIdent("routingKey"), ast.NewIdent("msg"))),
+			factory.NewDefine("policy", factory.NewCall(ast.NewIdent("policyFor"), ast.NewIdent("key"))),
+			factory.NewDefine("count", factory.NewCall(ast.NewIdent("retryCount"), ast.NewIdent("msg"))),
+			// Retries exhausted: reject without requeue so the queue dead-letters it
+			&ast.IfStmt{
+				Cond: &ast.BinaryExpr{
+					X:  ast.NewIdent("count"),
+					Op: token.GEQ,
+					Y:  factory.NewSelector("policy", "MaxRetries"),
+				},
+				Body: factory.NewBodyStmt(
+					logWarn("retries exhausted, moving message to the dead-letter queue",
+						attr("event", ast.NewIdent("key")),
+						attr("correlation_id", factory.NewSelector("msg", "CorrelationId")),
+						attr("retries", ast.NewIdent("count")),
+					),
+					factory.NewExprStmt(factory.NewSelectorCall("msg", "Nack", ast.NewIdent("false"), ast.NewIdent("false"))),
+					&ast.ReturnStmt{},
+				),
+			},
+			factory.NewDefineExpectsError("queue",
+				factory.NewCall(ast.NewIdent("retryQueue"),
+					ast.NewIdent("ch"),
+					factory.NewSelector("c", "queue"),
+					factory.NewSelector("policy", "Delay"),
+				),
+			),
+			factory.NewIfError(requeue("failed to declare retry queue")...),
+			// headers := amqp.Table{}
+			factory.NewDefine("headers", factory.NewCompositeLit(factory.NewSelector("amqp", "Table"))),
+			&ast.RangeStmt{
+				Key:   ast.NewIdent("k"),
+				Value: ast.NewIdent("v"),
+				Tok:   token.DEFINE,
+				X:     factory.NewSelector("msg", "Headers"),
+				Body: factory.NewBodyStmt(
+					&ast.AssignStmt{
+						Lhs: []ast.Expr{&ast.IndexExpr{X: ast.NewIdent("headers"), Index: ast.NewIdent("k")}},
+						Tok: token.ASSIGN,
+						Rhs: []ast.Expr{ast.NewIdent("v")},
+					},
+				),
+			},
+			&ast.AssignStmt{
+				Lhs: []ast.Expr{&ast.IndexExpr{X: ast.NewIdent("headers"), Index: ast.NewIdent("RetryCountHeader")}},
+				Tok: token.ASSIGN,
+				Rhs: []ast.Expr{
+					factory.NewCall(ast.NewIdent("int32"), &ast.BinaryExpr{
+						X:  ast.NewIdent("count"),
+						Op: token.ADD,
+						Y:  factory.NewBasicLitInt(1),
+					}),
+				},
+			},
+			&ast.AssignStmt{
+				Lhs: []ast.Expr{&ast.IndexExpr{X: ast.NewIdent("headers"), Index: ast.NewIdent("RoutingKeyHeader")}},
+				Tok: token.ASSIGN,
+				Rhs: []ast.Expr{ast.NewIdent("key")},
+			},
+			factory.NewAssignExpectsError(
+				factory.NewSelectorCall("ch", "Publish",
+					factory.NewBasicLit(""),
+					ast.NewIdent("queue"),
+					ast.NewIdent("false"),
+					ast.NewIdent("false"),
+					factory.NewCompositeLit(
+						factory.NewSelector("amqp", "Publishing"),
+						factory.NewKeyValue("ContentType", factory.NewSelector("msg", "ContentType")),
+						// Messages waiting out their delay survive a broker restart
+						factory.NewKeyValue("DeliveryMode", factory.NewSelector("amqp", "Persistent")),
+						factory.NewKeyValue("MessageId", factory.NewSelector("msg", "MessageId")),
+						factory.NewKeyValue("CorrelationId", factory.NewSelector("msg", "CorrelationId")),
+						factory.NewKeyValue("ReplyTo", factory.NewSelector("msg", "ReplyTo")),
+						factory.NewKeyValue("Headers", ast.NewIdent("headers")),
+						factory.NewKeyValue("Body", factory.NewSelector("msg", "Body")),
+					),
+				),
+			),
+			factory.NewIfError(requeue("failed to schedule retry")...),
+			logInfo("retrying event",
+				attr("event", ast.NewIdent("key")),
+				attr("correlation_id", factory.NewSelector("msg", "CorrelationId")),
+				attr("attempt", &ast.BinaryExpr{X: ast.NewIdent("count"), Op: token.ADD, Y: factory.NewBasicLitInt(1)}),
+				attr("max_retries", factory.NewSelector("policy", "MaxRetries")),
+				attr("delay", factory.NewSelector("policy", "Delay")),
+			),
+			factory.NewExprStmt(factory.NewSelectorCall("msg", "Ack", ast.NewIdent("false"))),
+		),
+	)
+
+	return &types.File{
+		Name: "retry.go",
+		Content: factory.NewFileNode("event",
+			imports,
+			headers,
+			retryPolicyDecl(),
+			setupDeadLetterFunc,
+			retryQueueFunc,
+			policyForFunc(),
+			topicMatchesFunc(),
+			matchWordsFunc("#", 0),
+			retryCountFunc,
+			routingKeyFunc,
+			retryFunc,
+		),
+	}
+}
+
+// retryPolicyDecl generates type RetryPolicy struct { MaxRetries int; Delay time.Duration }
+func retryPolicyDecl() *ast.GenDecl {
+	return factory.NewTypeStruct("RetryPolicy", factory.NewFieldList(
+		factory.NewField("MaxRetries", ast.NewIdent("int")),
+		factory.NewField("Delay", factory.NewSelector("time", "Duration")),
+	))
+}
+
+// policyForFunc generates policyFor: the retry policy of the binding matching a routing key
+func policyForFunc() *ast.FuncDecl {
+	return factory.NewFuncDecl(
+		"policyFor",
+		factory.NewFieldList(),
+		factory.NewFuncType(
+			factory.NewFieldList(factory.NewField("routingKey", ast.NewIdent("string"))),
+			factory.NewFieldList(factory.NewField("", ast.NewIdent("RetryPolicy"))),
+		),
+		factory.NewBodyStmt(
+			&ast.IfStmt{
+				Init: &ast.AssignStmt{
+					Lhs: []ast.Expr{ast.NewIdent("policy"), ast.NewIdent("ok")},
+					Tok: token.DEFINE,
+					Rhs: []ast.Expr{&ast.IndexExpr{X: ast.NewIdent("RetryPolicies"), Index: ast.NewIdent("routingKey")}},
+				},
+				Cond: ast.NewIdent("ok"),
+				Body: factory.NewBodyStmt(factory.NewReturn(ast.NewIdent("policy"))),
+			},
+			&ast.RangeStmt{
+				Key:   ast.NewIdent("pattern"),
+				Value: ast.NewIdent("policy"),
+				Tok:   token.DEFINE,
+				X:     ast.NewIdent("RetryPolicies"),
+				Body: factory.NewBodyStmt(
+					&ast.IfStmt{
+						Cond: factory.NewCall(ast.NewIdent("topicMatches"),
+							ast.NewIdent("pattern"),
+							ast.NewIdent("routingKey"),
+						),
+						Body: factory.NewBodyStmt(factory.NewReturn(ast.NewIdent("policy"))),
+					},
+				),
+			},
+			factory.NewReturn(factory.NewCompositeLit(ast.NewIdent("RetryPolicy"))),
+		),
+	)
+}
+
+// topicMatchesFunc generates topicMatches, which splits topics into words for matchWords
+func topicMatchesFunc() *ast.FuncDecl {
+	return factory.NewFuncDecl(
+		"topicMatches",
+		factory.NewFieldList(),
+		factory.NewFuncType(
+			factory.NewFieldList(&ast.Field{
+				Names: []*ast.Ident{ast.NewIdent("pattern"), ast.NewIdent("key")},
+				Type:  ast.NewIdent("string"),
+			}),
+			factory.NewFieldList(factory.NewField("", ast.NewIdent("bool"))),
+		),
+		factory.NewBodyStmt(
+			factory.NewReturn(
+				factory.NewCall(ast.NewIdent("matchWords"),
+					factory.NewSelectorCall("strings", "Split", ast.NewIdent("pattern"), factory.NewBasicLit(".")),
+					factory.NewSelectorCall("strings", "Split", ast.NewIdent("key"), factory.NewBasicLit(".")),
+				),
+			),
+		),
+	)
+}
+
+// matchWordsFunc generates matchWords: "*" matches exactly one word and multi
+// ("#" for RabbitMQ, ">" for NATS) matches at least minWords words
+func matchWordsFunc(multi string, minWords int) *ast.FuncDecl {
+	tail := func(name string) ast.Expr {
+		return &ast.SliceExpr{X: ast.NewIdent(name), Low: factory.NewBasicLitInt(1)}
+	}
+	first := func(name string) ast.Expr {
+		return &ast.IndexExpr{X: ast.NewIdent(name), Index: factory.NewBasicLitInt(0)}
+	}
+	isEmpty := func(name string) ast.Expr {
+		return &ast.BinaryExpr{X: factory.NewCall(ast.NewIdent("len"), ast.NewIdent(name)), Op: token.EQL, Y: factory.NewBasicLitInt(0)}
+	}
+
+	return factory.NewFuncDecl(
+		"matchWords",
+		factory.NewFieldList(),
+		factory.NewFuncType(
+			factory.NewFieldList(&ast.Field{
+				Names: []*ast.Ident{ast.NewIdent("pattern"), ast.NewIdent("key")},
+				Type:  &ast.ArrayType{Elt: ast.NewIdent("string")},
+			}),
+			factory.NewFieldList(factory.NewField("", ast.NewIdent("bool"))),
+		),
+		factory.NewBodyStmt(
+			&ast.IfStmt{
+				Cond: isEmpty("pattern"),
+				Body: factory.NewBodyStmt(factory.NewReturn(isEmpty("key"))),
+			},
+			&ast.IfStmt{
+				Cond: &ast.BinaryExpr{X: first("pattern"), Op: token.EQL, Y: factory.NewBasicLit(multi)},
+				Body: factory.NewBodyStmt(
+					&ast.ForStmt{
+						Init: factory.NewDefine("i", factory.NewBasicLitInt(minWords)),
+						Cond: &ast.BinaryExpr{
+							X:  ast.NewIdent("i"),
+							Op: token.LEQ,
+							Y:  factory.NewCall(ast.NewIdent("len"), ast.NewIdent("key")),
+						},
+						Post: &ast.IncDecStmt{X: ast.NewIdent("i"), Tok: token.INC},
+						Body: factory.NewBodyStmt(
+							&ast.IfStmt{
+								Cond: factory.NewCall(ast.NewIdent("matchWords"),
+									tail("pattern"),
+									&ast.SliceExpr{X: ast.NewIdent("key"), Low: ast.NewIdent("i")},
+								),
+								Body: factory.NewBodyStmt(factory.NewReturn(ast.NewIdent("true"))),
+							},
+						),
+					},
+					factory.NewReturn(ast.NewIdent("false")),
+				),
+			},
+			&ast.IfStmt{
+				Cond: &ast.BinaryExpr{
+					X:  isEmpty("key"),
+					Op: token.LOR,
+					Y: &ast.BinaryExpr{
+						X:  &ast.BinaryExpr{X: first("pattern"), Op: token.NEQ, Y: factory.NewBasicLit("*")},
+						Op: token.LAND,
+						Y:  &ast.BinaryExpr{X: first("pattern"), Op: token.NEQ, Y: first("key")},
+					},
+				},
+				Body: factory.NewBodyStmt(factory.NewReturn(ast.NewIdent("false"))),
+			},
+			factory.NewReturn(factory.NewCall(ast.NewIdent("matchWords"), tail("pattern"), tail("key"))),
+		),
+	)
+}
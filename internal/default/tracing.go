@@ -0,0 +1,404 @@
+package defaults
+
+import (
+	"go/ast"
+	"go/token"
+
+	"github.com/flaviogonzalez/instant-layer/internal/factory"
+	"github.com/flaviogonzalez/instant-layer/internal/types"
+)
+
+// TracingPackageName is the package setting up OpenTelemetry tracing
+const TracingPackageName = "tracing"
+
+// WithTracing traces a service with OpenTelemetry: spans are exported over
+// OTLP when OTEL_EXPORTER_OTLP_ENDPOINT is set, HTTP templates get the otelhttp
+// middleware and RabbitMQ services propagate the W3C trace context through
+// AMQP headers, from the emitter's publishing to the listener's reply.
+// gRPC and worker services ignore it.
+func WithTracing() Option {
+	return func(s *types.Service) {
+		s.Tracing = true
+	}
+}
+
+// WithTracingPackage adds the tracing package, with the HTTP middleware for HTTP templates
+func WithTracingPackage(http bool) Option {
+	return func(s *types.Service) {
+		s.Packages = append(s.Packages, TracingPackage(http))
+	}
+}
+
+// TracingPackage generates the tracing package: tracing.go with Setup and
+// http.go with the server middleware and the client transport
+func TracingPackage(http bool) *types.Package {
+	files := []*types.File{tracingSetupFile()}
+	if http {
+		files = append(files, tracingHTTPFile())
+	}
+	return &types.Package{Name: TracingPackageName, Files: files}
+}
+
+// tracesMessages reports whether the event package of s propagates the trace
+// context: only AMQP messages carry it, in their headers
+func tracesMessages(s *types.Service) bool {
+	return s.Tracing && TransportOf(s) == TransportRabbitMQ
+}
+
+// tracingSetupFile generates tracing.go with Setup, which always installs the
+// W3C trace-context propagator and only exports spans when an OTLP endpoint is configured
+func tracingSetupFile() *types.File {
+	imports := factory.NewImportDecl(
+		factory.NewImport("context", ""),
+		factory.NewImport("log/slog", ""),
+		factory.NewImport("os", ""),
+		factory.NewImport("go.opentelemetry.io/otel", ""),
+		factory.NewImport("go.opentelemetry.io/otel/attribute", ""),
+		factory.NewImport("go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp", ""),
+		factory.NewImport("go.opentelemetry.io/otel/propagation", ""),
+		factory.NewImport("go.opentelemetry.io/otel/sdk/resource", ""),
+		factory.NewImport("go.opentelemetry.io/otel/sdk/trace", "sdktrace"),
+	)
+
+	shutdownType := factory.NewFuncType(
+		factory.NewFieldList(factory.NewField("", factory.NewSelector("context", "Context"))),
+		factory.NewFieldList(factory.NewField("", ast.NewIdent("error"))),
+	)
+	noEndpoint := func(env string) ast.Expr {
+		return &ast.BinaryExpr{
+			X:  factory.NewSelectorCall("os", "Getenv", factory.NewBasicLit(env)),
+			Op: token.EQL,
+			Y:  factory.NewBasicLit(""),
+		}
+	}
+
+	// func Setup(service string) func(context.Context) error
+	setupFunc := factory.NewFuncDecl(
+		"Setup",
+		factory.NewFieldList(),
+		factory.NewFuncType(
+			factory.NewFieldList(factory.NewField("service", ast.NewIdent("string"))),
+			factory.NewFieldList(factory.NewField("", shutdownType)),
+		),
+		factory.NewBodyStmt(
+			// Incoming trace context is forwarded even when nothing is exported
+			factory.NewExprStmt(factory.NewSelectorCall("otel", "SetTextMapPropagator",
+				factory.NewSelectorCall("propagation", "NewCompositeTextMapPropagator",
+					factory.NewCompositeLit(factory.NewSelector("propagation", "TraceContext")),
+					factory.NewCompositeLit(factory.NewSelector("propagation", "Baggage")),
+				),
+			)),
+			factory.NewDefine("noop", factory.NewFuncLit(
+				factory.NewFuncType(
+					factory.NewFieldList(factory.NewField("_", factory.NewSelector("context", "Context"))),
+					factory.NewFieldList(factory.NewField("", ast.NewIdent("error"))),
+				),
+				factory.NewBodyStmt(factory.NewReturn(ast.NewIdent("nil"))),
+			)),
+			// The exporter reads the rest of its configuration from the OTEL_EXPORTER_OTLP_* variables
+			factory.NewIf(
+				&ast.BinaryExpr{
+					X:  noEndpoint("OTEL_EXPORTER_OTLP_ENDPOINT"),
+					Op: token.LAND,
+					Y:  noEndpoint("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"),
+				},
+				factory.NewReturn(ast.NewIdent("noop")),
+			),
+			factory.NewDefineExpectsError("exporter", factory.NewSelectorCall("otlptracehttp", "New",
+				factory.NewSelectorCall("context", "Background"),
+			)),
+			factory.NewIfError(
+				logError("tracing disabled: failed to create the OTLP exporter", errAttr()),
+				factory.NewReturn(ast.NewIdent("noop")),
+			),
+			factory.NewDefine("provider", factory.NewSelectorCall("sdktrace", "NewTracerProvider",
+				factory.NewSelectorCall("sdktrace", "WithBatcher", ast.NewIdent("exporter")),
+				factory.NewSelectorCall("sdktrace", "WithResource", factory.NewSelectorCall("resource", "NewSchemaless",
+					factory.NewSelectorCall("attribute", "String", factory.NewBasicLit("service.name"), ast.NewIdent("service")),
+				)),
+			)),
+			factory.NewExprStmt(factory.NewSelectorCall("otel", "SetTracerProvider", ast.NewIdent("provider"))),
+			factory.NewReturn(factory.NewSelector("provider", "Shutdown")),
+		),
+	)
+
+	return &types.File{
+		Name:    "tracing.go",
+		Content: factory.NewFileNode(TracingPackageName, imports, setupFunc),
+	}
+}
+
+// tracingHTTPFile generates http.go with Middleware, a server span per request
+// named after its chi route pattern, and Transport, which propagates the trace
+// context of outgoing requests
+func tracingHTTPFile() *types.File {
+	imports := factory.NewImportDecl(
+		factory.NewImport("net/http", ""),
+		factory.NewImport("github.com/go-chi/chi/v5", ""),
+		factory.NewImport("go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp", ""),
+		factory.NewImport("go.opentelemetry.io/otel/attribute", ""),
+		factory.NewImport("go.opentelemetry.io/otel/trace", ""),
+	)
+
+	handlerFunc := factory.NewFuncLit(
+		factory.NewFuncType(
+			factory.NewFieldList(
+				factory.NewField("w", factory.NewSelector("http", "ResponseWriter")),
+				factory.NewField("r", &ast.StarExpr{X: factory.NewSelector("http", "Request")}),
+			),
+			factory.NewFieldList(),
+		),
+		factory.NewBodyStmt(
+			factory.NewExprStmt(factory.NewSelectorCall("next", "ServeHTTP", ast.NewIdent("w"), ast.NewIdent("r"))),
+			// The pattern is only known once chi has routed the request
+			&ast.IfStmt{
+				Init: factory.NewDefine("rctx", factory.NewSelectorCall("chi", "RouteContext", factory.NewSelectorCall("r", "Context"))),
+				Cond: &ast.BinaryExpr{
+					X:  &ast.BinaryExpr{X: ast.NewIdent("rctx"), Op: token.NEQ, Y: ast.NewIdent("nil")},
+					Op: token.LAND,
+					Y:  &ast.BinaryExpr{X: factory.NewSelectorCall("rctx", "RoutePattern"), Op: token.NEQ, Y: factory.NewBasicLit("")},
+				},
+				Body: factory.NewBodyStmt(
+					factory.NewDefine("span", factory.NewSelectorCall("trace", "SpanFromContext", factory.NewSelectorCall("r", "Context"))),
+					factory.NewExprStmt(factory.NewSelectorCall("span", "SetName", &ast.BinaryExpr{
+						X:  &ast.BinaryExpr{X: factory.NewSelector("r", "Method"), Op: token.ADD, Y: factory.NewBasicLit(" ")},
+						Op: token.ADD,
+						Y:  factory.NewSelectorCall("rctx", "RoutePattern"),
+					})),
+					factory.NewExprStmt(factory.NewSelectorCall("span", "SetAttributes",
+						factory.NewSelectorCall("attribute", "String", factory.NewBasicLit("http.route"), factory.NewSelectorCall("rctx", "RoutePattern")),
+					)),
+				),
+			},
+		),
+	)
+
+	// func Middleware(next http.Handler) http.Handler
+	middlewareFunc := factory.NewFuncDecl(
+		"Middleware",
+		factory.NewFieldList(),
+		factory.NewFuncType(
+			factory.NewFieldList(factory.NewField("next", factory.NewSelector("http", "Handler"))),
+			factory.NewFieldList(factory.NewField("", factory.NewSelector("http", "Handler"))),
+		),
+		factory.NewBodyStmt(
+			factory.NewReturn(factory.NewSelectorCall("otelhttp", "NewHandler",
+				factory.NewCall(factory.NewSelector("http", "HandlerFunc"), handlerFunc),
+				factory.NewBasicLit("request"),
+			)),
+		),
+	)
+
+	// func Transport(base http.RoundTripper) http.RoundTripper
+	transportFunc := factory.NewFuncDecl(
+		"Transport",
+		factory.NewFieldList(),
+		factory.NewFuncType(
+			factory.NewFieldList(factory.NewField("base", factory.NewSelector("http", "RoundTripper"))),
+			factory.NewFieldList(factory.NewField("", factory.NewSelector("http", "RoundTripper"))),
+		),
+		factory.NewBodyStmt(
+			factory.NewReturn(factory.NewSelectorCall("otelhttp", "NewTransport", ast.NewIdent("base"))),
+		),
+	)
+
+	return &types.File{
+		Name:    "http.go",
+		Content: factory.NewFileNode(TracingPackageName, imports, middlewareFunc, transportFunc),
+	}
+}
+
+// eventTracingFile generates event/tracing.go for RabbitMQ services: the event
+// tracer and the carrier reading and writing the trace context in AMQP headers
+func eventTracingFile(s *types.Service) *types.File {
+	imports := factory.NewImportDecl(
+		factory.NewImport("context", ""),
+		factory.NewImport("github.com/rabbitmq/amqp091-go", "amqp"),
+		factory.NewImport("go.opentelemetry.io/otel", ""),
+	)
+
+	carrierRecv := factory.NewFieldList(factory.NewField("c", ast.NewIdent("headerCarrier")))
+
+	// func (c headerCarrier) Get(key string) string
+	getFunc := factory.NewFuncDecl(
+		"Get",
+		carrierRecv,
+		factory.NewFuncType(
+			factory.NewFieldList(factory.NewField("key", ast.NewIdent("string"))),
+			factory.NewFieldList(factory.NewField("", ast.NewIdent("string"))),
+		),
+		factory.NewBodyStmt(
+			&ast.AssignStmt{
+				Lhs: []ast.Expr{ast.NewIdent("value"), ast.NewIdent("_")},
+				Tok: token.DEFINE,
+				Rhs: []ast.Expr{&ast.TypeAssertExpr{
+					X:    &ast.IndexExpr{X: ast.NewIdent("c"), Index: ast.NewIdent("key")},
+					Type: ast.NewIdent("string"),
+				}},
+			},
+			factory.NewReturn(ast.NewIdent("value")),
+		),
+	)
+
+	// func (c headerCarrier) Set(key, value string)
+	setFunc := factory.NewFuncDecl(
+		"Set",
+		carrierRecv,
+		factory.NewFuncType(
+			factory.NewFieldList(
+				factory.NewField("key", ast.NewIdent("string")),
+				factory.NewField("value", ast.NewIdent("string")),
+			),
+			factory.NewFieldList(),
+		),
+		factory.NewBodyStmt(
+			factory.NewAssign(&ast.IndexExpr{X: ast.NewIdent("c"), Index: ast.NewIdent("key")}, ast.NewIdent("value")),
+		),
+	)
+
+	// func (c headerCarrier) Keys() []string
+	keysFunc := factory.NewFuncDecl(
+		"Keys",
+		carrierRecv,
+		factory.NewFuncType(
+			factory.NewFieldList(),
+			factory.NewFieldList(factory.NewField("", &ast.ArrayType{Elt: ast.NewIdent("string")})),
+		),
+		factory.NewBodyStmt(
+			factory.NewDefine("keys", factory.NewCall(ast.NewIdent("make"),
+				&ast.ArrayType{Elt: ast.NewIdent("string")},
+				factory.NewBasicLitInt(0),
+				factory.NewCall(ast.NewIdent("len"), ast.NewIdent("c")),
+			)),
+			&ast.RangeStmt{
+				Key: ast.NewIdent("key"),
+				Tok: token.DEFINE,
+				X:   ast.NewIdent("c"),
+				Body: factory.NewBodyStmt(
+					factory.NewAssign(ast.NewIdent("keys"), factory.NewCall(ast.NewIdent("append"), ast.NewIdent("keys"), ast.NewIdent("key"))),
+				),
+			},
+			factory.NewReturn(ast.NewIdent("keys")),
+		),
+	)
+
+	// func injectTrace(ctx context.Context) amqp.Table
+	injectFunc := factory.NewFuncDecl(
+		"injectTrace",
+		factory.NewFieldList(),
+		factory.NewFuncType(
+			factory.NewFieldList(factory.NewField("ctx", factory.NewSelector("context", "Context"))),
+			factory.NewFieldList(factory.NewField("", factory.NewSelector("amqp", "Table"))),
+		),
+		factory.NewBodyStmt(
+			factory.NewDefine("headers", factory.NewCompositeLit(factory.NewSelector("amqp", "Table"))),
+			factory.NewExprStmt(factory.NewCall(
+				&ast.SelectorExpr{X: factory.NewSelectorCall("otel", "GetTextMapPropagator"), Sel: ast.NewIdent("Inject")},
+				ast.NewIdent("ctx"),
+				factory.NewCall(ast.NewIdent("headerCarrier"), ast.NewIdent("headers")),
+			)),
+			factory.NewReturn(ast.NewIdent("headers")),
+		),
+	)
+
+	// func extractTrace(ctx context.Context, headers amqp.Table) context.Context
+	extractFunc := factory.NewFuncDecl(
+		"extractTrace",
+		factory.NewFieldList(),
+		factory.NewFuncType(
+			factory.NewFieldList(
+				factory.NewField("ctx", factory.NewSelector("context", "Context")),
+				factory.NewField("headers", factory.NewSelector("amqp", "Table")),
+			),
+			factory.NewFieldList(factory.NewField("", factory.NewSelector("context", "Context"))),
+		),
+		factory.NewBodyStmt(
+			factory.NewReturn(factory.NewCall(
+				&ast.SelectorExpr{X: factory.NewSelectorCall("otel", "GetTextMapPropagator"), Sel: ast.NewIdent("Extract")},
+				ast.NewIdent("ctx"),
+				factory.NewCall(ast.NewIdent("headerCarrier"), ast.NewIdent("headers")),
+			)),
+		),
+	)
+
+	return &types.File{
+		Name: "tracing.go",
+		Content: factory.NewFileNode("event",
+			imports,
+			&ast.GenDecl{Tok: token.VAR, Specs: []ast.Spec{&ast.ValueSpec{
+				Names:  []*ast.Ident{ast.NewIdent("tracer")},
+				Values: []ast.Expr{factory.NewSelectorCall("otel", "Tracer", factory.NewBasicLit(s.Name+"/event"))},
+			}}},
+			// headerCarrier adapts AMQP headers to the propagators' TextMapCarrier
+			&ast.GenDecl{Tok: token.TYPE, Specs: []ast.Spec{&ast.TypeSpec{
+				Name: ast.NewIdent("headerCarrier"),
+				Type: factory.NewSelector("amqp", "Table"),
+			}}},
+			getFunc,
+			setFunc,
+			keysFunc,
+			injectFunc,
+			extractFunc,
+		),
+	}
+}
+
+// startSpanStmts generates ctx, span := tracer.Start(<parent>, <name>, trace.WithSpanKind(trace.<kind>)); defer span.End()
+func startSpanStmts(ctx string, parent, name ast.Expr, kind string) []ast.Stmt {
+	return []ast.Stmt{
+		&ast.AssignStmt{
+			Lhs: []ast.Expr{ast.NewIdent(ctx), ast.NewIdent("span")},
+			Tok: token.DEFINE,
+			Rhs: []ast.Expr{factory.NewSelectorCall("tracer", "Start",
+				parent,
+				name,
+				factory.NewSelectorCall("trace", "WithSpanKind", factory.NewSelector("trace", kind)),
+			)},
+		},
+		&ast.DeferStmt{Call: factory.NewSelectorCall("span", "End")},
+	}
+}
+
+// spanName generates "<operation> " + <name>, the messaging span naming convention
+func spanName(operation string, name ast.Expr) ast.Expr {
+	return &ast.BinaryExpr{X: factory.NewBasicLit(operation + " "), Op: token.ADD, Y: name}
+}
+
+// contextField is the ctx context.Context parameter the traced event API takes first
+func contextField() *ast.Field {
+	return factory.NewField("ctx", factory.NewSelector("context", "Context"))
+}
+
+// traceImport is the OpenTelemetry trace API, imported next to the transport
+func traceImport() *ast.ImportSpec {
+	return factory.NewImport("go.opentelemetry.io/otel/trace", "")
+}
+
+// tracingMiddlewareStmt generates mux.Use(tracing.Middleware), placed after the
+// probes and /metrics so they do not produce spans
+func tracingMiddlewareStmt() ast.Stmt {
+	return factory.NewExprStmt(factory.NewSelectorCall("mux", "Use", factory.NewSelector(TracingPackageName, "Middleware")))
+}
+
+// tracingSetupStmts generates shutdown := tracing.Setup("<service>"); defer shutdown(context.Background()),
+// right after the logger setup in main. Nil when the service is not traced.
+func tracingSetupStmts(s *types.Service) []ast.Stmt {
+	if !s.Tracing {
+		return nil
+	}
+	return []ast.Stmt{
+		factory.NewDefine("shutdown", factory.NewSelectorCall(TracingPackageName, "Setup", factory.NewBasicLit(s.Name))),
+		&ast.DeferStmt{Call: factory.NewCall(ast.NewIdent("shutdown"), factory.NewSelectorCall("context", "Background"))},
+	}
+}
+
+// tracingMainImports returns the imports tracingSetupStmts needs in main
+func tracingMainImports(s *types.Service) []*ast.ImportSpec {
+	if !s.Tracing {
+		return nil
+	}
+	return []*ast.ImportSpec{
+		factory.NewImport("context", ""),
+		factory.NewImport(s.Name+"/"+TracingPackageName, ""),
+	}
+}
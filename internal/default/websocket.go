@@ -0,0 +1,685 @@
+package defaults
+
+import (
+	"go/ast"
+	"go/token"
+
+	"github.com/flaviogonzalez/instant-layer/internal/factory"
+	"github.com/flaviogonzalez/instant-layer/internal/types"
+)
+
+// WebSocketPath is the route upgraded to a websocket connection
+const WebSocketPath = "/ws"
+
+// WebSocketService creates a websocket service with appropriate options.
+// With messaging configured, events consumed from the broker are forwarded
+// to every connected client through the listener consumer.
+func WebSocketService(opts ...Option) *types.Service {
+	s := &types.Service{Port: 8083}
+
+	// Apply user options first
+	for _, o := range opts {
+		o(s)
+	}
+
+	// The upgrade route is recorded so ws:// connections validate against it
+	s.RoutesConfig = &types.RoutesConfig{
+		RoutesGroup: []*types.RoutesGroup{
+			{Routes: []*types.Route{{Path: WebSocketPath, Method: "GET", Handler: "ServeWS"}}},
+		},
+	}
+
+	// WebSocket services need: hub, routes, config, main (and the consumer when bridging)
+	baseOpts := []Option{
+		WithWebSocketHub(),
+		WithWebSocketRoutes(),
+		WithWebSocketConfig(),
+		WithWebSocketMain(),
+		WithHTTPLogger(),
+		WithHealth(),
+	}
+	if s.Metrics {
+		baseOpts = append(baseOpts, WithMetricsPackage(true))
+	}
+	if s.Tracing {
+		baseOpts = append(baseOpts, WithTracingPackage(true))
+	}
+	if s.Messaging != nil {
+		baseOpts = append(baseOpts, WithListenerEvent())
+	}
+
+	return applyOptions(s, baseOpts...)
+}
+
+// WebSocketHubPackage generates the hub package
+// Contains: hub.go (register/unregister/broadcast loop), client.go (read/write pumps)
+// and, with messaging configured, bridge.go (event handlers broadcasting to clients)
+func WebSocketHubPackage(s *types.Service) *types.Package {
+	files := []*types.File{webSocketHubFile(), webSocketClientFile()}
+	if s.Messaging != nil {
+		files = append(files, webSocketBridgeFile())
+	}
+	return &types.Package{Name: "hub", Files: files}
+}
+
+// webSocketHubFile generates hub.go: the Hub owns the clients and serialises
+// registrations, unregistrations and broadcasts through its Run loop
+func webSocketHubFile() *types.File {
+	clientChan := func() ast.Expr {
+		return &ast.ChanType{Dir: ast.SEND | ast.RECV, Value: &ast.StarExpr{X: ast.NewIdent("Client")}}
+	}
+	clientsMap := func() ast.Expr {
+		return &ast.MapType{Key: &ast.StarExpr{X: ast.NewIdent("Client")}, Value: ast.NewIdent("bool")}
+	}
+	bytesChan := func() ast.Expr {
+		return &ast.ChanType{Dir: ast.SEND | ast.RECV, Value: &ast.ArrayType{Elt: ast.NewIdent("byte")}}
+	}
+
+	// type Hub struct { clients map[*Client]bool; broadcast chan []byte; register, unregister chan *Client }
+	hubStruct := factory.NewTypeStruct("Hub", factory.NewFieldList(
+		factory.NewField("clients", clientsMap()),
+		factory.NewField("broadcast", bytesChan()),
+		factory.NewField("register", clientChan()),
+		factory.NewField("unregister", clientChan()),
+	))
+
+	// func NewHub() *Hub
+	newHubFunc := factory.NewFuncDecl(
+		"NewHub",
+		factory.NewFieldList(),
+		factory.NewFuncType(
+			factory.NewFieldList(),
+			factory.NewFieldList(factory.NewField("", &ast.StarExpr{X: ast.NewIdent("Hub")})),
+		),
+		factory.NewBodyStmt(
+			factory.NewReturn(factory.NewAddressOf(factory.NewCompositeLit(ast.NewIdent("Hub"),
+				factory.NewKeyValue("clients", factory.NewCall(ast.NewIdent("make"), clientsMap())),
+				factory.NewKeyValue("broadcast", factory.NewCall(ast.NewIdent("make"), bytesChan())),
+				factory.NewKeyValue("register", factory.NewCall(ast.NewIdent("make"), clientChan())),
+				factory.NewKeyValue("unregister", factory.NewCall(ast.NewIdent("make"), clientChan())),
+			))),
+		),
+	)
+
+	// delete(h.clients, client); close(client.send)
+	dropClient := func() []ast.Stmt {
+		return []ast.Stmt{
+			factory.NewExprStmt(factory.NewCall(ast.NewIdent("delete"), factory.NewSelector("h", "clients"), ast.NewIdent("client"))),
+			factory.NewExprStmt(factory.NewCall(ast.NewIdent("close"), factory.NewSelector("client", "send"))),
+		}
+	}
+
+	// func (h *Hub) Run()
+	runFunc := factory.NewFuncDecl(
+		"Run",
+		factory.NewFieldList(factory.NewField("h", &ast.StarExpr{X: ast.NewIdent("Hub")})),
+		factory.NewFuncType(factory.NewFieldList(), factory.NewFieldList()),
+		factory.NewBodyStmt(
+			&ast.ForStmt{Body: factory.NewBodyStmt(
+				&ast.SelectStmt{Body: factory.NewBodyStmt(
+					// case client := <-h.register:
+					&ast.CommClause{
+						Comm: factory.NewDefine("client", &ast.UnaryExpr{Op: token.ARROW, X: factory.NewSelector("h", "register")}),
+						Body: []ast.Stmt{
+							&ast.AssignStmt{
+								Lhs: []ast.Expr{&ast.IndexExpr{X: factory.NewSelector("h", "clients"), Index: ast.NewIdent("client")}},
+								Tok: token.ASSIGN,
+								Rhs: []ast.Expr{ast.NewIdent("true")},
+							},
+						},
+					},
+					// case client := <-h.unregister:
+					&ast.CommClause{
+						Comm: factory.NewDefine("client", &ast.UnaryExpr{Op: token.ARROW, X: factory.NewSelector("h", "unregister")}),
+						Body: []ast.Stmt{
+							&ast.IfStmt{
+								Init: &ast.AssignStmt{
+									Lhs: []ast.Expr{ast.NewIdent("_"), ast.NewIdent("ok")},
+									Tok: token.DEFINE,
+									Rhs: []ast.Expr{&ast.IndexExpr{X: factory.NewSelector("h", "clients"), Index: ast.NewIdent("client")}},
+								},
+								Cond: ast.NewIdent("ok"),
+								Body: factory.NewBodyStmt(dropClient()...),
+							},
+						},
+					},
+					// case message := <-h.broadcast:
+					&ast.CommClause{
+						Comm: factory.NewDefine("message", &ast.UnaryExpr{Op: token.ARROW, X: factory.NewSelector("h", "broadcast")}),
+						Body: []ast.Stmt{
+							&ast.RangeStmt{
+								Key: ast.NewIdent("client"),
+								Tok: token.DEFINE,
+								X:   factory.NewSelector("h", "clients"),
+								Body: factory.NewBodyStmt(
+									&ast.SelectStmt{Body: factory.NewBodyStmt(
+										&ast.CommClause{
+											Comm: &ast.SendStmt{Chan: factory.NewSelector("client", "send"), Value: ast.NewIdent("message")},
+										},
+										// Clients too slow to keep up are dropped rather than blocking the hub
+										&ast.CommClause{Body: dropClient()},
+									)},
+								),
+							},
+						},
+					},
+				)},
+			)},
+		),
+	)
+
+	// func (h *Hub) Broadcast(message []byte)
+	broadcastFunc := factory.NewFuncDecl(
+		"Broadcast",
+		factory.NewFieldList(factory.NewField("h", &ast.StarExpr{X: ast.NewIdent("Hub")})),
+		factory.NewFuncType(
+			factory.NewFieldList(factory.NewField("message", &ast.ArrayType{Elt: ast.NewIdent("byte")})),
+			factory.NewFieldList(),
+		),
+		factory.NewBodyStmt(
+			&ast.SendStmt{Chan: factory.NewSelector("h", "broadcast"), Value: ast.NewIdent("message")},
+		),
+	)
+
+	return &types.File{
+		Name:    "hub.go",
+		Content: factory.NewFileNode("hub", hubStruct, newHubFunc, runFunc, broadcastFunc),
+	}
+}
+
+// webSocketClientFile generates client.go: the upgrade handler and the per-connection
+// read and write pumps, with ping/pong keeping the read deadline alive
+func webSocketClientFile() *types.File {
+	imports := factory.NewImportDecl(
+		factory.NewImport("log/slog", ""),
+		factory.NewImport("net/http", ""),
+		factory.NewImport("time", ""),
+		factory.NewImport("github.com/gorilla/websocket", ""),
+	)
+
+	timeouts := &ast.GenDecl{
+		Tok:    token.CONST,
+		Lparen: 1,
+		Specs: []ast.Spec{
+			// writeWait bounds a single write
+			&ast.ValueSpec{
+				Names:  []*ast.Ident{ast.NewIdent("writeWait")},
+				Values: []ast.Expr{&ast.BinaryExpr{X: factory.NewBasicLitInt(10), Op: token.MUL, Y: factory.NewSelector("time", "Second")}},
+			},
+			// pongWait is how long a client may stay silent before it's dropped
+			&ast.ValueSpec{
+				Names:  []*ast.Ident{ast.NewIdent("pongWait")},
+				Values: []ast.Expr{&ast.BinaryExpr{X: factory.NewBasicLitInt(60), Op: token.MUL, Y: factory.NewSelector("time", "Second")}},
+			},
+			// pingPeriod must be shorter than pongWait
+			&ast.ValueSpec{
+				Names: []*ast.Ident{ast.NewIdent("pingPeriod")},
+				Values: []ast.Expr{&ast.BinaryExpr{
+					X:  &ast.ParenExpr{X: &ast.BinaryExpr{X: ast.NewIdent("pongWait"), Op: token.MUL, Y: factory.NewBasicLitInt(9)}},
+					Op: token.QUO,
+					Y:  factory.NewBasicLitInt(10),
+				}},
+			},
+			&ast.ValueSpec{
+				Names:  []*ast.Ident{ast.NewIdent("maxMessageSize")},
+				Values: []ast.Expr{factory.NewBasicLitInt(4096)},
+			},
+		},
+		Rparen: 1,
+	}
+
+	// var upgrader = websocket.Upgrader{ReadBufferSize: 1024, WriteBufferSize: 1024}
+	upgraderVar := factory.NewVarDecl("upgrader", nil)
+	upgraderVar.Specs[0].(*ast.ValueSpec).Values = []ast.Expr{
+		factory.NewCompositeLit(factory.NewSelector("websocket", "Upgrader"),
+			factory.NewKeyValue("ReadBufferSize", factory.NewBasicLitInt(1024)),
+			factory.NewKeyValue("WriteBufferSize", factory.NewBasicLitInt(1024)),
+		),
+	}
+
+	// type Client struct { hub *Hub; conn *websocket.Conn; send chan []byte }
+	clientStruct := factory.NewTypeStruct("Client", factory.NewFieldList(
+		factory.NewField("hub", &ast.StarExpr{X: ast.NewIdent("Hub")}),
+		factory.NewField("conn", &ast.StarExpr{X: factory.NewSelector("websocket", "Conn")}),
+		factory.NewField("send", &ast.ChanType{Dir: ast.SEND | ast.RECV, Value: &ast.ArrayType{Elt: ast.NewIdent("byte")}}),
+	))
+
+	// func (h *Hub) ServeWS(w http.ResponseWriter, r *http.Request)
+	serveWSFunc := factory.NewFuncDecl(
+		"ServeWS",
+		factory.NewFieldList(factory.NewField("h", &ast.StarExpr{X: ast.NewIdent("Hub")})),
+		factory.NewFuncType(
+			factory.NewFieldList(
+				factory.NewField("w", factory.NewSelector("http", "ResponseWriter")),
+				factory.NewField("r", &ast.StarExpr{X: factory.NewSelector("http", "Request")}),
+			),
+			factory.NewFieldList(),
+		),
+		factory.NewBodyStmt(
+			factory.NewDefineExpectsError("conn", factory.NewSelectorCall("upgrader", "Upgrade",
+				ast.NewIdent("w"), ast.NewIdent("r"), ast.NewIdent("nil"),
+			)),
+			factory.NewIfError(
+				logWarn("websocket upgrade failed", errAttr()),
+				factory.NewReturn(),
+			),
+			factory.NewDefine("client", factory.NewAddressOf(factory.NewCompositeLit(ast.NewIdent("Client"),
+				factory.NewKeyValue("hub", ast.NewIdent("h")),
+				factory.NewKeyValue("conn", ast.NewIdent("conn")),
+				factory.NewKeyValue("send", factory.NewCall(ast.NewIdent("make"),
+					&ast.ChanType{Dir: ast.SEND | ast.RECV, Value: &ast.ArrayType{Elt: ast.NewIdent("byte")}},
+					factory.NewBasicLitInt(256),
+				)),
+			))),
+			&ast.SendStmt{Chan: factory.NewSelector("h", "register"), Value: ast.NewIdent("client")},
+			&ast.GoStmt{Call: factory.NewSelectorCall("client", "writePump")},
+			&ast.GoStmt{Call: factory.NewSelectorCall("client", "readPump")},
+		),
+	)
+
+	// time.Now().Add(d)
+	deadline := func(d string) ast.Expr {
+		return factory.NewCall(
+			&ast.SelectorExpr{X: factory.NewSelectorCall("time", "Now"), Sel: ast.NewIdent("Add")},
+			ast.NewIdent(d),
+		)
+	}
+
+	// func (c *Client) readPump()
+	readPumpFunc := factory.NewFuncDecl(
+		"readPump",
+		factory.NewFieldList(factory.NewField("c", &ast.StarExpr{X: ast.NewIdent("Client")})),
+		factory.NewFuncType(factory.NewFieldList(), factory.NewFieldList()),
+		factory.NewBodyStmt(
+			&ast.DeferStmt{Call: factory.NewCall(factory.NewFuncLit(
+				factory.NewFuncType(factory.NewFieldList(), factory.NewFieldList()),
+				factory.NewBodyStmt(
+					&ast.SendStmt{Chan: factory.NewSelector("c", "hub.unregister"), Value: ast.NewIdent("c")},
+					factory.NewExprStmt(factory.NewSelectorCall("c", "conn.Close")),
+				),
+			))},
+			factory.NewExprStmt(factory.NewSelectorCall("c", "conn.SetReadLimit", ast.NewIdent("maxMessageSize"))),
+			factory.NewExprStmt(factory.NewSelectorCall("c", "conn.SetReadDeadline", deadline("pongWait"))),
+			// Every pong pushes the read deadline back
+			factory.NewExprStmt(factory.NewSelectorCall("c", "conn.SetPongHandler", factory.NewFuncLit(
+				factory.NewFuncType(
+					factory.NewFieldList(factory.NewField("", ast.NewIdent("string"))),
+					factory.NewFieldList(factory.NewField("", ast.NewIdent("error"))),
+				),
+				factory.NewBodyStmt(
+					factory.NewReturn(factory.NewSelectorCall("c", "conn.SetReadDeadline", deadline("pongWait"))),
+				),
+			))),
+			&ast.ForStmt{Body: factory.NewBodyStmt(
+				&ast.AssignStmt{
+					Lhs: []ast.Expr{ast.NewIdent("_"), ast.NewIdent("message"), ast.NewIdent("err")},
+					Tok: token.DEFINE,
+					Rhs: []ast.Expr{factory.NewSelectorCall("c", "conn.ReadMessage")},
+				},
+				factory.NewIfError(
+					factory.NewIf(
+						factory.NewSelectorCall("websocket", "IsUnexpectedCloseError",
+							ast.NewIdent("err"),
+							factory.NewSelector("websocket", "CloseGoingAway"),
+							factory.NewSelector("websocket", "CloseAbnormalClosure"),
+						),
+						logWarn("websocket closed unexpectedly", errAttr()),
+					),
+					factory.NewReturn(),
+				),
+				factory.NewExprStmt(factory.NewSelectorCall("c", "hub.Broadcast", ast.NewIdent("message"))),
+			)},
+		),
+	)
+
+	// if err := c.conn.WriteMessage(kind, payload); err != nil { return }
+	write := func(kind string, payload ast.Expr) ast.Stmt {
+		return withInit(
+			factory.NewIfError(factory.NewReturn()),
+			factory.NewDefine("err", factory.NewSelectorCall("c", "conn.WriteMessage",
+				factory.NewSelector("websocket", kind), payload,
+			)),
+		)
+	}
+
+	// func (c *Client) writePump()
+	writePumpFunc := factory.NewFuncDecl(
+		"writePump",
+		factory.NewFieldList(factory.NewField("c", &ast.StarExpr{X: ast.NewIdent("Client")})),
+		factory.NewFuncType(factory.NewFieldList(), factory.NewFieldList()),
+		factory.NewBodyStmt(
+			factory.NewDefine("ticker", factory.NewSelectorCall("time", "NewTicker", ast.NewIdent("pingPeriod"))),
+			&ast.DeferStmt{Call: factory.NewCall(factory.NewFuncLit(
+				factory.NewFuncType(factory.NewFieldList(), factory.NewFieldList()),
+				factory.NewBodyStmt(
+					factory.NewExprStmt(factory.NewSelectorCall("ticker", "Stop")),
+					factory.NewExprStmt(factory.NewSelectorCall("c", "conn.Close")),
+				),
+			))},
+			&ast.ForStmt{Body: factory.NewBodyStmt(
+				&ast.SelectStmt{Body: factory.NewBodyStmt(
+					// case message, ok := <-c.send:
+					&ast.CommClause{
+						Comm: &ast.AssignStmt{
+							Lhs: []ast.Expr{ast.NewIdent("message"), ast.NewIdent("ok")},
+							Tok: token.DEFINE,
+							Rhs: []ast.Expr{&ast.UnaryExpr{Op: token.ARROW, X: factory.NewSelector("c", "send")}},
+						},
+						Body: []ast.Stmt{
+							factory.NewExprStmt(factory.NewSelectorCall("c", "conn.SetWriteDeadline", deadline("writeWait"))),
+							// The hub closed the channel: say goodbye
+							factory.NewIf(
+								&ast.UnaryExpr{Op: token.NOT, X: ast.NewIdent("ok")},
+								factory.NewExprStmt(factory.NewSelectorCall("c", "conn.WriteMessage",
+									factory.NewSelector("websocket", "CloseMessage"),
+									factory.NewCompositeLit(&ast.ArrayType{Elt: ast.NewIdent("byte")}),
+								)),
+								factory.NewReturn(),
+							),
+							write("TextMessage", ast.NewIdent("message")),
+						},
+					},
+					// case <-ticker.C:
+					&ast.CommClause{
+						Comm: factory.NewExprStmt(&ast.UnaryExpr{Op: token.ARROW, X: factory.NewSelector("ticker", "C")}),
+						Body: []ast.Stmt{
+							factory.NewExprStmt(factory.NewSelectorCall("c", "conn.SetWriteDeadline", deadline("writeWait"))),
+							write("PingMessage", ast.NewIdent("nil")),
+						},
+					},
+				)},
+			)},
+		),
+	)
+
+	return &types.File{
+		Name: "client.go",
+		Content: factory.NewFileNode("hub",
+			imports,
+			timeouts,
+			upgraderVar,
+			clientStruct,
+			serveWSFunc,
+			readPumpFunc,
+			writePumpFunc,
+		),
+	}
+}
+
+// webSocketBridgeFile generates bridge.go: event handlers, in the shape the listener
+// consumer dispatches through, that broadcast each event to every connected client
+func webSocketBridgeFile() *types.File {
+	imports := factory.NewImportDecl(
+		factory.NewImport("encoding/json", ""),
+	)
+
+	handlerFunc := func() *ast.FuncType {
+		return factory.NewFuncType(
+			factory.NewFieldList(factory.NewField("", factory.NewSelector("json", "RawMessage"))),
+			factory.NewFieldList(
+				factory.NewField("", &ast.ArrayType{Elt: ast.NewIdent("byte")}),
+				factory.NewField("", ast.NewIdent("error")),
+			),
+		)
+	}
+	handlersMap := func() ast.Expr {
+		return &ast.MapType{Key: ast.NewIdent("string"), Value: handlerFunc()}
+	}
+
+	// type Message struct { Name string `json:"name"`; Data json.RawMessage `json:"data"` }
+	messageStruct := factory.NewStructDecl("Message",
+		factory.NewStructField("Name", ast.NewIdent("string"), `json:"name"`),
+		factory.NewStructField("Data", factory.NewSelector("json", "RawMessage"), `json:"data"`),
+	)
+
+	// func (h *Hub) Bridge(names ...string) map[string]func(json.RawMessage) ([]byte, error)
+	bridgeFunc := factory.NewFuncDecl(
+		"Bridge",
+		factory.NewFieldList(factory.NewField("h", &ast.StarExpr{X: ast.NewIdent("Hub")})),
+		factory.NewFuncType(
+			factory.NewFieldList(factory.NewField("names", &ast.Ellipsis{Elt: ast.NewIdent("string")})),
+			factory.NewFieldList(factory.NewField("", handlersMap())),
+		),
+		factory.NewBodyStmt(
+			factory.NewDefine("handlers", factory.NewCall(ast.NewIdent("make"),
+				handlersMap(),
+				factory.NewCall(ast.NewIdent("len"), ast.NewIdent("names")),
+			)),
+			&ast.RangeStmt{
+				Key:   ast.NewIdent("_"),
+				Value: ast.NewIdent("name"),
+				Tok:   token.DEFINE,
+				X:     ast.NewIdent("names"),
+				Body: factory.NewBodyStmt(
+					&ast.AssignStmt{
+						Lhs: []ast.Expr{&ast.IndexExpr{X: ast.NewIdent("handlers"), Index: ast.NewIdent("name")}},
+						Tok: token.ASSIGN,
+						Rhs: []ast.Expr{factory.NewFuncLit(
+							factory.NewFuncType(
+								factory.NewFieldList(factory.NewField("data", factory.NewSelector("json", "RawMessage"))),
+								factory.NewFieldList(
+									factory.NewField("", &ast.ArrayType{Elt: ast.NewIdent("byte")}),
+									factory.NewField("", ast.NewIdent("error")),
+								),
+							),
+							factory.NewBodyStmt(
+								factory.NewDefineExpectsError("message", factory.NewSelectorCall("json", "Marshal",
+									factory.NewCompositeLit(ast.NewIdent("Message"),
+										factory.NewKeyValue("Name", ast.NewIdent("name")),
+										factory.NewKeyValue("Data", ast.NewIdent("data")),
+									),
+								)),
+								factory.NewIfError(factory.NewReturn(ast.NewIdent("nil"), ast.NewIdent("err"))),
+								factory.NewExprStmt(factory.NewSelectorCall("h", "Broadcast", ast.NewIdent("message"))),
+								factory.NewReturn(ast.NewIdent("nil"), ast.NewIdent("nil")),
+							),
+						)},
+					},
+				),
+			},
+			factory.NewReturn(ast.NewIdent("handlers")),
+		),
+	)
+
+	return &types.File{
+		Name:    "bridge.go",
+		Content: factory.NewFileNode("hub", imports, messageStruct, bridgeFunc),
+	}
+}
+
+// WebSocketRoutesFile generates routes.go mounting the upgrade handler
+func WebSocketRoutesFile(s *types.Service) *types.File {
+	importSpecs := []*ast.ImportSpec{
+		factory.NewImport(s.Name+"/"+HealthPackageName, ""),
+		factory.NewImport(s.Name+"/hub", ""),
+		factory.NewImport(s.Name+"/"+LoggerPackageName, ""),
+		factory.NewImport("net/http", ""),
+		factory.NewImport("github.com/go-chi/chi/v5", ""),
+		factory.NewImport("github.com/go-chi/chi/v5/middleware", ""),
+	}
+	stmts := []ast.Stmt{
+		factory.NewDefine("mux", factory.NewSelectorCall("chi", "NewRouter")),
+		probesStmt(),
+	}
+	if s.Metrics {
+		importSpecs = append(importSpecs, factory.NewImport(s.Name+"/"+MetricsPackageName, ""))
+		stmts = append(stmts, metricsStmt())
+	}
+	if s.Tracing {
+		importSpecs = append(importSpecs, factory.NewImport(s.Name+"/"+TracingPackageName, ""))
+		stmts = append(stmts, tracingMiddlewareStmt())
+	}
+	imports := factory.NewImportDecl(importSpecs...)
+
+	stmts = append(stmts,
+		factory.NewExprStmt(factory.NewSelectorCall("mux", "Use",
+			factory.NewSelector("middleware", "RequestID"),
+			factory.NewSelector(LoggerPackageName, "Requests"),
+		)),
+		factory.NewExprStmt(factory.NewSelectorCall("mux", "Get",
+			factory.NewBasicLit(WebSocketPath),
+			factory.NewSelector("h", "ServeWS"),
+		)),
+		factory.NewReturn(ast.NewIdent("mux")),
+	)
+
+	// func Routes(h *hub.Hub) http.Handler
+	routesFunc := factory.NewFuncDecl(
+		"Routes",
+		factory.NewFieldList(),
+		factory.NewFuncType(
+			factory.NewFieldList(factory.NewField("h", &ast.StarExpr{X: factory.NewSelector("hub", "Hub")})),
+			factory.NewFieldList(factory.NewField("", factory.NewSelector("http", "Handler"))),
+		),
+		factory.NewBodyStmt(stmts...),
+	)
+
+	return &types.File{
+		Name:    "routes.go",
+		Content: factory.NewFileNode("routes", imports, routesFunc),
+	}
+}
+
+// WebSocketConfigFile generates config.go for a websocket service: runs the hub,
+// the bridge from the broker when messaging is configured, and the HTTP server
+func WebSocketConfigFile(s *types.Service) *types.File {
+	bridge := s.Messaging != nil
+	t := transportFor(s)
+
+	importSpecs := []*ast.ImportSpec{
+		factory.NewImport(s.Name+"/hub", ""),
+		factory.NewImport(s.Name+"/routes", ""),
+		factory.NewImport("log/slog", ""),
+		factory.NewImport("net/http", ""),
+		factory.NewImport("os", ""),
+	}
+	if bridge {
+		importSpecs = append(importSpecs,
+			factory.NewImport(s.Name+"/event", ""),
+			factory.NewImport(s.Name+"/"+HealthPackageName, ""),
+		)
+		importSpecs = append(importSpecs, t.imports...)
+		if len(s.Events) > 0 {
+			importSpecs = append(importSpecs, factory.NewImport(EventsModule, ""))
+		}
+	}
+	imports := factory.NewImportDecl(importSpecs...)
+
+	fields := []*ast.Field{factory.NewField("Hub", &ast.StarExpr{X: factory.NewSelector("hub", "Hub")}), envField()}
+	values := []ast.Expr{
+		factory.NewKeyValue("Hub", factory.NewSelectorCall("hub", "NewHub")),
+		factory.NewKeyValue("Env", ast.NewIdent("env")),
+	}
+	var initStmts []ast.Stmt
+	if bridge {
+		fields = append(fields, factory.NewField("Conn", t.connType))
+		initStmts = append(initStmts,
+			eventAddressStmt(s, "env"),
+			factory.NewDefine("conn", factory.NewSelectorCall("event", t.connect)),
+			registerTransportCheckStmt(s, ast.NewIdent("conn")),
+		)
+		values = append(values, factory.NewKeyValue("Conn", ast.NewIdent("conn")))
+	}
+
+	// type Config struct { Hub *hub.Hub; Env *Env; Conn ... }
+	configStruct := factory.NewTypeStruct("Config", factory.NewFieldList(fields...))
+
+	// func InitConfig(env *Env) *Config
+	initConfigFunc := factory.NewFuncDecl(
+		"InitConfig",
+		factory.NewFieldList(),
+		factory.NewFuncType(
+			envParams(),
+			factory.NewFieldList(factory.NewField("", &ast.StarExpr{X: ast.NewIdent("Config")})),
+		),
+		factory.NewBodyStmt(append(initStmts,
+			factory.NewReturn(factory.NewAddressOf(factory.NewCompositeLit(ast.NewIdent("Config"), values...))),
+		)...),
+	)
+
+	serverStmts := []ast.Stmt{
+		&ast.GoStmt{Call: factory.NewSelectorCall("app", "Hub.Run")},
+	}
+	if bridge {
+		// go func() { if err := app.StartBridge(); err != nil { slog.Error(...) } }()
+		serverStmts = append(serverStmts, &ast.GoStmt{Call: factory.NewCall(factory.NewFuncLit(
+			factory.NewFuncType(factory.NewFieldList(), factory.NewFieldList()),
+			factory.NewBodyStmt(
+				withInit(
+					factory.NewIfError(logError("broker bridge stopped", errAttr())),
+					factory.NewDefine("err", factory.NewSelectorCall("app", "StartBridge")),
+				),
+			),
+		))})
+	}
+	serverStmts = append(serverStmts,
+		factory.NewDefine("server", factory.NewAddressOf(factory.NewCompositeLit(
+			factory.NewSelector("http", "Server"),
+			append([]ast.Expr{
+				factory.NewKeyValue("Addr", serverAddr()),
+				factory.NewKeyValue("Handler", factory.NewSelectorCall("routes", "Routes", factory.NewSelector("app", "Hub"))),
+			}, serverTimeouts()...)...,
+		))),
+		withInit(
+			factory.NewIfError(logFatal("server stopped", errAttr())...),
+			factory.NewDefine("err", factory.NewSelectorCall("server", "ListenAndServe")),
+		),
+	)
+
+	// func (app *Config) InitServer()
+	initServerFunc := factory.NewFuncDecl(
+		"InitServer",
+		factory.NewFieldList(factory.NewField("app", &ast.StarExpr{X: ast.NewIdent("Config")})),
+		factory.NewFuncType(factory.NewFieldList(), factory.NewFieldList()),
+		factory.NewBodyStmt(serverStmts...),
+	)
+
+	decls := []ast.Decl{imports}
+	if bridge {
+		// var BridgedEvents = []string{events.UserCreatedName, ...}
+		var names []ast.Expr
+		for _, ev := range s.Events {
+			names = append(names, factory.NewSelector(EventsModule, ev.Name+"Name"))
+		}
+		bridgedVar := factory.NewVarDecl("BridgedEvents", nil)
+		bridgedVar.Specs[0].(*ast.ValueSpec).Values = []ast.Expr{
+			factory.NewCompositeLit(&ast.ArrayType{Elt: ast.NewIdent("string")}, names...),
+		}
+		decls = append(decls, bridgedVar)
+	}
+	decls = append(decls, configStruct, initConfigFunc, initServerFunc)
+
+	if bridge {
+		// func (app *Config) StartBridge() error
+		decls = append(decls, factory.NewFuncDecl(
+			"StartBridge",
+			factory.NewFieldList(factory.NewField("app", &ast.StarExpr{X: ast.NewIdent("Config")})),
+			factory.NewFuncType(
+				factory.NewFieldList(),
+				factory.NewFieldList(factory.NewField("", ast.NewIdent("error"))),
+			),
+			factory.NewBodyStmt(
+				factory.NewDefine("consumer", factory.NewSelectorCall("event", "NewConsumer",
+					factory.NewSelector("app", "Conn"),
+					factory.NewSelector("event", "Exchange"),
+					&ast.CallExpr{
+						Fun:      factory.NewSelector("app", "Hub.Bridge"),
+						Args:     []ast.Expr{ast.NewIdent("BridgedEvents")},
+						Ellipsis: 1,
+					},
+				)),
+				withInit(
+					factory.NewIfError(factory.NewReturn(ast.NewIdent("err"))),
+					factory.NewDefine("err", factory.NewSelectorCall("consumer", "Setup")),
+				),
+				logInfo("bridging topics to websocket clients", attr("topics", factory.NewSelector("event", "Bindings"))),
+				factory.NewReturn(factory.NewSelectorCall("consumer", "Listen", factory.NewSelector("event", "Bindings"))),
+			),
+		))
+	}
+
+	return &types.File{
+		Name:    "config.go",
+		Content: factory.NewFileNode("config", decls...),
+	}
+}
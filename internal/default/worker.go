@@ -0,0 +1,545 @@
+package defaults
+
+import (
+	"go/ast"
+	"go/token"
+
+	"github.com/flaviogonzalez/instant-layer/internal/factory"
+	"github.com/flaviogonzalez/instant-layer/internal/types"
+)
+
+// DefaultJobTimeout is the timeout, in seconds, of jobs that don't set one
+const DefaultJobTimeout = 60
+
+// WorkerService creates a worker service running scheduled jobs with appropriate options.
+// With a database configured (WithLeaderLock), each run takes a Postgres advisory
+// lock so only one replica runs a job at a time.
+func WorkerService(opts ...Option) *types.Service {
+	s := &types.Service{Port: 0} // Workers don't need an HTTP port
+
+	// Apply user options first
+	for _, o := range opts {
+		o(s)
+	}
+
+	if len(s.Jobs) == 0 {
+		s.Jobs = []*types.Job{DefaultJob()}
+	}
+
+	// Jobs neither serve nor publish requests to trace
+	s.Tracing = false
+
+	// Worker services need: jobs, scheduler, config, main
+	baseOpts := []Option{
+		WithWorkerJobs(),
+		WithWorkerScheduler(),
+		WithWorkerConfig(),
+		WithWorkerMain(),
+		WithLogger(),
+		WithAdminHealth(),
+	}
+	if s.Metrics {
+		baseOpts = append(baseOpts, WithMetricsPackage(false))
+	}
+
+	return applyOptions(s, baseOpts...)
+}
+
+// DefaultJob returns the example job of a new worker
+func DefaultJob() *types.Job {
+	return &types.Job{Name: "heartbeat", Schedule: "@every 1m", Timeout: 30}
+}
+
+// JobFuncName returns the Go function implementing a job, e.g. CleanupSessions
+func JobFuncName(job *types.Job) string {
+	return GoName(job.Name)
+}
+
+// JobFileName returns the file holding a job's function, e.g. cleanup_sessions.go
+func JobFileName(job *types.Job) string {
+	return SnakeCase(job.Name) + ".go"
+}
+
+// WorkerJobsPackage generates the jobs package
+// Contains: registry.go (the schedules from layer.json) and one file per job
+func WorkerJobsPackage(s *types.Service) *types.Package {
+	files := []*types.File{WorkerRegistryFile(s)}
+	for _, job := range s.Jobs {
+		files = append(files, WorkerJobFile(job))
+	}
+	return &types.Package{Name: "jobs", Files: files}
+}
+
+// WorkerRegistryFile generates registry.go: the Job type and the registered jobs
+func WorkerRegistryFile(s *types.Service) *types.File {
+	imports := factory.NewImportDecl(
+		factory.NewImport("context", ""),
+		factory.NewImport("time", ""),
+	)
+
+	// type Job struct { Name, Schedule string; Timeout time.Duration; Run func(ctx context.Context) error }
+	jobStruct := factory.NewTypeStruct("Job", factory.NewFieldList(
+		factory.NewField("Name", ast.NewIdent("string")),
+		factory.NewField("Schedule", ast.NewIdent("string")),
+		factory.NewField("Timeout", factory.NewSelector("time", "Duration")),
+		factory.NewField("Run", jobFuncType()),
+	))
+
+	var jobs []ast.Expr
+	for _, job := range s.Jobs {
+		timeout := job.Timeout
+		if timeout <= 0 {
+			timeout = DefaultJobTimeout
+		}
+		jobs = append(jobs, factory.NewCompositeLit(nil,
+			factory.NewKeyValue("Name", factory.NewBasicLit(job.Name)),
+			factory.NewKeyValue("Schedule", factory.NewBasicLit(job.Schedule)),
+			factory.NewKeyValue("Timeout", &ast.BinaryExpr{
+				X: factory.NewBasicLitInt(timeout), Op: token.MUL, Y: factory.NewSelector("time", "Second"),
+			}),
+			factory.NewKeyValue("Run", ast.NewIdent(JobFuncName(job))),
+		))
+	}
+
+	// func Registry() []Job
+	registryFunc := factory.NewFuncDecl(
+		"Registry",
+		factory.NewFieldList(),
+		factory.NewFuncType(
+			factory.NewFieldList(),
+			factory.NewFieldList(factory.NewField("", &ast.ArrayType{Elt: ast.NewIdent("Job")})),
+		),
+		factory.NewBodyStmt(
+			factory.NewReturn(factory.NewCompositeLit(&ast.ArrayType{Elt: ast.NewIdent("Job")}, jobs...)),
+		),
+	)
+
+	return &types.File{
+		Name:    "registry.go",
+		Content: factory.NewFileNode("jobs", imports, jobStruct, registryFunc),
+	}
+}
+
+// WorkerJobFile generates the stub of a job, to be filled by the developer
+func WorkerJobFile(job *types.Job) *types.File {
+	imports := factory.NewImportDecl(
+		factory.NewImport("context", ""),
+		factory.NewImport("log/slog", ""),
+	)
+
+	// func {Name}(ctx context.Context) error
+	jobFunc := factory.NewFuncDecl(
+		JobFuncName(job),
+		factory.NewFieldList(),
+		jobFuncType(),
+		factory.NewBodyStmt(
+			logInfo("running job", attr("job", factory.NewBasicLit(job.Name))),
+			factory.NewReturn(ast.NewIdent("nil")),
+		),
+	)
+
+	return &types.File{
+		Name:    JobFileName(job),
+		Content: factory.NewFileNode("jobs", imports, jobFunc),
+	}
+}
+
+// jobFuncType returns func(ctx context.Context) error
+func jobFuncType() *ast.FuncType {
+	return factory.NewFuncType(
+		factory.NewFieldList(factory.NewField("ctx", factory.NewSelector("context", "Context"))),
+		factory.NewFieldList(factory.NewField("", ast.NewIdent("error"))),
+	)
+}
+
+// WorkerSchedulerPackage generates the scheduler package
+// Contains: scheduler.go (cron, overlap prevention, timeouts) and, with a
+// database, lock.go (Postgres advisory locks)
+func WorkerSchedulerPackage(s *types.Service) *types.Package {
+	files := []*types.File{workerSchedulerFile(s)}
+	if s.DB != nil {
+		files = append(files, workerLockFile())
+	}
+	return &types.Package{Name: "scheduler", Files: files}
+}
+
+// workerSchedulerFile generates scheduler.go
+func workerSchedulerFile(s *types.Service) *types.File {
+	imports := factory.NewImportDecl(
+		factory.NewImport(s.Name+"/jobs", ""),
+		factory.NewImport("context", ""),
+		factory.NewImport("log/slog", ""),
+		factory.NewImport("time", ""),
+		factory.NewImport("github.com/robfig/cron/v3", ""),
+	)
+
+	// type Locker interface { TryLock(ctx context.Context, name string) (unlock func(), ok bool, err error) }
+	lockerInterface := factory.NewTypeInterface("Locker", factory.NewFieldList(
+		factory.NewField("TryLock", factory.NewFuncType(
+			factory.NewFieldList(
+				factory.NewField("ctx", factory.NewSelector("context", "Context")),
+				factory.NewField("name", ast.NewIdent("string")),
+			),
+			factory.NewFieldList(
+				factory.NewField("unlock", factory.NewFuncType(factory.NewFieldList(), factory.NewFieldList())),
+				factory.NewField("ok", ast.NewIdent("bool")),
+				factory.NewField("err", ast.NewIdent("error")),
+			),
+		)),
+	))
+
+	// type Scheduler struct { cron *cron.Cron; locker Locker }
+	schedulerStruct := factory.NewTypeStruct("Scheduler", factory.NewFieldList(
+		factory.NewField("cron", &ast.StarExpr{X: factory.NewSelector("cron", "Cron")}),
+		factory.NewField("locker", ast.NewIdent("Locker")),
+	))
+
+	// func New(locker Locker) *Scheduler
+	newFunc := factory.NewFuncDecl(
+		"New",
+		factory.NewFieldList(),
+		factory.NewFuncType(
+			factory.NewFieldList(factory.NewField("locker", ast.NewIdent("Locker"))),
+			factory.NewFieldList(factory.NewField("", &ast.StarExpr{X: ast.NewIdent("Scheduler")})),
+		),
+		factory.NewBodyStmt(
+			// A run still going when the next one is due is skipped, not stacked
+			factory.NewDefine("c", factory.NewSelectorCall("cron", "New",
+				factory.NewSelectorCall("cron", "WithChain",
+					factory.NewSelectorCall("cron", "Recover", factory.NewSelector("cron", "DefaultLogger")),
+					factory.NewSelectorCall("cron", "SkipIfStillRunning", factory.NewSelector("cron", "DefaultLogger")),
+				),
+			)),
+			factory.NewReturn(factory.NewAddressOf(factory.NewCompositeLit(ast.NewIdent("Scheduler"),
+				factory.NewKeyValue("cron", ast.NewIdent("c")),
+				factory.NewKeyValue("locker", ast.NewIdent("locker")),
+			))),
+		),
+	)
+
+	recv := func() *ast.FieldList {
+		return factory.NewFieldList(factory.NewField("s", &ast.StarExpr{X: ast.NewIdent("Scheduler")}))
+	}
+
+	// func (s *Scheduler) Register(job jobs.Job) error
+	registerFunc := factory.NewFuncDecl(
+		"Register",
+		recv(),
+		factory.NewFuncType(
+			factory.NewFieldList(factory.NewField("job", factory.NewSelector("jobs", "Job"))),
+			factory.NewFieldList(factory.NewField("", ast.NewIdent("error"))),
+		),
+		factory.NewBodyStmt(
+			&ast.AssignStmt{
+				Lhs: []ast.Expr{ast.NewIdent("_"), ast.NewIdent("err")},
+				Tok: token.DEFINE,
+				Rhs: []ast.Expr{factory.NewSelectorCall("s", "cron.AddFunc",
+					factory.NewSelector("job", "Schedule"),
+					factory.NewFuncLit(
+						factory.NewFuncType(factory.NewFieldList(), factory.NewFieldList()),
+						factory.NewBodyStmt(factory.NewExprStmt(factory.NewSelectorCall("s", "run", ast.NewIdent("job")))),
+					),
+				)},
+			},
+			factory.NewReturn(ast.NewIdent("err")),
+		),
+	)
+
+	// func (s *Scheduler) run(job jobs.Job)
+	runFunc := factory.NewFuncDecl(
+		"run",
+		recv(),
+		factory.NewFuncType(
+			factory.NewFieldList(factory.NewField("job", factory.NewSelector("jobs", "Job"))),
+			factory.NewFieldList(),
+		),
+		factory.NewBodyStmt(
+			&ast.AssignStmt{
+				Lhs: []ast.Expr{ast.NewIdent("ctx"), ast.NewIdent("cancel")},
+				Tok: token.DEFINE,
+				Rhs: []ast.Expr{factory.NewSelectorCall("context", "WithTimeout",
+					factory.NewSelectorCall("context", "Background"),
+					factory.NewSelector("job", "Timeout"),
+				)},
+			},
+			&ast.DeferStmt{Call: factory.NewCall(ast.NewIdent("cancel"))},
+			// Only the replica holding the job's lock runs it
+			factory.NewIf(
+				&ast.BinaryExpr{X: factory.NewSelector("s", "locker"), Op: token.NEQ, Y: ast.NewIdent("nil")},
+				&ast.AssignStmt{
+					Lhs: []ast.Expr{ast.NewIdent("unlock"), ast.NewIdent("ok"), ast.NewIdent("err")},
+					Tok: token.DEFINE,
+					Rhs: []ast.Expr{factory.NewSelectorCall("s", "locker.TryLock", ast.NewIdent("ctx"), factory.NewSelector("job", "Name"))},
+				},
+				factory.NewIfError(
+					logError("failed to take job lock", attr("job", factory.NewSelector("job", "Name")), errAttr()),
+					factory.NewReturn(),
+				),
+				factory.NewIf(&ast.UnaryExpr{Op: token.NOT, X: ast.NewIdent("ok")}, factory.NewReturn()),
+				&ast.DeferStmt{Call: factory.NewCall(ast.NewIdent("unlock"))},
+			),
+			factory.NewDefine("start", factory.NewSelectorCall("time", "Now")),
+			withInit(
+				factory.NewIfError(
+					logError("job failed",
+						attr("job", factory.NewSelector("job", "Name")),
+						attr("duration", factory.NewSelectorCall("time", "Since", ast.NewIdent("start"))),
+						errAttr(),
+					),
+					factory.NewReturn(),
+				),
+				factory.NewDefine("err", factory.NewSelectorCall("job", "Run", ast.NewIdent("ctx"))),
+			),
+			logInfo("job done",
+				attr("job", factory.NewSelector("job", "Name")),
+				attr("duration", factory.NewSelectorCall("time", "Since", ast.NewIdent("start"))),
+			),
+		),
+	)
+
+	// func (s *Scheduler) Start()
+	startFunc := factory.NewFuncDecl(
+		"Start",
+		recv(),
+		factory.NewFuncType(factory.NewFieldList(), factory.NewFieldList()),
+		factory.NewBodyStmt(factory.NewExprStmt(factory.NewSelectorCall("s", "cron.Start"))),
+	)
+
+	// func (s *Scheduler) Stop() waits for the running jobs
+	stopFunc := factory.NewFuncDecl(
+		"Stop",
+		recv(),
+		factory.NewFuncType(factory.NewFieldList(), factory.NewFieldList()),
+		factory.NewBodyStmt(
+			factory.NewExprStmt(&ast.UnaryExpr{
+				Op: token.ARROW,
+				X:  factory.NewCall(&ast.SelectorExpr{X: factory.NewSelectorCall("s", "cron.Stop"), Sel: ast.NewIdent("Done")}),
+			}),
+		),
+	)
+
+	return &types.File{
+		Name: "scheduler.go",
+		Content: factory.NewFileNode("scheduler",
+			imports,
+			lockerInterface,
+			schedulerStruct,
+			newFunc,
+			registerFunc,
+			runFunc,
+			startFunc,
+			stopFunc,
+		),
+	}
+}
+
+// workerLockFile generates lock.go: a Locker backed by Postgres session advisory
+// locks, held on a dedicated connection for the duration of a run
+func workerLockFile() *types.File {
+	imports := factory.NewImportDecl(
+		factory.NewImport("context", ""),
+		factory.NewImport("database/sql", ""),
+	)
+
+	// type PostgresLocker struct { DB *sql.DB }
+	lockerStruct := factory.NewTypeStruct("PostgresLocker", factory.NewFieldList(
+		factory.NewField("DB", &ast.StarExpr{X: factory.NewSelector("sql", "DB")}),
+	))
+
+	// func (l *PostgresLocker) TryLock(ctx context.Context, name string) (func(), bool, error)
+	tryLockFunc := factory.NewFuncDecl(
+		"TryLock",
+		factory.NewFieldList(factory.NewField("l", &ast.StarExpr{X: ast.NewIdent("PostgresLocker")})),
+		factory.NewFuncType(
+			factory.NewFieldList(
+				factory.NewField("ctx", factory.NewSelector("context", "Context")),
+				factory.NewField("name", ast.NewIdent("string")),
+			),
+			factory.NewFieldList(
+				factory.NewField("", factory.NewFuncType(factory.NewFieldList(), factory.NewFieldList())),
+				factory.NewField("", ast.NewIdent("bool")),
+				factory.NewField("", ast.NewIdent("error")),
+			),
+		),
+		factory.NewBodyStmt(
+			factory.NewDefineExpectsError("conn", factory.NewSelectorCall("l", "DB.Conn", ast.NewIdent("ctx"))),
+			factory.NewIfError(factory.NewReturn(ast.NewIdent("nil"), ast.NewIdent("false"), ast.NewIdent("err"))),
+			&ast.DeclStmt{Decl: factory.NewVarDecl("ok", ast.NewIdent("bool"))},
+			factory.NewAssign(ast.NewIdent("err"), factory.NewCall(
+				&ast.SelectorExpr{
+					X: factory.NewSelectorCall("conn", "QueryRowContext",
+						ast.NewIdent("ctx"),
+						factory.NewBasicLit("SELECT pg_try_advisory_lock(hashtext($1))"),
+						ast.NewIdent("name"),
+					),
+					Sel: ast.NewIdent("Scan"),
+				},
+				factory.NewAddressOf(ast.NewIdent("ok")),
+			)),
+			// Another replica holds the lock: give the connection back
+			factory.NewIf(
+				&ast.BinaryExpr{
+					X:  &ast.BinaryExpr{X: ast.NewIdent("err"), Op: token.NEQ, Y: ast.NewIdent("nil")},
+					Op: token.LOR,
+					Y:  &ast.UnaryExpr{Op: token.NOT, X: ast.NewIdent("ok")},
+				},
+				factory.NewExprStmt(factory.NewSelectorCall("conn", "Close")),
+				factory.NewReturn(ast.NewIdent("nil"), ast.NewIdent("false"), ast.NewIdent("err")),
+			),
+			factory.NewDefine("unlock", factory.NewFuncLit(
+				factory.NewFuncType(factory.NewFieldList(), factory.NewFieldList()),
+				factory.NewBodyStmt(
+					// The run's context may be done: unlock regardless
+					factory.NewExprStmt(factory.NewSelectorCall("conn", "ExecContext",
+						factory.NewSelectorCall("context", "Background"),
+						factory.NewBasicLit("SELECT pg_advisory_unlock(hashtext($1))"),
+						ast.NewIdent("name"),
+					)),
+					factory.NewExprStmt(factory.NewSelectorCall("conn", "Close")),
+				),
+			)),
+			factory.NewReturn(ast.NewIdent("unlock"), ast.NewIdent("true"), ast.NewIdent("nil")),
+		),
+	)
+
+	return &types.File{
+		Name:    "lock.go",
+		Content: factory.NewFileNode("scheduler", imports, lockerStruct, tryLockFunc),
+	}
+}
+
+// WorkerConfigFile generates config.go for a worker service: registers the jobs
+// and runs the scheduler until SIGINT/SIGTERM, letting running jobs finish
+func WorkerConfigFile(s *types.Service) *types.File {
+	importSpecs := []*ast.ImportSpec{
+		factory.NewImport(s.Name+"/"+HealthPackageName, ""),
+		factory.NewImport(s.Name+"/jobs", ""),
+		factory.NewImport(s.Name+"/scheduler", ""),
+		factory.NewImport("log/slog", ""),
+		factory.NewImport("os", ""),
+		factory.NewImport("os/signal", ""),
+		factory.NewImport("syscall", ""),
+	}
+	if s.DB != nil {
+		importSpecs = append(importSpecs,
+			factory.NewImport("database/sql", ""),
+			factory.NewImport("time", ""),
+			factory.NewImport("github.com/jackc/pgx/v5/stdlib", "_"),
+		)
+	}
+	if s.Metrics {
+		importSpecs = append(importSpecs, factory.NewImport(s.Name+"/"+MetricsPackageName, ""))
+	}
+	imports := factory.NewImportDecl(importSpecs...)
+
+	fields := []*ast.Field{factory.NewField("Scheduler", &ast.StarExpr{X: factory.NewSelector("scheduler", "Scheduler")}), envField()}
+	values := []ast.Expr{factory.NewKeyValue("Scheduler", ast.NewIdent("sched")), factory.NewKeyValue("Env", ast.NewIdent("env"))}
+	locker := ast.Expr(ast.NewIdent("nil"))
+	var initStmts []ast.Stmt
+	if s.DB != nil {
+		fields = append(fields, factory.NewField("Db", &ast.StarExpr{X: factory.NewSelector("sql", "DB")}))
+		values = append(values, factory.NewKeyValue("Db", ast.NewIdent("db")))
+		initStmts = append(initStmts,
+			factory.NewDefine("db", factory.NewCall(ast.NewIdent("connectToDB"), factory.NewSelector("env", "DatabaseURL"))),
+			registerCheckStmt("postgres", "Postgres", ast.NewIdent("db")),
+		)
+		if s.Metrics {
+			initStmts = append(initStmts, registerDBStmt(s))
+		}
+		locker = factory.NewAddressOf(factory.NewCompositeLit(factory.NewSelector("scheduler", "PostgresLocker"),
+			factory.NewKeyValue("DB", ast.NewIdent("db")),
+		))
+	}
+
+	// type Config struct { Scheduler *scheduler.Scheduler; Env *Env; Db *sql.DB }
+	configStruct := factory.NewTypeStruct("Config", factory.NewFieldList(fields...))
+
+	// func InitConfig(env *Env) *Config
+	initConfigFunc := factory.NewFuncDecl(
+		"InitConfig",
+		factory.NewFieldList(),
+		factory.NewFuncType(
+			envParams(),
+			factory.NewFieldList(factory.NewField("", &ast.StarExpr{X: ast.NewIdent("Config")})),
+		),
+		factory.NewBodyStmt(append(initStmts,
+			factory.NewDefine("sched", factory.NewSelectorCall("scheduler", "New", locker)),
+			&ast.RangeStmt{
+				Key:   ast.NewIdent("_"),
+				Value: ast.NewIdent("job"),
+				Tok:   token.DEFINE,
+				X:     factory.NewSelectorCall("jobs", "Registry"),
+				Body: factory.NewBodyStmt(
+					withInit(
+						factory.NewIfError(logFatal("invalid job", attr("job", factory.NewSelector("job", "Name")), errAttr())...),
+						factory.NewDefine("err", factory.NewSelectorCall("sched", "Register", ast.NewIdent("job"))),
+					),
+				),
+			},
+			factory.NewReturn(factory.NewAddressOf(factory.NewCompositeLit(ast.NewIdent("Config"), values...))),
+		)...),
+	)
+
+	// func (app *Config) Run()
+	runFunc := factory.NewFuncDecl(
+		"Run",
+		factory.NewFieldList(factory.NewField("app", &ast.StarExpr{X: ast.NewIdent("Config")})),
+		factory.NewFuncType(factory.NewFieldList(), factory.NewFieldList()),
+		factory.NewBodyStmt(
+			// Workers serve no HTTP: the probes get an admin server of their own
+			serveAdminStmt(adminMiddlewares(s)...),
+			factory.NewExprStmt(factory.NewSelectorCall("app", "Scheduler.Start")),
+			logInfo("scheduler started"),
+			// stop := make(chan os.Signal, 1)
+			factory.NewDefine("stop", factory.NewCall(ast.NewIdent("make"),
+				&ast.ChanType{Dir: ast.SEND | ast.RECV, Value: factory.NewSelector("os", "Signal")},
+				factory.NewBasicLitInt(1),
+			)),
+			factory.NewExprStmt(factory.NewSelectorCall("signal", "Notify",
+				ast.NewIdent("stop"), factory.NewSelector("os", "Interrupt"), factory.NewSelector("syscall", "SIGTERM"),
+			)),
+			factory.NewExprStmt(&ast.UnaryExpr{Op: token.ARROW, X: ast.NewIdent("stop")}),
+			logInfo("waiting for running jobs"),
+			factory.NewExprStmt(factory.NewSelectorCall("app", "Scheduler.Stop")),
+		),
+	)
+
+	decls := []ast.Decl{imports}
+	if s.DB != nil {
+		openDBFunc, connectToDBFunc := connectToDBFuncs("pgx")
+		decls = append(decls, factory.NewVarDecl("counts", ast.NewIdent("int")), configStruct, initConfigFunc, runFunc, openDBFunc, connectToDBFunc)
+	} else {
+		decls = append(decls, configStruct, initConfigFunc, runFunc)
+	}
+
+	return &types.File{
+		Name:    "config.go",
+		Content: factory.NewFileNode("config", decls...),
+	}
+}
+
+// WorkerMainFile generates main.go for a worker service
+func WorkerMainFile(s *types.Service) *types.File {
+	imports := factory.NewImportDecl(
+		factory.NewImport(s.Name+"/config", ""),
+		factory.NewImport(s.Name+"/"+LoggerPackageName, ""),
+	)
+
+	// func main() { env := config.LoadEnv(); logger.Setup(...); app := config.InitConfig(env); app.Run() }
+	mainFunc := factory.NewFuncDecl(
+		"main",
+		factory.NewFieldList(),
+		factory.NewFuncType(factory.NewFieldList(), factory.NewFieldList()),
+		factory.NewBodyStmt(
+			loadEnvStmt(),
+			loggerSetupStmt(s),
+			factory.NewDefine("app", factory.NewSelectorCall("config", "InitConfig", ast.NewIdent("env"))),
+			factory.NewExprStmt(factory.NewSelectorCall("app", "Run")),
+		),
+	)
+
+	return &types.File{
+		Name:    "main.go",
+		Content: factory.NewFileNode("main", imports, mainFunc),
+	}
+}
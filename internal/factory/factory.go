@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"go/ast"
 	"go/token"
+	"strings"
 )
 
 func NewFileNode(name string, decls ...ast.Decl) *ast.File {
@@ -199,6 +200,38 @@ func NewVarDecl(name string, typ ast.Expr) *ast.GenDecl {
 	}
 }
 
+func NewConstDecl(name string, value ast.Expr) *ast.GenDecl {
+	return &ast.GenDecl{
+		Tok: token.CONST,
+		Specs: []ast.Spec{
+			&ast.ValueSpec{
+				Names: []*ast.Ident{
+					ast.NewIdent(name),
+				},
+				Values: []ast.Expr{value},
+			},
+		},
+	}
+}
+
+// NewTypeExpr builds a type expression from its source form: string, *User, []byte, time.Time
+func NewTypeExpr(typ string) ast.Expr {
+	switch {
+	case strings.HasPrefix(typ, "[]"):
+		return &ast.ArrayType{Elt: NewTypeExpr(typ[2:])}
+	case strings.HasPrefix(typ, "*"):
+		return &ast.StarExpr{X: NewTypeExpr(typ[1:])}
+	case strings.HasPrefix(typ, "map[") && strings.Contains(typ, "]"):
+		end := strings.Index(typ, "]")
+		return &ast.MapType{Key: NewTypeExpr(typ[4:end]), Value: NewTypeExpr(typ[end+1:])}
+	case strings.Contains(typ, "."):
+		parts := strings.SplitN(typ, ".", 2)
+		return NewSelector(parts[0], parts[1])
+	default:
+		return ast.NewIdent(typ)
+	}
+}
+
 func NewTypeStruct(name string, typ *ast.FieldList) *ast.GenDecl {
 	return &ast.GenDecl{
 		Tok: token.TYPE,
@@ -213,6 +246,21 @@ func NewTypeStruct(name string, typ *ast.FieldList) *ast.GenDecl {
 	}
 }
 
+// NewTypeInterface declares an interface type; methods are fields with a func type
+func NewTypeInterface(name string, methods *ast.FieldList) *ast.GenDecl {
+	return &ast.GenDecl{
+		Tok: token.TYPE,
+		Specs: []ast.Spec{
+			&ast.TypeSpec{
+				Name: ast.NewIdent(name),
+				Type: &ast.InterfaceType{
+					Methods: methods,
+				},
+			},
+		},
+	}
+}
+
 func NewIfError(stmt ...ast.Stmt) *ast.IfStmt {
 	return &ast.IfStmt{
 		Cond: &ast.BinaryExpr{
@@ -226,6 +274,40 @@ func NewIfError(stmt ...ast.Stmt) *ast.IfStmt {
 	}
 }
 
+// NewIf builds if cond { stmt... }
+func NewIf(cond ast.Expr, stmt ...ast.Stmt) *ast.IfStmt {
+	return &ast.IfStmt{
+		Cond: cond,
+		Body: &ast.BlockStmt{
+			List: stmt,
+		},
+	}
+}
+
+// NewAssign builds lhs = rhs
+func NewAssign(lhs ast.Expr, rhs ast.Expr) *ast.AssignStmt {
+	return &ast.AssignStmt{
+		Lhs: []ast.Expr{lhs},
+		Tok: token.ASSIGN,
+		Rhs: []ast.Expr{rhs},
+	}
+}
+
+// NewVarStmt builds var name typ inside a function body
+func NewVarStmt(name string, typ ast.Expr) *ast.DeclStmt {
+	return &ast.DeclStmt{
+		Decl: NewVarDecl(name, typ),
+	}
+}
+
+// NewAddressOf builds &x
+func NewAddressOf(x ast.Expr) *ast.UnaryExpr {
+	return &ast.UnaryExpr{
+		Op: token.AND,
+		X:  x,
+	}
+}
+
 func NewReturn(values ...ast.Expr) *ast.ReturnStmt {
 	return &ast.ReturnStmt{
 		Results: values,
@@ -308,6 +308,31 @@ func TestNewVarDecl(t *testing.T) {
 	}
 }
 
+// TestNewConstDecl tests constant declaration creation
+func TestNewConstDecl(t *testing.T) {
+	constDecl := NewConstDecl("Version", NewBasicLitInt(1))
+
+	if constDecl.Tok != token.CONST {
+		t.Errorf("Tok = %v, want %v", constDecl.Tok, token.CONST)
+	}
+
+	output := renderNode(constDecl)
+	if !strings.Contains(output, "const Version = 1") {
+		t.Errorf("Output should contain const declaration, got: %s", output)
+	}
+}
+
+// TestNewTypeExpr tests type expression creation from source form
+func TestNewTypeExpr(t *testing.T) {
+	tests := []string{"string", "*User", "[]byte", "time.Time", "[]*events.UserCreated", "map[string][]models.Tag"}
+
+	for _, typ := range tests {
+		if got := renderNode(NewTypeExpr(typ)); strings.TrimSpace(got) != typ {
+			t.Errorf("NewTypeExpr(%q) rendered %q", typ, got)
+		}
+	}
+}
+
 // TestNewTypeStruct tests type struct declaration creation
 func TestNewTypeStruct(t *testing.T) {
 	structDecl := NewTypeStruct("Config",
@@ -352,6 +377,53 @@ func TestNewIfError(t *testing.T) {
 	}
 }
 
+// TestNewIf tests if statement creation
+func TestNewIf(t *testing.T) {
+	ifStmt := NewIf(ast.NewIdent("ok"), NewReturn())
+
+	if ident, ok := ifStmt.Cond.(*ast.Ident); !ok || ident.Name != "ok" {
+		t.Error("Cond should be 'ok'")
+	}
+	if len(ifStmt.Body.List) != 1 {
+		t.Errorf("Body = %d, want 1", len(ifStmt.Body.List))
+	}
+}
+
+// TestNewAssign tests assignment statement creation
+func TestNewAssign(t *testing.T) {
+	assign := NewAssign(NewSelector("c", "queue"), NewSelector("q", "Name"))
+
+	if assign.Tok != token.ASSIGN {
+		t.Errorf("Tok = %v, want %v", assign.Tok, token.ASSIGN)
+	}
+	if len(assign.Lhs) != 1 || len(assign.Rhs) != 1 {
+		t.Error("Should have one value on each side")
+	}
+}
+
+// TestNewVarStmt tests var statement creation
+func TestNewVarStmt(t *testing.T) {
+	decl := NewVarStmt("counts", ast.NewIdent("int64"))
+
+	genDecl, ok := decl.Decl.(*ast.GenDecl)
+	if !ok || genDecl.Tok != token.VAR {
+		t.Fatal("Decl should be a var GenDecl")
+	}
+	spec := genDecl.Specs[0].(*ast.ValueSpec)
+	if spec.Names[0].Name != "counts" {
+		t.Errorf("Name = %q, want %q", spec.Names[0].Name, "counts")
+	}
+}
+
+// TestNewAddressOf tests address-of expression creation
+func TestNewAddressOf(t *testing.T) {
+	addr := NewAddressOf(NewCompositeLit(ast.NewIdent("Config")))
+
+	if addr.Op != token.AND {
+		t.Errorf("Op = %v, want %v", addr.Op, token.AND)
+	}
+}
+
 // TestNewReturn tests return statement creation
 func TestNewReturn(t *testing.T) {
 	// No values
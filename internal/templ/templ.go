@@ -4,14 +4,19 @@ import (
 	"embed"
 	"os"
 	"path/filepath"
+	"strconv"
 	"text/template"
-
-	"github.com/flaviogonzalez/instant-layer/internal/types"
 )
 
 //go:embed *.tmpl
 var templates embed.FS
 
+// helmTemplates are the Helm templates of every service subchart, copied as
+// is: they are driven by the subchart's values
+//
+//go:embed all:helm
+var helmTemplates embed.FS
+
 // GoModData holds data for generating go.mod files
 type GoModData struct {
 	Name         string
@@ -29,14 +34,127 @@ type Dependency struct {
 type DockerComposeData struct {
 	Name     string
 	Services []*ServiceData
+	Infra    []*InfraData
+}
+
+// InfraData represents an infrastructure container in docker-compose: a
+// message transport or the postgres server
+type InfraData struct {
+	Name        string
+	Image       string
+	Command     []string
+	Ports       []string
+	Environment []string
+	Volumes     []string
 }
 
 // ServiceData represents a service in docker-compose
 type ServiceData struct {
-	Name      string
-	Port      int
-	DB        *types.Database
-	DependsOn []string
+	Name          string
+	Port          int      // published port, 0 for services without a server
+	ContainerPort int      // port the server listens on inside the container
+	Environment   []string // KEY=value entries: the service's config.Env, then e.g. base URLs of called services
+	EnvFile       string   // project .env passed to the container, empty for none
+	DependsOn     []string
+	HealthCheck   string // readiness URL polled from inside the container, empty for none
+}
+
+// GoWorkData holds data for generating the go.work of a project
+type GoWorkData struct {
+	GoVersion string
+	Use       []string // module directories relative to the root, e.g. auth-service
+	Replace   []Replace
+}
+
+// Replace points the v0.0.0 a service requires of a module at its directory
+// in the workspace
+type Replace struct {
+	Module string
+	Dir    string
+}
+
+// LibData holds data for generating the package of a shared library module
+type LibData struct {
+	Project string
+	Module  string // e.g. libs/money
+	Package string // e.g. money
+}
+
+// EnvExampleData holds data for generating a .env.example
+type EnvExampleData struct {
+	Header []string // comment lines
+	Vars   []EnvVarData
+}
+
+// EnvVarData is a variable of .env.example with its default or example value
+type EnvVarData struct {
+	Name     string
+	Value    string
+	Required bool
+}
+
+// AsyncAPIData holds data for generating an AsyncAPI 3.0 document
+type AsyncAPIData struct {
+	Title       string
+	Version     string
+	Description string
+	Servers     []*AsyncAPIServer
+	Channels    []*AsyncAPIChannel
+	Operations  []*AsyncAPIOperation
+	Messages    []*AsyncAPIMessage
+	Schemas     []*AsyncAPISchema
+}
+
+// AsyncAPIServer is the infra container of a message transport
+type AsyncAPIServer struct {
+	Name     string // e.g. rabbitmq
+	Host     string // e.g. rabbitmq:5672
+	Protocol string // amqp, nats, kafka
+}
+
+// AsyncAPIChannel is a topic of an exchange, with its AMQP binding for RabbitMQ
+type AsyncAPIChannel struct {
+	ID           string
+	Address      string // routing key, subject or topic
+	Description  string
+	Server       string
+	Messages     []string // message IDs
+	Exchange     string   // RabbitMQ only
+	ExchangeType string
+	Durable      bool
+}
+
+// AsyncAPIOperation is a service sending to or receiving from a channel
+type AsyncAPIOperation struct {
+	ID       string
+	Action   string // send, receive
+	Channel  string // channel ID
+	Summary  string
+	Messages []string // message IDs
+}
+
+// AsyncAPIMessage is the envelope of an event; Schema references its data, if typed
+type AsyncAPIMessage struct {
+	ID      string
+	Name    string // wire name, e.g. user.created
+	Title   string
+	Version int
+	Const   bool // the name is fixed (typed events)
+	Schema  string
+}
+
+// AsyncAPISchema is the JSON schema of an event's data
+type AsyncAPISchema struct {
+	ID         string
+	Properties []*SchemaProperty
+}
+
+// SchemaProperty is a single JSON schema property
+type SchemaProperty struct {
+	Name   string
+	Type   string
+	Format string
+	Items  string // item type of arrays
 }
 
 // DefaultDependencies returns the default dependencies for a new service
@@ -47,6 +165,102 @@ func DefaultDependencies() []Dependency {
 	}
 }
 
+// TransportInfra returns the container running a message transport, named
+// after the host generated services dial. Unknown transports return nil.
+func TransportInfra(transport string) *InfraData {
+	switch transport {
+	case "rabbitmq":
+		return &InfraData{
+			Name:  "rabbitmq",
+			Image: "rabbitmq:3-management",
+			Ports: []string{"5672:5672", "15672:15672"},
+			// Credentials come from the project's .env
+			Environment: []string{
+				"RABBITMQ_DEFAULT_USER=${RABBITMQ_DEFAULT_USER:-guest}",
+				"RABBITMQ_DEFAULT_PASS=${RABBITMQ_DEFAULT_PASS:-guest}",
+			},
+		}
+	case "nats":
+		return &InfraData{
+			Name:    "nats",
+			Image:   "nats:2",
+			Command: []string{"-js"}, // JetStream backs streams, consumers and retries
+			Ports:   []string{"4222:4222", "8222:8222"},
+		}
+	case "kafka":
+		// Single node KRaft cluster
+		return &InfraData{
+			Name:  "kafka",
+			Image: "apache/kafka:3.8.0",
+			Ports: []string{"9092:9092"},
+			Environment: []string{
+				"KAFKA_NODE_ID=1",
+				"KAFKA_PROCESS_ROLES=broker,controller",
+				"KAFKA_LISTENERS=PLAINTEXT://:9092,CONTROLLER://:9093",
+				"KAFKA_ADVERTISED_LISTENERS=PLAINTEXT://kafka:9092",
+				"KAFKA_CONTROLLER_LISTENER_NAMES=CONTROLLER",
+				"KAFKA_LISTENER_SECURITY_PROTOCOL_MAP=CONTROLLER:PLAINTEXT,PLAINTEXT:PLAINTEXT",
+				"KAFKA_CONTROLLER_QUORUM_VOTERS=1@localhost:9093",
+				"KAFKA_OFFSETS_TOPIC_REPLICATION_FACTOR=1",
+				"KAFKA_AUTO_CREATE_TOPICS_ENABLE=true",
+			},
+		}
+	}
+	return nil
+}
+
+// DockerfileHeader starts the Dockerfiles generated by layer: the ones
+// still starting with it are rewritten, the others belong to the user
+const DockerfileHeader = "# Generated by layer"
+
+// DockerfileData holds data for generating the Dockerfile of a service,
+// built with the project root as context
+type DockerfileData struct {
+	Name          string
+	GoVersion     string
+	ContainerPort int
+	Modules       []string // shared modules go.mod replaces, relative to the root, e.g. events
+}
+
+// GenerateDockerfile generates the Dockerfile of a service
+func GenerateDockerfile(outputPath string, data DockerfileData) error {
+	if data.GoVersion == "" {
+		data.GoVersion = DefaultGoVersion()
+	}
+	return executeFile("dockerfile.tmpl", outputPath, data)
+}
+
+// PostgresInitDir is the directory at the project root holding the scripts
+// the postgres container runs on its first start
+const PostgresInitDir = "postgres"
+
+// PostgresInfra returns the postgres container serving the databases of the
+// services, created by the scripts of PostgresInitDir
+func PostgresInfra() *InfraData {
+	return &InfraData{
+		Name:  "postgres",
+		Image: "postgres:16",
+		Ports: []string{"5432:5432"},
+		// Credentials come from the project's .env
+		Environment: []string{
+			"POSTGRES_USER=${POSTGRES_USER:-postgres}",
+			"POSTGRES_PASSWORD=${POSTGRES_PASSWORD:-postgres}",
+		},
+		Volumes: []string{"./" + PostgresInitDir + ":/docker-entrypoint-initdb.d:ro"},
+	}
+}
+
+// PostgresInitData holds the databases created in the postgres container
+type PostgresInitData struct {
+	Databases []string
+}
+
+// GeneratePostgresInit generates the script creating the database of every
+// service on the postgres container's first start
+func GeneratePostgresInit(outputPath string, data PostgresInitData) error {
+	return executeFile("postgresinit.tmpl", outputPath, data)
+}
+
 // DefaultGoVersion returns the latest stable Go version
 func DefaultGoVersion() string {
 	return "1.25.2"
@@ -94,7 +308,249 @@ func GenerateDockerCompose(outputPath string, data DockerComposeData) error {
 	return tmpl.Execute(f, data)
 }
 
+// GenerateGoWork generates the go.work at the root of a project
+func GenerateGoWork(outputPath string, data GoWorkData) error {
+	if data.GoVersion == "" {
+		data.GoVersion = DefaultGoVersion()
+	}
+	return executeFile("gowork.tmpl", outputPath, data)
+}
+
+// GenerateLib generates the doc.go of a shared library module
+func GenerateLib(outputPath string, data LibData) error {
+	return executeFile("lib.tmpl", outputPath, data)
+}
+
+// GenerateEnvExample generates the .env.example of a service or of the project
+func GenerateEnvExample(outputPath string, data EnvExampleData) error {
+	tmpl, err := template.ParseFS(templates, "envexample.tmpl")
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(outputPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return tmpl.Execute(f, data)
+}
+
+// GenerateAsyncAPI generates an AsyncAPI document
+func GenerateAsyncAPI(outputPath string, data AsyncAPIData) error {
+	tmpl, err := template.New("asyncapi.tmpl").Funcs(funcs).ParseFS(templates, "asyncapi.tmpl")
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(outputPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return tmpl.Execute(f, data)
+}
+
+// ProtoData holds data for generating the .proto skeleton of a gRPC service
+type ProtoData struct {
+	Name      string // service name, e.g. user-service
+	Package   string // protobuf package, e.g. user_service.v1
+	GoPackage string // import path of the stubs, e.g. user-service/pb
+	GoName    string // Go package name of the stubs, e.g. pb
+	Service   string // gRPC service, e.g. UserService
+	File      string // base name of the .proto, e.g. user_service
+}
+
+// GenerateProto generates the .proto skeleton of a gRPC service into dir, with a
+// generate.go holding the go:generate step that regenerates its stubs
+func GenerateProto(dir string, data ProtoData) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	for name, output := range map[string]string{
+		"proto.tmpl":      data.File + ".proto",
+		"gogenerate.tmpl": "generate.go",
+	} {
+		tmpl, err := template.ParseFS(templates, name)
+		if err != nil {
+			return err
+		}
+
+		f, err := os.Create(filepath.Join(dir, output))
+		if err != nil {
+			return err
+		}
+		err = tmpl.Execute(f, data)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// K8sData holds data for generating the Kubernetes manifests of a project:
+// a kustomize base and its dev and prod overlays
+type K8sData struct {
+	Name     string // project, the namespace of prod
+	Services []*K8sServiceData
+	Ingress  []*K8sIngressRule // empty for no Ingress
+}
+
+// K8sServiceData is the Deployment, Service and config of a service
+type K8sServiceData struct {
+	Name      string
+	Image     string
+	Replicas  int
+	Port      int    // container port of the server, 0 for services without one
+	Protocol  string // name of the server port: http or grpc
+	AdminPort int    // health port of listeners and workers, 0 for none
+	Probe     *K8sProbe
+	Config    []EnvVarData // ConfigMap variables
+	Secrets   []EnvVarData // Secret variables, with their dev values
+	External  []EnvVarData // ConfigMap addresses of external dependencies, set per overlay
+}
+
+// K8sProbe is where the liveness and readiness probes of a container are served
+type K8sProbe struct {
+	Port      int
+	LivePath  string
+	ReadyPath string
+	GRPC      bool // the gRPC health service instead of HTTP endpoints
+}
+
+// K8sIngressRule routes a host to the HTTP port of a service
+type K8sIngressRule struct {
+	Host    string
+	Service string
+}
+
+// HasSecrets reports whether any service reads a Secret
+func (d K8sData) HasSecrets() bool {
+	for _, svc := range d.Services {
+		if len(svc.Secrets) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// HasExternal reports whether any service dials an external dependency
+// configured outside of its Secret
+func (d K8sData) HasExternal() bool {
+	for _, svc := range d.Services {
+		if len(svc.External) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// GenerateK8s generates the manifests of a project into dir: base/ with a
+// Deployment and Service per service, overlays/dev and overlays/prod
+func GenerateK8s(dir string, data K8sData) error {
+	outputs := map[string]string{
+		"k8sbase.tmpl": filepath.Join("base", "kustomization.yaml"),
+		"k8sdev.tmpl":  filepath.Join("overlays", "dev", "kustomization.yaml"),
+		"k8sprod.tmpl": filepath.Join("overlays", "prod", "kustomization.yaml"),
+	}
+	if len(data.Ingress) > 0 {
+		outputs["k8singress.tmpl"] = filepath.Join("base", "ingress.yaml")
+	}
+
+	for name, output := range outputs {
+		if err := executeFile(name, filepath.Join(dir, output), data); err != nil {
+			return err
+		}
+	}
+	for _, svc := range data.Services {
+		if err := executeFile("k8sservice.tmpl", filepath.Join(dir, "base", svc.Name+".yaml"), svc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// helmService is a service subchart of a project's chart
+type helmService struct {
+	Project string
+	*K8sServiceData
+}
+
+// GenerateHelm generates the chart of a project into dir: Chart.yaml and
+// values.yaml of the umbrella chart, one subchart per service in charts/
+func GenerateHelm(dir string, data K8sData) error {
+	for name, output := range map[string]string{
+		"helmchart.tmpl":  "Chart.yaml",
+		"helmvalues.tmpl": "values.yaml",
+	} {
+		if err := executeFile(name, filepath.Join(dir, output), data); err != nil {
+			return err
+		}
+	}
+
+	files, err := helmTemplates.ReadDir("helm")
+	if err != nil {
+		return err
+	}
+	for _, svc := range data.Services {
+		chart := filepath.Join(dir, "charts", svc.Name)
+		sub := helmService{Project: data.Name, K8sServiceData: svc}
+		for name, output := range map[string]string{
+			"helmsubchart.tmpl":  "Chart.yaml",
+			"helmsubvalues.tmpl": "values.yaml",
+		} {
+			if err := executeFile(name, filepath.Join(chart, output), sub); err != nil {
+				return err
+			}
+		}
+
+		if err := os.MkdirAll(filepath.Join(chart, "templates"), 0755); err != nil {
+			return err
+		}
+		for _, file := range files {
+			content, err := helmTemplates.ReadFile("helm/" + file.Name())
+			if err != nil {
+				return err
+			}
+			if err := os.WriteFile(filepath.Join(chart, "templates", file.Name()), content, 0644); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// executeFile renders a template to outputPath, creating its directory
+func executeFile(name, outputPath string, data any) error {
+	tmpl, err := GetTemplate(name)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(outputPath), 0755); err != nil {
+		return err
+	}
+
+	f, err := os.Create(outputPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return tmpl.Execute(f, data)
+}
+
+// funcs are the helpers available to document templates
+var funcs = template.FuncMap{
+	// quote renders a YAML double-quoted scalar
+	"quote": strconv.Quote,
+}
+
 // GetTemplate returns a parsed template by name
 func GetTemplate(name string) (*template.Template, error) {
-	return template.ParseFS(templates, name)
+	return template.New(name).Funcs(funcs).ParseFS(templates, name)
 }
@@ -10,9 +10,18 @@ type Service struct {
 	Packages     []*Package    `json:"-"`
 	Name         string        `json:"name,omitempty"` // e.g. ecommerce-service
 	Port         int           `json:"port,omitempty"`
+	Replicas     int           `json:"replicas,omitempty"` // pods of its Deployment, 1 when unset
 	DB           *Database     `json:"db,omitzero"`
 	RoutesConfig *RoutesConfig `json:"routesConfig,omitzero"`
 	Benchmark    *Benchmark    `json:"benchmark,omitempty"`
+	Messaging    *Messaging    `json:"messaging,omitzero"`
+	Outbox       bool          `json:"outbox,omitempty"`   // DB service relaying events through a transactional outbox
+	Metrics      bool          `json:"metrics,omitempty"`  // instrumented with Prometheus metrics served on /metrics
+	Tracing      bool          `json:"tracing,omitempty"`  // traced with OpenTelemetry, trace context propagated through messages
+	Template     string        `json:"template,omitempty"` // template ID used to scaffold the service (auth, broker, listener...)
+	Events       []*Event      `json:"-"`                  // shared event contracts the service is generated against
+	Models       []*Model      `json:"models,omitempty"`   // request and response structs of the models package
+	Jobs         []*Job        `json:"jobs,omitempty"`     // scheduled jobs of a worker service
 }
 
 // Package represents a Go package to be generated
@@ -31,7 +40,7 @@ type File struct {
 type Database struct {
 	TimeoutConn int    `json:"timeoutConn,omitempty"`
 	Driver      string `json:"driver,omitempty"`
-	URL         string `json:"url,omitempty"` // always in .ENV, need to create a .env file with DATABASE_URL={{url}}
+	URL         string `json:"url,omitempty"` // default of <SERVICE>_DATABASE_URL in the project's .env
 	Port        int
 }
 
@@ -39,6 +48,7 @@ type Database struct {
 type RoutesConfig struct {
 	CORS        *CorsOptions   `json:"cors,omitzero"`
 	RoutesGroup []*RoutesGroup `json:"routesGroup,omitempty"`
+	Docs        bool           `json:"docs,omitempty"` // serve the OpenAPI spec and a Swagger UI page
 }
 
 // CorsOptions for CORS middleware configuration
@@ -52,6 +62,7 @@ type CorsOptions struct {
 
 // RoutesGroup represents a group of routes with shared configuration
 type RoutesGroup struct {
+	Name       string     `json:"name,omitempty"` // e.g. the OpenAPI tag the routes were grouped by
 	Prefix     string     `json:"prefix,omitempty"`
 	Middleware Middleware `json:"middleware,omitzero"` // todo: omitempty when middleware fulfill
 	Routes     []*Route   `json:"routes,omitempty"`
@@ -63,9 +74,66 @@ type Middleware struct {
 
 // Route represents a single HTTP route
 type Route struct {
-	Path    string `json:"path,omitempty"`    // /user
-	Method  string `json:"method,omitempty"`  // POST, DELETE, PUT, GET
-	Handler string `json:"handler,omitempty"` // User Implementation.
+	Path     string `json:"path,omitempty"`     // /user
+	Method   string `json:"method,omitempty"`   // POST, DELETE, PUT, GET
+	Handler  string `json:"handler,omitempty"`  // User Implementation.
+	Request  string `json:"request,omitempty"`  // Go type of the decoded request body, e.g. models.CreateUserRequest
+	Response string `json:"response,omitempty"` // Go type of the encoded response body, e.g. []models.User
+	Status   int    `json:"status,omitempty"`   // success status code, 200 when not set
+}
+
+// Model is a JSON struct of a service's models package
+type Model struct {
+	Name   string        `json:"name"` // Go type name, e.g. User
+	Fields []*ModelField `json:"fields,omitempty"`
+}
+
+// ModelField is a single field of a model
+type ModelField struct {
+	Name     string `json:"name"`               // Go field name, e.g. CreatedAt
+	Type     string `json:"type"`               // Go type, e.g. string, []Tag, *Address, time.Time
+	JSON     string `json:"json"`               // json tag, e.g. created_at
+	Optional bool   `json:"optional,omitempty"` // tagged omitempty
+}
+
+// Messaging configures the exchange and queue of broker and listener services
+type Messaging struct {
+	Transport    string     `json:"transport,omitempty"`    // rabbitmq (default), nats, kafka
+	Exchange     string     `json:"exchange,omitempty"`     // e.g. logs_topic
+	ExchangeType string     `json:"exchangeType,omitempty"` // topic, direct, fanout, headers
+	Queue        string     `json:"queue,omitempty"`        // listener queue, empty for a server-named queue
+	Durable      bool       `json:"durable,omitempty"`      // exchange and queue survive broker restarts
+	Bindings     []*Binding `json:"bindings,omitempty"`     // listener routing keys
+}
+
+// Binding binds the listener queue to the exchange with a routing key.
+// Failed messages are retried MaxRetries times, RetryDelay apart, then dead-lettered.
+type Binding struct {
+	Topic      string `json:"topic"`                  // routing key or pattern, e.g. log.INFO, user.*
+	MaxRetries int    `json:"maxRetries,omitempty"`   // 0 dead-letters on the first failure
+	RetryDelay int    `json:"retryDelayMs,omitempty"` // milliseconds between retries
+}
+
+// Job is a scheduled job of a worker service
+type Job struct {
+	Name     string `json:"name"`                 // job name, e.g. cleanup-sessions
+	Schedule string `json:"schedule"`             // cron expression or descriptor, e.g. */5 * * * *, @every 30s
+	Timeout  int    `json:"timeoutSec,omitempty"` // seconds a run may take before its context is cancelled
+}
+
+// Event describes a typed event contract shared by brokers and listeners
+type Event struct {
+	Name    string        `json:"name"`              // Go type name, e.g. UserCreated
+	Topic   string        `json:"topic,omitempty"`   // wire name and routing key, e.g. user.created
+	Version int           `json:"version,omitempty"` // schema version
+	Fields  []*EventField `json:"fields,omitempty"`
+}
+
+// EventField is a single typed field of an event contract
+type EventField struct {
+	Name string `json:"name"`           // Go field name, e.g. UserID
+	Type string `json:"type"`           // Go type, e.g. string, int64, time.Time
+	JSON string `json:"json,omitempty"` // json tag, e.g. user_id
 }
 
 // ConnectionProtocol represents the protocol used for inter-service communication